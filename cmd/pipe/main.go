@@ -0,0 +1,93 @@
+// Пробный прогон Pipe: синтетический источник и DelayConsumer вместо настоящего приёмника.
+// Показывает, какие батчи получаются при заданных пачках и задержке приёмника.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"PipeProducerConsumer/pipe"
+)
+
+// counterProducer отдаёт chunks пачек по chunkSize элементов, cookie - номер пачки. Источник конечный
+type counterProducer struct {
+	chunks    int
+	chunkSize int
+	sent      int
+	committed int
+}
+
+func (p *counterProducer) Next(ctx context.Context) ([]any, int, error) {
+	if p.sent >= p.chunks {
+		return nil, 0, pipe.ErrEndOfStream
+	}
+	p.sent++
+	items := make([]any, p.chunkSize)
+	for i := range items {
+		items[i] = p.sent
+	}
+	return items, p.sent, nil
+}
+
+func (p *counterProducer) Commit(ctx context.Context, cookie int) error {
+	p.committed = cookie
+	return nil
+}
+
+func main() {
+	chunks := flag.Int("chunks", 1000, "how many chunks the source returns")
+	chunkSize := flag.Int("chunk-size", 30, "items per chunk")
+	perItem := flag.Duration("per-item", time.Microsecond, "consumer latency per item")
+	base := flag.Duration("base", time.Millisecond, "consumer latency per batch")
+	inline := flag.Bool("inline", true, "read, process and commit in one goroutine")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "how long to drain after SIGINT/SIGTERM")
+	describe := flag.String("describe", "", "print the pipeline topology as text, mermaid or dot and exit")
+	flag.Parse()
+
+	// SIGINT/SIGTERM - перестаём читать и дописываем прочитанное, но не дольше drain-timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p := &counterProducer{chunks: *chunks, chunkSize: *chunkSize}
+	c := pipe.DelayConsumer(pipe.LinearLatency(*base, *perItem))
+	stats := pipe.NewFlushStats()
+
+	started := time.Now()
+	opts := []pipe.Option{pipe.WithConfig(pipe.Config{Inline: *inline}), pipe.WithFlushStats(stats), pipe.WithDrainTimeout(*drainTimeout)}
+	if *describe != "" {
+		t, err := pipe.Describe(p, c, opts...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch *describe {
+		case "text":
+			fmt.Fprint(os.Stdout, t)
+		case "mermaid":
+			fmt.Fprint(os.Stdout, t.Mermaid())
+		case "dot":
+			fmt.Fprint(os.Stdout, t.DOT())
+		default:
+			log.Fatalf("unknown -describe format %q, want text, mermaid or dot", *describe)
+		}
+		return
+	}
+
+	pl := pipe.NewPipeline(p, c, opts...)
+	if err := pl.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := pl.Wait(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stdout, "read %d chunks, committed up to %d in %s\n", p.sent, p.committed, time.Since(started))
+	for _, note := range stats.TuningReport().Notes {
+		fmt.Fprintln(os.Stdout, note)
+	}
+}
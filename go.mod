@@ -1 +1,3 @@
 module PipeProducerConsumer
+
+go 1.21
@@ -0,0 +1,131 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/*
+Привязка партиции к обработчику. С Merge и WithWorkers батчи одного источника могут попасть в разные
+горутины и писаться в приёмник одновременно - порядок внутри партиции теряется. С WithPartitionAffinity
+батч партиции ждёт, пока предыдущий батч той же партиции не будет обработан и закоммичен; батчи разных
+партиций по-прежнему идут параллельно.
+*/
+
+// Partitioner - опциональный интерфейс источника, который читает несколько партиций: из какой пришёл cookie.
+// Вызывается из горутины чтения, пока cookie ещё не закоммичен. Merge и PriorityMerge отдают номер источника
+type Partitioner interface {
+	PartitionOf(cookie int) int
+}
+
+// WithPartitionAffinity с WithWorkers не обрабатывает два батча одной партиции одновременно: следующий
+// батч партиции ждёт, пока предыдущий не пройдёт Process и Commit. Порядок внутри партиции сохраняется
+// от Next до Commit, даже с WithUnorderedCommits. partition - номер партиции по cookie; nil - спросить
+// источник (Partitioner, например Merge). Батч с cookie нескольких партиций ждёт их все.
+func WithPartitionAffinity(partition func(cookie int) int) Option {
+	return func(cfg *config) {
+		cfg.affinity = &affinityConfig{partition: partition}
+	}
+}
+
+type affinityConfig struct {
+	partition func(cookie int) int
+}
+
+// partitionAffinity - очереди батчей по партициям. Батч обрабатывается, когда он первый во всех своих
+// очередях. nil - привязки нет
+type partitionAffinity struct {
+	partition func(cookie int) int
+
+	mu     sync.Mutex
+	queues map[int][]uint64
+	// Закрывается, когда какая-то очередь сдвинулась
+	changed chan struct{}
+}
+
+// newPartitionAffinity берёт функцию из опции или из источника
+func newPartitionAffinity(cfg *config, p any) (*partitionAffinity, error) {
+	if cfg.affinity == nil {
+		return nil, nil
+	}
+	partition := cfg.affinity.partition
+	if partition == nil {
+		pr, ok := p.(Partitioner)
+		if !ok {
+			return nil, &ConfigError{Problems: []ConfigProblem{{Option: "WithPartitionAffinity",
+				Problem: fmt.Sprintf("producer %T has no PartitionOf", p), Suggestion: "pass a partition function or read through Merge"}}}
+		}
+		partition = pr.PartitionOf
+	}
+	return &partitionAffinity{partition: partition, queues: make(map[int][]uint64), changed: make(chan struct{})}, nil
+}
+
+// register ставит батч в очереди его партиций. Зовётся из горутины чтения по порядку батчей
+func (a *partitionAffinity) register(seq uint64, cookies []int) []int {
+	if a == nil || len(cookies) == 0 {
+		return nil
+	}
+	var parts []int
+	seen := make(map[int]bool, 1)
+	for _, c := range cookies {
+		part := a.partition(c)
+		if !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, part := range parts {
+		a.queues[part] = append(a.queues[part], seq)
+	}
+	return parts
+}
+
+// acquire ждёт, пока батч не станет первым во всех своих очередях. Ошибка - только отмена ctx
+func (a *partitionAffinity) acquire(ctx context.Context, seq uint64, parts []int) error {
+	if a == nil || len(parts) == 0 {
+		return nil
+	}
+	for {
+		a.mu.Lock()
+		ready := true
+		for _, part := range parts {
+			if a.queues[part][0] != seq {
+				ready = false
+				break
+			}
+		}
+		changed := a.changed
+		a.mu.Unlock()
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// release убирает батч из его очередей и будит ждущих
+func (a *partitionAffinity) release(parts []int) {
+	if a == nil || len(parts) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, part := range parts {
+		if q := a.queues[part][1:]; len(q) > 0 {
+			a.queues[part] = q
+		} else {
+			delete(a.queues, part)
+		}
+	}
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
@@ -0,0 +1,184 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// partitionSource - конечный источник, каждая пачка ровно на батч, элементы помечены номером партиции.
+// byParity - партиция по чётности cookie, а не part
+type partitionSource struct {
+	part     int
+	byParity bool
+	chunks   int
+	sent     int
+}
+
+func (p *partitionSource) Next(ctx context.Context) ([]any, int, error) {
+	if p.sent >= p.chunks {
+		return nil, 0, ErrEndOfStream
+	}
+	p.sent++
+	part := p.part
+	if p.byParity {
+		part = p.sent % 2
+	}
+	items := make([]any, MaxItems)
+	for i := range items {
+		items[i] = [2]int{part, p.sent}
+	}
+	return items, p.sent, nil
+}
+
+func (p *partitionSource) Commit(ctx context.Context, cookie int) error {
+	return nil
+}
+
+// partitionConsumer запоминает порядок батчей каждой партиции и сколько их обрабатывалось одновременно
+type partitionConsumer struct {
+	mu         sync.Mutex
+	running    map[int]int
+	overlap    int
+	maxRunning int
+	total      int
+	order      map[int][]int
+}
+
+func (c *partitionConsumer) Process(ctx context.Context, items []any) error {
+	item := items[0].([2]int)
+	part := item[0]
+	c.mu.Lock()
+	c.running[part]++
+	if c.running[part] > 1 {
+		c.overlap++
+	}
+	c.total++
+	c.maxRunning = max(c.maxRunning, c.total)
+	c.order[part] = append(c.order[part], item[1])
+	c.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running[part]--
+	c.total--
+	c.mu.Unlock()
+	return nil
+}
+
+func TestWithPartitionAffinity(t *testing.T) {
+	c := &partitionConsumer{running: map[int]int{}, order: map[int][]int{}}
+	src := Merge(&partitionSource{part: 0, chunks: 10}, &partitionSource{part: 1, chunks: 10})
+
+	err := Pipe(src, c, WithWorkers(4), WithUnorderedCommits(), WithPartitionAffinity(nil))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if c.overlap != 0 {
+		t.Errorf("%d batches processed alongside a batch of the same partition", c.overlap)
+	}
+	want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for part := 0; part < 2; part++ {
+		if !reflect.DeepEqual(c.order[part], want) {
+			t.Errorf("partition %d processed in order %v, want %v", part, c.order[part], want)
+		}
+	}
+	// Разные партиции по-прежнему идут параллельно
+	if c.maxRunning < 2 {
+		t.Errorf("at most %d Process calls at once, want 2", c.maxRunning)
+	}
+}
+
+func TestWithPartitionAffinityFunc(t *testing.T) {
+	// Партиция по чётности cookie
+	c := &partitionConsumer{running: map[int]int{}, order: map[int][]int{}}
+	var mu sync.Mutex
+	parts := map[int]int{}
+	err := Pipe(&partitionSource{byParity: true, chunks: 8}, c, WithWorkers(4),
+		WithPartitionAffinity(func(cookie int) int {
+			mu.Lock()
+			defer mu.Unlock()
+			parts[cookie] = cookie % 2
+			return cookie % 2
+		}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if len(parts) != 8 {
+		t.Errorf("partition asked for %d cookies, want 8", len(parts))
+	}
+	if c.overlap != 0 {
+		t.Errorf("%d batches processed alongside a batch of the same partition", c.overlap)
+	}
+	if want := []int{2, 4, 6, 8}; !reflect.DeepEqual(c.order[0], want) {
+		t.Errorf("partition 0 processed in order %v, want %v", c.order[0], want)
+	}
+	if want := []int{1, 3, 5, 7}; !reflect.DeepEqual(c.order[1], want) {
+		t.Errorf("partition 1 processed in order %v, want %v", c.order[1], want)
+	}
+}
+
+func TestWithPartitionAffinityConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Producer
+		opts []Option
+	}{
+		{"one worker", Merge(&partitionSource{}), []Option{WithPartitionAffinity(nil)}},
+		{"no partitioner", &partitionSource{}, []Option{WithWorkers(2), WithPartitionAffinity(nil)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Pipe(tt.p, &testConsumer{}, tt.opts...)
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 || cfgErr.Problems[0].Option != "WithPartitionAffinity" {
+				t.Fatalf("Pipe() error = %v, want a WithPartitionAffinity problem", err)
+			}
+		})
+	}
+}
+
+func TestPartitionAffinityMultiplePartitions(t *testing.T) {
+	a, _ := newPartitionAffinity(&config{affinity: &affinityConfig{partition: func(cookie int) int { return cookie / 10 }}}, nil)
+	// Батч 1 - партиция 0, батч 2 - партиции 0 и 1, батч 3 - партиция 1
+	p1 := a.register(1, []int{1})
+	p2 := a.register(2, []int{2, 11, 12})
+	p3 := a.register(3, []int{13})
+	if fmt.Sprint(p2) != "[0 1]" {
+		t.Fatalf("register() = %v, want [0 1]", p2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := a.acquire(ctx, 3, p3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("batch 3 acquired before batch 2 of the same partition: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- a.acquire(context.Background(), 2, p2) }()
+	select {
+	case err := <-done:
+		t.Fatalf("batch 2 acquired before batch 1 released partition 0: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := a.acquire(context.Background(), 1, p1); err != nil {
+		t.Fatalf("acquire(1) error = %v", err)
+	}
+	a.release(p1)
+	if err := <-done; err != nil {
+		t.Fatalf("acquire(2) error = %v", err)
+	}
+	a.release(p2)
+	if err := a.acquire(context.Background(), 3, p3); err != nil {
+		t.Fatalf("acquire(3) error = %v", err)
+	}
+	a.release(p3)
+	if len(a.queues) != 0 {
+		t.Errorf("queues left after release: %v", a.queues)
+	}
+}
@@ -0,0 +1,144 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Арена для []byte элементов.
+Источники, которые разбирают сообщения в []byte, на каждый элемент делают отдельную аллокацию,
+и на тысячах элементов в батче это заметная нагрузка на GC. С WithArena Pipe кладёт в контекст Next
+арену, источник берёт память под элементы из неё (ArenaFromContext + Alloc), а после Commit батча
+Pipe разом возвращает все её куски в пул.
+*/
+
+// arenaKey - ключ контекста, под которым Pipe передаёт арену в Next
+type arenaKey struct{}
+
+// Arena раздаёт []byte из крупных кусков (slab). Освобождается целиком, отдельные элементы не освобождаются.
+// Память арены живёт до Commit батча, поэтому консюмер не должен хранить ссылки на элементы после Process.
+type Arena struct {
+	pool     *sync.Pool
+	slabSize int
+
+	mu    sync.Mutex
+	slabs [][]byte
+	cur   []byte
+	// Сколько батчей ещё держат арену (пачка могла попасть не в тот батч, под который её читали)
+	refs atomic.Int32
+}
+
+func newArena(pool *sync.Pool, slabSize int) *Arena {
+	a := &Arena{pool: pool, slabSize: slabSize}
+	a.refs.Store(1)
+	return a
+}
+
+// Alloc возвращает срез длины n из арены. Слишком большие куски выделяются отдельно, мимо пула.
+func (a *Arena) Alloc(n int) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n > a.slabSize {
+		return make([]byte, n)
+	}
+	if cap(a.cur)-len(a.cur) < n {
+		slab := a.pool.Get().(*[]byte)
+		a.slabs = append(a.slabs, *slab)
+		a.cur = (*slab)[:0]
+	}
+	start := len(a.cur)
+	a.cur = a.cur[:start+n]
+	// Ограничиваем ёмкость, чтобы append к элементу не залез в соседний
+	return a.cur[start : start+n : start+n]
+}
+
+// retain - ещё один батч держит арену
+func (a *Arena) retain() {
+	a.refs.Add(1)
+}
+
+// release отпускает арену, последний владелец возвращает куски в пул
+func (a *Arena) release() {
+	if a.refs.Add(-1) > 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range a.slabs {
+		slab := a.slabs[i][:0]
+		a.pool.Put(&slab)
+	}
+	a.slabs = nil
+	a.cur = nil
+}
+
+// ArenaFromContext возвращает арену, из которой источнику стоит брать память под элементы
+// в текущем вызове Next. nil, если арена не включена.
+func ArenaFromContext(ctx context.Context) *Arena {
+	a, _ := ctx.Value(arenaKey{}).(*Arena)
+	return a
+}
+
+// WithArena включает арены для элементов: slabSize - размер одного куска памяти в байтах.
+func WithArena(slabSize int) Option {
+	return func(cfg *config) {
+		cfg.arenaSlabSize = slabSize
+	}
+}
+
+// arenaSet - арены, которые держит один батч
+type arenaSet struct {
+	pool     *sync.Pool
+	slabSize int
+	// Арена, из которой читает источник прямо сейчас
+	cur *Arena
+	// Все арены текущего буфера
+	held []*Arena
+}
+
+func newArenaSet(slabSize int) *arenaSet {
+	if slabSize <= 0 {
+		return nil
+	}
+	pool := &sync.Pool{New: func() any {
+		slab := make([]byte, 0, slabSize)
+		return &slab
+	}}
+	s := &arenaSet{pool: pool, slabSize: slabSize}
+	s.cur = newArena(pool, slabSize)
+	s.held = []*Arena{s.cur}
+	return s
+}
+
+// withArena кладёт текущую арену в контекст Next
+func (s *arenaSet) withArena(ctx context.Context) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, arenaKey{}, s.cur)
+}
+
+// flush отдаёт арены собранного батча. Пачка, из-за которой батч отправляется, уже прочитана в текущую
+// арену, но попадёт в следующий буфер - поэтому новый буфер тоже держит текущую арену,
+// а источник дальше читает в свежую.
+func (s *arenaSet) flush() []*Arena {
+	if s == nil {
+		return nil
+	}
+	held := s.held
+	s.cur.retain()
+	prev := s.cur
+	s.cur = newArena(s.pool, s.slabSize)
+	s.held = []*Arena{prev, s.cur}
+	return held
+}
+
+// releaseArenas отпускает арены закоммиченного батча
+func releaseArenas(arenas []*Arena) {
+	for _, a := range arenas {
+		a.release()
+	}
+}
@@ -0,0 +1,128 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// arenaProducer кладёт каждый элемент в арену из контекста Next, заполняя его номером пачки
+type arenaProducer struct {
+	testProducer
+	noArena bool
+}
+
+func (p *arenaProducer) Next(ctx context.Context) ([]any, int, error) {
+	items, cookie, err := p.testProducer.Next(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	a := ArenaFromContext(ctx)
+	if a == nil {
+		p.noArena = true
+		return items, cookie, nil
+	}
+	for i := range items {
+		b := a.Alloc(8)
+		copy(b, fmt.Sprintf("%08d", cookie))
+		items[i] = b
+	}
+	return items, cookie, nil
+}
+
+// arenaConsumer проверяет, что память элементов не перетёрли до Process
+type arenaConsumer struct {
+	testConsumer
+	mu      sync.Mutex
+	corrupt bool
+}
+
+func (c *arenaConsumer) Process(ctx context.Context, items []any) error {
+	// Батч состоит из целых пачек по 30 одинаковых элементов - если память отдали раньше времени,
+	// внутри пачки окажутся чужие номера
+	for start := 0; start < len(items); start += 30 {
+		first := items[start].([]byte)
+		for _, item := range items[start : start+30] {
+			if !bytes.Equal(item.([]byte), first) {
+				c.mu.Lock()
+				c.corrupt = true
+				c.mu.Unlock()
+			}
+		}
+	}
+	return c.testConsumer.Process(ctx, items)
+}
+
+func TestWithArenaKeepsItemsUntilCommit(t *testing.T) {
+	p := &arenaProducer{testProducer: testProducer{chunks: 200, chunkSize: 30, finish: make(chan struct{})}}
+	c := &sizedConsumer{hint: 100}
+	ac := &arenaConsumer{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Pipe(p, &checkedSized{sizedConsumer: c, check: ac}, WithArena(64))
+	}()
+	waitCommits(t, &p.testProducer, 195)
+	close(p.finish)
+	if err := <-done; !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if p.noArena {
+		t.Fatal("Next got no arena in context")
+	}
+	if ac.corrupt {
+		t.Fatal("items were overwritten before Process")
+	}
+}
+
+// checkedSized - sizedConsumer, который ещё и проверяет содержимое элементов
+type checkedSized struct {
+	*sizedConsumer
+	check *arenaConsumer
+}
+
+func (c *checkedSized) Process(ctx context.Context, items []any) error {
+	if err := c.check.Process(ctx, items); err != nil {
+		return err
+	}
+	return c.sizedConsumer.Process(ctx, items)
+}
+
+func TestArenaAllocAndRelease(t *testing.T) {
+	s := newArenaSet(16)
+	a := s.cur
+
+	x := a.Alloc(10)
+	y := a.Alloc(10) // не влезает в остаток первого куска
+	big := a.Alloc(100)
+	if len(x) != 10 || cap(x) != 10 || len(y) != 10 || len(big) != 100 {
+		t.Fatalf("bad allocations: len/cap %d/%d, %d, %d", len(x), cap(x), len(y), len(big))
+	}
+	if len(a.slabs) != 2 {
+		t.Fatalf("slabs = %d, want 2", len(a.slabs))
+	}
+
+	// Батч отправлен: старый буфер держит a, новый тоже держит a и читает в свежую арену
+	held := s.flush()
+	if len(held) != 1 || held[0] != a || s.cur == a {
+		t.Fatal("flush did not hand over the current arena")
+	}
+	releaseArenas(held)
+	if a.slabs == nil {
+		t.Fatal("arena freed while the next buffer still holds it")
+	}
+	releaseArenas(s.flush())
+	if a.slabs != nil {
+		t.Fatal("arena not freed after all batches released it")
+	}
+}
+
+func TestWithArenaNegativeSlab(t *testing.T) {
+	var cfgErr *ConfigError
+	if err := Pipe(&testProducer{}, &testConsumer{}, WithArena(-1)); !errors.As(err, &cfgErr) {
+		t.Fatalf("Pipe() error = %v, want *ConfigError", err)
+	}
+}
@@ -0,0 +1,39 @@
+package pipe
+
+/*
+Лимит батча в байтах. Некоторые приёмники (Clickhouse в том числе) упираются не в число строк,
+а в размер вставки. С WithMaxBatchBytes батч отправляется, когда следующая пачка не влезает
+ни по числу элементов, ни по байтам - что наступит раньше.
+*/
+
+// batchBytes - лимит батча в байтах и как мерить элемент
+type batchBytes struct {
+	max  int
+	size func(item any) int
+}
+
+// WithMaxBatchBytes ограничивает батч n байтами в дополнение к лимиту по числу элементов.
+// size возвращает размер элемента (обычно длину сериализованной строки).
+// Пачка источника не режется: если она одна больше n, то уходит отдельным батчем.
+func WithMaxBatchBytes(n int, size func(item any) int) Option {
+	return func(cfg *config) {
+		cfg.batchBytes = &batchBytes{max: n, size: size}
+	}
+}
+
+// itemsBytes считает размер элементов, 0 - лимит по байтам выключен
+func itemsBytes[T any](bb *batchBytes, items []T) int {
+	if bb == nil {
+		return 0
+	}
+	n := 0
+	for _, item := range items {
+		n += bb.size(any(item))
+	}
+	return n
+}
+
+// fits - влезут ли ещё add байт в батч, где уже лежит have
+func (bb *batchBytes) fits(have, add int) bool {
+	return bb == nil || have+add <= bb.max
+}
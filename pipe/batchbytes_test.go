@@ -0,0 +1,47 @@
+package pipe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWithMaxBatchBytes(t *testing.T) {
+	// 3000 элементов по 10 байт - 30000 байт на пачку, в 65000 влезают две
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	c := &testConsumer{}
+	stats := NewFlushStats()
+	size := func(item any) int { return 10 }
+	err := Pipe(p, c, WithInlineMode(), WithMaxBatchBytes(65000, size), WithFlushStats(stats))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got, want := c.batchSizes(), []int{6000, 6000, 6000, 3000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch sizes = %v, want %v", got, want)
+	}
+	checkCommitsInOrder(t, p.commits())
+	if got := stats.Histogram().ByReason[FlushBytes]; got != 3 {
+		t.Errorf("bytes flushes = %d, want 3", got)
+	}
+}
+
+func TestWithMaxBatchBytesOversizedChunk(t *testing.T) {
+	// Пачка больше лимита не режется, а уходит одна
+	p := &testProducer{chunks: 3, chunkSize: 100}
+	c := &testConsumer{}
+	err := Pipe(p, c, WithInlineMode(), WithMaxBatchBytes(50, func(item any) int { return 1 }))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got, want := c.batchSizes(), []int{100, 100, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch sizes = %v, want %v", got, want)
+	}
+}
+
+func TestWithMaxBatchBytesValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithMaxBatchBytes(0, nil))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 2 problems", err)
+	}
+}
@@ -0,0 +1,58 @@
+package pipe
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+/*
+Хеш содержимого батча для сверки "что прочитали == что записали". Pipe считает его при сборке батча
+и пишет в журнал событий (Event.Hash) и в BatchMeta.Hash - SQL-приёмник может положить его
+в аудит-таблицу в той же транзакции, что и данные, а ночная сверка сравнивает одно с другим.
+*/
+
+// WithBatchHash включает хеш батча: SHA-256 по элементам в порядке батча.
+// encode превращает элемент в байты; nil - []byte и string берутся как есть, остальное через fmt %v.
+// Хеш зависит от порядка элементов и от границ батча.
+func WithBatchHash(encode func(item any) []byte) Option {
+	return func(cfg *config) {
+		cfg.batchHash = true
+		cfg.hashEncode = encode
+	}
+}
+
+// encodeItem - кодирование элемента по умолчанию
+func encodeItem(item any) []byte {
+	switch v := item.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return fmt.Appendf(nil, "%v", v)
+	}
+}
+
+// hashItems считает хеш батча, "" - хеш выключен
+func hashItems[T any](cfg *config, items []T) string {
+	if !cfg.batchHash {
+		return ""
+	}
+	encode := cfg.hashEncode
+	if encode == nil {
+		encode = encodeItem
+	}
+
+	h := sha256.New()
+	var size [8]byte
+	for _, item := range items {
+		b := encode(any(item))
+		// Длина перед каждым элементом, чтобы ["ab", "c"] и ["a", "bc"] давали разный хеш
+		binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+		h.Write(size[:])
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package pipe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// hashConsumer запоминает хеши батчей из BatchContext
+type hashConsumer struct {
+	hint   int
+	hashes []string
+}
+
+func (c *hashConsumer) Process(ctx context.Context, items []any) error {
+	meta, _ := BatchContext(ctx)
+	c.hashes = append(c.hashes, meta.Hash)
+	return nil
+}
+
+func (c *hashConsumer) PreferredBatchSize(ctx context.Context) int {
+	return c.hint
+}
+
+func TestWithBatchHashInMetaAndEventLog(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{"a"}, {"b"}, {"c"}}}
+	c := &hashConsumer{hint: 1}
+	log := &memoryEventLog{}
+
+	err := Pipe(p, c, WithInlineMode(), WithEventLog(log), WithBatchHash(nil))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Одна строка "a": длина 8 байтами big endian и сами байты
+	sum := sha256.Sum256([]byte("\x00\x00\x00\x00\x00\x00\x00\x01a"))
+	if want := hex.EncodeToString(sum[:]); len(c.hashes) != 3 || c.hashes[0] != want {
+		t.Fatalf("hashes = %v, first want %s", c.hashes, want)
+	}
+	for _, e := range log.events {
+		if e.Type != EventRunStarted && e.Hash != c.hashes[e.Batch-1] {
+			t.Fatalf("event %s of batch %d has hash %q, want %q", e.Type, e.Batch, e.Hash, c.hashes[e.Batch-1])
+		}
+	}
+}
+
+func TestHashItemsSeparatesItems(t *testing.T) {
+	cfg, err := newConfig([]Option{WithBatchHash(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hashItems(cfg, []any{"ab", "c"}) == hashItems(cfg, []any{"a", "bc"}) {
+		t.Fatal("different items give the same hash")
+	}
+	if hashItems(cfg, []any{1, 2}) == hashItems(cfg, []any{2, 1}) {
+		t.Fatal("hash ignores item order")
+	}
+	if h := hashItems(&config{}, []any{1}); h != "" {
+		t.Fatalf("hash without WithBatchHash = %q", h)
+	}
+}
@@ -0,0 +1,50 @@
+package pipe
+
+import "errors"
+
+/*
+Границы групп элементов. CDC-источники отдают транзакцию как несколько записей и маркер её конца,
+и приёмник должен получить транзакцию целиком в одном батче, иначе по пути кто-то увидит её половину.
+С WithBoundaries батч отправляется только до конца последней закрытой группы, а незакрытая группа
+(вместе с cookie её пачек) переезжает в следующий батч.
+*/
+
+// ErrGroupTooLarge - группа из WithBoundaries не закрылась за MaxItems элементов и в один батч не влезет
+var ErrGroupTooLarge = errors.New("boundary group exceeds MaxItems")
+
+// WithBoundaries не разрывает группы между батчами: isEnd возвращает true на последнем элементе группы
+// (например, на записи коммита транзакции). Пока группа не закрыта, батч может выйти больше лимита
+// консюмера, но не больше MaxItems - дальше Pipe останавливается с ErrGroupTooLarge.
+// Незакрытая группа не уходит в приёмник ни по таймеру, ни при остановке: после рестарта источник отдаст её снова.
+// Не сочетается с WithArena и LargeItemSolo - им нужно отправлять буфер в произвольном месте.
+func WithBoundaries(isEnd func(item any) bool) Option {
+	return func(cfg *config) {
+		cfg.boundaries = isEnd
+	}
+}
+
+// lastBoundary - позиция сразу за последним концом группы в items, 0 - концов нет
+func lastBoundary[T any](isEnd func(item any) bool, items []T) int {
+	for i := len(items) - 1; i >= 0; i-- {
+		if isEnd(any(items[i])) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// splitSpans делит описание пачек буфера по позиции n: что уходит в батч и что остаётся (со сдвигом к началу)
+func splitSpans(spans []CookieSpan, n int) (sent, rest []CookieSpan) {
+	for _, s := range spans {
+		switch end := s.Offset + s.Items; {
+		case end <= n:
+			sent = append(sent, s)
+		case s.Offset >= n:
+			rest = append(rest, CookieSpan{Cookie: s.Cookie, Offset: s.Offset - n, Items: s.Items})
+		default:
+			sent = append(sent, CookieSpan{Cookie: s.Cookie, Offset: s.Offset, Items: n - s.Offset})
+			rest = append(rest, CookieSpan{Cookie: s.Cookie, Offset: 0, Items: end - n})
+		}
+	}
+	return sent, rest
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// txEnd - конец транзакции: запись коммита с суффиксом C
+func txEnd(item any) bool {
+	return strings.HasSuffix(item.(string), "C")
+}
+
+func TestWithBoundaries(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{"a1", "a2"}, {"aC", "b1"}, {"b2", "bC"}, {"c1"}}}
+	c := &itemsConsumer{p: p, hint: 3}
+	var metas []BatchMeta
+	err := Pipe(p, &metaSpy{itemsConsumer: c, metas: &metas}, WithInlineMode(), WithBoundaries(txEnd))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Транзакции целиком, незакрытая c1 не отправлена и её пачка не закоммичена
+	want := [][]any{{"a1", "a2", "aC"}, {"b1", "b2", "bC"}}
+	if !reflect.DeepEqual(c.batches, want) {
+		t.Errorf("batches = %v, want %v", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Errorf("commits = %v, want [1 2 3]", p.committed)
+	}
+	// Пачка 2 разрезана границей: в Spans обоих батчей, в Cookies - только второго
+	wantSpans := [][]CookieSpan{
+		{{Cookie: 1, Offset: 0, Items: 2}, {Cookie: 2, Offset: 2, Items: 1}},
+		{{Cookie: 2, Offset: 0, Items: 1}, {Cookie: 3, Offset: 1, Items: 2}},
+	}
+	if len(metas) != 2 || !reflect.DeepEqual(metas[0].Spans, wantSpans[0]) || !reflect.DeepEqual(metas[1].Spans, wantSpans[1]) {
+		t.Errorf("metas = %+v", metas)
+	}
+	if !reflect.DeepEqual(metas[0].Cookies, []int{1}) || !reflect.DeepEqual(metas[1].Cookies, []int{2, 3}) {
+		t.Errorf("cookies = %v, %v", metas[0].Cookies, metas[1].Cookies)
+	}
+}
+
+// metaSpy запоминает BatchMeta каждого батча и передаёт его дальше
+type metaSpy struct {
+	*itemsConsumer
+	metas *[]BatchMeta
+}
+
+func (s *metaSpy) Process(ctx context.Context, items []any) error {
+	meta, _ := BatchContext(ctx)
+	*s.metas = append(*s.metas, meta)
+	return s.itemsConsumer.Process(ctx, items)
+}
+
+func TestWithBoundariesGroupTooLarge(t *testing.T) {
+	half := make([]any, MaxItems/2+1)
+	for i := range half {
+		half[i] = "row"
+	}
+	p := &listProducer{chunks: [][]any{half, half}}
+	err := Pipe(p, &testConsumer{}, WithInlineMode(), WithBoundaries(txEnd))
+	if !errors.Is(err, ErrGroupTooLarge) {
+		t.Fatalf("Pipe() error = %v, want %v", err, ErrGroupTooLarge)
+	}
+	if len(p.committed) != 0 {
+		t.Errorf("commits = %v, want none", p.committed)
+	}
+}
+
+func TestWithBoundariesValidation(t *testing.T) {
+	_, err := newConfig([]Option{WithBoundaries(txEnd), WithArena(4096),
+		WithLargeItems(LargeItems{Threshold: 10, Size: intSize, Policy: LargeItemSolo})})
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("newConfig() error = %v, want a *ConfigError with 2 problems", err)
+	}
+}
@@ -0,0 +1,191 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Предохранитель перед приёмником. Когда приёмник лежит, повторы и DLQ только добивают его и гоняют
+батчи впустую. После Failures ошибок Process подряд предохранитель размыкается: Process не вызывается
+OpenFor, батчи ждут, а за ними встаёт и чтение. Потом идут пробы по одному вызову, и после Probes
+удачных подряд всё работает как раньше.
+*/
+
+// BreakerState - состояние предохранителя
+type BreakerState string
+
+const (
+	// Process вызывается как обычно
+	BreakerClosed BreakerState = "closed"
+	// Приёмник считается лежащим, Process не вызывается
+	BreakerOpen BreakerState = "open"
+	// Проба: Process вызывается по одному батчу за раз
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// CircuitBreaker - настройки предохранителя вокруг Process
+type CircuitBreaker struct {
+	// Сколько ошибок Process подряд размыкают предохранитель
+	Failures int
+	// Сколько ждём до первой пробы
+	OpenFor time.Duration
+	// Сколько удачных проб подряд замыкают его обратно, 0 - одна
+	Probes int
+	// Кому сообщаем о переходах, nil - никому. Вызов синхронный, из горутины обработки
+	OnStateChange func(from, to BreakerState)
+}
+
+// WithCircuitBreaker ставит предохранитель вокруг Process. Считается каждая попытка, в том числе повторы
+// WithProcessRetry. Пока предохранитель разомкнут, попытка ждёт, а не падает: ошибкой для батча остаётся ошибка
+// последней попытки. PartialError от ProcessWithResults - ответ живого приёмника и ошибкой не считается.
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(cfg *config) {
+		cfg.breaker = &breaker{cb: cb, now: time.Now, state: BreakerClosed, changed: make(chan struct{})}
+	}
+}
+
+// breaker - состояние предохранителя запуска
+type breaker struct {
+	cb  CircuitBreaker
+	now func() time.Time
+
+	mu    sync.Mutex
+	state BreakerState
+	// Ошибок подряд в closed и удачных проб подряд в half-open
+	failures  int
+	successes int
+	openedAt  time.Time
+	// Проба уже идёт - остальные ждут её итога
+	probing bool
+	// Закрывается при каждой смене состояния и конце пробы
+	changed chan struct{}
+}
+
+func (b *breaker) validate(add func(option, problem, suggestion string)) {
+	if b == nil {
+		return
+	}
+	if b.cb.Failures <= 0 {
+		add("WithCircuitBreaker", fmt.Sprintf("failures %d is not positive", b.cb.Failures), "use e.g. 5")
+	}
+	if b.cb.OpenFor <= 0 {
+		add("WithCircuitBreaker", "open duration is not positive", "use e.g. 30s")
+	}
+	if b.cb.Probes < 0 {
+		add("WithCircuitBreaker", fmt.Sprintf("probes %d is negative", b.cb.Probes), "use 0 for a single probe")
+	}
+}
+
+// allow ждёт, пока можно вызвать Process. probe - вызов идёт пробой, его итог надо отдать в record.
+// Ошибка - только отмена ctx
+func (b *breaker) allow(ctx context.Context) (probe bool, err error) {
+	if b == nil {
+		return false, nil
+	}
+	for {
+		b.mu.Lock()
+		var wait <-chan time.Time
+		var timer *time.Timer
+		switch b.state {
+		case BreakerClosed:
+			b.mu.Unlock()
+			return false, nil
+		case BreakerOpen:
+			left := b.cb.OpenFor - b.now().Sub(b.openedAt)
+			if left <= 0 {
+				from := b.setState(BreakerHalfOpen)
+				b.mu.Unlock()
+				b.notify(from, BreakerHalfOpen)
+				continue
+			}
+			timer = time.NewTimer(left)
+			wait = timer.C
+		case BreakerHalfOpen:
+			if !b.probing {
+				b.probing = true
+				b.mu.Unlock()
+				return true, nil
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-wait:
+		case <-changed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// record учитывает итог вызова Process
+func (b *breaker) record(probe bool, err error) {
+	if b == nil {
+		return
+	}
+	var partial *PartialError
+	failed := err != nil && !errors.As(err, &partial)
+
+	b.mu.Lock()
+	from, to := b.state, b.state
+	switch {
+	case probe:
+		b.probing = false
+		switch {
+		case failed:
+			b.setState(BreakerOpen)
+		case b.successes+1 >= max(b.cb.Probes, 1):
+			b.setState(BreakerClosed)
+		default:
+			b.successes++
+			b.wake()
+		}
+	case b.state == BreakerClosed && failed:
+		if b.failures++; b.failures >= b.cb.Failures {
+			b.setState(BreakerOpen)
+		}
+	case b.state == BreakerClosed:
+		b.failures = 0
+	}
+	// Вызовы, пропущенные ещё до размыкания, на состояние уже не влияют
+	to = b.state
+	b.mu.Unlock()
+	if from != to {
+		b.notify(from, to)
+	}
+}
+
+// setState переводит предохранитель и будит ждущих. Вызывать под b.mu, возвращает прошлое состояние
+func (b *breaker) setState(to BreakerState) BreakerState {
+	from := b.state
+	b.state = to
+	b.failures, b.successes = 0, 0
+	if to == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	b.wake()
+	return from
+}
+
+// wake будит всех, кто ждёт в allow. Вызывать под b.mu
+func (b *breaker) wake() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+	if b.cb.OnStateChange != nil {
+		b.cb.OnStateChange(from, to)
+	}
+}
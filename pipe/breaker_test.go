@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// breakerLog запоминает переходы предохранителя
+type breakerLog struct {
+	mu          sync.Mutex
+	transitions []string
+}
+
+func (l *breakerLog) record(from, to BreakerState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.transitions = append(l.transitions, string(from)+"->"+string(to))
+}
+
+func (l *breakerLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.transitions...)
+}
+
+// allowSoon - allow с коротким таймаутом: ошибка значит, что вызов пришлось бы ждать
+func allowSoon(b *breaker) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	return b.allow(ctx)
+}
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Unix(0, 0)
+	log := &breakerLog{}
+	cfg := &config{}
+	WithCircuitBreaker(CircuitBreaker{Failures: 2, OpenFor: time.Minute, Probes: 2, OnStateChange: log.record})(cfg)
+	b := cfg.breaker
+	b.now = func() time.Time { return now }
+
+	// Частичный отказ - ответ живого приёмника, размыкают только настоящие ошибки подряд
+	b.record(false, errTransient)
+	b.record(false, &PartialError{Items: []ItemError{{Err: errBadRow}}, Total: 2})
+	b.record(false, errTransient)
+	if _, err := allowSoon(b); err != nil {
+		t.Fatalf("allow() after a success in between error = %v", err)
+	}
+	b.record(false, errTransient)
+	if _, err := allowSoon(b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("allow() while open error = %v, want to wait", err)
+	}
+
+	// Время вышло: одна проба за раз, вторая ждёт её итога
+	now = now.Add(time.Minute)
+	if probe, err := allowSoon(b); err != nil || !probe {
+		t.Fatalf("allow() after OpenFor = %v, %v, want a probe", probe, err)
+	}
+	if _, err := allowSoon(b); err == nil {
+		t.Fatal("second allow() passed while the probe is running")
+	}
+	b.record(true, nil)
+	if probe, err := allowSoon(b); err != nil || !probe {
+		t.Fatalf("allow() after one good probe = %v, %v, want another probe", probe, err)
+	}
+	b.record(true, nil)
+	if probe, err := allowSoon(b); err != nil || probe {
+		t.Fatalf("allow() after closing = %v, %v, want a normal call", probe, err)
+	}
+
+	want := []string{"closed->open", "open->half_open", "half_open->closed"}
+	if got := log.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	// Две ошибки размыкают, третья попытка - проба и снова ошибка, четвёртая - удачная проба
+	p := &testProducer{chunks: 1, chunkSize: 10}
+	c := &flakyConsumer{fails: 3, err: errTransient}
+	log := &breakerLog{}
+
+	start := time.Now()
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(),
+		WithProcessRetry(RetryPolicy{Attempts: 5, Backoff: time.Millisecond}),
+		WithCircuitBreaker(CircuitBreaker{Failures: 2, OpenFor: 30 * time.Millisecond, OnStateChange: log.record}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Pipe() took %s, want two open periods of 30ms", elapsed)
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(c.attempts, want) {
+		t.Errorf("attempts = %v, want %v", c.attempts, want)
+	}
+	want := []string{"closed->open", "open->half_open", "half_open->open", "open->half_open", "half_open->closed"}
+	if got := log.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestWithCircuitBreakerValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithCircuitBreaker(CircuitBreaker{Probes: -1}))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 3 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 3 problems", err)
+	}
+}
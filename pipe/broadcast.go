@@ -0,0 +1,131 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Запись одного потока в несколько приёмников (двойная запись на время миграции: Clickhouse и S3).
+В отличие от ShadowConsumer все приёмники равноправны: коммит зависит от того, сколько из них
+приняли батч, а не от одного основного.
+*/
+
+// BroadcastMiss - приёмник не принял батч, но остальные набрали кворум и батч закоммичен.
+// В этом приёмнике батча нет - его надо дописать отдельно.
+type BroadcastMiss struct {
+	// Номер батча в рамках запуска Pipe (0, если консюмер вызван не из Pipe)
+	Batch uint64
+	Items int
+	// Позиция приёмника в BroadcastConsumer и его описание (SinkDescriber)
+	Consumer int
+	Sink     string
+	Err      error
+	Time     time.Time
+}
+
+// BroadcastConsumer отдаёт каждый батч всем consumers параллельно, каждому свою копию слайса.
+// Батч принят, когда его приняли хотя бы quorum приёмников (quorum <= 0 или больше их числа - все).
+// Тогда ошибки остальных уходят в onMiss (может быть nil) синхронно, до коммита, а без кворума
+// возвращаются все ошибки через errors.Join. С WithProcessRetry повтор идёт во все приёмники,
+// и те, что уже приняли батч, получат его ещё раз.
+// BatchSizer - наименьшая подсказка приёмников, OnStart/OnStop (и io.Closer) вызываются у всех.
+func BroadcastConsumer(consumers []Consumer, quorum int, onMiss func(BroadcastMiss)) Consumer {
+	if quorum <= 0 || quorum > len(consumers) {
+		quorum = len(consumers)
+	}
+	return &broadcastConsumer{consumers: consumers, quorum: quorum, onMiss: onMiss}
+}
+
+type broadcastConsumer struct {
+	consumers []Consumer
+	quorum    int
+	onMiss    func(BroadcastMiss)
+}
+
+func (b *broadcastConsumer) Process(ctx context.Context, items []any) error {
+	errs := make([]error, len(b.consumers))
+	var wg sync.WaitGroup
+	for i, c := range b.consumers {
+		wg.Add(1)
+		go func(i int, c Consumer) {
+			defer wg.Done()
+			errs[i] = c.Process(ctx, append([]any(nil), items...))
+		}(i, c)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, err := range errs {
+		if err == nil {
+			accepted++
+		}
+	}
+	if accepted < b.quorum {
+		var failed []error
+		for i, err := range errs {
+			if err != nil {
+				failed = append(failed, fmt.Errorf("consumer %d: %w", i, err))
+			}
+		}
+		return fmt.Errorf("%d of %d consumers accepted the batch, quorum %d: %w", accepted, len(b.consumers), b.quorum, errors.Join(failed...))
+	}
+
+	if b.onMiss == nil || accepted == len(b.consumers) {
+		return nil
+	}
+	var batch uint64
+	if meta, ok := BatchContext(ctx); ok {
+		batch = meta.Seq
+	}
+	for i, err := range errs {
+		if err != nil {
+			b.onMiss(BroadcastMiss{Batch: batch, Items: len(items), Consumer: i, Sink: describeSink(b.consumers[i]), Err: err, Time: time.Now()})
+		}
+	}
+	return nil
+}
+
+func (b *broadcastConsumer) PreferredBatchSize(ctx context.Context) int {
+	hint := 0
+	for _, c := range b.consumers {
+		if bs, ok := c.(BatchSizer); ok {
+			if n := bs.PreferredBatchSize(ctx); n > 0 && (hint == 0 || n < hint) {
+				hint = n
+			}
+		}
+	}
+	return hint
+}
+
+func (b *broadcastConsumer) DescribeSink() string {
+	sinks := make([]string, len(b.consumers))
+	for i, c := range b.consumers {
+		sinks[i] = describeSink(c)
+	}
+	return "broadcast(" + strings.Join(sinks, ", ") + ")"
+}
+
+func (b *broadcastConsumer) adapters() []adapter {
+	adapters := make([]adapter, len(b.consumers))
+	for i, c := range b.consumers {
+		adapters[i] = adapter{fmt.Sprintf("consumer %d", i), c}
+	}
+	return adapters
+}
+
+func (b *broadcastConsumer) OnStart(ctx context.Context) error {
+	started, err := startAdapters(ctx, b.adapters())
+	if err != nil {
+		return withStopError(err, stopAdapters(ctx, started))
+	}
+	return nil
+}
+
+func (b *broadcastConsumer) OnStop(ctx context.Context) error {
+	return stopAdapters(ctx, b.adapters())
+}
@@ -0,0 +1,58 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBroadcastConsumerQuorum(t *testing.T) {
+	// Третий приёмник падает на втором батче, кворум 2 из 3 - коммитится всё
+	a, b, s3 := &countingSink{hint: 6000}, &countingSink{}, &countingSink{failOn: 2}
+	var misses []BroadcastMiss
+	c := BroadcastConsumer([]Consumer{a, b, s3}, 2, func(m BroadcastMiss) { misses = append(misses, m) })
+	p := &testProducer{chunks: 6, chunkSize: 3000}
+
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode()); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5, 6}) {
+		t.Fatalf("commits = %v, want 1..6", got)
+	}
+	if a.count() != 18000 || b.count() != 18000 || s3.count() != 12000 {
+		t.Errorf("items = %d, %d, %d, want 18000, 18000, 12000", a.count(), b.count(), s3.count())
+	}
+	if len(misses) != 1 || misses[0].Batch != 2 || misses[0].Consumer != 2 || misses[0].Items != 6000 {
+		t.Fatalf("misses = %+v, want batch 2 missing in consumer 2", misses)
+	}
+	// Консюмеры закрыты через OnStop
+	if a.closed != 1 || s3.closed != 1 {
+		t.Errorf("closed = %d, %d, want 1, 1", a.closed, s3.closed)
+	}
+}
+
+func TestBroadcastConsumerNoQuorum(t *testing.T) {
+	// По умолчанию нужны все - ошибка одного не даёт коммитить
+	a, b := &countingSink{}, &countingSink{failOn: 1}
+	c := BroadcastConsumer([]Consumer{a, b}, 0, nil)
+	p := &testProducer{chunks: 2, chunkSize: 3000}
+
+	err := Pipe(finiteProducer{p}, c, WithInlineMode())
+	if err == nil || errors.Is(err, ErrEndOfStream) {
+		t.Fatalf("Pipe() error = %v, want the consumer error", err)
+	}
+	if len(p.commits()) != 0 {
+		t.Fatalf("commits = %v, want none", p.commits())
+	}
+}
+
+func TestBroadcastConsumerDescribe(t *testing.T) {
+	c := BroadcastConsumer([]Consumer{&countingSink{hint: 5000}, &countingSink{hint: 2000}, &countingSink{}}, 0, nil)
+	if got := c.(BatchSizer).PreferredBatchSize(context.Background()); got != 2000 {
+		t.Errorf("PreferredBatchSize() = %d, want 2000", got)
+	}
+	if got, want := describeSink(c), "broadcast(*pipe.countingSink, *pipe.countingSink, *pipe.countingSink)"; got != want {
+		t.Errorf("DescribeSink() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Захват последних батчей для разбора инцидентов: перед каждым Process батч целиком пишется на диск
+в кольцо из maxBatches файлов. Когда в приёмнике нашли кривые данные, по ReadCapture видно,
+что именно ему отправили, без перечитывания источника.
+*/
+
+// CapturedBatch - батч, записанный WithCapture
+type CapturedBatch struct {
+	Time    time.Time    `json:"time"`
+	Seq     uint64       `json:"seq"`
+	Cookies []int        `json:"cookies"`
+	Spans   []CookieSpan `json:"spans"`
+	Hash    string       `json:"hash,omitempty"`
+	// Элементы в JSON - как их закодировал encoding/json
+	Items []json.RawMessage `json:"items"`
+}
+
+// WithCapture перед каждым Process сохраняет батч в dir, храня последние maxBatches батчей.
+// Элементы кодируются через encoding/json. Ошибка записи останавливает Pipe, как и ошибка журнала событий:
+// захват, в котором молча нет части батчей, при разборе инцидента хуже, чем никакого.
+// Запись идёт синхронно перед Process, так что на больших батчах это заметная задержка.
+func WithCapture(dir string, maxBatches int) Option {
+	return func(cfg *config) {
+		cfg.capture = &capture{dir: dir, max: maxBatches}
+	}
+}
+
+// capture - кольцо файлов с последними батчами
+type capture struct {
+	dir string
+	max int
+
+	once  sync.Once
+	mkErr error
+}
+
+// write пишет батч в его слот кольца. Сначала во временный файл, потом rename - чтобы при падении
+// посреди записи в слоте остался прошлый целый батч.
+func (c *capture) write(meta BatchMeta, items any) error {
+	if c == nil {
+		return nil
+	}
+	c.once.Do(func() {
+		c.mkErr = os.MkdirAll(c.dir, 0o755)
+	})
+	if c.mkErr != nil {
+		return c.mkErr
+	}
+
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("capture batch %d: %w", meta.Seq, err)
+	}
+	var encoded []json.RawMessage
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		return fmt.Errorf("capture batch %d: %w", meta.Seq, err)
+	}
+	data, err := json.Marshal(CapturedBatch{
+		Time:    time.Now(),
+		Seq:     meta.Seq,
+		Cookies: meta.Cookies,
+		Spans:   meta.Spans,
+		Hash:    meta.Hash,
+		Items:   encoded,
+	})
+	if err != nil {
+		return fmt.Errorf("capture batch %d: %w", meta.Seq, err)
+	}
+
+	slot := filepath.Join(c.dir, fmt.Sprintf("batch-%d.json", meta.Seq%uint64(c.max)))
+	tmp := slot + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, slot)
+}
+
+// ReadCapture читает батчи, сохранённые WithCapture, от старых к новым
+func ReadCapture(dir string) ([]CapturedBatch, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "batch-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make([]CapturedBatch, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var b CapturedBatch
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		batches = append(batches, b)
+	}
+	// По времени записи, а не по Seq: в кольце могут остаться батчи прошлого запуска
+	sort.Slice(batches, func(i, j int) bool {
+		if !batches[i].Time.Equal(batches[j].Time) {
+			return batches[i].Time.Before(batches[j].Time)
+		}
+		return batches[i].Seq < batches[j].Seq
+	})
+	return batches, nil
+}
@@ -0,0 +1,45 @@
+package pipe
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithCaptureKeepsLastBatches(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "capture")
+	p := &listProducer{chunks: [][]any{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}}
+
+	err := Pipe(p, &itemsConsumer{p: p, hint: 1}, WithInlineMode(), WithCapture(dir, 3))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	batches, err := ReadCapture(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Отправлено 5 батчей, в кольце три последних
+	if len(batches) != 3 {
+		t.Fatalf("captured %d batches, want 3", len(batches))
+	}
+	for i, b := range batches {
+		var item string
+		if err := json.Unmarshal(b.Items[0], &item); err != nil {
+			t.Fatal(err)
+		}
+		want := string(rune('c' + i))
+		if b.Seq != uint64(i+3) || item != want || len(b.Cookies) != 1 || b.Cookies[0] != i+3 {
+			t.Fatalf("batch %d = seq %d, item %q, cookies %v; want seq %d, item %q", i, b.Seq, item, b.Cookies, i+3, want)
+		}
+	}
+}
+
+func TestWithCaptureValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithCapture("", 0))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want two WithCapture problems", err)
+	}
+}
@@ -0,0 +1,178 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/*
+Режим догона. После простоя или при резком всплеске источник отстаёт на миллионы записей, и настройки,
+подобранные под ровный поток (маленькие батчи, WithMinItems, один обработчик), только тянут время.
+С WithCatchUp Pipe сам спрашивает лаг и, пока он выше порога, собирает полные батчи, не ждёт WithMinItems
+и обрабатывает больше батчей одновременно. Догнал - возвращается к обычным настройкам.
+*/
+
+// DefaultCatchUpInterval - как часто по умолчанию спрашиваем лаг источника
+const DefaultCatchUpInterval = 10 * time.Second
+
+// LagReporter - опциональный интерфейс источника: на сколько записей он отстаёт от головы потока
+// (например, сумма лагов партиций консюмер-группы). Вызывается из отдельной горутины, параллельно с Next.
+type LagReporter interface {
+	Lag(ctx context.Context) (int64, error)
+}
+
+// CatchUp - настройки WithCatchUp
+type CatchUp struct {
+	// Лаг выше Enter - включаем догон, не выше Exit - выключаем. Exit 0 - половина Enter
+	Enter int64
+	Exit  int64
+	// Как часто спрашиваем лаг, 0 - DefaultCatchUpInterval
+	Interval time.Duration
+	// Сколько батчей обрабатываем одновременно в догоне, 0 - как обычно (WithWorkers)
+	Workers int
+	// Откуда брать лаг, nil - у источника (LagReporter)
+	Lag func(ctx context.Context) (int64, error)
+	// Вызывается на каждой смене режима, синхронно из горутины опроса лага
+	OnChange func(CatchUpChange)
+}
+
+// CatchUpChange - смена режима: Active - догон начался, иначе закончился
+type CatchUpChange struct {
+	Active bool
+	Lag    int64
+	Time   time.Time
+}
+
+// WithCatchUp включает догон, пока лаг источника выше c.Enter: батчи всегда по MaxItems (подсказку
+// BatchSizer не слушаем), WithMinItems не ждём, обработчиков - c.Workers. Commit по-прежнему по порядку,
+// если нет WithUnorderedCommits. Ошибка при запросе лага не останавливает Pipe: её пишет WithLogger,
+// а режим остаётся прежним.
+func WithCatchUp(c CatchUp) Option {
+	return func(cfg *config) {
+		cfg.catchUp = &c
+	}
+}
+
+// poolSize - сколько обработчиков запускаем. С WithCatchUp лишние ждут догона
+func (cfg *config) poolSize() int {
+	n := max(cfg.workers, 1)
+	if cfg.catchUp != nil {
+		n = max(n, cfg.catchUp.Workers)
+	}
+	return n
+}
+
+// catchUpMode - текущий режим запуска. nil - догона нет
+type catchUpMode struct {
+	CatchUp
+
+	mu     sync.Mutex
+	active bool
+	// Закрывается на каждой смене режима
+	changed chan struct{}
+}
+
+// newCatchUpMode берёт лаг из опции или из источника
+func newCatchUpMode(cfg *config, p any) (*catchUpMode, error) {
+	if cfg.catchUp == nil {
+		return nil, nil
+	}
+	m := &catchUpMode{CatchUp: *cfg.catchUp, changed: make(chan struct{})}
+	if m.Exit == 0 {
+		m.Exit = m.Enter / 2
+	}
+	if m.Interval == 0 {
+		m.Interval = DefaultCatchUpInterval
+	}
+	if m.Lag == nil {
+		lr, ok := p.(LagReporter)
+		if !ok {
+			return nil, &ConfigError{Problems: []ConfigProblem{{Option: "WithCatchUp",
+				Problem: fmt.Sprintf("producer %T has no Lag", p), Suggestion: "pass a Lag func"}}}
+		}
+		m.Lag = lr.Lag
+	}
+	return m, nil
+}
+
+// state - идёт ли догон и канал, который закроется на следующей смене
+func (m *catchUpMode) state() (bool, <-chan struct{}) {
+	if m == nil {
+		return false, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active, m.changed
+}
+
+func (m *catchUpMode) isActive() bool {
+	active, _ := m.state()
+	return active
+}
+
+// batchLimit - в догоне батч всегда полный
+func (m *catchUpMode) batchLimit(ctx context.Context, c any) int {
+	if m.isActive() {
+		return MaxItems
+	}
+	return batchLimit(ctx, c)
+}
+
+// loop раз в Interval спрашивает лаг и переключает режим, пока не отменят ctx или не закроют stop
+func (m *catchUpMode) loop(ctx context.Context, cfg *config, stop <-chan struct{}) {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.check(ctx, cfg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check - один опрос лага
+func (m *catchUpMode) check(ctx context.Context, cfg *config) {
+	lag, err := m.Lag(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			cfg.log(ctx, slog.LevelWarn, "catch-up lag check failed", slog.Any("error", err))
+		}
+		return
+	}
+
+	m.mu.Lock()
+	active := m.active
+	switch {
+	case !active && lag > m.Enter:
+		active = true
+	case active && lag <= m.Exit:
+		active = false
+	}
+	if active == m.active {
+		m.mu.Unlock()
+		return
+	}
+	m.active = active
+	close(m.changed)
+	m.changed = make(chan struct{})
+	m.mu.Unlock()
+
+	if active {
+		cfg.log(ctx, slog.LevelInfo, "catch-up started", slog.Int64("lag", lag), slog.Int("workers", cfg.poolSize()))
+	} else {
+		cfg.log(ctx, slog.LevelInfo, "catch-up finished", slog.Int64("lag", lag))
+	}
+	if m.OnChange != nil {
+		m.OnChange(CatchUpChange{Active: active, Lag: lag, Time: time.Now()})
+	}
+}
@@ -0,0 +1,162 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithCatchUpBatchLimit(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	c := &sizedConsumer{hint: 100}
+	var lag atomic.Int64
+	lag.Store(5000)
+	changes := make(chan CatchUpChange, 10)
+	pl := NewPipeline(src, c, WithMaxBatchDelay(time.Hour), WithCatchUp(CatchUp{
+		Enter:    1000,
+		Interval: 5 * time.Millisecond,
+		Lag:      func(ctx context.Context) (int64, error) { return lag.Load(), nil },
+		OnChange: func(ch CatchUpChange) { changes <- ch },
+	}))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if ch := <-changes; !ch.Active || ch.Lag != 5000 {
+		t.Fatalf("first change = %+v, want catch-up started at lag 5000", ch)
+	}
+
+	// Подсказку консюмера (100) в догоне не слушаем. Лимит уже начатого батча мог остаться прежним
+	chunk := make([]any, 100)
+	feed(src, chunk, chunk, chunk, chunk)
+	lag.Store(100)
+	if ch := <-changes; ch.Active || ch.Lag != 100 {
+		t.Fatalf("second change = %+v, want catch-up finished at lag 100", ch)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{400}) && !reflect.DeepEqual(got, []int{100, 300}) {
+		t.Errorf("batches = %v, want [400] or [100 300]", got)
+	}
+}
+
+func TestWithCatchUpWorkers(t *testing.T) {
+	p := &testProducer{chunks: 12, chunkSize: MaxItems}
+	c := &concurrencyConsumer{}
+	var calls atomic.Int32
+	err := Pipe(finiteProducer{p}, c, WithCatchUp(CatchUp{
+		Enter:   1000,
+		Workers: 3,
+		// Догон только со второго опроса: первые батчи идут одним обработчиком
+		Interval: 20 * time.Millisecond,
+		Lag: func(ctx context.Context) (int64, error) {
+			if calls.Add(1) == 1 {
+				return 0, nil
+			}
+			return 5000, nil
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if c.first > 1 {
+		t.Errorf("%d batches processed at once before catch-up, want 1", c.first)
+	}
+	if c.max < 2 {
+		t.Errorf("at most %d batches processed at once in catch-up, want more", c.max)
+	}
+	want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	if got := p.commits(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commits = %v, want %v", got, want)
+	}
+}
+
+// concurrencyConsumer считает, сколько Process шло одновременно: first - за первые два батча, max - всего
+type concurrencyConsumer struct {
+	mu      sync.Mutex
+	running int
+	batches int
+	first   int
+	max     int
+}
+
+func (c *concurrencyConsumer) Process(ctx context.Context, items []any) error {
+	c.mu.Lock()
+	c.running++
+	c.batches++
+	if c.batches <= 2 {
+		c.first = max(c.first, c.running)
+	}
+	c.max = max(c.max, c.running)
+	c.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return nil
+}
+
+func TestWithCatchUpLagErrorKeepsMode(t *testing.T) {
+	errLag := errors.New("lag unavailable")
+	var changes []CatchUpChange
+	_, err := newCatchUpMode(&config{catchUp: &CatchUp{Enter: 10, OnChange: func(ch CatchUpChange) { changes = append(changes, ch) }}}, nil)
+	if err == nil {
+		t.Fatal("newCatchUpMode() without Lag or LagReporter error = nil")
+	}
+	lags := []any{20, errLag, 8, 5}
+	m, err := newCatchUpMode(&config{catchUp: &CatchUp{Enter: 10, OnChange: func(ch CatchUpChange) { changes = append(changes, ch) },
+		Lag: func(ctx context.Context) (int64, error) {
+			l := lags[0]
+			lags = lags[1:]
+			if err, ok := l.(error); ok {
+				return 0, err
+			}
+			return int64(l.(int)), nil
+		}}}, nil)
+	if err != nil {
+		t.Fatalf("newCatchUpMode() error = %v", err)
+	}
+	var active []bool
+	for i := 0; i < 4; i++ {
+		m.check(context.Background(), &config{})
+		active = append(active, m.isActive())
+	}
+	// Ошибка режим не меняет, 8 - ещё выше Exit (половины Enter)
+	if want := []bool{true, true, true, false}; !reflect.DeepEqual(active, want) {
+		t.Errorf("active after each check = %v, want %v", active, want)
+	}
+	if len(changes) != 2 || !changes[0].Active || changes[1].Active || changes[1].Lag != 5 {
+		t.Errorf("changes = %+v, want started at 20 and finished at 5", changes)
+	}
+}
+
+func TestWithCatchUpConfig(t *testing.T) {
+	lag := func(ctx context.Context) (int64, error) { return 0, nil }
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"no enter", []Option{WithCatchUp(CatchUp{Lag: lag})}},
+		{"exit above enter", []Option{WithCatchUp(CatchUp{Enter: 10, Exit: 20, Lag: lag})}},
+		{"inline workers", []Option{WithInlineMode(), WithCatchUp(CatchUp{Enter: 10, Workers: 4, Lag: lag})}},
+		{"no lag", []Option{WithCatchUp(CatchUp{Enter: 10})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Pipe(&testProducer{}, &testConsumer{}, tt.opts...)
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 || cfgErr.Problems[0].Option != "WithCatchUp" {
+				t.Fatalf("Pipe() error = %v, want a WithCatchUp problem", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package pipe
+
+import "context"
+
+/*
+Переходники для кода, который уже построен на каналах: не нужно писать свой Producer или Consumer
+ради того, чтобы прочитать канал или положить в него батч.
+*/
+
+// ChanProducer читает элементы из ch. Next ждёт первый элемент и забирает всё, что уже лежит в канале,
+// но не больше свободного места в батче (BatchCapacity). Закрытый и вычитанный канал - ErrEndOfStream.
+// cookie синтезирует cookie пачки по её порядковому номеру с 1 (nil - сам номер), commit подтверждает
+// обработку (nil - подтверждать некому). Next и Commit зовутся из разных горутин Pipe - commit и cookie
+// должны это выдерживать.
+func ChanProducer[T any](ch <-chan T, commit func(ctx context.Context, cookie int) error, cookie func(seq int, items []T) int) ProducerOf[T] {
+	return &chanSource[T]{ch: ch, commit: commit, cookie: cookie}
+}
+
+type chanSource[T any] struct {
+	ch     <-chan T
+	commit func(ctx context.Context, cookie int) error
+	cookie func(seq int, items []T) int
+	// Сколько пачек отдано (Next не зовут параллельно)
+	seq int
+}
+
+func (s *chanSource[T]) Next(ctx context.Context) ([]T, int, error) {
+	limit := MaxItems
+	if n, ok := BatchCapacity(ctx); ok && n > 0 {
+		limit = n
+	}
+
+	var items []T
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case item, ok := <-s.ch:
+		if !ok {
+			return nil, 0, ErrEndOfStream
+		}
+		items = append(items, item)
+	}
+	// Добираем то, что уже есть, не дожидаясь новых элементов. Закрытие канала увидит следующий Next
+drain:
+	for len(items) < limit {
+		select {
+		case item, ok := <-s.ch:
+			if !ok {
+				break drain
+			}
+			items = append(items, item)
+		default:
+			break drain
+		}
+	}
+
+	s.seq++
+	if s.cookie == nil {
+		return items, s.seq, nil
+	}
+	return items, s.cookie(s.seq, items), nil
+}
+
+func (s *chanSource[T]) Commit(ctx context.Context, cookie int) error {
+	if s.commit == nil {
+		return nil
+	}
+	return s.commit(ctx, cookie)
+}
+
+// ChanConsumer кладёт каждый батч в ch (своей копией слайса) и считает его обработанным, как только
+// канал его принял - дальше за данные отвечает читатель канала. Пока канал полон, Process ждёт,
+// отмена контекста батча - ошибка Process.
+func ChanConsumer[T any](ch chan<- []T) ConsumerOf[T] {
+	return &chanSink[T]{ch: ch}
+}
+
+type chanSink[T any] struct {
+	ch chan<- []T
+}
+
+func (s *chanSink[T]) Process(ctx context.Context, items []T) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.ch <- append([]T(nil), items...):
+		return nil
+	}
+}
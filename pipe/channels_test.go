@@ -0,0 +1,87 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestChanAdapters(t *testing.T) {
+	in := make(chan int, 25000)
+	for i := 1; i <= 25000; i++ {
+		in <- i
+	}
+	close(in)
+
+	var mu sync.Mutex
+	var committed []int
+	commit := func(ctx context.Context, cookie int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		committed = append(committed, cookie)
+		return nil
+	}
+	// Cookie - последний элемент пачки, как оффсет
+	lastItem := func(seq int, items []int) int { return items[len(items)-1] }
+	out := make(chan []int, 10)
+
+	if err := PipeOf(ChanProducer(in, commit, lastItem), ChanConsumer(out)); err != nil {
+		t.Fatalf("PipeOf() error = %v", err)
+	}
+	close(out)
+
+	var got []int
+	for batch := range out {
+		if len(batch) > MaxItems {
+			t.Fatalf("batch of %d items exceeds MaxItems", len(batch))
+		}
+		got = append(got, batch...)
+	}
+	if len(got) != 25000 || got[0] != 1 || got[24999] != 25000 {
+		t.Fatalf("got %d items, want 1..25000", len(got))
+	}
+	if n := len(committed); n == 0 || committed[n-1] != 25000 {
+		t.Fatalf("commits = %v, want the last one to be 25000", committed)
+	}
+}
+
+func TestChanProducerSequenceCookies(t *testing.T) {
+	in := make(chan string, 3)
+	in <- "a"
+	in <- "b"
+	in <- "c"
+	close(in)
+	p := ChanProducer[string](in, nil, nil)
+	// Пачка не больше свободного места в батче
+	ctx := context.WithValue(context.Background(), capacityKey{}, 2)
+
+	var chunks [][]string
+	var cookies []int
+	for {
+		items, cookie, err := p.Next(ctx)
+		if err != nil {
+			if !errors.Is(err, ErrEndOfStream) {
+				t.Fatalf("Next() error = %v, want %v", err, ErrEndOfStream)
+			}
+			break
+		}
+		chunks = append(chunks, items)
+		cookies = append(cookies, cookie)
+	}
+	if !reflect.DeepEqual(chunks, [][]string{{"a", "b"}, {"c"}}) || !reflect.DeepEqual(cookies, []int{1, 2}) {
+		t.Fatalf("chunks = %v, cookies = %v, want [[a b] [c]] and [1 2]", chunks, cookies)
+	}
+	if err := p.Commit(ctx, 1); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+}
+
+func TestChanConsumerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ChanConsumer(make(chan []int)).Process(ctx, []int{1}); err != context.Canceled {
+		t.Fatalf("Process() error = %v, want %v", err, context.Canceled)
+	}
+}
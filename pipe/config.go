@@ -0,0 +1,201 @@
+package pipe
+
+import (
+	"time"
+)
+
+// Config - настройки Pipe одной структурой, для тех, кто собирает их из своего конфига, а не из кода.
+// Нулевое значение поля - значение по умолчанию (или выключенная функция), как без соответствующей опции.
+// Настройки, которые без колбэка или канала не имеют смысла (WithGCStats, WithKeyStats, WithDeliveryReports,
+// WithStateHook, WithDedup и т.п.), задаются опциями рядом с WithConfig. Необязательные колбэки в структурах
+// (CircuitBreaker.OnStateChange, CatchUp.OnChange) можно заполнить и здесь.
+type Config struct {
+	// См. WithNextTimeout
+	NextTimeout time.Duration
+	NextRetries int
+	// См. WithMaxBatchDelay
+	MaxBatchDelay time.Duration
+	// См. WithMinItems
+	MinItems     int
+	MinItemsWait time.Duration
+	// См. WithLeaseRenewInterval, 0 - DefaultLeaseRenewInterval
+	LeaseRenewInterval time.Duration
+	// См. WithInlineMode
+	Inline bool
+	// См. WithWorkers
+	Workers int
+	// См. WithArena
+	ArenaSlabSize int
+	// См. WithMemoryThrottle
+	MemoryThrottle float64
+	// См. WithLargeItems
+	LargeItems *LargeItems
+	// См. WithNextRetry (повторы на ошибках Next, а NextRetries выше - на таймаутах)
+	NextRetry *RetryPolicy
+	// См. WithProcessRetry
+	ProcessRetry *RetryPolicy
+	// См. WithCommitRetry
+	CommitRetry *CommitRetry
+	// См. WithDeadLetter
+	DeadLetter DeadLetter
+	// См. WithLatencySLO
+	LatencySLO *LatencySLO
+	// См. WithEventLog
+	EventLog EventLog
+	// См. WithTags
+	Tags map[string]string
+	// См. WithDefensiveCopies
+	DefensiveCopies bool
+	// См. WithBatchHash (с кодированием элементов по умолчанию)
+	BatchHash bool
+	// См. WithDrainTimeout
+	DrainTimeout time.Duration
+	// См. WithQuietPeriod
+	QuietPeriod time.Duration
+	// См. WithBatchDeadline
+	BatchDeadline time.Duration
+	// См. WithUnorderedCommits
+	UnorderedCommits bool
+	// См. WithCanceledAsFailure
+	CanceledAsFailure bool
+	// См. WithShutdownTimeouts
+	ShutdownTimeouts *ShutdownTimeouts
+	// См. WithRateLimit
+	RateLimit      float64
+	RateLimitBurst int
+	// См. WithBatchRateLimit
+	BatchRateLimit      float64
+	BatchRateLimitBurst int
+	// См. WithAdaptiveQueue
+	QueueMinDepth int
+	QueueMaxDepth int
+	// См. WithErrorBudget
+	MaxErrorRate      float64
+	ErrorBudgetWindow time.Duration
+	// См. WithCircuitBreaker
+	CircuitBreaker *CircuitBreaker
+	// См. WithCatchUp
+	CatchUp *CatchUp
+	// См. WithCapture
+	CaptureDir     string
+	CaptureBatches int
+	// См. WithCrashDumpDir
+	CrashDumpDir string
+	// См. WithProfiling
+	Profiling bool
+}
+
+// WithConfig применяет настройки из c. Опции после неё перекрывают то, что задано в c.
+func WithConfig(c Config) Option {
+	return func(cfg *config) {
+		var opts []Option
+		if c.NextTimeout != 0 || c.NextRetries != 0 {
+			opts = append(opts, WithNextTimeout(c.NextTimeout, c.NextRetries))
+		}
+		if c.MaxBatchDelay != 0 {
+			opts = append(opts, WithMaxBatchDelay(c.MaxBatchDelay))
+		}
+		if c.MinItems != 0 || c.MinItemsWait != 0 {
+			opts = append(opts, WithMinItems(c.MinItems, c.MinItemsWait))
+		}
+		if c.LeaseRenewInterval != 0 {
+			opts = append(opts, WithLeaseRenewInterval(c.LeaseRenewInterval))
+		}
+		if c.Inline {
+			opts = append(opts, WithInlineMode())
+		}
+		if c.Workers != 0 {
+			opts = append(opts, WithWorkers(c.Workers))
+		}
+		if c.ArenaSlabSize != 0 {
+			opts = append(opts, WithArena(c.ArenaSlabSize))
+		}
+		if c.MemoryThrottle != 0 {
+			opts = append(opts, WithMemoryThrottle(c.MemoryThrottle))
+		}
+		if c.LargeItems != nil {
+			opts = append(opts, WithLargeItems(*c.LargeItems))
+		}
+		if c.NextRetry != nil {
+			opts = append(opts, WithNextRetry(*c.NextRetry))
+		}
+		if c.ProcessRetry != nil {
+			opts = append(opts, WithProcessRetry(*c.ProcessRetry))
+		}
+		if c.CommitRetry != nil {
+			opts = append(opts, WithCommitRetry(*c.CommitRetry))
+		}
+		if c.DeadLetter != nil {
+			opts = append(opts, WithDeadLetter(c.DeadLetter))
+		}
+		if c.LatencySLO != nil {
+			opts = append(opts, WithLatencySLO(*c.LatencySLO))
+		}
+		if c.EventLog != nil {
+			opts = append(opts, WithEventLog(c.EventLog))
+		}
+		if len(c.Tags) > 0 {
+			opts = append(opts, WithTags(c.Tags))
+		}
+		if c.DefensiveCopies {
+			opts = append(opts, WithDefensiveCopies())
+		}
+		if c.BatchHash {
+			opts = append(opts, WithBatchHash(nil))
+		}
+		if c.DrainTimeout != 0 {
+			opts = append(opts, WithDrainTimeout(c.DrainTimeout))
+		}
+		if c.QuietPeriod != 0 {
+			opts = append(opts, WithQuietPeriod(c.QuietPeriod))
+		}
+		if c.BatchDeadline != 0 {
+			opts = append(opts, WithBatchDeadline(c.BatchDeadline))
+		}
+		if c.UnorderedCommits {
+			opts = append(opts, WithUnorderedCommits())
+		}
+		if c.CanceledAsFailure {
+			opts = append(opts, WithCanceledAsFailure())
+		}
+		if c.ShutdownTimeouts != nil {
+			opts = append(opts, WithShutdownTimeouts(*c.ShutdownTimeouts))
+		}
+		if c.RateLimit != 0 || c.RateLimitBurst != 0 {
+			opts = append(opts, WithRateLimit(c.RateLimit, c.RateLimitBurst))
+		}
+		if c.BatchRateLimit != 0 || c.BatchRateLimitBurst != 0 {
+			opts = append(opts, WithBatchRateLimit(c.BatchRateLimit, c.BatchRateLimitBurst))
+		}
+		if c.QueueMinDepth != 0 || c.QueueMaxDepth != 0 {
+			opts = append(opts, WithAdaptiveQueue(c.QueueMinDepth, c.QueueMaxDepth))
+		}
+		if c.MaxErrorRate != 0 || c.ErrorBudgetWindow != 0 {
+			opts = append(opts, WithErrorBudget(c.MaxErrorRate, c.ErrorBudgetWindow))
+		}
+		if c.CircuitBreaker != nil {
+			opts = append(opts, WithCircuitBreaker(*c.CircuitBreaker))
+		}
+		if c.CatchUp != nil {
+			opts = append(opts, WithCatchUp(*c.CatchUp))
+		}
+		if c.CaptureDir != "" || c.CaptureBatches != 0 {
+			opts = append(opts, WithCapture(c.CaptureDir, c.CaptureBatches))
+		}
+		if c.CrashDumpDir != "" {
+			opts = append(opts, WithCrashDumpDir(c.CrashDumpDir))
+		}
+		if c.Profiling {
+			opts = append(opts, WithProfiling())
+		}
+		for _, opt := range opts {
+			opt(cfg)
+		}
+	}
+}
+
+// Validate проверяет настройки так же, как Pipe перед стартом. Ошибка - *ConfigError.
+func (c Config) Validate() error {
+	_, err := newConfig([]Option{WithConfig(c)})
+	return err
+}
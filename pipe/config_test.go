@@ -0,0 +1,94 @@
+package pipe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithConfigAppliesSettings(t *testing.T) {
+	cfg, err := newConfig([]Option{WithConfig(Config{
+		NextTimeout: time.Second,
+		NextRetries: 2,
+		Inline:      true,
+		Tags:        map[string]string{"team": "ingest"},
+		BatchHash:   true,
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.nextTimeout != time.Second || cfg.nextRetries != 2 || !cfg.inline || cfg.tags["team"] != "ingest" || !cfg.batchHash {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if cfg.leaseRenewInterval != DefaultLeaseRenewInterval {
+		t.Fatalf("zero LeaseRenewInterval changed the default to %s", cfg.leaseRenewInterval)
+	}
+}
+
+func TestWithConfigAppliesStopAndLimitSettings(t *testing.T) {
+	cfg, err := newConfig([]Option{WithConfig(Config{
+		DrainTimeout:        time.Minute,
+		QuietPeriod:         time.Second,
+		Workers:             2,
+		UnorderedCommits:    true,
+		CanceledAsFailure:   true,
+		ShutdownTimeouts:    &ShutdownTimeouts{Flush: time.Second},
+		RateLimit:           100,
+		RateLimitBurst:      10,
+		BatchRateLimit:      2,
+		BatchRateLimitBurst: 1,
+		MaxErrorRate:        0.5,
+		ErrorBudgetWindow:   time.Minute,
+		Profiling:           true,
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.drainTimeout != time.Minute || cfg.quietPeriod != time.Second || !cfg.unorderedCommits || !cfg.canceledAsFailure {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if cfg.shutdownTimeouts.Flush != time.Second || cfg.itemsRate == nil || cfg.batchesRate == nil || cfg.errorBudget == nil || !cfg.profiling {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if cfg.itemsRate.rate != 100 || cfg.itemsRate.burst != 10 {
+		t.Fatalf("rate limit = %v/%d, want 100/10", cfg.itemsRate.rate, cfg.itemsRate.burst)
+	}
+
+	if err := (Config{RateLimit: -1, BatchDeadline: -time.Second}).Validate(); err == nil {
+		t.Fatal("Validate() accepted a negative rate limit and batch deadline")
+	}
+}
+
+func TestWithConfigOverriddenByLaterOptions(t *testing.T) {
+	cfg, err := newConfig([]Option{WithConfig(Config{LeaseRenewInterval: time.Minute}), WithLeaseRenewInterval(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.leaseRenewInterval != time.Second {
+		t.Fatalf("lease renew interval = %s, want 1s", cfg.leaseRenewInterval)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	err := Config{NextTimeout: -time.Second, MemoryThrottle: 2}.Validate()
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Validate() = %v, want two problems", err)
+	}
+	if err := (Config{}).Validate(); err != nil {
+		t.Fatalf("zero Config is invalid: %v", err)
+	}
+}
+
+func TestWithMaxBatchDelayValidation(t *testing.T) {
+	if err := (Config{MaxBatchDelay: -time.Second}).Validate(); err == nil {
+		t.Fatal("Validate() accepted a negative MaxBatchDelay")
+	}
+	cfg, err := newConfig([]Option{WithConfig(Config{MaxBatchDelay: time.Second})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.maxBatchDelay != time.Second {
+		t.Fatalf("max batch delay = %s, want 1s", cfg.maxBatchDelay)
+	}
+}
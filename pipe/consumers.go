@@ -0,0 +1,68 @@
+package pipe
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+/*
+Консюмеры для пробных прогонов: подставляем их вместо настоящего приёмника и меряем,
+сколько вытягивают источник и сам Pipe в условиях, похожих на прод.
+*/
+
+// NullConsumer ничего не делает с батчами - верхняя граница пропускной способности источника и Pipe
+type NullConsumer struct{}
+
+func (NullConsumer) Process(ctx context.Context, items []any) error {
+	return nil
+}
+
+// Latency - сколько должна длиться обработка батча из items элементов
+type Latency func(items int) time.Duration
+
+// FixedLatency - каждая обработка длится d
+func FixedLatency(d time.Duration) Latency {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency - задержка равномерно распределена в [min, max)
+func UniformLatency(min, max time.Duration) Latency {
+	return func(int) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rand.Int63n(int64(max-min)))
+	}
+}
+
+// LinearLatency - base плюс perItem на каждый элемент, как у вставки в базу
+func LinearLatency(base, perItem time.Duration) Latency {
+	return func(items int) time.Duration {
+		return base + time.Duration(items)*perItem
+	}
+}
+
+// DelayConsumer имитирует приёмник: ничего не пишет, но на каждый батч ждёт задержку из latency.
+// Отмену контекста уважает, как и положено нормальному приёмнику.
+func DelayConsumer(latency Latency) Consumer {
+	return delayConsumer{latency: latency}
+}
+
+type delayConsumer struct {
+	latency Latency
+}
+
+func (c delayConsumer) Process(ctx context.Context, items []any) error {
+	t := time.NewTimer(c.latency(len(items)))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNullConsumer(t *testing.T) {
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	if err := Pipe(p, NullConsumer{}, WithInlineMode()); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	checkCommitsInOrder(t, p.commits())
+	if len(p.commits()) != 7 {
+		t.Fatalf("commits = %v, want 7", p.commits())
+	}
+}
+
+func TestDelayConsumer(t *testing.T) {
+	c := DelayConsumer(LinearLatency(10*time.Millisecond, time.Millisecond))
+
+	start := time.Now()
+	if err := c.Process(context.Background(), make([]any, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 30*time.Millisecond {
+		t.Fatalf("Process took %s, want at least 30ms", took)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DelayConsumer(FixedLatency(time.Hour)).Process(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Process() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestUniformLatency(t *testing.T) {
+	l := UniformLatency(time.Millisecond, 2*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if d := l(1); d < time.Millisecond || d >= 2*time.Millisecond {
+			t.Fatalf("latency %s outside [1ms, 2ms)", d)
+		}
+	}
+	if d := UniformLatency(time.Second, time.Second)(1); d != time.Second {
+		t.Fatalf("degenerate range latency = %s", d)
+	}
+}
@@ -0,0 +1,117 @@
+package pipe
+
+import "context"
+
+/*
+Какой контекст получает каждый метод адаптеров. На это можно опираться:
+
+  - OnStart (Starter) получает контекст запуска Pipe, до первого Next. OnStop (Stopper) - контекст запуска
+    без отмены, после того как Pipe всё остановил.
+  - Next получает контекст запуска Pipe. Он отменяется, когда Pipe останавливается (по ошибке или
+    завершению). В нём есть BatchCapacity и, с WithArena, ArenaFromContext. С WithNextTimeout у каждого
+    вызова свой дедлайн поверх контекста запуска. BatchContext в Next не бывает - батч ещё не собран.
+  - Process получает контекст попытки: он порождён контекстом батча, в нём есть BatchContext
+    и AttemptFromContext (номер попытки с 1, с WithProcessRetry растёт на каждом повторе).
+  - HandleFailed (DeadLetter) получает контекст батча, попытки в нём нет. Для элементов, выкинутых
+    преобразованием (ItemErrorsDeadLetter), - контекст запуска: батча ещё нет.
+  - Transform.Apply получает контекст запуска, без BatchCapacity и BatchContext.
+  - Commit получает контекст батча, к которому относится cookie: BatchContext есть, попытки нет.
+  - Контекст запуска порождён базовым (WithBaseContext, по умолчанию context.Background()), так что
+    значения из базового - тенант, регион и т.п. - видны во всех вызовах, включая OnStart и OnStop.
+  - Контекст батча порождён контекстом запуска и отменяется сразу после того, как батч закоммичен
+    или обработка упала - горутины адаптера, привязанные к нему, не переживут батч.
+*/
+
+// BatchMeta - описание батча, который сейчас обрабатывается
+type BatchMeta struct {
+	// Порядковый номер батча в рамках запуска Pipe
+	Seq   uint64
+	Items int
+	// Cookie батча в порядке коммита
+	Cookies []int
+	// Из каких пачек Next собран батч, по порядку элементов. Пачка, разрезанная между батчами
+	// (WithLargeItems, WithBoundaries), попадает в Spans каждого из них, а в Cookies - только последнего.
+	Spans []CookieSpan
+	// Хеш содержимого батча, "" без WithBatchHash
+	Hash string
+}
+
+// CookieSpan - элементы батча, пришедшие из одной пачки Next
+type CookieSpan struct {
+	Cookie int
+	// Позиция первого элемента пачки в батче и сколько их
+	Offset int
+	Items  int
+}
+
+// CookieOf возвращает cookie пачки, из которой пришёл i-й элемент батча -
+// чтобы понять, в какой пачке источника лежали плохие строки
+func (m BatchMeta) CookieOf(i int) (int, bool) {
+	for _, s := range m.Spans {
+		if i >= s.Offset && i < s.Offset+s.Items {
+			return s.Cookie, true
+		}
+	}
+	return 0, false
+}
+
+// appendSpan добавляет пачку к описанию батча, склеивая соседние куски одной пачки
+func appendSpan(spans []CookieSpan, span CookieSpan) []CookieSpan {
+	if n := len(spans); n > 0 {
+		last := &spans[n-1]
+		if last.Cookie == span.Cookie && last.Offset+last.Items == span.Offset {
+			last.Items += span.Items
+			return spans
+		}
+	}
+	return append(spans, span)
+}
+
+// WithBaseContext задаёт базовый контекст запуска: fn вызывается один раз при старте Pipe, и все контексты
+// адаптеров порождены им. Берутся только значения - отмена и дедлайн базового контекста Pipe не останавливают.
+func WithBaseContext(fn func() context.Context) Option {
+	return func(cfg *config) {
+		cfg.baseContext = fn
+	}
+}
+
+// runBase - базовый контекст запуска без отмены
+func (cfg *config) runBase() context.Context {
+	if cfg.baseContext == nil {
+		return context.Background()
+	}
+	base := cfg.baseContext()
+	if base == nil {
+		return context.Background()
+	}
+	return context.WithoutCancel(base)
+}
+
+type batchKey struct{}
+
+type attemptKey struct{}
+
+// BatchContext возвращает описание батча, к которому относится вызов Process или Commit.
+// false - контекст не батча (например, Next).
+func BatchContext(ctx context.Context) (BatchMeta, bool) {
+	meta, ok := ctx.Value(batchKey{}).(BatchMeta)
+	return meta, ok
+}
+
+// AttemptFromContext возвращает номер попытки Process для текущего батча, начиная с 1.
+// false - контекст не попытки (например, Commit).
+func AttemptFromContext(ctx context.Context) (int, bool) {
+	attempt, ok := ctx.Value(attemptKey{}).(int)
+	return attempt, ok
+}
+
+// withBatch порождает контекст батча
+func withBatch(ctx context.Context, meta BatchMeta) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	return context.WithValue(ctx, batchKey{}, meta), cancel
+}
+
+// withAttempt порождает контекст попытки внутри контекста батча
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
@@ -0,0 +1,177 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// ctxProducer проверяет контексты Next и Commit
+type ctxProducer struct {
+	testProducer
+
+	ctxMu      sync.Mutex
+	violations []string
+	commitCtxs []context.Context
+}
+
+func (p *ctxProducer) violate(msg string) {
+	p.ctxMu.Lock()
+	defer p.ctxMu.Unlock()
+	p.violations = append(p.violations, msg)
+}
+
+func (p *ctxProducer) Next(ctx context.Context) ([]any, int, error) {
+	if _, ok := BatchContext(ctx); ok {
+		p.violate("Next got a batch context")
+	}
+	if _, ok := AttemptFromContext(ctx); ok {
+		p.violate("Next got an attempt context")
+	}
+	return p.testProducer.Next(ctx)
+}
+
+func (p *ctxProducer) Commit(ctx context.Context, cookie int) error {
+	meta, ok := BatchContext(ctx)
+	if !ok {
+		p.violate("Commit got no batch context")
+	} else if cookie < meta.Cookies[0] || cookie > meta.Cookies[len(meta.Cookies)-1] {
+		p.violate("Commit cookie outside of its batch")
+	}
+	if _, ok := AttemptFromContext(ctx); ok {
+		p.violate("Commit got an attempt context")
+	}
+	p.ctxMu.Lock()
+	p.commitCtxs = append(p.commitCtxs, ctx)
+	p.ctxMu.Unlock()
+	return p.testProducer.Commit(ctx, cookie)
+}
+
+// ctxConsumer запоминает BatchMeta и попытку каждого Process
+type ctxConsumer struct {
+	metas    []BatchMeta
+	attempts []int
+}
+
+func (c *ctxConsumer) Process(ctx context.Context, items []any) error {
+	meta, _ := BatchContext(ctx)
+	attempt, _ := AttemptFromContext(ctx)
+	c.metas = append(c.metas, meta)
+	c.attempts = append(c.attempts, attempt)
+	return nil
+}
+
+func TestContextGuarantees(t *testing.T) {
+	p := &ctxProducer{testProducer: testProducer{chunks: 7, chunkSize: 3000}}
+	c := &ctxConsumer{}
+
+	if err := Pipe(p, c, WithInlineMode()); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	if len(p.violations) > 0 {
+		t.Fatalf("context contract violated: %v", p.violations)
+	}
+	spans := func(first int) []CookieSpan {
+		return []CookieSpan{{Cookie: first, Offset: 0, Items: 3000}, {Cookie: first + 1, Offset: 3000, Items: 3000}, {Cookie: first + 2, Offset: 6000, Items: 3000}}
+	}
+	want := []BatchMeta{
+		{Seq: 1, Items: 9000, Cookies: []int{1, 2, 3}, Spans: spans(1)},
+		{Seq: 2, Items: 9000, Cookies: []int{4, 5, 6}, Spans: spans(4)},
+		{Seq: 3, Items: 3000, Cookies: []int{7}, Spans: spans(7)[:1]},
+	}
+	if !reflect.DeepEqual(c.metas, want) {
+		t.Fatalf("batch metas = %+v, want %+v", c.metas, want)
+	}
+	if !reflect.DeepEqual(c.attempts, []int{1, 1, 1}) {
+		t.Fatalf("attempts = %v, want [1 1 1]", c.attempts)
+	}
+	// Контекст батча отменяется, как только батч закоммичен
+	for i, ctx := range p.commitCtxs {
+		if ctx.Err() == nil {
+			t.Fatalf("batch context of commit %d outlived its batch", i)
+		}
+	}
+}
+
+func TestContextAccessorsOutsidePipe(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := BatchContext(ctx); ok {
+		t.Fatal("BatchContext reported a batch outside of Pipe")
+	}
+	if _, ok := AttemptFromContext(ctx); ok {
+		t.Fatal("AttemptFromContext reported an attempt outside of Pipe")
+	}
+}
+
+// Пачка, разрезанная между батчами, видна в Spans каждого из них
+func TestBatchMetaSpansWithSoloItem(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 1}, {1, 100, 2}, {1, 1, 1}, {1, 1, 1}}}
+	c := &metaConsumer{}
+
+	Pipe(p, c, WithInlineMode(), WithLargeItems(LargeItems{Threshold: 10, Size: intSize, Policy: LargeItemSolo}))
+
+	want := []BatchMeta{
+		{Seq: 1, Items: 3, Cookies: []int{1}, Spans: []CookieSpan{{Cookie: 1, Items: 2}, {Cookie: 2, Offset: 2, Items: 1}}},
+		{Seq: 2, Items: 1, Spans: []CookieSpan{{Cookie: 2, Items: 1}}},
+		{Seq: 3, Items: 4, Cookies: []int{2, 3}, Spans: []CookieSpan{{Cookie: 2, Items: 1}, {Cookie: 3, Offset: 1, Items: 3}}},
+		{Seq: 4, Items: 3, Cookies: []int{4}, Spans: []CookieSpan{{Cookie: 4, Items: 3}}},
+	}
+	if !reflect.DeepEqual(c.metas, want) {
+		t.Fatalf("metas = %+v, want %+v", c.metas, want)
+	}
+	if cookie, ok := c.metas[2].CookieOf(2); !ok || cookie != 3 {
+		t.Fatalf("CookieOf(2) = %d, %v, want 3", cookie, ok)
+	}
+	if _, ok := c.metas[2].CookieOf(4); ok {
+		t.Fatal("CookieOf() found an item outside of the batch")
+	}
+}
+
+// metaConsumer запоминает BatchMeta, батч до 4 элементов
+type metaConsumer struct {
+	metas []BatchMeta
+}
+
+func (c *metaConsumer) Process(ctx context.Context, items []any) error {
+	meta, _ := BatchContext(ctx)
+	c.metas = append(c.metas, meta)
+	return nil
+}
+
+func (c *metaConsumer) PreferredBatchSize(ctx context.Context) int {
+	return 4
+}
+
+type tenantKey struct{}
+
+// tenantConsumer запоминает тенант из контекста каждого Process
+type tenantConsumer struct {
+	tenants []any
+}
+
+func (c *tenantConsumer) Process(ctx context.Context, items []any) error {
+	c.tenants = append(c.tenants, ctx.Value(tenantKey{}))
+	return nil
+}
+
+func TestWithBaseContext(t *testing.T) {
+	base, cancel := context.WithCancel(context.WithValue(context.Background(), tenantKey{}, "acme"))
+	// Отмена базового контекста Pipe не останавливает - берутся только значения
+	cancel()
+
+	p := &testProducer{chunks: 2, chunkSize: 10}
+	c := &tenantConsumer{}
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithBaseContext(func() context.Context { return base }))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if !reflect.DeepEqual(c.tenants, []any{"acme"}) {
+		t.Errorf("tenants = %v, want [acme]", c.tenants)
+	}
+	if got := p.commits(); len(got) != 2 {
+		t.Errorf("commits = %v, want [1 2]", got)
+	}
+}
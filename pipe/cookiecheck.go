@@ -0,0 +1,82 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCookieOrder - источник вернул cookie не по порядку (см. WithCookieCheck)
+var ErrCookieOrder = errors.New("producer returned cookies out of order")
+
+// CookieProblemKind - что не так с cookie
+type CookieProblemKind string
+
+const (
+	// Cookie не больше предыдущего
+	CookieNotIncreasing CookieProblemKind = "not_increasing"
+	// Cookie больше предыдущего больше чем на 1 (только с CookieCheck.Contiguous)
+	CookieGap CookieProblemKind = "gap"
+)
+
+// CookieProblem - найденное нарушение порядка cookie
+type CookieProblem struct {
+	Kind   CookieProblemKind
+	Prev   int
+	Cookie int
+}
+
+func (p CookieProblem) String() string {
+	return fmt.Sprintf("%s: cookie %d after %d", p.Kind, p.Cookie, p.Prev)
+}
+
+// CookieCheck - настройки проверки cookie
+type CookieCheck struct {
+	// Cookie должны идти подряд, без пропусков (номера сообщений, offset'ы одной партиции).
+	// false - проверяется только строгий рост
+	Contiguous bool
+	// Куда сообщать о проблемах. nil - проблема останавливает Pipe с ErrCookieOrder
+	Warn func(CookieProblem)
+}
+
+// WithCookieCheck проверяет cookie каждой непустой пачки Next против предыдущей. Ловит сломанные адаптеры
+// источника до того, как они испортят коммиты. Подходит только источникам с числовыми растущими cookie.
+func WithCookieCheck(check CookieCheck) Option {
+	return func(cfg *config) {
+		cfg.cookieCheck = &check
+	}
+}
+
+// cookieChecker помнит последний cookie текущего запуска
+type cookieChecker struct {
+	check *CookieCheck
+	prev  int
+	seen  bool
+}
+
+// observe проверяет очередной cookie. Ошибка - только когда Warn не задан
+func (c *cookieChecker) observe(cookie int) error {
+	if c.check == nil {
+		return nil
+	}
+	prev, seen := c.prev, c.seen
+	c.prev, c.seen = cookie, true
+	if !seen {
+		return nil
+	}
+
+	var problem CookieProblem
+	switch {
+	case cookie <= prev:
+		problem = CookieProblem{Kind: CookieNotIncreasing, Prev: prev, Cookie: cookie}
+	case c.check.Contiguous && cookie != prev+1:
+		problem = CookieProblem{Kind: CookieGap, Prev: prev, Cookie: cookie}
+	default:
+		return nil
+	}
+
+	if c.check.Warn != nil {
+		c.check.Warn(problem)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCookieOrder, problem)
+}
@@ -0,0 +1,61 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cookieListProducer отдаёт по одному элементу с заданными cookie
+type cookieListProducer struct {
+	cookies []int
+	next    int
+}
+
+func (p *cookieListProducer) Next(ctx context.Context) ([]any, int, error) {
+	if p.next >= len(p.cookies) {
+		return nil, 0, errSourceDone
+	}
+	p.next++
+	return []any{p.next}, p.cookies[p.next-1], nil
+}
+
+func (p *cookieListProducer) Commit(ctx context.Context, cookie int) error {
+	return nil
+}
+
+func TestWithCookieCheckStopsOnOutOfOrderCookie(t *testing.T) {
+	p := &cookieListProducer{cookies: []int{1, 2, 2, 3}}
+
+	err := Pipe(p, &testConsumer{}, WithInlineMode(), WithCookieCheck(CookieCheck{}))
+	if !errors.Is(err, ErrCookieOrder) {
+		t.Fatalf("Pipe() error = %v, want %v", err, ErrCookieOrder)
+	}
+	if p.next != 3 {
+		t.Fatalf("Pipe read %d chunks, want to stop at the third", p.next)
+	}
+	// Нарушение порядка - ошибка стадии чтения
+	var se *StageError
+	if !errors.As(err, &se) || se.Stage != StageRead {
+		t.Errorf("errors.As() = %v, want a read StageError", se)
+	}
+}
+
+func TestWithCookieCheckWarnsOnGap(t *testing.T) {
+	var problems []CookieProblem
+	p := &cookieListProducer{cookies: []int{10, 11, 13, 12}}
+
+	err := Pipe(p, &testConsumer{}, WithInlineMode(), WithCookieCheck(CookieCheck{
+		Contiguous: true,
+		Warn:       func(pr CookieProblem) { problems = append(problems, pr) },
+	}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	want := []CookieProblem{{Kind: CookieGap, Prev: 11, Cookie: 13}, {Kind: CookieNotIncreasing, Prev: 13, Cookie: 12}}
+	if !reflect.DeepEqual(problems, want) {
+		t.Fatalf("problems = %v, want %v", problems, want)
+	}
+}
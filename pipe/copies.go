@@ -0,0 +1,48 @@
+package pipe
+
+import "fmt"
+
+// WithDefensiveCopies передаёт в Process собственную копию слайса батча. Нужна для консюмеров,
+// которые держат слайс после Process или меняют его: без копии они делят память с буфером Pipe.
+// Сами элементы не копируются - для этого WithItemClone.
+func WithDefensiveCopies() Option {
+	return func(cfg *config) {
+		cfg.defensiveCopies = true
+	}
+}
+
+// WithItemClone включает WithDefensiveCopies и вдобавок пропускает каждый элемент копии через clone.
+// Нужна, если элементы - ссылочные типы ([]byte, map, указатели), которые консюмер меняет на месте.
+// В PipeOf clone должен возвращать элемент того же типа T, иначе батч не уходит в Process и это ошибка обработки.
+// nil (удаления WithTombstones) тоже идёт через clone, и clone может вернуть nil.
+func WithItemClone(clone func(item any) any) Option {
+	return func(cfg *config) {
+		cfg.defensiveCopies = true
+		cfg.itemClone = clone
+	}
+}
+
+// consumerItems возвращает элементы в том виде, в котором их получит Process
+func consumerItems[T any](cfg *config, items []T) ([]T, error) {
+	if !cfg.defensiveCopies {
+		return items, nil
+	}
+	cp := make([]T, len(items))
+	if cfg.itemClone == nil {
+		copy(cp, items)
+		return cp, nil
+	}
+	for i, item := range items {
+		clone := cfg.itemClone(item)
+		// nil остаётся нулевым T
+		if clone == nil {
+			continue
+		}
+		v, ok := clone.(T)
+		if !ok {
+			return nil, fmt.Errorf("item clone returned %T for item %d, want %T", clone, i, v)
+		}
+		cp[i] = v
+	}
+	return cp, nil
+}
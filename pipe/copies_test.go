@@ -0,0 +1,100 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mutatingConsumer портит полученные элементы и держит ссылку на батч после Process
+type mutatingConsumer struct {
+	hint int
+	kept [][]any
+}
+
+func (c *mutatingConsumer) PreferredBatchSize(ctx context.Context) int {
+	return c.hint
+}
+
+func (c *mutatingConsumer) Process(ctx context.Context, items []any) error {
+	for i, item := range items {
+		if b, ok := item.([]byte); ok {
+			b[0] = 'x'
+		}
+		items[i] = nil
+	}
+	c.kept = append(c.kept, items)
+	return nil
+}
+
+func TestConsumerItemsCopiesSlice(t *testing.T) {
+	items := []any{1, 2, 3}
+	cfg, err := newConfig([]Option{WithDefensiveCopies()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := consumerItems(cfg, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp[0] = nil
+	if items[0] != 1 {
+		t.Fatalf("original batch changed through the copy: %v", items)
+	}
+}
+
+func TestWithItemCloneProtectsSourceItems(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{[]byte("a1"), []byte("a2")}, {[]byte("b1")}, {[]byte("c1")}}}
+	c := &mutatingConsumer{hint: 2}
+	clone := func(item any) any {
+		return bytes.Clone(item.([]byte))
+	}
+
+	err := Pipe(p, c, WithInlineMode(), WithItemClone(clone))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	if len(c.kept) == 0 {
+		t.Fatal("Process was never called")
+	}
+	for _, chunk := range p.chunks {
+		for _, item := range chunk {
+			if item == nil || item.([]byte)[0] == 'x' {
+				t.Fatalf("consumer changed source items: %q", p.chunks)
+			}
+		}
+	}
+}
+
+func TestWithItemCloneNilItems(t *testing.T) {
+	// Удаления (nil) проходят через clone, а не роняют Pipe
+	p := &listProducer{chunks: [][]any{{[]byte("a"), nil}, {nil}}}
+	c := &itemsConsumer{p: p}
+	identity := func(item any) any { return item }
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithItemClone(identity))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if want := [][]any{{[]byte("a"), nil, nil}}; !reflect.DeepEqual(c.batches, want) {
+		t.Errorf("batches = %q, want %q", c.batches, want)
+	}
+}
+
+func TestWithItemCloneWrongType(t *testing.T) {
+	// clone вернул не тот тип - ошибка обработки, а не паника
+	p := &rowProducer{chunks: [][]row{{{1, 1}, {2, 1}}}}
+	clone := func(item any) any { return fmt.Sprint(item) }
+	err := PipeOf[row](p, &rowConsumer{}, WithInlineMode(), WithItemClone(clone))
+	if err == nil || !strings.Contains(err.Error(), "item clone returned string for item 0, want pipe.row") {
+		t.Fatalf("PipeOf() error = %v, want a clone type error", err)
+	}
+	if len(p.committed) != 0 {
+		t.Errorf("commits = %v, want none", p.committed)
+	}
+}
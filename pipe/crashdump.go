@@ -0,0 +1,257 @@
+package pipe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+/*
+Дамп на случай аварии: когда Pipe падает (первая ошибка или паника в его горутинах), пишем всё,
+что знаем о запуске в этот момент - настройки, статистику, последний батч и стеки горутин.
+Иначе на разборе инцидента с данными остаётся только текст ошибки из лога.
+*/
+
+// CrashDump - состояние запуска Pipe в момент аварии
+type CrashDump struct {
+	Time time.Time `json:"time"`
+	// Первая ошибка запуска или значение паники
+	Error string            `json:"error"`
+	Panic bool              `json:"panic,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	// Включённые настройки в человекочитаемом виде
+	Settings map[string]string `json:"settings"`
+	// Сколько батчей собрано и закоммичено, последний закоммиченный cookie
+	Flushed       uint64 `json:"flushed"`
+	Committed     uint64 `json:"committed"`
+	LastCommitted int    `json:"last_committed"`
+	// Снимок WithFlushStats, nil без неё
+	FlushStats *FlushHistogram `json:"flush_stats,omitempty"`
+	// Последний батч, дошедший до обработки, nil - ни одного
+	LastBatch *BatchMeta `json:"last_batch,omitempty"`
+	// Стеки: всех горутин для ошибки, упавшей горутины для паники
+	Stacks string `json:"stacks"`
+}
+
+// WithCrashDump вызывает fn с дампом, когда Pipe падает: на первой ошибке запуска (ErrEndOfStream не ошибка)
+// и на панике в горутинах Pipe. Паника после дампа летит дальше, как и без опции.
+// Вызов синхронный, из горутины, где случилась авария.
+func WithCrashDump(fn func(CrashDump)) Option {
+	return func(cfg *config) {
+		cfg.crashHooks = append(cfg.crashHooks, fn)
+	}
+}
+
+// WithCrashDumpDir пишет дамп в файл crash-<время>.json в dir (в тех же случаях, что и WithCrashDump).
+// Ошибка записи добавляется к ошибке Pipe, но не заменяет её.
+func WithCrashDumpDir(dir string) Option {
+	return func(cfg *config) {
+		cfg.crashDirs = append(cfg.crashDirs, dir)
+	}
+}
+
+// writeCrashDump пишет дамп в dir
+func writeCrashDump(dir string, d CrashDump) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("crash dump: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("crash dump: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("crash-%s.json", d.Time.UTC().Format("20060102T150405.000000000")))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("crash dump: %w", err)
+	}
+	return nil
+}
+
+// crashDumper копит то, что попадёт в дамп. nil - дампы выключены
+type crashDumper struct {
+	cfg *config
+
+	mu            sync.Mutex
+	flushed       uint64
+	committed     uint64
+	lastCommitted int
+	lastBatch     *BatchMeta
+}
+
+func newCrashDumper(cfg *config) *crashDumper {
+	if len(cfg.crashHooks) == 0 && len(cfg.crashDirs) == 0 {
+		return nil
+	}
+	return &crashDumper{cfg: cfg}
+}
+
+// flush - собран очередной батч
+func (d *crashDumper) flush(seq uint64) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flushed = seq
+}
+
+// batch - батч дошёл до обработки
+func (d *crashDumper) batch(meta BatchMeta) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastBatch = &meta
+}
+
+// commit - закоммичены все cookie батча
+func (d *crashDumper) commit(cookies []int) {
+	if d == nil || len(cookies) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.committed++
+	d.lastCommitted = cookies[len(cookies)-1]
+}
+
+// write собирает дамп ошибки и отдаёт его во все приёмники дампов
+func (d *crashDumper) write(err error) error {
+	if d == nil {
+		return nil
+	}
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return d.dump(CrashDump{Error: err.Error(), Stacks: string(buf)})
+}
+
+// onPanic пишет дамп паники и паникует дальше. Вызывать только через defer в горутинах Pipe
+func (d *crashDumper) onPanic() {
+	if d == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		d.dump(CrashDump{Error: fmt.Sprint(r), Panic: true, Stacks: string(debug.Stack())})
+		panic(r)
+	}
+}
+
+func (d *crashDumper) dump(cd CrashDump) error {
+	cd.Time = time.Now()
+	cd.Tags = d.cfg.tags
+	cd.Settings = d.cfg.settings()
+	if s := d.cfg.flushStats; s != nil {
+		h := s.Histogram()
+		cd.FlushStats = &h
+	}
+	d.mu.Lock()
+	cd.Flushed, cd.Committed, cd.LastCommitted, cd.LastBatch = d.flushed, d.committed, d.lastCommitted, d.lastBatch
+	d.mu.Unlock()
+
+	for _, fn := range d.cfg.crashHooks {
+		fn(cd)
+	}
+	var errs []error
+	for _, dir := range d.cfg.crashDirs {
+		errs = append(errs, writeCrashDump(dir, cd))
+	}
+	return errors.Join(errs...)
+}
+
+// settings - включённые настройки для дампа. Колбэки не сериализуются, поэтому только то, что можно прочитать
+func (cfg *config) settings() map[string]string {
+	s := map[string]string{
+		"inline":               fmt.Sprint(cfg.inline),
+		"lease_renew_interval": cfg.leaseRenewInterval.String(),
+	}
+	set := func(name string, on bool, value any) {
+		if on {
+			s[name] = fmt.Sprint(value)
+		}
+	}
+	set("workers", cfg.workers > 1, cfg.workers)
+	set("unordered_commits", cfg.unorderedCommits, true)
+	set("partition_affinity", cfg.affinity != nil, true)
+	if c := cfg.catchUp; c != nil {
+		s["catch_up"] = fmt.Sprintf("enter=%d exit=%d workers=%d", c.Enter, c.Exit, c.Workers)
+	}
+	set("hooks", len(cfg.hooks) > 0, len(cfg.hooks))
+	set("next_timeout", cfg.nextTimeout > 0, cfg.nextTimeout)
+	set("next_retries", cfg.nextTimeout > 0, cfg.nextRetries)
+	set("max_batch_delay", cfg.maxBatchDelay > 0, cfg.maxBatchDelay)
+	set("quiet_period", cfg.quietPeriod > 0, cfg.quietPeriod)
+	set("min_items", cfg.minItems > 0, fmt.Sprintf("%d (wait up to %s)", cfg.minItems, cfg.minItemsWait))
+	set("drain_timeout", cfg.drainTimeout > 0, cfg.drainTimeout)
+	set("canceled_as_failure", cfg.canceledAsFailure, true)
+	if t := cfg.shutdownTimeouts; t != (ShutdownTimeouts{}) {
+		s["shutdown_timeouts"] = fmt.Sprintf("flush=%s process=%s commit=%s adapters=%s", t.Flush, t.Process, t.Commit, t.Adapters)
+	}
+	set("arena_slab_size", cfg.arenaSlabSize > 0, cfg.arenaSlabSize)
+	set("memory_throttle", cfg.memoryThrottle > 0, cfg.memoryThrottle)
+	set("defensive_copies", cfg.defensiveCopies, true)
+	set("batch_hash", cfg.batchHash, true)
+	set("profiling", cfg.profiling, true)
+	if rp := cfg.nextRetry; rp != nil {
+		s["next_retry"] = fmt.Sprintf("attempts=%d backoff=%s", rp.Attempts, rp.Backoff)
+	}
+	if rp := cfg.processRetry; rp != nil {
+		s["process_retry"] = fmt.Sprintf("attempts=%d backoff=%s", rp.Attempts, rp.Backoff)
+	}
+	set("batch_deadline", cfg.batchDeadline > 0, cfg.batchDeadline)
+	if t := cfg.tombstones; t != nil {
+		s["tombstones"] = fmt.Sprint(t.policy)
+	}
+	if q := cfg.queue; q != nil {
+		s["adaptive_queue"] = fmt.Sprintf("min=%d max=%d", q.min, q.max)
+	}
+	set("commit_mirrors", len(cfg.commitMirrors) > 0, len(cfg.commitMirrors))
+	if b := cfg.breaker; b != nil {
+		s["circuit_breaker"] = fmt.Sprintf("failures=%d open_for=%s probes=%d", b.cb.Failures, b.cb.OpenFor, b.cb.Probes)
+	}
+	if b := cfg.itemsRate; b != nil {
+		s["rate_limit"] = fmt.Sprintf("items_per_sec=%g burst=%d", b.rate, b.burst)
+	}
+	if b := cfg.batchesRate; b != nil {
+		s["batch_rate_limit"] = fmt.Sprintf("batches_per_sec=%g burst=%d", b.rate, b.burst)
+	}
+	if cr := cfg.commitRetry; cr != nil {
+		s["commit_retry"] = fmt.Sprintf("attempts=%d backoff=%s skip=%v", cr.Attempts, cr.Backoff, cr.OnExhausted == CommitSkip)
+	}
+	if bb := cfg.batchBytes; bb != nil {
+		s["max_batch_bytes"] = fmt.Sprint(bb.max)
+	}
+	if f := cfg.inFlight; f != nil {
+		s["max_in_flight_bytes"] = fmt.Sprint(f.max)
+	}
+	if li := cfg.largeItems; li != nil {
+		s["large_items"] = fmt.Sprintf("threshold=%d policy=%d", li.Threshold, li.Policy)
+	}
+	if c := cfg.capture; c != nil {
+		s["capture"] = fmt.Sprintf("%s (last %d)", c.dir, c.max)
+	}
+	if cc := cfg.cookieCheck; cc != nil {
+		s["cookie_check"] = fmt.Sprintf("contiguous=%v warn=%v", cc.Contiguous, cc.Warn != nil)
+	}
+	if dc := cfg.duplicateChunks; dc != nil {
+		s["duplicate_chunks"] = fmt.Sprintf("window=%d", dc.window)
+	}
+	if d := cfg.dedup; d != nil && d.window != nil {
+		s["dedup"] = fmt.Sprintf("max_keys=%d ttl=%s", d.window.maxKeys, d.window.ttl)
+	}
+	if len(cfg.transforms) > 0 {
+		s["transforms"] = fmt.Sprint(len(cfg.transforms))
+	}
+	if cfg.deadLetter != nil {
+		s["dead_letter"] = describeSink(cfg.deadLetter)
+	}
+	if cfg.eventLog != nil {
+		s["event_log"] = fmt.Sprintf("%T", cfg.eventLog)
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package pipe
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithCrashDumpOnFailure(t *testing.T) {
+	boom := errors.New("sink down")
+	dir := t.TempDir()
+	var dumps []CrashDump
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	err := Pipe(p, &describedConsumer{err: boom}, WithInlineMode(), WithTags(map[string]string{"pipe": "events"}),
+		WithCrashDump(func(d CrashDump) { dumps = append(dumps, d) }), WithCrashDumpDir(dir))
+	if !errors.Is(err, boom) {
+		t.Fatalf("Pipe() error = %v, want %v", err, boom)
+	}
+
+	if len(dumps) != 1 {
+		t.Fatalf("got %d dumps, want 1", len(dumps))
+	}
+	d := dumps[0]
+	// Ошибка - с её стадией (StageError)
+	if d.Error != "process: "+boom.Error() || d.Panic {
+		t.Errorf("dump error = %q, panic = %v", d.Error, d.Panic)
+	}
+	if d.LastBatch == nil || d.LastBatch.Seq != 1 || d.LastBatch.Items != 9000 || d.Flushed != 1 || d.Committed != 0 {
+		t.Errorf("dump batches: last = %+v, flushed = %d, committed = %d", d.LastBatch, d.Flushed, d.Committed)
+	}
+	if d.Tags["pipe"] != "events" || d.Settings["inline"] != "true" {
+		t.Errorf("dump tags = %v, settings = %v", d.Tags, d.Settings)
+	}
+	// Дамп снят, пока горутина чтения ещё жива
+	if !strings.Contains(d.Stacks, "goroutine") {
+		t.Errorf("dump has no goroutine stacks")
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("dump files = %v, want 1", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromFile CrashDump
+	if err := json.Unmarshal(data, &fromFile); err != nil {
+		t.Fatal(err)
+	}
+	if fromFile.Error != d.Error || fromFile.LastBatch == nil || fromFile.LastBatch.Seq != 1 {
+		t.Errorf("dump file = %+v", fromFile)
+	}
+}
+
+func TestWithCrashDumpNotOnEndOfStream(t *testing.T) {
+	called := false
+	p := &testProducer{chunks: 2, chunkSize: 10}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithCrashDump(func(CrashDump) { called = true })); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if called {
+		t.Error("crash dump written for a clean end of stream")
+	}
+}
+
+func TestCrashDumperOnPanic(t *testing.T) {
+	var got []CrashDump
+	cfg, err := newConfig([]Option{WithCrashDump(func(d CrashDump) { got = append(got, d) })})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newCrashDumper(cfg)
+
+	var repanicked any
+	func() {
+		defer func() { repanicked = recover() }()
+		defer d.onPanic()
+		panic("nil map write")
+	}()
+
+	if repanicked != "nil map write" {
+		t.Errorf("panic after dump = %v, want the original value", repanicked)
+	}
+	if len(got) != 1 || !got[0].Panic || got[0].Error != "nil map write" || !strings.Contains(got[0].Stacks, "TestCrashDumperOnPanic") {
+		t.Errorf("dumps = %+v", got)
+	}
+}
+
+func TestWithCrashDumpValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithCrashDump(nil), WithCrashDumpDir(""))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 2 problems", err)
+	}
+}
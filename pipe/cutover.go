@@ -0,0 +1,135 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+Переезд на новый приёмник без ручных скриптов: сначала пишем в оба (старый основной, новый теневой,
+см. ShadowConsumer), и как только новый продержался Window без единого расхождения, переключаемся -
+дальше батчи идут только в новый, и коммиты зависят только от него.
+Переключение происходит между батчами: батч целиком пишется либо по старой схеме, либо по новой.
+*/
+
+// CutoverPhase - этап переезда
+type CutoverPhase string
+
+const (
+	// Пишем в оба приёмника, коммиты зависят от старого
+	CutoverDualWrite CutoverPhase = "dual_write"
+	// Пишем только в новый
+	CutoverSwitched CutoverPhase = "switched"
+)
+
+// CutoverConfig - условия переключения
+type CutoverConfig struct {
+	// Сколько двойная запись должна идти без расхождений. Расхождение начинает отсчёт заново
+	Window time.Duration
+	// И сколько батчей минимум должно пройти за это время без расхождений
+	MinBatches int
+	// Сверка приёмников после батча (см. ShadowCompare), nil - сверяется только успех записи в новый
+	Compare ShadowCompare
+	// Куда сообщать о расхождениях, может быть nil
+	OnDivergence func(Divergence)
+	// Вызывается один раз при переключении, может быть nil
+	OnSwitch func()
+}
+
+// Cutover - консюмер, который переводит запись со старого приёмника на новый
+type Cutover struct {
+	cfg     CutoverConfig
+	newSink Consumer
+	dual    *shadowConsumer
+
+	mu         sync.Mutex
+	phase      CutoverPhase
+	diverged   bool
+	cleanSince time.Time
+	clean      int
+}
+
+// NewCutover начинает переезд с oldSink на newSink в фазе двойной записи
+func NewCutover(oldSink, newSink Consumer, cfg CutoverConfig) *Cutover {
+	c := &Cutover{cfg: cfg, newSink: newSink, phase: CutoverDualWrite}
+	c.dual = &shadowConsumer{primary: oldSink, shadow: newSink, compare: cfg.Compare, onDivergence: c.divergence}
+	return c
+}
+
+// Phase - текущий этап переезда
+func (c *Cutover) Phase() CutoverPhase {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.phase
+}
+
+func (c *Cutover) divergence(d Divergence) {
+	c.mu.Lock()
+	c.diverged = true
+	c.mu.Unlock()
+	if c.cfg.OnDivergence != nil {
+		c.cfg.OnDivergence(d)
+	}
+}
+
+func (c *Cutover) Process(ctx context.Context, items []any) error {
+	if c.Phase() == CutoverSwitched {
+		return c.newSink.Process(ctx, items)
+	}
+	if err := c.dual.Process(ctx, items); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	if c.diverged || c.cleanSince.IsZero() {
+		c.cleanSince = now
+		c.clean = 0
+	}
+	if !c.diverged {
+		c.clean++
+	}
+	c.diverged = false
+	switched := c.clean >= c.cfg.MinBatches && now.Sub(c.cleanSince) >= c.cfg.Window
+	if switched {
+		c.phase = CutoverSwitched
+	}
+	c.mu.Unlock()
+
+	if switched && c.cfg.OnSwitch != nil {
+		c.cfg.OnSwitch()
+	}
+	return nil
+}
+
+// current - приёмник, от которого сейчас зависят коммиты
+func (c *Cutover) current() Consumer {
+	if c.Phase() == CutoverSwitched {
+		return c.newSink
+	}
+	return c.dual.primary
+}
+
+func (c *Cutover) PreferredBatchSize(ctx context.Context) int {
+	if bs, ok := c.current().(BatchSizer); ok {
+		return bs.PreferredBatchSize(ctx)
+	}
+	return 0
+}
+
+func (c *Cutover) adapters() []adapter {
+	return []adapter{{"old", c.dual.primary}, {"new", c.newSink}}
+}
+
+func (c *Cutover) DescribeSink() string {
+	return describeSink(c.current())
+}
+
+func (c *Cutover) OnStart(ctx context.Context) error {
+	return c.dual.OnStart(ctx)
+}
+
+func (c *Cutover) OnStop(ctx context.Context) error {
+	return c.dual.OnStop(ctx)
+}
@@ -0,0 +1,55 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCutoverSwitchesAfterCleanWindow(t *testing.T) {
+	oldSink, newSink := &countingSink{hint: 1000}, &countingSink{hint: 1000, failOn: 2}
+	var switched, divergences int
+	c := NewCutover(oldSink, newSink, CutoverConfig{
+		MinBatches:   2,
+		Compare:      sameCount(oldSink, newSink),
+		OnDivergence: func(Divergence) { divergences++ },
+		OnSwitch:     func() { switched++ },
+	})
+
+	p := &testProducer{chunks: 9, chunkSize: 1000}
+	err := Pipe(p, c, WithInlineMode())
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Батч 2 падает в новом приёмнике, а с 3-го счётчики строк уже разные - окно сбрасывается каждый раз
+	if c.Phase() != CutoverDualWrite || switched != 0 {
+		t.Fatalf("phase = %s after divergent batches, switched %d times", c.Phase(), switched)
+	}
+	if divergences != 8 {
+		t.Fatalf("divergences = %d, want 8", divergences)
+	}
+	if got := p.commits(); len(got) != 9 {
+		t.Fatalf("commits = %v, new sink failures must not block them", got)
+	}
+}
+
+func TestCutoverSwitchesToNewSinkOnly(t *testing.T) {
+	oldSink, newSink := &countingSink{}, &countingSink{}
+	switched := 0
+	c := NewCutover(oldSink, newSink, CutoverConfig{MinBatches: 2, Compare: sameCount(oldSink, newSink), OnSwitch: func() { switched++ }})
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		if err := c.Process(ctx, []any{1, 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.Phase() != CutoverSwitched || switched != 1 {
+		t.Fatalf("phase = %s, switched %d times, want switched once", c.Phase(), switched)
+	}
+	if oldSink.count() != 4 || newSink.count() != 8 {
+		t.Fatalf("old sink has %d items, new %d, want 4 and 8", oldSink.count(), newSink.count())
+	}
+}
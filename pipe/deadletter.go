@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+/*
+Очередь недоставленного (DLQ). Без неё батч, который приёмник так и не принял, останавливает Pipe,
+и после рестарта всё повторяется на том же батче. С WithDeadLetter такой батч уходит в DeadLetter,
+его cookie коммитятся, и Pipe едет дальше, а ядовитые данные лежат там, где их можно разобрать
+и переотправить руками.
+*/
+
+// DeadLetter принимает батчи, на которых Process упал окончательно (после всех повторов WithProcessRetry).
+// ctx - контекст батча (BatchContext), err - последняя ошибка Process.
+// Если консюмер - ResultConsumer и не приняты отдельные элементы, в items только они, а BatchContext
+// описывает эту часть батча (Items, Spans), а в err есть *PartialError (errors.As).
+// Элементы, которые выкинуло преобразование с ItemErrorsDeadLetter, приходят до сборки батча: ctx - контекст
+// запуска без BatchContext, err - *ItemFailures.
+// Ошибка HandleFailed останавливает Pipe вместе с ошибкой Process: батч тогда никуда не записан и не закоммичен.
+type DeadLetter interface {
+	HandleFailed(ctx context.Context, items []any, err error) error
+}
+
+// WithDeadLetter отправляет окончательно упавшие батчи в dl и коммитит их, вместо того чтобы останавливать Pipe.
+// В отчётах о доставке такие cookie приходят со статусом DeliveryDeadLettered.
+// Отмена запуска батч в dl не отправляет - это не ошибка данных.
+// Если dl реализует Starter/Stopper или io.Closer, Pipe не запускает и не закрывает его - это дело владельца.
+func WithDeadLetter(dl DeadLetter) Option {
+	return func(cfg *config) {
+		cfg.deadLetter = dl
+	}
+}
+
+// deadLetterItems отправляет батч в DLQ. nil - батч принят и его можно коммитить
+func deadLetterItems[T any](ctx context.Context, dl DeadLetter, items []T, err error) error {
+	all, ok := any(items).([]any)
+	if !ok {
+		all = make([]any, len(items))
+		for i, item := range items {
+			all[i] = item
+		}
+	}
+	if dlErr := dl.HandleFailed(ctx, all, err); dlErr != nil {
+		return errors.Join(err, fmt.Errorf("dead letter: %w", dlErr))
+	}
+	return nil
+}
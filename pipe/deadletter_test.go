@@ -0,0 +1,117 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var errPoison = errors.New("cannot parse row")
+
+// poisonConsumer не принимает батчи, в которых есть элемент poison
+type poisonConsumer struct {
+	testConsumer
+	poison any
+}
+
+func (c *poisonConsumer) Process(ctx context.Context, items []any) error {
+	for _, item := range items {
+		if item == c.poison {
+			return errPoison
+		}
+	}
+	return c.testConsumer.Process(ctx, items)
+}
+
+// memoryDeadLetter складывает упавшие батчи в память
+type memoryDeadLetter struct {
+	err     error
+	batches [][]any
+	errs    []error
+	metas   []BatchMeta
+}
+
+func (d *memoryDeadLetter) HandleFailed(ctx context.Context, items []any, err error) error {
+	if d.err != nil {
+		return d.err
+	}
+	meta, _ := BatchContext(ctx)
+	d.batches = append(d.batches, items)
+	d.errs = append(d.errs, err)
+	d.metas = append(d.metas, meta)
+	return nil
+}
+
+func (d *memoryDeadLetter) DescribeSink() string {
+	return "memory-dlq"
+}
+
+func TestWithDeadLetter(t *testing.T) {
+	// Батч 1 - пачки 1..3 с ядовитой пачкой 1, батч 2 - пачка 4
+	p := &testProducer{chunks: 4, chunkSize: 3000}
+	c := &poisonConsumer{poison: 1}
+	dl := &memoryDeadLetter{}
+	reports := make(chan DeliveryReport, 100)
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDeadLetter(dl), WithDeliveryReports(reports))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	close(reports)
+
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Errorf("commits = %v, want [1 2 3 4]", got)
+	}
+	if len(dl.batches) != 1 || len(dl.batches[0]) != 9000 || !errors.Is(dl.errs[0], errPoison) || dl.metas[0].Seq != 1 {
+		t.Fatalf("dead letters: %d batches, errs %v, metas %+v", len(dl.batches), dl.errs, dl.metas)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3000}) {
+		t.Errorf("consumer batches = %v, want [3000]", got)
+	}
+
+	statuses := map[int]DeliveryStatus{}
+	for r := range reports {
+		statuses[r.Cookie] = r.Status
+		if r.Status == DeliveryDeadLettered && (r.Sink != "memory-dlq" || !errors.Is(r.Err, errPoison)) {
+			t.Errorf("dead-lettered report = %+v", r)
+		}
+	}
+	want := map[int]DeliveryStatus{1: DeliveryDeadLettered, 2: DeliveryDeadLettered, 3: DeliveryDeadLettered, 4: DeliveryCommitted}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+}
+
+func TestWithDeadLetterFails(t *testing.T) {
+	dlqDown := errors.New("dlq topic unavailable")
+	p := &testProducer{chunks: 4, chunkSize: 3000}
+	err := Pipe(finiteProducer{p}, &poisonConsumer{poison: 1}, WithInlineMode(), WithDeadLetter(&memoryDeadLetter{err: dlqDown}))
+	if !errors.Is(err, errPoison) || !errors.Is(err, dlqDown) {
+		t.Fatalf("Pipe() error = %v, want both the Process and the dead letter errors", err)
+	}
+	if got := p.commits(); len(got) != 0 {
+		t.Errorf("commits = %v, want none", got)
+	}
+}
+
+// rejectRows не принимает ни одного батча строк
+type rejectRows struct{}
+
+func (rejectRows) Process(ctx context.Context, items []row) error {
+	return errPoison
+}
+
+func TestWithDeadLetterTyped(t *testing.T) {
+	p := &rowProducer{chunks: [][]row{{{1, 1}, {2, 1}}, {{3, 1}}}}
+	dl := &memoryDeadLetter{}
+	if err := PipeOf[row](p, rejectRows{}, WithInlineMode(), WithDeadLetter(dl)); !errors.Is(err, errSourceDone) {
+		t.Fatalf("PipeOf() error = %v, want %v", err, errSourceDone)
+	}
+	want := [][]any{{row{1, 1}, row{2, 1}, row{3, 1}}}
+	if !reflect.DeepEqual(dl.batches, want) {
+		t.Errorf("dead letters = %v, want %v", dl.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", p.committed)
+	}
+}
@@ -0,0 +1,196 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Повторные пачки. Некоторые клиенты источника при переподключении или повторе запроса отдают
+ту же пачку ещё раз с тем же cookie. Без защиты её элементы попадут в приёмник дважды, а cookie
+закоммитится два раза. С WithDuplicateChunks такая пачка просто выбрасывается.
+*/
+
+// WithDuplicateChunks выбрасывает пачку Next, если её cookie уже встречался среди последних window пачек запуска.
+// onDrop (может быть nil) узнаёт о каждой выброшенной пачке - это повод разобраться с клиентом источника.
+// Годится только источникам, у которых один cookie - это всегда одни и те же данные.
+func WithDuplicateChunks(window int, onDrop func(cookie, items int)) Option {
+	return func(cfg *config) {
+		cfg.duplicateChunks = &duplicateChunks{window: window, onDrop: onDrop}
+	}
+}
+
+// duplicateChunks - настройки WithDuplicateChunks
+type duplicateChunks struct {
+	window int
+	onDrop func(cookie, items int)
+}
+
+// chunkDeduper помнит cookie последних window пачек текущего запуска
+type chunkDeduper struct {
+	cfg  *duplicateChunks
+	seen map[int]struct{}
+	// Cookie по порядку прихода, самый старый вытесняется первым
+	order []int
+}
+
+func newChunkDeduper(cfg *duplicateChunks) *chunkDeduper {
+	if cfg == nil {
+		return nil
+	}
+	return &chunkDeduper{cfg: cfg, seen: make(map[int]struct{}, cfg.window)}
+}
+
+// duplicate - пачка с этим cookie уже была и её надо выбросить
+func (d *chunkDeduper) duplicate(cookie, items int) bool {
+	if d == nil {
+		return false
+	}
+	if _, ok := d.seen[cookie]; ok {
+		if d.cfg.onDrop != nil {
+			d.cfg.onDrop(cookie, items)
+		}
+		return true
+	}
+	if len(d.order) == d.cfg.window {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.seen[cookie] = struct{}{}
+	d.order = append(d.order, cookie)
+	return false
+}
+
+/*
+Повторные элементы. После рестарта источник отдаёт заново всё, что не успело закоммититься,
+и элементы, уже записанные в приёмник, попадают туда второй раз. WithDedup помнит ключи
+записанных элементов и выкидывает повторы до Process.
+*/
+
+// DedupWindow - ключи элементов, уже записанных в приёмник: не больше maxKeys последних и не старше ttl.
+// Живёт отдельно от запуска, как Stats: один DedupWindow на перезапуски Pipe в одном процессе ловит
+// повторы после рестарта. Безопасен для нескольких Pipe сразу.
+type DedupWindow struct {
+	maxKeys int
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]time.Time
+	// Ключи по времени записи, самый старый вытесняется первым
+	order   []dedupKey
+	dropped int64
+}
+
+type dedupKey struct {
+	key string
+	at  time.Time
+}
+
+// NewDedupWindow создаёт окно на maxKeys ключей и ttl времени (0 - без этого ограничения, но одно из двух нужно)
+func NewDedupWindow(maxKeys int, ttl time.Duration) *DedupWindow {
+	return &DedupWindow{maxKeys: maxKeys, ttl: ttl, now: time.Now, keys: make(map[string]time.Time)}
+}
+
+// Dropped - сколько повторов выкинуто за всё время
+func (w *DedupWindow) Dropped() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+// WithDedup выкидывает перед Process элементы, чей ключ key уже есть в w или в ещё не записанных батчах
+// этого запуска. В w ключ попадает, как только Process батча прошёл (или батч ушёл в DLQ), ещё до Commit:
+// повтор после рестарта - это как раз записанный батч, чей Commit не прошёл. А элемент из батча, который
+// так и не записался, в w не попадёт и после рестарта пройдёт снова. Проверка идёт после WithTransform, в горутине чтения.
+// Пачка, из которой выкинуто всё, коммитится как обычно.
+func WithDedup(key func(item any) string, w *DedupWindow) Option {
+	return func(cfg *config) {
+		cfg.dedup = &dedupConfig{key: key, window: w}
+	}
+}
+
+type dedupConfig struct {
+	key    func(item any) string
+	window *DedupWindow
+}
+
+// evict вытесняет ключи сверх лимитов. Вызывать под w.mu
+func (w *DedupWindow) evict(now time.Time) {
+	cut := 0
+	for cut < len(w.order) {
+		oldest := w.order[cut]
+		if (w.maxKeys <= 0 || len(w.order)-cut <= w.maxKeys) && (w.ttl <= 0 || now.Sub(oldest.at) <= w.ttl) {
+			break
+		}
+		// Ключ мог быть записан ещё раз позже - тогда в keys уже новое время
+		if w.keys[oldest.key].Equal(oldest.at) {
+			delete(w.keys, oldest.key)
+		}
+		cut++
+	}
+	w.order = w.order[cut:]
+}
+
+// itemDeduper - ключи этого запуска, которые прочитаны, но ещё не записаны. nil - WithDedup нет
+type itemDeduper struct {
+	cfg *dedupConfig
+
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+func newItemDeduper(cfg *dedupConfig) *itemDeduper {
+	if cfg == nil {
+		return nil
+	}
+	return &itemDeduper{cfg: cfg, pending: make(map[string]int)}
+}
+
+// dedupItems выкидывает повторы из пачки
+func dedupItems[T any](d *itemDeduper, items []T) []T {
+	if d == nil {
+		return items
+	}
+	w := d.cfg.window
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.evict(w.now())
+	kept := items[:0:0]
+	for _, item := range items {
+		key := d.cfg.key(any(item))
+		if _, seen := w.keys[key]; seen || d.pending[key] > 0 {
+			w.dropped++
+			continue
+		}
+		d.pending[key]++
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// rememberDedup переносит ключи записанного батча в окно
+func rememberDedup[T any](d *itemDeduper, items []T) {
+	if d == nil || len(items) == 0 {
+		return
+	}
+	w := d.cfg.window
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := w.now()
+	for _, item := range items {
+		key := d.cfg.key(any(item))
+		if d.pending[key]--; d.pending[key] <= 0 {
+			delete(d.pending, key)
+		}
+		w.keys[key] = now
+		w.order = append(w.order, dedupKey{key: key, at: now})
+	}
+	w.evict(now)
+}
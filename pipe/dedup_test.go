@@ -0,0 +1,182 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithDuplicateChunks(t *testing.T) {
+	// Пачка 2 пришла дважды подряд, проверка порядка повтора не видит
+	p := &cookieListProducer{cookies: []int{1, 2, 2, 3}}
+	c := &testConsumer{}
+	var dropped []int
+
+	err := Pipe(p, c, WithInlineMode(), WithCookieCheck(CookieCheck{}), WithDuplicateChunks(4, func(cookie, items int) {
+		dropped = append(dropped, cookie)
+	}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("batch sizes = %v, want [3]", got)
+	}
+	if !reflect.DeepEqual(dropped, []int{2}) {
+		t.Errorf("dropped = %v, want [2]", dropped)
+	}
+}
+
+func TestWithDuplicateChunksWindow(t *testing.T) {
+	// Окно из двух пачек: 1 уже вытеснена и второй раз проходит, 3 ещё в окне
+	p := &cookieListProducer{cookies: []int{1, 2, 3, 1, 3}}
+	c := &testConsumer{}
+
+	err := Pipe(p, c, WithInlineMode(), WithDuplicateChunks(2, nil))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("batch sizes = %v, want [4]", got)
+	}
+}
+
+func TestWithDuplicateChunksValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithDuplicateChunks(0, nil))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+	}
+}
+
+func TestWithDedup(t *testing.T) {
+	key := func(item any) string { return fmt.Sprint(item) }
+	w := NewDedupWindow(100, 0)
+
+	// Повтор внутри запуска выкидывается, хотя первый экземпляр ещё не закоммичен
+	p := &listProducer{chunks: [][]any{{1, 2}, {2, 3}, {1}}}
+	c := &itemsConsumer{p: p}
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDedup(key, w)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if want := [][]any{{1, 2, 3}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Errorf("commits = %v, want [1 2 3]", p.committed)
+	}
+
+	// Рестарт: источник отдаёт всё заново, закоммиченное уже в окне
+	p = &listProducer{chunks: [][]any{{1, 2, 3, 4}}}
+	c = &itemsConsumer{p: p}
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDedup(key, w)); err != nil {
+		t.Fatalf("Pipe() after restart error = %v", err)
+	}
+	if want := [][]any{{4}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches after restart = %v, want %v", c.batches, want)
+	}
+	if got := w.Dropped(); got != 5 {
+		t.Errorf("Dropped() = %d, want 5", got)
+	}
+}
+
+// failOnceConsumer отбивает первый батч
+type failOnceConsumer struct {
+	testConsumer
+	failed bool
+}
+
+var errSinkDown = errors.New("sink down")
+
+func (c *failOnceConsumer) Process(ctx context.Context, items []any) error {
+	if !c.failed {
+		c.failed = true
+		return errSinkDown
+	}
+	return c.testConsumer.Process(ctx, items)
+}
+
+func TestWithDedupKeepsUncommittedItems(t *testing.T) {
+	// Батч не записался - после рестарта его элементы должны пройти, а не считаться повтором
+	key := func(item any) string { return fmt.Sprint(item) }
+	w := NewDedupWindow(100, 0)
+	c := &failOnceConsumer{}
+
+	p := &listProducer{chunks: [][]any{{1, 2}}}
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDedup(key, w)); !errors.Is(err, errSinkDown) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSinkDown)
+	}
+	p = &listProducer{chunks: [][]any{{1, 2}}}
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDedup(key, w)); err != nil {
+		t.Fatalf("Pipe() after restart error = %v", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("batch sizes = %v, want [2]", got)
+	}
+}
+
+// failCommitList - listProducer, у которого не проходит ни один Commit
+type failCommitList struct {
+	listProducer
+}
+
+func (p *failCommitList) Commit(ctx context.Context, cookie int) error {
+	return errors.New("broker unavailable")
+}
+
+func TestWithDedupDropsRedeliveryAfterFailedCommit(t *testing.T) {
+	// Батч записался, а Commit нет - после рестарта источник отдаёт его снова, и это повтор
+	key := func(item any) string { return fmt.Sprint(item) }
+	w := NewDedupWindow(100, 0)
+	c := &testConsumer{}
+
+	p := &failCommitList{listProducer{chunks: [][]any{{1, 2, 3}}}}
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDedup(key, w)); err == nil {
+		t.Fatal("Pipe() error = nil, want the commit error")
+	}
+	redelivered := &listProducer{chunks: [][]any{{1, 2, 3}}}
+	if err := Pipe(finiteProducer{redelivered}, c, WithInlineMode(), WithDedup(key, w)); err != nil {
+		t.Fatalf("Pipe() after restart error = %v", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("batch sizes = %v, want [3]: the redelivered batch must not be written again", got)
+	}
+	if got := w.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+	// Пачку, из которой выкинуто всё, всё равно коммитим
+	if !reflect.DeepEqual(redelivered.committed, []int{1}) {
+		t.Errorf("commits after restart = %v, want [1]", redelivered.committed)
+	}
+}
+
+func TestDedupWindowEviction(t *testing.T) {
+	now := time.Unix(0, 0)
+	w := NewDedupWindow(2, time.Minute)
+	w.now = func() time.Time { return now }
+	d := newItemDeduper(&dedupConfig{key: func(item any) string { return item.(string) }, window: w})
+
+	rememberDedup(d, dedupItems(d, []string{"a", "b", "c"}))
+	// Лимит по числу: "a" вытеснен
+	if got := dedupItems(d, []string{"a", "b", "c"}); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("after count eviction kept %v, want [a]", got)
+	}
+	rememberDedup(d, []string{"a"})
+	// Лимит по времени: через две минуты не помнится ничего
+	now = now.Add(2 * time.Minute)
+	if got := dedupItems(d, []string{"a", "c"}); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Fatalf("after ttl eviction kept %v, want [a c]", got)
+	}
+}
+
+func TestWithDedupValidation(t *testing.T) {
+	key := func(item any) string { return "" }
+	for _, opt := range []Option{WithDedup(nil, NewDedupWindow(1, 0)), WithDedup(key, nil), WithDedup(key, NewDedupWindow(0, 0))} {
+		var cfgErr *ConfigError
+		if err := Pipe(&testProducer{}, &testConsumer{}, opt); !errors.As(err, &cfgErr) {
+			t.Fatalf("Pipe() error = %v, want a *ConfigError", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeliveryStatus - чем закончилась доставка данных одного cookie
+type DeliveryStatus string
+
+const (
+	// Данные записаны в приёмник и cookie закоммичен
+	DeliveryCommitted DeliveryStatus = "committed"
+	// Обработка или коммит упали, cookie не закоммичен и после рестарта данные придут снова
+	DeliveryFailed DeliveryStatus = "failed"
+	// Приёмник данные не принял, они ушли в DeadLetter (WithDeadLetter), cookie закоммичен.
+	// С ResultConsumer - хотя бы один элемент пачки, остальные записаны в приёмник
+	DeliveryDeadLettered DeliveryStatus = "dead_lettered"
+)
+
+// DeliveryReport - подтверждение для upstream-системы по одному cookie
+type DeliveryReport struct {
+	Cookie int
+	Status DeliveryStatus
+	// Номер батча в рамках запуска и сколько в нём было элементов
+	Batch uint64
+	Items int
+	// Куда записали (см. SinkDescriber), для DeliveryDeadLettered - описание DeadLetter
+	Sink string
+	// Причина для DeliveryFailed и DeliveryDeadLettered
+	Err  error
+	Time time.Time
+	// Теги запуска (WithTags)
+	Tags map[string]string
+}
+
+// SinkDescriber - опциональный интерфейс консюмера: описание приёмника для DeliveryReport
+// (кластер, таблица). Без него в отчёт пишется тип консюмера.
+type SinkDescriber interface {
+	DescribeSink() string
+}
+
+// WithDeliveryReports после каждого коммита (и при падении батча) отправляет в ch отчёт по каждому cookie.
+// Отправка блокирующая - ch нужно вычитывать, иначе Pipe встанет. Pipe канал не закрывает.
+func WithDeliveryReports(ch chan<- DeliveryReport) Option {
+	return func(cfg *config) {
+		cfg.deliveryReports = ch
+	}
+}
+
+// describeSink - описание приёмника для отчётов
+func describeSink(c any) string {
+	if d, ok := c.(SinkDescriber); ok {
+		return d.DescribeSink()
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+// reportDelivery отправляет отчёты по cookies, если отчёты включены
+func (cfg *config) reportDelivery(ctx context.Context, meta BatchMeta, sink string, status DeliveryStatus, err error, cookies ...int) {
+	if cfg.deliveryReports == nil {
+		return
+	}
+	for _, cookie := range cookies {
+		r := DeliveryReport{
+			Cookie: cookie,
+			Status: status,
+			Batch:  meta.Seq,
+			Items:  meta.Items,
+			Sink:   sink,
+			Err:    err,
+			Time:   time.Now(),
+			Tags:   cfg.tags,
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case cfg.deliveryReports <- r:
+		}
+	}
+}
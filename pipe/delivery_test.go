@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type describedConsumer struct {
+	testConsumer
+	err error
+}
+
+func (c *describedConsumer) DescribeSink() string {
+	return "clickhouse://events"
+}
+
+func (c *describedConsumer) Process(ctx context.Context, items []any) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.testConsumer.Process(ctx, items)
+}
+
+func TestWithDeliveryReports(t *testing.T) {
+	reports := make(chan DeliveryReport, 100)
+	p := &testProducer{chunks: 6, chunkSize: 3000}
+
+	err := Pipe(p, &describedConsumer{}, WithInlineMode(), WithDeliveryReports(reports))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	close(reports)
+
+	var got []DeliveryReport
+	for r := range reports {
+		got = append(got, r)
+	}
+	if len(got) != 6 {
+		t.Fatalf("got %d reports, want 6", len(got))
+	}
+	for i, r := range got {
+		if r.Cookie != i+1 || r.Status != DeliveryCommitted || r.Sink != "clickhouse://events" || r.Items != 9000 || r.Batch != uint64(i/3+1) {
+			t.Fatalf("report %d = %+v", i, r)
+		}
+	}
+}
+
+func TestWithDeliveryReportsFailed(t *testing.T) {
+	boom := errors.New("sink down")
+	reports := make(chan DeliveryReport, 100)
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+
+	err := Pipe(p, &describedConsumer{err: boom}, WithInlineMode(), WithDeliveryReports(reports))
+	if !errors.Is(err, boom) {
+		t.Fatalf("Pipe() error = %v, want %v", err, boom)
+	}
+	close(reports)
+
+	var cookies []int
+	for r := range reports {
+		if r.Status != DeliveryFailed || !errors.Is(r.Err, boom) {
+			t.Fatalf("unexpected report %+v", r)
+		}
+		cookies = append(cookies, r.Cookie)
+	}
+	if len(cookies) != 3 || cookies[0] != 1 || cookies[2] != 3 {
+		t.Fatalf("failed cookies = %v, want [1 2 3]", cookies)
+	}
+}
+
+func TestDescribeSinkFallsBackToType(t *testing.T) {
+	if got := describeSink(NullConsumer{}); got != "pipe.NullConsumer" {
+		t.Fatalf("describeSink() = %q", got)
+	}
+}
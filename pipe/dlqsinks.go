@@ -0,0 +1,152 @@
+package pipe
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Готовые DeadLetter: в отдельный Kafka-топик и в SQL-таблицу. Клиент Kafka и драйвер БД пакет не тянет -
+топику нужен TopicWriter (обёртка над своим продюсером), таблице - то, у чего есть ExecContext (*sql.DB, *sql.Tx).
+*/
+
+// DeadLetterMessage - одно сообщение для DLQ-топика: элемент и заголовки с причиной
+type DeadLetterMessage struct {
+	Value   []byte
+	Headers map[string]string
+}
+
+// TopicWriter пишет сообщения в DLQ-топик. Запись всего слайса должна быть атомарной настолько,
+// насколько умеет клиент: при ошибке Pipe считает, что батч никуда не записан.
+type TopicWriter interface {
+	WriteMessages(ctx context.Context, msgs ...DeadLetterMessage) error
+}
+
+// TopicDeadLetter пишет каждый элемент упавшего батча отдельным сообщением в топик.
+// Заголовки: dlq-error, dlq-batch, dlq-cookie (пачка источника, из которой элемент), dlq-failed-at
+// и теги запуска с префиксом dlq-tag-.
+type TopicDeadLetter struct {
+	Writer TopicWriter
+	// Имя топика - только для описания в отчётах о доставке
+	Topic string
+	// Кодирование элемента, nil - encoding/json
+	Encode func(item any) ([]byte, error)
+}
+
+func (d *TopicDeadLetter) HandleFailed(ctx context.Context, items []any, err error) error {
+	meta, _ := BatchContext(ctx)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	msgs := make([]DeadLetterMessage, 0, len(items))
+	for i, item := range items {
+		value, encErr := encodeDeadLetter(d.Encode, item)
+		if encErr != nil {
+			return fmt.Errorf("encode item %d: %w", i, encErr)
+		}
+		headers := map[string]string{
+			"dlq-error":     err.Error(),
+			"dlq-batch":     strconv.FormatUint(meta.Seq, 10),
+			"dlq-failed-at": now,
+		}
+		if cookie, ok := meta.CookieOf(i); ok {
+			headers["dlq-cookie"] = strconv.Itoa(cookie)
+		}
+		for k, v := range TagsFromContext(ctx) {
+			headers["dlq-tag-"+k] = v
+		}
+		msgs = append(msgs, DeadLetterMessage{Value: value, Headers: headers})
+	}
+	return d.Writer.WriteMessages(ctx, msgs...)
+}
+
+func (d *TopicDeadLetter) DescribeSink() string {
+	return "kafka-dlq://" + d.Topic
+}
+
+// SQLExecer - то, во что SQLDeadLetter пишет строки: *sql.DB, *sql.Tx, *sql.Conn
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sqlDeadLetterRows - сколько строк вставляем одним запросом, чтобы не упереться в лимит параметров
+const sqlDeadLetterRows = 1000
+
+// SQLDeadLetter вставляет каждый элемент упавшего батча строкой в таблицу Table с колонками
+// (batch_seq, cookie, payload, error, failed_at). cookie - пачка источника, из которой элемент, или NULL.
+// Больше sqlDeadLetterRows строк вставляются несколькими запросами: если упадёт не первый, часть строк
+// уже в таблице, и после рестарта они запишутся ещё раз - сверяйте по batch_seq и cookie.
+// Таблицу создаёт владелец, например:
+//
+//	CREATE TABLE dead_letters (batch_seq BIGINT, cookie BIGINT NULL, payload TEXT, error TEXT, failed_at TIMESTAMP)
+type SQLDeadLetter struct {
+	DB    SQLExecer
+	Table string
+	// Плейсхолдеры $1, $2... (Postgres) вместо ? (MySQL, Clickhouse, SQLite)
+	Numbered bool
+	// Кодирование элемента, nil - encoding/json
+	Encode func(item any) ([]byte, error)
+}
+
+func (d *SQLDeadLetter) HandleFailed(ctx context.Context, items []any, err error) error {
+	meta, _ := BatchContext(ctx)
+	now := time.Now().UTC()
+	for start := 0; start < len(items); start += sqlDeadLetterRows {
+		end := min(start+sqlDeadLetterRows, len(items))
+		var q strings.Builder
+		fmt.Fprintf(&q, "INSERT INTO %s (batch_seq, cookie, payload, error, failed_at) VALUES ", d.Table)
+		args := make([]any, 0, (end-start)*5)
+		for i := start; i < end; i++ {
+			payload, encErr := encodeDeadLetter(d.Encode, items[i])
+			if encErr != nil {
+				return fmt.Errorf("encode item %d: %w", i, encErr)
+			}
+			var cookie any
+			if c, ok := meta.CookieOf(i); ok {
+				cookie = c
+			}
+			if i > start {
+				q.WriteString(", ")
+			}
+			q.WriteString("(")
+			for col := 0; col < 5; col++ {
+				if col > 0 {
+					q.WriteString(", ")
+				}
+				if d.Numbered {
+					fmt.Fprintf(&q, "$%d", len(args)+col+1)
+				} else {
+					q.WriteString("?")
+				}
+			}
+			q.WriteString(")")
+			args = append(args, int64(meta.Seq), cookie, string(payload), err.Error(), now)
+		}
+		if _, execErr := d.DB.ExecContext(ctx, q.String(), args...); execErr != nil {
+			return execErr
+		}
+	}
+	return nil
+}
+
+func (d *SQLDeadLetter) DescribeSink() string {
+	return "sql-dlq://" + d.Table
+}
+
+// encodeDeadLetter кодирует элемент для DLQ: []byte и string как есть, остальное через encode или encoding/json
+func encodeDeadLetter(encode func(any) ([]byte, error), item any) ([]byte, error) {
+	if encode != nil {
+		return encode(item)
+	}
+	switch v := item.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return json.Marshal(v)
+	}
+}
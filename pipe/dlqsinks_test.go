@@ -0,0 +1,103 @@
+package pipe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// memoryTopic запоминает записанные сообщения
+type memoryTopic struct {
+	msgs []DeadLetterMessage
+}
+
+func (w *memoryTopic) WriteMessages(ctx context.Context, msgs ...DeadLetterMessage) error {
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func TestTopicDeadLetter(t *testing.T) {
+	w := &memoryTopic{}
+	dl := &TopicDeadLetter{Writer: w, Topic: "events-dlq"}
+	p := &testProducer{chunks: 2, chunkSize: 2}
+	err := Pipe(finiteProducer{p}, &poisonConsumer{poison: 2}, WithInlineMode(), WithDeadLetter(dl),
+		WithTags(map[string]string{"pipe": "events"}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	if len(w.msgs) != 4 {
+		t.Fatalf("got %d messages, want 4", len(w.msgs))
+	}
+	// Элементы пачки 1 - "1", пачки 2 - "2"
+	for i, m := range w.msgs {
+		cookie := "1"
+		if i >= 2 {
+			cookie = "2"
+		}
+		if string(m.Value) != cookie || m.Headers["dlq-cookie"] != cookie || m.Headers["dlq-batch"] != "1" ||
+			m.Headers["dlq-error"] != errPoison.Error() || m.Headers["dlq-tag-pipe"] != "events" {
+			t.Errorf("message %d = %s %v", i, m.Value, m.Headers)
+		}
+	}
+	if got := dl.DescribeSink(); got != "kafka-dlq://events-dlq" {
+		t.Errorf("DescribeSink() = %q", got)
+	}
+}
+
+// execRecorder запоминает запросы вместо базы
+type execRecorder struct {
+	queries []string
+	args    [][]any
+	err     error
+}
+
+func (e *execRecorder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	e.args = append(e.args, args)
+	return nil, e.err
+}
+
+func TestSQLDeadLetter(t *testing.T) {
+	db := &execRecorder{}
+	dl := &SQLDeadLetter{DB: db, Table: "dead_letters", Numbered: true}
+	ctx, done := withBatch(context.Background(), BatchMeta{Seq: 7, Spans: []CookieSpan{{Cookie: 3, Items: 2}}})
+	defer done()
+
+	if err := dl.HandleFailed(ctx, []any{map[string]int{"id": 1}, "raw"}, errPoison); err != nil {
+		t.Fatal(err)
+	}
+	want := "INSERT INTO dead_letters (batch_seq, cookie, payload, error, failed_at) VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10)"
+	if len(db.queries) != 1 || db.queries[0] != want {
+		t.Fatalf("queries = %q", db.queries)
+	}
+	args := db.args[0]
+	if args[0] != int64(7) || args[1] != 3 || args[2] != `{"id":1}` || args[3] != errPoison.Error() || args[7] != "raw" {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestSQLDeadLetterChunksLargeBatches(t *testing.T) {
+	db := &execRecorder{}
+	dl := &SQLDeadLetter{DB: db, Table: "dead_letters"}
+	items := make([]any, sqlDeadLetterRows+1)
+	for i := range items {
+		items[i] = i
+	}
+	if err := dl.HandleFailed(context.Background(), items, errPoison); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.queries) != 2 || strings.Count(db.queries[1], "?") != 5 || db.args[1][1] != nil {
+		t.Errorf("got %d queries, last one %q with args %v", len(db.queries), db.queries[len(db.queries)-1], db.args[len(db.args)-1])
+	}
+}
+
+func TestSQLDeadLetterError(t *testing.T) {
+	down := errors.New("connection refused")
+	dl := &SQLDeadLetter{DB: &execRecorder{err: down}, Table: "dead_letters"}
+	if err := dl.HandleFailed(context.Background(), []any{1}, errPoison); !errors.Is(err, down) {
+		t.Fatalf("HandleFailed() error = %v, want %v", err, down)
+	}
+}
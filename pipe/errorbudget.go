@@ -0,0 +1,104 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrErrorBudgetExceeded - доля батчей с ошибками Process за окно выше WithErrorBudget
+var ErrErrorBudgetExceeded = errors.New("error budget exceeded")
+
+// errorBudgetMinBatches - пока в окне меньше батчей, бюджет не оцениваем: одна ошибка на первом батче - это не 100%
+const errorBudgetMinBatches = 10
+
+// ErrorBudgetReport - состояние бюджета ошибок на окне
+type ErrorBudgetReport struct {
+	Total int
+	// Батчи, у которых упала хотя бы одна попытка Process (вытянутые повтором или ушедшие в DLQ)
+	Failed int
+	Rate   float64
+	// true - бюджет превышен
+	Exceeded bool
+}
+
+// WithErrorBudget терпит отдельные ошибки Process (их вытягивают WithProcessRetry и WithDeadLetter),
+// но останавливает Pipe с ErrErrorBudgetExceeded, когда доля батчей с ошибками за скользящее окно window
+// выше maxErrorRate. Батч, на котором бюджет кончился, успевает закоммититься.
+func WithErrorBudget(maxErrorRate float64, window time.Duration) Option {
+	return func(cfg *config) {
+		if cfg.errorBudget == nil {
+			cfg.errorBudget = &errorBudget{}
+		}
+		cfg.errorBudget.maxRate = maxErrorRate
+		cfg.errorBudget.window = window
+	}
+}
+
+// WithErrorBudgetAlert вместо остановки по WithErrorBudget вызывает fn, когда бюджет превышен
+// и когда доля ошибок вернулась в норму
+func WithErrorBudgetAlert(fn func(ErrorBudgetReport)) Option {
+	return func(cfg *config) {
+		if cfg.errorBudget == nil {
+			cfg.errorBudget = &errorBudget{}
+		}
+		cfg.errorBudget.alert = fn
+	}
+}
+
+// errorBudget - скользящее окно батчей с отметкой об ошибках
+type errorBudget struct {
+	maxRate float64
+	window  time.Duration
+	alert   func(ErrorBudgetReport)
+
+	mu       sync.Mutex
+	samples  []errorSample
+	exceeded bool
+}
+
+type errorSample struct {
+	at     time.Time
+	failed bool
+}
+
+// observe добавляет батч. Ошибка - бюджет превышен и алерта нет, Pipe надо остановить
+func (b *errorBudget) observe(now time.Time, failed bool) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	b.samples = append(b.samples, errorSample{at: now, failed: failed})
+	cut := 0
+	for cut < len(b.samples) && now.Sub(b.samples[cut].at) > b.window {
+		cut++
+	}
+	b.samples = b.samples[cut:]
+
+	r := ErrorBudgetReport{Total: len(b.samples)}
+	for _, s := range b.samples {
+		if s.failed {
+			r.Failed++
+		}
+	}
+	r.Rate = float64(r.Failed) / float64(r.Total)
+	r.Exceeded = r.Total >= errorBudgetMinBatches && r.Rate > b.maxRate
+	changed := r.Total >= errorBudgetMinBatches && r.Exceeded != b.exceeded
+	if changed {
+		b.exceeded = r.Exceeded
+	}
+	b.mu.Unlock()
+
+	if b.alert != nil {
+		if changed {
+			b.alert(r)
+		}
+		return nil
+	}
+	if r.Exceeded {
+		return fmt.Errorf("%w: %d of %d batches failed in %s", ErrErrorBudgetExceeded, r.Failed, r.Total, b.window)
+	}
+	return nil
+}
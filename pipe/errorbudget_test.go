@@ -0,0 +1,67 @@
+package pipe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithErrorBudget(t *testing.T) {
+	// Пачка на батч, ядовитая только первая: 1 из 10 батчей - больше 5%
+	p := &testProducer{chunks: 12, chunkSize: MaxItems}
+	err := Pipe(p, &poisonConsumer{poison: 1}, WithInlineMode(), WithDeadLetter(&memoryDeadLetter{}),
+		WithErrorBudget(0.05, time.Minute))
+	if !errors.Is(err, ErrErrorBudgetExceeded) {
+		t.Fatalf("Pipe() error = %v, want %v", err, ErrErrorBudgetExceeded)
+	}
+	if got := p.commits(); len(got) != 10 {
+		t.Errorf("commits = %v, want 1..10", got)
+	}
+}
+
+func TestWithErrorBudgetTolerated(t *testing.T) {
+	p := &testProducer{chunks: 12, chunkSize: MaxItems}
+	err := Pipe(finiteProducer{p}, &poisonConsumer{poison: 1}, WithInlineMode(), WithDeadLetter(&memoryDeadLetter{}),
+		WithErrorBudget(0.2, time.Minute))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+}
+
+func TestErrorBudgetAlert(t *testing.T) {
+	var reports []ErrorBudgetReport
+	b := &errorBudget{maxRate: 0.1, window: time.Minute, alert: func(r ErrorBudgetReport) { reports = append(reports, r) }}
+	now := time.Unix(0, 0)
+	observe := func(failed bool) {
+		if err := b.observe(now, failed); err != nil {
+			t.Fatalf("observe() error = %v with an alert set", err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	// 2 ошибки из 10 - превышение
+	observe(true)
+	observe(true)
+	for i := 0; i < 8; i++ {
+		observe(false)
+	}
+	// Через минуту ошибки выпали из окна - снова в норме
+	now = now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		observe(false)
+	}
+
+	want := []ErrorBudgetReport{{Total: 10, Failed: 2, Rate: 0.2, Exceeded: true}, {Total: 10, Failed: 0, Rate: 0}}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("reports = %+v, want %+v", reports, want)
+	}
+}
+
+func TestWithErrorBudgetValidation(t *testing.T) {
+	_, err := newConfig([]Option{WithErrorBudgetAlert(func(ErrorBudgetReport) {})})
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("newConfig() error = %v, want a *ConfigError with 2 problems", err)
+	}
+}
@@ -0,0 +1,194 @@
+package pipe
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType - этап жизни батча
+type EventType string
+
+const (
+	// Pipe запущен. В событии - оценка окна дублей от прошлого запуска (см. duplicateWindow)
+	EventRunStarted EventType = "run_started"
+	// Батч собран и передан консюмеру
+	EventBatchFlushed EventType = "batch_flushed"
+	// Консюмер успешно обработал батч (или батч ушёл в DLQ). Пишется сразу после Process, до очереди коммитов,
+	// так что с WithWorkers события разных батчей идут не по порядку
+	EventBatchProcessed EventType = "batch_processed"
+	// Все cookie батча закоммичены в источник
+	EventBatchCommitted EventType = "batch_committed"
+	// Преобразование выкинуло элементы пачки FirstCookie (ItemErrorsSkip), Batch не заполнен
+	EventItemsSkipped EventType = "items_skipped"
+	// Преобразование отправило элементы пачки FirstCookie в DeadLetter (ItemErrorsDeadLetter), Batch не заполнен
+	EventItemsDeadLettered EventType = "items_dead_lettered"
+)
+
+// Event - запись в журнале событий: что случилось с каким батчем и какой диапазон cookie он покрывает
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+	// Порядковый номер батча в рамках одного запуска Pipe
+	Batch       uint64 `json:"batch"`
+	Items       int    `json:"items"`
+	FirstCookie int    `json:"first_cookie"`
+	LastCookie  int    `json:"last_cookie"`
+	// Только для EventRunStarted: сколько батчей и элементов прошлого запуска придут повторно
+	DuplicateBatches int `json:"duplicate_batches,omitempty"`
+	DuplicateItems   int `json:"duplicate_items,omitempty"`
+	// Хеш содержимого батча (WithBatchHash)
+	Hash string `json:"hash,omitempty"`
+	// Теги запуска (WithTags)
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// duplicateWindow считает окно неизбежных дублей at-least-once после рестарта: батчи прошлого запуска,
+// которые консюмер уже обработал, но их cookie так и не закоммитили. Источник отдаст их ещё раз.
+// Результат - событие EventRunStarted с DuplicateBatches/DuplicateItems и диапазоном cookie в FirstCookie/LastCookie.
+// Это верхняя оценка: если батч упал посреди коммитов, часть его cookie могла успеть закоммититься.
+func duplicateWindow(events []Event) Event {
+	var processed []Event
+	for _, e := range events {
+		switch e.Type {
+		case EventRunStarted:
+			// Нас интересует только последний запуск
+			processed = processed[:0]
+		case EventBatchProcessed:
+			processed = append(processed, e)
+		case EventBatchCommitted:
+			for i, p := range processed {
+				if p.Batch == e.Batch {
+					processed = append(processed[:i], processed[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	// processed идут в порядке обработки, не батчей - диапазон cookie собираем по краям
+	window := Event{Type: EventRunStarted}
+	for i, e := range processed {
+		if i == 0 || e.FirstCookie < window.FirstCookie {
+			window.FirstCookie = e.FirstCookie
+		}
+		window.LastCookie = max(window.LastCookie, e.LastCookie)
+		window.DuplicateBatches++
+		window.DuplicateItems += e.Items
+	}
+	return window
+}
+
+// EventLog - журнал событий батчей только на дозапись. По нему потом можно ответить на вопрос
+// "был ли когда-нибудь записан диапазон X". Ошибка записи в журнал останавливает Pipe - аудит без дыр.
+// Реализация под SQL делается поверх этого же интерфейса.
+type EventLog interface {
+	Append(ctx context.Context, e Event) error
+}
+
+// EventReader - журнал, который умеет отдать уже записанные события. Если журнал его реализует,
+// на старте Pipe пишет EventRunStarted с оценкой окна дублей от прошлого запуска.
+type EventReader interface {
+	ReadEvents(ctx context.Context) ([]Event, error)
+}
+
+// WithEventLog пишет события жизни каждого батча в log
+func WithEventLog(log EventLog) Option {
+	return func(cfg *config) {
+		cfg.eventLog = log
+	}
+}
+
+// FileEventLog - журнал событий в файле, по одному JSON на строку
+type FileEventLog struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// OpenFileEventLog открывает (или создаёт) файл журнала на дозапись
+func OpenFileEventLog(path string) (*FileEventLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEventLog{path: path, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// ReadEvents читает все события из файла журнала
+func (l *FileEventLog) ReadEvents(ctx context.Context) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, sc.Err()
+}
+
+// Append дописывает событие и сразу сбрасывает его на диск, чтобы после падения журнал был полным
+func (l *FileEventLog) Append(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Close закрывает файл журнала
+func (l *FileEventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// startEventLog пишет в журнал EventRunStarted с окном дублей прошлого запуска
+func startEventLog(ctx context.Context, log EventLog) error {
+	if log == nil {
+		return nil
+	}
+
+	var window Event
+	if r, ok := log.(EventReader); ok {
+		events, err := r.ReadEvents(ctx)
+		if err != nil {
+			return err
+		}
+		window = duplicateWindow(events)
+	}
+
+	window.Type = EventRunStarted
+	window.Time = time.Now()
+	window.Tags = TagsFromContext(ctx)
+	return log.Append(ctx, window)
+}
@@ -0,0 +1,194 @@
+package pipe
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileEventLogRecordsBatchLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProducer{chunks: 6, chunkSize: 3000}
+	if err := Pipe(p, &testConsumer{}, WithEventLog(log)); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []Event
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad event line %q: %v", sc.Text(), err)
+		}
+		events = append(events, e)
+	}
+
+	// 6 пачек по 3000: два батча по 9000, второй уходит уже при остановке источника.
+	// Батчи обрабатываются конвейером, поэтому события разных батчей могут перемежаться,
+	// но внутри одного батча порядок строгий.
+	byBatch := make(map[uint64][]EventType)
+	if len(events) == 0 || events[0].Type != EventRunStarted || events[0].DuplicateItems != 0 {
+		t.Fatalf("log does not start with a clean run_started: %+v", events)
+	}
+	for _, e := range events[1:] {
+		if e.Time.IsZero() || e.Items != 9000 {
+			t.Fatalf("bad event %+v", e)
+		}
+		first, last := int(e.Batch-1)*3+1, int(e.Batch)*3
+		if e.FirstCookie != first || e.LastCookie != last {
+			t.Fatalf("event %+v, want cookies %d..%d", e, first, last)
+		}
+		byBatch[e.Batch] = append(byBatch[e.Batch], e.Type)
+	}
+	if len(byBatch) != 2 {
+		t.Fatalf("events for %d batches, want 2: %+v", len(byBatch), events)
+	}
+	for seq, types := range byBatch {
+		if !reflect.DeepEqual(types, []EventType{EventBatchFlushed, EventBatchProcessed, EventBatchCommitted}) {
+			t.Fatalf("batch %d lifecycle = %v", seq, types)
+		}
+	}
+}
+
+type failingEventLog struct{ err error }
+
+func (l failingEventLog) Append(ctx context.Context, e Event) error { return l.err }
+
+func TestEventLogErrorStopsPipe(t *testing.T) {
+	boom := errors.New("disk full")
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+
+	if err := Pipe(p, &testConsumer{}, WithEventLog(failingEventLog{err: boom})); !errors.Is(err, boom) {
+		t.Fatalf("Pipe() error = %v, want %v", err, boom)
+	}
+	if len(p.commits()) != 0 {
+		t.Fatalf("committed %v without an audit trail", p.commits())
+	}
+}
+
+// commitFailProducer не может закоммитить cookie failOn
+type commitFailProducer struct {
+	testProducer
+	failOn int
+}
+
+func (p *commitFailProducer) Commit(ctx context.Context, cookie int) error {
+	if cookie == p.failOn {
+		return errors.New("broker unavailable")
+	}
+	return p.testProducer.Commit(ctx, cookie)
+}
+
+func TestEventLogReportsDuplicateWindowAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	// Первый запуск: второй батч (cookie 4..6) обработан, но его коммит падает
+	p := &commitFailProducer{testProducer: testProducer{chunks: 7, chunkSize: 3000, finish: make(chan struct{})}, failOn: 5}
+	defer close(p.finish)
+	if err := Pipe(p, &testConsumer{}, WithEventLog(log)); err == nil {
+		t.Fatal("Pipe() succeeded despite commit failure")
+	}
+
+	// Второй запуск сразу пишет окно дублей
+	p2 := &testProducer{chunks: 1, chunkSize: 1}
+	Pipe(p2, &testConsumer{}, WithEventLog(log))
+
+	events, err := log.ReadEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []Event
+	for _, e := range events {
+		if e.Type == EventRunStarted {
+			starts = append(starts, e)
+		}
+	}
+	if len(starts) != 2 {
+		t.Fatalf("got %d run_started events, want 2", len(starts))
+	}
+	w := starts[1]
+	if w.DuplicateBatches != 1 || w.DuplicateItems != 9000 || w.FirstCookie != 4 || w.LastCookie != 6 {
+		t.Fatalf("duplicate window = %+v, want 1 batch of 9000 items, cookies 4..6", w)
+	}
+}
+
+// laterBatchConsumer роняет первый батч, но только когда второй уже записан
+type laterBatchConsumer struct {
+	second chan struct{}
+}
+
+func (c *laterBatchConsumer) Process(ctx context.Context, items []any) error {
+	meta, _ := BatchContext(ctx)
+	if meta.Seq == 1 {
+		<-c.second
+		return errSinkDown
+	}
+	if meta.Seq == 2 {
+		close(c.second)
+	}
+	return nil
+}
+
+func TestEventLogProcessedBeforeEarlierBatchFails(t *testing.T) {
+	// С двумя обработчиками второй батч записан, а коммита не будет: упал первый. Рестарт его повторит
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := OpenFileEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	p := &testProducer{chunks: 3, chunkSize: MaxItems, finish: make(chan struct{})}
+	defer close(p.finish)
+	err = Pipe(p, &laterBatchConsumer{second: make(chan struct{})}, WithWorkers(2), WithEventLog(log))
+	if !errors.Is(err, errSinkDown) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSinkDown)
+	}
+	if got := p.commits(); len(got) != 0 {
+		t.Fatalf("commits = %v, want none", got)
+	}
+
+	events, err := log.ReadEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := duplicateWindow(events)
+	if w.DuplicateBatches != 1 || w.DuplicateItems != MaxItems || w.FirstCookie != 2 || w.LastCookie != 2 {
+		t.Fatalf("duplicate window = %+v, want batch 2 with cookie 2", w)
+	}
+}
+
+func TestDuplicateWindowOutOfOrder(t *testing.T) {
+	events := []Event{
+		{Type: EventRunStarted},
+		{Type: EventBatchProcessed, Batch: 3, Items: 1, FirstCookie: 7, LastCookie: 9},
+		{Type: EventBatchProcessed, Batch: 2, Items: 1, FirstCookie: 4, LastCookie: 6},
+	}
+	if w := duplicateWindow(events); w.FirstCookie != 4 || w.LastCookie != 9 || w.DuplicateBatches != 2 {
+		t.Fatalf("duplicate window = %+v, want cookies 4..9 over 2 batches", w)
+	}
+}
@@ -0,0 +1,288 @@
+package pipe
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// FlushReason - почему батч ушёл консюмеру
+type FlushReason string
+
+const (
+	// Следующая пачка не влезла в лимит батча
+	FlushSize FlushReason = "size"
+	// Батч отправлен раньше из-за крупного элемента (WithLargeItems, LargeItemSolo), или это сам крупный элемент
+	FlushLargeItem FlushReason = "large_item"
+	// Источник остановился, и Pipe дописывает то, что успел прочитать
+	FlushShutdown FlushReason = "shutdown"
+	// Буфер пролежал дольше WithMaxBatchDelay, а источник всё молчит
+	FlushTimer FlushReason = "timer"
+	// Следующая пачка не влезла в лимит WithMaxBatchBytes
+	FlushBytes FlushReason = "bytes"
+	// Буфер отправили по просьбе снаружи: Pipeline.Barrier или Pipeline.Flush
+	FlushManual FlushReason = "manual"
+)
+
+// Батчи раскладываем по корзинам степеней двойки: 1, 2, 4, ..., 8192 и последняя до MaxItems
+var flushBuckets = func() []int {
+	var b []int
+	for n := 1; n < MaxItems; n *= 2 {
+		b = append(b, n)
+	}
+	return append(b, MaxItems)
+}()
+
+// HistogramBucket - сколько батчей размером не больше UpperBound (и больше предыдущей корзины)
+type HistogramBucket struct {
+	UpperBound int
+	Count      int
+}
+
+// FlushHistogram - распределение размеров отправленных батчей
+type FlushHistogram struct {
+	Batches int
+	Items   int
+	// Корзины по возрастанию UpperBound, пустые тоже есть
+	Buckets  []HistogramBucket
+	ByReason map[FlushReason]int
+	// Сколько в среднем батч собирался: от первой пачки в буфере до отправки
+	MeanFillTime time.Duration
+}
+
+// Percentile - оценка сверху размера батча для перцентиля q из (0, 1]: граница корзины, в которую он попал
+func (h FlushHistogram) Percentile(q float64) int {
+	need := int(q*float64(h.Batches) + 0.5)
+	if need < 1 {
+		need = 1
+	}
+	seen := 0
+	for _, b := range h.Buckets {
+		seen += b.Count
+		if seen >= need {
+			return b.UpperBound
+		}
+	}
+	return 0
+}
+
+// FlushStats копит статистику отправленных батчей. Заводится через NewFlushStats, подключается
+// через WithFlushStats, а читать Histogram и TuningReport можно в любой момент, в том числе
+// пока Pipe работает. Один FlushStats можно отдать нескольким запускам подряд - статистика суммируется.
+type FlushStats struct {
+	mu       sync.Mutex
+	counts   []int
+	batches  int
+	items    int
+	byReason map[FlushReason]int
+	fillTime time.Duration
+	// Последний лимит батча (с учётом BatchSizer)
+	limit int
+	// Сколько было пачек Next и элементов в них
+	chunks     int
+	chunkItems int
+	// Пачки Next как они пришли от источника (до преобразований): размеры по flushBuckets,
+	// промежутки между ними по metricsBuckets плюс последняя корзина без границы
+	arrivals     int
+	arrivedItems int
+	arrivalSizes []int
+	gaps         []int
+	gapCount     int
+	gapSum       time.Duration
+}
+
+// NewFlushStats создаёт пустую статистику
+func NewFlushStats() *FlushStats {
+	return &FlushStats{
+		counts:       make([]int, len(flushBuckets)),
+		byReason:     make(map[FlushReason]int),
+		arrivalSizes: make([]int, len(flushBuckets)),
+		gaps:         make([]int, len(metricsBuckets)+1),
+	}
+}
+
+// WithFlushStats пишет в s размер, причину и время сборки каждого отправленного батча
+func WithFlushStats(s *FlushStats) Option {
+	return func(cfg *config) {
+		cfg.flushStats = s
+	}
+}
+
+// observe учитывает один отправленный батч
+func (s *FlushStats) observe(items, limit int, reason FlushReason, fill time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[sizeBucket(items)]++
+	s.batches++
+	s.items += items
+	s.byReason[reason]++
+	s.fillTime += fill
+	s.limit = limit
+}
+
+// sizeBucket - корзина размера: ближайшая сверху степень двойки, всё выше 8192 - в последнюю
+func sizeBucket(items int) int {
+	idx := 0
+	if items > 1 {
+		idx = bits.Len(uint(items - 1))
+	}
+	return min(idx, len(flushBuckets)-1)
+}
+
+// observeArrival учитывает пачку Next как она пришла от источника: items элементов через gap после
+// предыдущей (0 - первая за запуск)
+func (s *FlushStats) observeArrival(items int, gap time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.arrivals++
+	s.arrivedItems += items
+	s.arrivalSizes[sizeBucket(items)]++
+	if gap <= 0 {
+		return
+	}
+	idx := len(metricsBuckets)
+	for i, le := range metricsBuckets {
+		if gap.Seconds() <= le {
+			idx = i
+			break
+		}
+	}
+	s.gaps[idx]++
+	s.gapCount++
+	s.gapSum += gap
+}
+
+// DurationBucket - сколько промежутков не длиннее UpperBound (и длиннее предыдущей корзины), 0 - без границы
+type DurationBucket struct {
+	UpperBound time.Duration
+	Count      int
+}
+
+// ChunkHistogram - распределение пачек, которые отдаёт Next (до преобразований): по ним видно, насколько
+// источник и обработка близки к пределу, без телеметрии самого источника
+type ChunkHistogram struct {
+	Chunks int
+	Items  int
+	// Размеры пачек, корзины как у FlushHistogram
+	Sizes []HistogramBucket
+	// Промежутки между соседними пачками одного запуска, последняя корзина - всё длиннее 10s
+	Gaps    []DurationBucket
+	MeanGap time.Duration
+	// Сколько элементов в секунду отдаёт источник, пока отдаёт: средняя пачка на средний промежуток
+	ItemRate float64
+}
+
+// Chunks возвращает снимок распределения пачек Next
+func (s *FlushStats) Chunks() ChunkHistogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := ChunkHistogram{Chunks: s.arrivals, Items: s.arrivedItems}
+	for i, n := range s.arrivalSizes {
+		h.Sizes = append(h.Sizes, HistogramBucket{UpperBound: flushBuckets[i], Count: n})
+	}
+	for i, n := range s.gaps {
+		var bound time.Duration
+		if i < len(metricsBuckets) {
+			bound = time.Duration(metricsBuckets[i] * float64(time.Second))
+		}
+		h.Gaps = append(h.Gaps, DurationBucket{UpperBound: bound, Count: n})
+	}
+	if s.gapCount > 0 {
+		h.MeanGap = s.gapSum / time.Duration(s.gapCount)
+		h.ItemRate = float64(s.arrivedItems) / float64(s.arrivals) / h.MeanGap.Seconds()
+	}
+	return h
+}
+
+// observeChunk учитывает одну непустую пачку Next
+func (s *FlushStats) observeChunk(items int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks++
+	s.chunkItems += items
+}
+
+// Histogram возвращает снимок накопленной статистики
+func (s *FlushStats) Histogram() FlushHistogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := FlushHistogram{Batches: s.batches, Items: s.items, ByReason: make(map[FlushReason]int, len(s.byReason))}
+	for i, n := range s.counts {
+		h.Buckets = append(h.Buckets, HistogramBucket{UpperBound: flushBuckets[i], Count: n})
+	}
+	for r, n := range s.byReason {
+		h.ByReason[r] = n
+	}
+	if s.batches > 0 {
+		h.MeanFillTime = s.fillTime / time.Duration(s.batches)
+	}
+	return h
+}
+
+// TuningReport - подсказка по настройке размера батча по наблюдаемому трафику
+type TuningReport struct {
+	Histogram FlushHistogram
+	// Лимит батча, с которым работали, и какой стоит попробовать (0 - менять нечего)
+	Limit              int
+	SuggestedBatchSize int
+	// Человекочитаемые пояснения
+	Notes []string
+}
+
+// TuningReport разбирает гистограмму и советует, куда крутить лимит батча.
+// Таймера на отправку у Pipe нет, поэтому по задержке только пояснение про время сборки батча.
+func (s *FlushStats) TuningReport() TuningReport {
+	h := s.Histogram()
+	s.mu.Lock()
+	limit := s.limit
+	chunk := 0
+	if s.chunks > 0 {
+		chunk = s.chunkItems / s.chunks
+	}
+	s.mu.Unlock()
+
+	r := TuningReport{Histogram: h, Limit: limit}
+	if h.Batches == 0 {
+		r.Notes = append(r.Notes, "no batches flushed yet")
+		return r
+	}
+
+	mean := h.Items / h.Batches
+	p50, p95 := h.Percentile(0.5), h.Percentile(0.95)
+	r.Notes = append(r.Notes, fmt.Sprintf("%d batches, mean %d items, p50 <= %d, p95 <= %d, mean fill time %s",
+		h.Batches, mean, p50, p95, h.MeanFillTime))
+
+	// Батч уходит недозаполненным, когда следующая пачка Next не влезает в остаток лимита.
+	// Лимит, кратный типичной пачке, забирает на одну пачку больше; если выше MaxItems нельзя -
+	// пусть источник режет пачки по BatchCapacity.
+	if limit > 0 && chunk > 0 && mean < limit*9/10 && h.ByReason[FlushSize] > h.Batches/2 {
+		if next := (limit/chunk + 1) * chunk; next <= MaxItems {
+			r.SuggestedBatchSize = next
+			r.Notes = append(r.Notes, fmt.Sprintf("batches fill %d%% of the %d limit with source chunks of ~%d items: a limit of %d takes one more chunk per batch",
+				100*mean/limit, limit, chunk, next))
+		} else {
+			r.Notes = append(r.Notes, fmt.Sprintf("batches fill %d%% of the %d limit with source chunks of ~%d items: size chunks by BatchCapacity to fill the rest",
+				100*mean/limit, limit, chunk))
+		}
+	}
+	if n := h.ByReason[FlushLargeItem]; n > h.Batches/10 {
+		r.Notes = append(r.Notes, fmt.Sprintf("%d of %d batches were cut by large items: raise the LargeItems threshold or switch to LargeItemSplit", n, h.Batches))
+	}
+	if h.MeanFillTime > time.Second {
+		r.Notes = append(r.Notes, fmt.Sprintf("a batch takes %s to fill: with a slow source a smaller limit lowers end-to-end latency", h.MeanFillTime))
+	}
+	return r
+}
@@ -0,0 +1,113 @@
+package pipe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlushStatsHistogram(t *testing.T) {
+	s := NewFlushStats()
+	p := &listProducer{chunks: [][]any{{1, 1}, {1, 100, 2}, {1, 1, 1}, {1, 1, 1}}}
+
+	err := Pipe(p, &itemsConsumer{p: p, hint: 4}, WithInlineMode(), WithFlushStats(s),
+		WithLargeItems(LargeItems{Threshold: 10, Size: intSize, Policy: LargeItemSolo}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Батчи [1 1 1], [100], [2 1 1 1] и остаток [1 1 1] при остановке источника
+	h := s.Histogram()
+	if h.Batches != 4 || h.Items != 11 {
+		t.Fatalf("batches = %d, items = %d, want 4 and 11", h.Batches, h.Items)
+	}
+	want := map[int]int{1: 1, 4: 3}
+	for _, b := range h.Buckets {
+		if b.Count != want[b.UpperBound] {
+			t.Fatalf("bucket <= %d has %d batches, want %d", b.UpperBound, b.Count, want[b.UpperBound])
+		}
+	}
+	if h.ByReason[FlushLargeItem] != 2 || h.ByReason[FlushSize] != 1 || h.ByReason[FlushShutdown] != 1 {
+		t.Fatalf("reasons = %v", h.ByReason)
+	}
+	if got := h.Percentile(0.5); got != 4 {
+		t.Fatalf("p50 = %d, want 4", got)
+	}
+}
+
+func TestFlushStatsTuningReportSuggestsWholeChunks(t *testing.T) {
+	s := NewFlushStats()
+	// Лимит 5000 и пачки по 3000: в батч влезает только одна пачка
+	err := Pipe(&testProducer{chunks: 5, chunkSize: 3000}, &sizedConsumer{hint: 5000}, WithInlineMode(), WithFlushStats(s))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	r := s.TuningReport()
+	if r.Limit != 5000 || r.SuggestedBatchSize != 6000 {
+		t.Fatalf("report = %+v, want limit 5000 and suggestion 6000", r)
+	}
+}
+
+func TestFlushStatsTuningReportEmpty(t *testing.T) {
+	r := NewFlushStats().TuningReport()
+	if r.SuggestedBatchSize != 0 || len(r.Notes) != 1 {
+		t.Fatalf("report = %+v", r)
+	}
+}
+
+func TestFlushStatsChunks(t *testing.T) {
+	s := NewFlushStats()
+	s.observeArrival(3000, 0)
+	s.observeArrival(3000, 20*time.Millisecond)
+	s.observeArrival(3000, 40*time.Millisecond)
+	s.observeArrival(10, 20*time.Second)
+
+	h := s.Chunks()
+	if h.Chunks != 4 || h.Items != 9010 {
+		t.Fatalf("chunks = %d, items = %d, want 4 and 9010", h.Chunks, h.Items)
+	}
+	sizes := map[int]int{}
+	for _, b := range h.Sizes {
+		if b.Count > 0 {
+			sizes[b.UpperBound] = b.Count
+		}
+	}
+	if want := map[int]int{16: 1, 4096: 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("sizes = %v, want %v", sizes, want)
+	}
+	gaps := map[time.Duration]int{}
+	for _, b := range h.Gaps {
+		if b.Count > 0 {
+			gaps[b.UpperBound] = b.Count
+		}
+	}
+	// Промежуток длиннее последней границы - в корзине без границы
+	if want := map[time.Duration]int{25 * time.Millisecond: 1, 50 * time.Millisecond: 1, 0: 1}; !reflect.DeepEqual(gaps, want) {
+		t.Errorf("gaps = %v, want %v", gaps, want)
+	}
+	if want := (20*time.Second + 60*time.Millisecond) / 3; h.MeanGap != want {
+		t.Errorf("MeanGap = %s, want %s", h.MeanGap, want)
+	}
+	if h.ItemRate <= 0 {
+		t.Errorf("ItemRate = %g, want a positive rate", h.ItemRate)
+	}
+}
+
+func TestWithFlushStatsChunks(t *testing.T) {
+	s := NewFlushStats()
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithFlushStats(s)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	h := s.Chunks()
+	gaps := 0
+	for _, b := range h.Gaps {
+		gaps += b.Count
+	}
+	// У первой пачки запуска промежутка нет
+	if h.Chunks != 7 || h.Items != 21000 || gaps != 6 {
+		t.Errorf("chunks = %d, items = %d, gaps = %d, want 7, 21000 and 6", h.Chunks, h.Items, gaps)
+	}
+}
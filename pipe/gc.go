@@ -0,0 +1,104 @@
+package pipe
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// GCStats - нагрузка на GC за время одного батча. Счётчики общие на процесс, поэтому это
+// всё, что случилось с момента коммита предыдущего батча, а не строго аллокации самого Pipe.
+type GCStats struct {
+	// Номер батча в рамках запуска
+	Batch uint64
+	Items int
+	// Сколько байт выделено в куче
+	AllocBytes uint64
+	// Сколько прошло циклов GC и сколько суммарно длились их паузы
+	GCCycles uint32
+	GCPause  time.Duration
+}
+
+// WithGCStats после коммита каждого батча передаёт в fn его GCStats.
+// Читает runtime.MemStats (короткая остановка мира), так что на крошечных батчах это заметно.
+func WithGCStats(fn func(GCStats)) Option {
+	return func(cfg *config) {
+		cfg.gcStats = fn
+	}
+}
+
+// WithMemoryThrottle притормаживает Next, пока занятая процессом память выше fraction от мягкого
+// лимита (debug.SetMemoryLimit / GOMEMLIMIT). Без лимита ничего не делает.
+// Так Pipe не набирает новые данные, когда процесс и так упирается в потолок памяти.
+func WithMemoryThrottle(fraction float64) Option {
+	return func(cfg *config) {
+		cfg.memoryThrottle = fraction
+	}
+}
+
+// gcSampler считает разницу GC-счётчиков между батчами
+type gcSampler struct {
+	last runtime.MemStats
+}
+
+func newGCSampler() *gcSampler {
+	s := &gcSampler{}
+	runtime.ReadMemStats(&s.last)
+	return s
+}
+
+// sample возвращает GCStats с прошлого вызова
+func (s *gcSampler) sample(batch uint64, items int) GCStats {
+	var now runtime.MemStats
+	runtime.ReadMemStats(&now)
+	st := GCStats{
+		Batch:      batch,
+		Items:      items,
+		AllocBytes: now.TotalAlloc - s.last.TotalAlloc,
+		GCCycles:   now.NumGC - s.last.NumGC,
+		GCPause:    time.Duration(now.PauseTotalNs - s.last.PauseTotalNs),
+	}
+	s.last = now
+	return st
+}
+
+// Сколько памяти процесс занимает с точки зрения мягкого лимита
+var memoryLimitSamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+func memoryInUse() uint64 {
+	samples := make([]metrics.Sample, len(memoryLimitSamples))
+	copy(samples, memoryLimitSamples)
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// throttleMemory ждёт, пока память не опустится ниже порога из WithMemoryThrottle
+func (cfg *config) throttleMemory(ctx context.Context) error {
+	if cfg.memoryThrottle <= 0 {
+		return nil
+	}
+
+	wait := 10 * time.Millisecond
+	for {
+		// -1 не меняет лимит, а только возвращает текущий
+		limit := debug.SetMemoryLimit(-1)
+		if limit == math.MaxInt64 || float64(memoryInUse()) < cfg.memoryThrottle*float64(limit) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait < time.Second {
+			wait *= 2
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"errors"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithGCStatsPerBatch(t *testing.T) {
+	var mu sync.Mutex
+	var stats []GCStats
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+
+	err := Pipe(p, &testConsumer{}, WithInlineMode(), WithGCStats(func(st GCStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		stats = append(stats, st)
+	}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Два полных батча и остаток, дописанный после остановки источника
+	if len(stats) != 3 {
+		t.Fatalf("got %d GC stats, want one per batch (3)", len(stats))
+	}
+	for i, st := range stats {
+		if want := []int{9000, 9000, 3000}[i]; st.Batch != uint64(i+1) || st.Items != want {
+			t.Fatalf("stats[%d] = %+v", i, st)
+		}
+		// Каждая пачка - это 3000 interface-значений, без аллокаций батч не собрать
+		if st.AllocBytes == 0 {
+			t.Fatalf("stats[%d] reports no allocations", i)
+		}
+	}
+}
+
+func TestWithMemoryThrottleHoldsNext(t *testing.T) {
+	// Лимит в 1 байт - процесс заведомо выше любой доли
+	old := debug.SetMemoryLimit(1)
+	restored := false
+	restore := func() {
+		if !restored {
+			debug.SetMemoryLimit(old)
+			restored = true
+		}
+	}
+	defer restore()
+
+	p := &testProducer{chunks: 1, chunkSize: 1}
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, &testConsumer{}, WithMemoryThrottle(0.9)) }()
+
+	time.Sleep(50 * time.Millisecond)
+	p.mu.Lock()
+	sent := p.sent
+	p.mu.Unlock()
+	if sent != 0 {
+		t.Fatal("Next was called above the memory threshold")
+	}
+
+	restore()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errSourceDone) {
+			t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Pipe() stayed throttled after memory limit was lifted")
+	}
+}
+
+func TestWithMemoryThrottleValidation(t *testing.T) {
+	var cfgErr *ConfigError
+	if err := Pipe(&testProducer{}, &testConsumer{}, WithMemoryThrottle(1.5)); !errors.As(err, &cfgErr) {
+		t.Fatalf("Pipe() error = %v, want *ConfigError", err)
+	}
+}
@@ -0,0 +1,74 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// Hooks - свои колбэки на стадиях батча: метрики и алерты без форка Pipe. Любое поле может быть nil.
+// Колбэки синхронные, из горутин Pipe (с WithWorkers - из нескольких сразу), так что долгий колбэк
+// тормозит обработку. Паника в колбэке не роняет Pipe: её пишет WithLogger (Error), батч идёт дальше.
+type Hooks struct {
+	// Батч собран и уходит обработчику. reason - почему его отправили
+	OnFlush func(ctx context.Context, meta BatchMeta, reason FlushReason)
+	// Приёмник принял батч, took - удачная попытка Process без пауз между повторами
+	OnProcessed func(ctx context.Context, meta BatchMeta, took time.Duration)
+	// Все cookie батча закоммичены
+	OnCommitted func(ctx context.Context, meta BatchMeta)
+	// Стадия не справилась: Next (meta пустая), преобразования (в meta только cookie пачки), Process
+	// после всех повторов (дальше батч уйдёт в DLQ, если она есть) или Commit
+	OnError func(ctx context.Context, meta BatchMeta, stage Stage, err error)
+}
+
+// WithHooks добавляет колбэки стадий. Можно передать несколько раз - вызываются в порядке опций
+func WithHooks(h Hooks) Option {
+	return func(cfg *config) {
+		cfg.hooks = append(cfg.hooks, h)
+	}
+}
+
+func (cfg *config) hookFlush(ctx context.Context, meta BatchMeta, reason FlushReason) {
+	for _, h := range cfg.hooks {
+		if h.OnFlush != nil {
+			cfg.callHook(ctx, "OnFlush", func() { h.OnFlush(ctx, meta, reason) })
+		}
+	}
+}
+
+func (cfg *config) hookProcessed(ctx context.Context, meta BatchMeta, took time.Duration) {
+	for _, h := range cfg.hooks {
+		if h.OnProcessed != nil {
+			cfg.callHook(ctx, "OnProcessed", func() { h.OnProcessed(ctx, meta, took) })
+		}
+	}
+}
+
+func (cfg *config) hookCommitted(ctx context.Context, meta BatchMeta) {
+	for _, h := range cfg.hooks {
+		if h.OnCommitted != nil {
+			cfg.callHook(ctx, "OnCommitted", func() { h.OnCommitted(ctx, meta) })
+		}
+	}
+}
+
+func (cfg *config) hookError(ctx context.Context, meta BatchMeta, stage Stage, err error) {
+	for _, h := range cfg.hooks {
+		if h.OnError != nil {
+			cfg.callHook(ctx, "OnError", func() { h.OnError(ctx, meta, stage, err) })
+		}
+	}
+}
+
+// callHook зовёт колбэк и глушит его панику
+func (cfg *config) callHook(ctx context.Context, name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			cfg.log(ctx, slog.LevelError, "hook panicked", slog.String("hook", name),
+				slog.String("panic", fmt.Sprint(r)), slog.String("stack", string(debug.Stack())))
+		}
+	}()
+	fn()
+}
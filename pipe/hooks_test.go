@@ -0,0 +1,114 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithHooks(t *testing.T) {
+	p := &testProducer{chunks: 3, chunkSize: 6000}
+	var events []string
+	hooks := Hooks{
+		OnFlush: func(ctx context.Context, meta BatchMeta, reason FlushReason) {
+			events = append(events, fmt.Sprintf("flush %d %d %v %s", meta.Seq, meta.Items, meta.Cookies, reason))
+		},
+		OnProcessed: func(ctx context.Context, meta BatchMeta, took time.Duration) {
+			if took <= 0 {
+				t.Errorf("OnProcessed took = %v", took)
+			}
+			events = append(events, fmt.Sprintf("processed %d", meta.Seq))
+		},
+		OnCommitted: func(ctx context.Context, meta BatchMeta) {
+			events = append(events, fmt.Sprintf("committed %d %v", meta.Seq, meta.Cookies))
+		},
+		OnError: func(ctx context.Context, meta BatchMeta, stage Stage, err error) {
+			events = append(events, fmt.Sprintf("error %s", stage))
+		},
+	}
+	// Второй набор тоже вызывается
+	var second int
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithHooks(hooks),
+		WithHooks(Hooks{OnCommitted: func(ctx context.Context, meta BatchMeta) { second++ }}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	want := []string{
+		"flush 1 6000 [1] size",
+		"processed 1",
+		"committed 1 [1]",
+		"flush 2 6000 [2] size",
+		"processed 2",
+		"committed 2 [2]",
+		"flush 3 6000 [3] shutdown",
+		"processed 3",
+		"committed 3 [3]",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if second != 3 {
+		t.Errorf("second OnCommitted called %d times, want 3", second)
+	}
+}
+
+func TestWithHooksOnError(t *testing.T) {
+	p := &testProducer{chunks: 1, chunkSize: 10}
+	var stages []Stage
+	var seqs []uint64
+	err := Pipe(finiteProducer{p}, &failOnceConsumer{}, WithInlineMode(), WithHooks(Hooks{
+		OnError: func(ctx context.Context, meta BatchMeta, stage Stage, err error) {
+			if !errors.Is(err, errSinkDown) {
+				t.Errorf("OnError err = %v, want %v", err, errSinkDown)
+			}
+			stages, seqs = append(stages, stage), append(seqs, meta.Seq)
+		},
+		OnCommitted: func(ctx context.Context, meta BatchMeta) {
+			t.Errorf("OnCommitted called for a failed batch %d", meta.Seq)
+		},
+	}))
+	if !errors.Is(err, errSinkDown) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSinkDown)
+	}
+	if !reflect.DeepEqual(stages, []Stage{StageProcess}) || !reflect.DeepEqual(seqs, []uint64{1}) {
+		t.Errorf("OnError stages = %v for batches %v, want [process] for [1]", stages, seqs)
+	}
+}
+
+func TestWithHooksPanic(t *testing.T) {
+	// Паникуют все колбэки - Pipe всё равно дописывает и коммитит всё
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	p := &testProducer{chunks: 2, chunkSize: 6000}
+	boom := func() { panic("hook bug") }
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithWorkers(2), WithLogger(logger), WithHooks(Hooks{
+		OnFlush:     func(context.Context, BatchMeta, FlushReason) { boom() },
+		OnProcessed: func(context.Context, BatchMeta, time.Duration) { boom() },
+		OnCommitted: func(context.Context, BatchMeta) { boom() },
+	}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", got)
+	}
+
+	panics := map[string]int{}
+	for _, r := range logRecords(t, &buf) {
+		if r["msg"] != "hook panicked" {
+			continue
+		}
+		if r["level"] != "ERROR" || r["panic"] != "hook bug" || r["stack"] == "" {
+			t.Errorf("panic record = %v", r)
+		}
+		panics[r["hook"].(string)]++
+	}
+	if want := map[string]int{"OnFlush": 2, "OnProcessed": 2, "OnCommitted": 2}; !reflect.DeepEqual(panics, want) {
+		t.Errorf("logged panics = %v, want %v", panics, want)
+	}
+}
@@ -0,0 +1,79 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+Общий потолок памяти на все отправленные, но ещё не закоммиченные батчи. WithMaxBatchBytes ограничивает
+один батч, а в полёте их несколько: очередь к консюмеру и тот, что сейчас в Process. С медленным приёмником
+очередь держит полные батчи, и чем больше обработчиков, тем больше их в памяти одновременно.
+*/
+
+// inFlightBytes - сколько байт в отправленных батчах и сколько можно
+type inFlightBytes struct {
+	max  int
+	size func(item any) int
+
+	mu   sync.Mutex
+	used int
+	// Закрывается при каждом освобождении, чтобы ждущий отправки проверил место заново
+	freed chan struct{}
+}
+
+// WithMaxInFlightBytes не даёт отправить батч, пока байты всех отправленных и ещё не закоммиченных батчей
+// вместе с ним больше n. size - размер элемента, как в WithMaxBatchBytes (лимиты независимы).
+// Чтение источника при этом тоже встаёт. Один батч больше n всё равно уходит, если в полёте ничего нет.
+func WithMaxInFlightBytes(n int, size func(item any) int) Option {
+	return func(cfg *config) {
+		cfg.inFlight = &inFlightBytes{max: n, size: size, freed: make(chan struct{})}
+	}
+}
+
+// inFlightItemsBytes - размер батча для потолка, 0 - потолка нет
+func inFlightItemsBytes[T any](f *inFlightBytes, items []T) int {
+	if f == nil {
+		return 0
+	}
+	n := 0
+	for _, item := range items {
+		n += f.size(any(item))
+	}
+	return n
+}
+
+// acquire ждёт, пока n байт влезут под потолок, и занимает их. Ошибка - только отмена ctx
+func (f *inFlightBytes) acquire(ctx context.Context, n int) error {
+	if f == nil {
+		return nil
+	}
+	for {
+		f.mu.Lock()
+		if f.used == 0 || f.used+n <= f.max {
+			f.used += n
+			f.mu.Unlock()
+			return nil
+		}
+		freed := f.freed
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release отдаёт n байт батча, который закоммичен или уже не будет обработан
+func (f *inFlightBytes) release(n int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.used -= n
+	close(f.freed)
+	f.freed = make(chan struct{})
+}
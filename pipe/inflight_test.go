@@ -0,0 +1,89 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithMaxInFlightBytes(t *testing.T) {
+	// Пачка - отдельный батч в 5000 байт, в полёте помещаются два. Пока первый висит в Process,
+	// второй ждёт в очереди, третий собран и ждёт места, а четвёртая пачка прочитана в буфер
+	p := &testProducer{chunks: 20, chunkSize: 5000}
+	c := &slowConsumer{release: make(chan struct{})}
+	size := func(item any) int { return 1 }
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Pipe(p, c, WithMaxBatchBytes(5000, size), WithMaxInFlightBytes(10000, size))
+	}()
+
+	deadline := time.After(time.Second)
+	for p.sentChunks() < 4 {
+		select {
+		case <-deadline:
+			t.Fatalf("read %d chunks, want 4", p.sentChunks())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	// Без потолка чтение ушло бы дальше - в канал влезает ещё несколько батчей
+	time.Sleep(50 * time.Millisecond)
+	if got := p.sentChunks(); got != 4 {
+		t.Fatalf("read %d chunks while the first batch is stuck, want 4", got)
+	}
+
+	close(c.release)
+	if err := <-done; !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got := len(p.commits()); got != 20 {
+		t.Fatalf("committed %d cookies, want 20", got)
+	}
+	checkCommitsInOrder(t, p.commits())
+}
+
+func TestWithMaxInFlightBytesOversizedBatch(t *testing.T) {
+	// Батч больше потолка уходит, когда в полёте пусто, а не висит вечно
+	p := &testProducer{chunks: 3, chunkSize: 100}
+	c := &testConsumer{}
+	err := Pipe(p, c, WithMaxBatchBytes(50, func(item any) int { return 1 }), WithMaxInFlightBytes(80, func(item any) int { return 1 }))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got := len(p.commits()); got != 3 {
+		t.Fatalf("committed %d cookies, want 3", got)
+	}
+}
+
+func TestInFlightBytesAcquireCancel(t *testing.T) {
+	f := &inFlightBytes{max: 10, freed: make(chan struct{})}
+	if err := f.acquire(context.Background(), 8); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.acquire(ctx, 8); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() over the limit error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	got := make(chan error, 1)
+	go func() { got <- f.acquire(context.Background(), 8) }()
+	f.release(8)
+	if err := <-got; err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+}
+
+func TestWithMaxInFlightBytesValidation(t *testing.T) {
+	size := func(item any) int { return 1 }
+	err := Pipe(&testProducer{}, &testConsumer{}, WithMaxInFlightBytes(0, nil))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 2 problems", err)
+	}
+	err = Pipe(&testProducer{}, &testConsumer{}, WithMaxBatchBytes(100, size), WithMaxInFlightBytes(50, size))
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+	}
+}
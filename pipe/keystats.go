@@ -0,0 +1,158 @@
+package pipe
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+/*
+Статистика по ключу внутри батча: сколько в нём разных ключей и какие ключи самые частые.
+Перекос по ключу (один клиент/шард даёт половину батча) хорошо объясняет горячие точки в приёмнике.
+Считаем приближённо и в фиксированной памяти: HyperLogLog для кардинальности и Space-Saving для top-K.
+*/
+
+// Точность HyperLogLog: 2^hllPrecision регистров, ошибка около 1.6%
+const hllPrecision = 12
+
+// KeyCount - ключ и (приближённое сверху) число его вхождений в батч
+type KeyCount struct {
+	Key   string
+	Count int
+}
+
+// KeyStats - статистика по ключу одного батча
+type KeyStats struct {
+	Batch uint64
+	Items int
+	// Оценка числа разных ключей
+	Cardinality uint64
+	// Самые частые ключи по убыванию
+	TopK []KeyCount
+}
+
+// WithKeyStats для каждого батча перед Process считает статистику по ключу key и передаёт её в fn.
+// topK - сколько самых частых ключей отдавать. С WithMetrics статистика последнего батча уходит ещё и
+// в метрики (batch_key_items по ключам top-K и batch_key_cardinality), а fn тогда можно не задавать.
+// Ключ становится значением метки - ключ с высокой кардинальностью (id записи) лучше не брать.
+func WithKeyStats(key func(item any) string, topK int, fn func(KeyStats)) Option {
+	return func(cfg *config) {
+		cfg.keyStats = &keyStatsConfig{key: key, topK: topK, fn: fn, seed: maphash.MakeSeed()}
+	}
+}
+
+type keyStatsConfig struct {
+	key  func(item any) string
+	topK int
+	fn   func(KeyStats)
+	seed maphash.Seed
+}
+
+// collectKeyStats считает статистику батча и отдаёт её в колбэк и метрики m
+func collectKeyStats[T any](ks *keyStatsConfig, m *Metrics, batch uint64, items []T) {
+	if ks == nil {
+		return
+	}
+
+	var h maphash.Hash
+	h.SetSeed(ks.seed)
+	hll := newHyperLogLog()
+	top := newSpaceSaving(ks.topK)
+	for _, item := range items {
+		key := ks.key(any(item))
+		h.Reset()
+		h.WriteString(key)
+		hll.add(h.Sum64())
+		top.add(key)
+	}
+
+	st := KeyStats{
+		Batch:       batch,
+		Items:       len(items),
+		Cardinality: hll.estimate(),
+		TopK:        top.top(),
+	}
+	m.keyStats(st)
+	if ks.fn != nil {
+		ks.fn(st)
+	}
+}
+
+// hyperLogLog - оценка числа разных значений
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	// Единица в младших битах ограничивает счёт нулей, если остаток хеша нулевой
+	rest := hash<<hllPrecision | 1<<(hllPrecision-1)
+	rank := uint8(bits.LeadingZeros64(rest)) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+	// На малых числах HLL сильно врёт - переходим на linear counting
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+// spaceSaving - поиск частых ключей в k счётчиках (алгоритм Space-Saving)
+type spaceSaving struct {
+	k      int
+	counts map[string]int
+}
+
+func newSpaceSaving(k int) *spaceSaving {
+	return &spaceSaving{k: k, counts: make(map[string]int, k)}
+}
+
+func (s *spaceSaving) add(key string) {
+	if _, ok := s.counts[key]; ok || len(s.counts) < s.k {
+		s.counts[key]++
+		return
+	}
+	// Места нет - вытесняем самый редкий ключ, новый наследует его счёт (оценка сверху)
+	minKey, minCount := "", math.MaxInt
+	for k, c := range s.counts {
+		if c < minCount {
+			minKey, minCount = k, c
+		}
+	}
+	delete(s.counts, minKey)
+	s.counts[key] = minCount + 1
+}
+
+func (s *spaceSaving) top() []KeyCount {
+	top := make([]KeyCount, 0, len(s.counts))
+	for k, c := range s.counts {
+		top = append(top, KeyCount{Key: k, Count: c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	return top
+}
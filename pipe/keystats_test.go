@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	ks := &keyStatsConfig{topK: 1, seed: maphash.MakeSeed()}
+	for _, n := range []int{10, 1000, 50000} {
+		var got KeyStats
+		ks.fn = func(st KeyStats) { got = st }
+		ks.key = func(item any) string { return fmt.Sprint(item) }
+
+		items := make([]any, 0, 2*n)
+		for i := 0; i < n; i++ {
+			// Каждый ключ дважды - дубли не должны влиять на оценку
+			items = append(items, i, i)
+		}
+		collectKeyStats(ks, nil, 1, items)
+
+		if rel := math.Abs(float64(got.Cardinality)-float64(n)) / float64(n); rel > 0.05 {
+			t.Fatalf("cardinality of %d keys estimated as %d", n, got.Cardinality)
+		}
+	}
+}
+
+func TestSpaceSavingFindsHeavyHitters(t *testing.T) {
+	s := newSpaceSaving(10)
+	for i := 0; i < 1000; i++ {
+		s.add("hot")
+		if i%2 == 0 {
+			s.add("warm")
+		}
+		s.add(fmt.Sprint("cold-", i))
+	}
+
+	top := s.top()
+	if len(top) != 10 || top[0].Key != "hot" || top[1].Key != "warm" {
+		t.Fatalf("top = %+v, want hot, warm first", top)
+	}
+	if top[0].Count < 1000 {
+		t.Fatalf("hot count %d underestimates 1000", top[0].Count)
+	}
+}
+
+func TestWithKeyStatsPerBatch(t *testing.T) {
+	var stats []KeyStats
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	// testProducer заполняет пачку её номером - в батче из трёх пачек ровно три ключа
+	key := func(item any) string { return fmt.Sprint(item) }
+
+	err := Pipe(p, &testConsumer{}, WithInlineMode(), WithKeyStats(key, 3, func(st KeyStats) { stats = append(stats, st) }))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	// Два полных батча и остаток из одной пачки
+	if len(stats) != 3 {
+		t.Fatalf("got %d stats, want 3", len(stats))
+	}
+	for _, st := range stats[:2] {
+		if st.Items != 9000 || st.Cardinality != 3 || len(st.TopK) != 3 || st.TopK[0].Count != 3000 {
+			t.Fatalf("unexpected stats %+v", st)
+		}
+	}
+}
+
+func TestWithKeyStatsValidation(t *testing.T) {
+	var cfgErr *ConfigError
+	if err := Pipe(&testProducer{}, &testConsumer{}, WithKeyStats(nil, 0, nil)); !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want 2 problems", err)
+	}
+}
@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrItemTooLarge - элемент больше порога из WithLargeItems при политике LargeItemFail
+var ErrItemTooLarge = errors.New("item exceeds size threshold")
+
+// LargeItemPolicy - что делать с элементом больше порога
+type LargeItemPolicy int
+
+const (
+	// Останавливаем Pipe с ErrItemTooLarge - громко, а не молча валим лимиты приёмника
+	LargeItemFail LargeItemPolicy = iota
+	// Отправляем элемент отдельным батчем из одного элемента
+	LargeItemSolo
+	// Режем элемент функцией Split и кладём части в батч как обычные элементы
+	LargeItemSplit
+)
+
+func (p LargeItemPolicy) String() string {
+	switch p {
+	case LargeItemFail:
+		return "fail"
+	case LargeItemSolo:
+		return "solo"
+	case LargeItemSplit:
+		return "split"
+	}
+	return fmt.Sprintf("LargeItemPolicy(%d)", int(p))
+}
+
+// LargeItems - настройки обработки слишком крупных элементов
+type LargeItems struct {
+	// Порог размера элемента (в единицах Size, обычно байтах)
+	Threshold int
+	// Размер элемента
+	Size   func(item any) int
+	Policy LargeItemPolicy
+	// Разрезает элемент на части, нужен для LargeItemSplit. В PipeOf части должны быть того же типа T
+	Split func(item any) []any
+}
+
+// WithLargeItems включает проверку размера каждого элемента: элементы больше li.Threshold
+// обрабатываются по li.Policy. Без опции элементы проходят как есть.
+func WithLargeItems(li LargeItems) Option {
+	return func(cfg *config) {
+		cfg.largeItems = &li
+	}
+}
+
+// segment - кусок пачки: обычные элементы или один крупный, который надо отправить отдельно
+type segment[T any] struct {
+	items []T
+	solo  bool
+}
+
+// segmentItems применяет политику li к пачке
+func segmentItems[T any](li *LargeItems, items []T) ([]segment[T], error) {
+	if li == nil {
+		return []segment[T]{{items: items}}, nil
+	}
+
+	var segs []segment[T]
+	// Начало текущего куска обычных элементов
+	start := 0
+	// Для Split собираем пачку заново, только если что-то действительно резали
+	var split []T
+
+	for i, item := range items {
+		size := li.Size(any(item))
+		if size <= li.Threshold {
+			if split != nil {
+				split = append(split, item)
+			}
+			continue
+		}
+
+		switch li.Policy {
+		case LargeItemSolo:
+			if start < i {
+				segs = append(segs, segment[T]{items: items[start:i]})
+			}
+			segs = append(segs, segment[T]{items: items[i : i+1], solo: true})
+			start = i + 1
+		case LargeItemSplit:
+			if split == nil {
+				split = append(make([]T, 0, len(items)), items[:i]...)
+			}
+			for _, part := range li.Split(any(item)) {
+				p, ok := part.(T)
+				if !ok {
+					return nil, fmt.Errorf("LargeItems.Split returned %T for item %d, want %T", part, i, item)
+				}
+				split = append(split, p)
+			}
+		default:
+			return nil, fmt.Errorf("%w: item %d has size %d > %d", ErrItemTooLarge, i, size, li.Threshold)
+		}
+	}
+
+	if split != nil {
+		return []segment[T]{{items: split}}, nil
+	}
+	if start < len(items) {
+		segs = append(segs, segment[T]{items: items[start:]})
+	}
+	return segs, nil
+}
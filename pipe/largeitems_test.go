@@ -0,0 +1,118 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// listProducer отдаёт заранее заданные пачки, cookie - номер пачки с 1
+type listProducer struct {
+	chunks [][]any
+
+	mu        sync.Mutex
+	sent      int
+	committed []int
+}
+
+func (p *listProducer) Next(ctx context.Context) ([]any, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent >= len(p.chunks) {
+		return nil, 0, errSourceDone
+	}
+	p.sent++
+	return p.chunks[p.sent-1], p.sent, nil
+}
+
+func (p *listProducer) Commit(ctx context.Context, cookie int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed = append(p.committed, cookie)
+	return nil
+}
+
+// itemsConsumer запоминает сами батчи и cookie, закоммиченные к началу каждого Process
+type itemsConsumer struct {
+	p       *listProducer
+	hint    int
+	batches [][]any
+	commits [][]int
+}
+
+func (c *itemsConsumer) Process(ctx context.Context, items []any) error {
+	c.batches = append(c.batches, append([]any(nil), items...))
+	c.p.mu.Lock()
+	c.commits = append(c.commits, append([]int(nil), c.p.committed...))
+	c.p.mu.Unlock()
+	return nil
+}
+
+func (c *itemsConsumer) PreferredBatchSize(ctx context.Context) int {
+	return c.hint
+}
+
+func intSize(item any) int {
+	return item.(int)
+}
+
+func TestWithLargeItemsSolo(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 1}, {1, 100, 2}, {1, 1, 1}, {1, 1, 1}}}
+	c := &itemsConsumer{p: p, hint: 4}
+
+	err := Pipe(p, c, WithInlineMode(), WithLargeItems(LargeItems{Threshold: 10, Size: intSize, Policy: LargeItemSolo}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	want := [][]any{{1, 1, 1}, {100}, {2, 1, 1, 1}, {1, 1, 1}}
+	if !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	// Cookie второй пачки коммитится только вместе с батчем, где лежит её хвост
+	if got := p.committed; !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Fatalf("commits = %v, want [1 2 3 4]", got)
+	}
+	if !reflect.DeepEqual(c.commits[2], []int{1}) {
+		t.Fatalf("cookie 2 committed before its last batch: %v", c.commits)
+	}
+}
+
+func TestWithLargeItemsSplit(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 25, 2}, {1}}}
+	c := &itemsConsumer{p: p, hint: 4}
+	split := func(item any) []any {
+		var parts []any
+		for n := item.(int); n > 0; n -= 10 {
+			parts = append(parts, min(n, 10))
+		}
+		return parts
+	}
+
+	err := Pipe(p, c, WithInlineMode(), WithLargeItems(LargeItems{Threshold: 10, Size: intSize, Policy: LargeItemSplit, Split: split}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]any{{1, 10, 10, 5, 2}, {1}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+}
+
+func TestWithLargeItemsFail(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 1}, {1, 100}}}
+
+	err := Pipe(p, &itemsConsumer{p: p}, WithLargeItems(LargeItems{Threshold: 10, Size: intSize}))
+	if !errors.Is(err, ErrItemTooLarge) {
+		t.Fatalf("Pipe() error = %v, want %v", err, ErrItemTooLarge)
+	}
+}
+
+func TestWithLargeItemsValidation(t *testing.T) {
+	var cfgErr *ConfigError
+	err := Pipe(&listProducer{}, &testConsumer{}, WithLargeItems(LargeItems{Policy: LargeItemSplit}))
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 3 {
+		t.Fatalf("Pipe() error = %v, want 3 problems", err)
+	}
+}
@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseRenewInterval - как часто по умолчанию продлеваем аренду cookie
+const DefaultLeaseRenewInterval = 10 * time.Second
+
+// LeaseRenewer - опциональный интерфейс источника с таймаутом видимости (SQS, Pub/Sub).
+// Пока данные лежат в буфере или обрабатываются консюмером, Pipe периодически передаёт в RenewLeases
+// все выданные, но ещё не закоммиченные cookie (в порядке Next), чтобы источник не отдал их повторно.
+// Вызывается из отдельной горутины, параллельно с Next и Commit. Ошибка продления останавливает Pipe.
+type LeaseRenewer interface {
+	RenewLeases(ctx context.Context, cookies []int) error
+}
+
+// leaseTracker - cookie, которые источник уже выдал, а мы ещё не закоммитили
+type leaseTracker struct {
+	mu      sync.Mutex
+	cookies []int
+}
+
+func (t *leaseTracker) add(cookie int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cookies = append(t.cookies, cookie)
+}
+
+// remove убирает закоммиченный cookie. Коммитим по порядку, так что обычно это первый элемент
+func (t *leaseTracker) remove(cookie int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, c := range t.cookies {
+		if c == cookie {
+			t.cookies = append(t.cookies[:i], t.cookies[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *leaseTracker) snapshot() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]int(nil), t.cookies...)
+}
+
+// renewLoop раз в interval продлевает аренду, пока не отменят ctx или не закроют stop.
+// Если источник не умеет продлевать аренду - сразу выходим.
+func (t *leaseTracker) renewLoop(ctx context.Context, p any, interval time.Duration, stop <-chan struct{}) error {
+	lr, ok := p.(LeaseRenewer)
+	if !ok {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			cookies := t.snapshot()
+			if len(cookies) == 0 {
+				continue
+			}
+			if err := lr.RenewLeases(ctx, cookies); err != nil {
+				return err
+			}
+		}
+	}
+}
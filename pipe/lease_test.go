@@ -0,0 +1,106 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseProducer запоминает все продления аренды
+type leaseProducer struct {
+	testProducer
+	renewErr error
+
+	renewMu sync.Mutex
+	renewed [][]int
+}
+
+func (p *leaseProducer) RenewLeases(ctx context.Context, cookies []int) error {
+	p.renewMu.Lock()
+	defer p.renewMu.Unlock()
+	p.renewed = append(p.renewed, cookies)
+	return p.renewErr
+}
+
+func (p *leaseProducer) renewCalls() [][]int {
+	p.renewMu.Lock()
+	defer p.renewMu.Unlock()
+	return append([][]int(nil), p.renewed...)
+}
+
+// slowConsumer держит первый батч, пока не закроют release
+type slowConsumer struct {
+	testConsumer
+	release chan struct{}
+	once    sync.Once
+}
+
+func (c *slowConsumer) Process(ctx context.Context, items []any) error {
+	c.once.Do(func() {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+		}
+	})
+	return c.testConsumer.Process(ctx, items)
+}
+
+func TestLeaseRenewerRenewsUncommittedCookies(t *testing.T) {
+	p := &leaseProducer{testProducer: testProducer{chunks: 4, chunkSize: 3000, finish: make(chan struct{})}}
+	c := &slowConsumer{release: make(chan struct{})}
+
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, c, WithLeaseRenewInterval(5*time.Millisecond)) }()
+
+	// Пока первый батч висит в Process, его cookie должны продлеваться. Первые продления могут успеть
+	// до того, как прочитана пачка 4, поэтому ждём продления всех четырёх
+	deadline := time.After(time.Second)
+	for {
+		calls := p.renewCalls()
+		if n := len(calls); n > 0 && len(calls[n-1]) == 4 {
+			if last := calls[n-1]; last[0] != 1 || last[3] != 4 {
+				t.Fatalf("renewal = %v, want [1 2 3 4]", last)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("renewals = %v, want one of [1 2 3 4]", calls)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// После коммита первого батча его cookie больше не продлеваются
+	close(c.release)
+	waitCommits(t, &p.testProducer, 3)
+	seen := len(p.renewCalls())
+	for len(p.renewCalls()) < seen+2 {
+		select {
+		case <-deadline:
+			t.Fatal("leases were not renewed after commit")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if last := p.renewCalls()[seen+1]; len(last) != 1 || last[0] != 4 {
+		t.Fatalf("renewal after commit = %v, want [4]", last)
+	}
+
+	close(p.finish)
+	if err := <-done; !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+}
+
+func TestLeaseRenewerErrorStopsPipe(t *testing.T) {
+	boom := errors.New("lease lost")
+	p := &leaseProducer{testProducer: testProducer{chunks: 4, chunkSize: 3000, finish: make(chan struct{})}, renewErr: boom}
+	c := &slowConsumer{release: make(chan struct{})}
+	defer close(c.release)
+
+	err := Pipe(p, c, WithLeaseRenewInterval(5*time.Millisecond))
+	if !errors.Is(err, boom) {
+		t.Fatalf("Pipe() error = %v, want %v", err, boom)
+	}
+}
@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Starter - опциональный интерфейс источника или консюмера: подготовка перед первым батчем
+// (прогрев кешей справочников, prepared statements, проверка соединения).
+// OnStart вызывается один раз на запуск, до первого Next, с контекстом запуска Pipe.
+// Ошибка останавливает Pipe ещё до чтения данных и возвращается как *StartError.
+type Starter interface {
+	OnStart(ctx context.Context) error
+}
+
+// StartError - адаптер не смог подготовиться к запуску
+type StartError struct {
+	// "producer", "consumer" или роль внутри составного консюмера ("primary", "shadow")
+	Adapter string
+	Err     error
+}
+
+func (e *StartError) Error() string {
+	return fmt.Sprintf("%s start: %v", e.Adapter, e.Err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// Stopper - опциональный интерфейс источника или консюмера: завершение после остановки Pipe
+// (сбросить внутренние буферы, закрыть соединения). Вместо него подойдёт io.Closer.
+// Вызывается ровно один раз, когда Pipe уже ничего не читает и не обрабатывает, и только у адаптеров,
+// которые успешно стартовали. Контекст - контекст запуска без отмены (теги в нём есть),
+// ограничивать время остановки - забота адаптера. С WithShutdownTimeouts в контексте дедлайн шага adapters.
+type Stopper interface {
+	OnStop(ctx context.Context) error
+}
+
+// adapter - источник или консюмер под своим именем для ошибок
+type adapter struct {
+	name string
+	a    any
+}
+
+// adaptersOf - адаптеры запуска в порядке старта. Один объект в обеих ролях попадает один раз.
+func adaptersOf(p, c any) []adapter {
+	if sameAdapter(p, c) {
+		return []adapter{{"producer", p}}
+	}
+	return []adapter{{"producer", p}, {"consumer", c}}
+}
+
+// startAdapters вызывает OnStart по порядку и возвращает адаптеры, которые успели стартовать
+func startAdapters(ctx context.Context, adapters []adapter) ([]adapter, error) {
+	for i, ad := range adapters {
+		s, ok := ad.a.(Starter)
+		if !ok {
+			continue
+		}
+		if err := s.OnStart(ctx); err != nil {
+			return adapters[:i], &StartError{Adapter: ad.name, Err: err}
+		}
+	}
+	return adapters, nil
+}
+
+// stopAdapters останавливает адаптеры в обратном порядке. Ошибка одного не мешает остановить остальные.
+func stopAdapters(ctx context.Context, adapters []adapter) error {
+	return stopAdaptersIn(context.WithoutCancel(ctx), adapters)
+}
+
+// stopAdaptersIn - stopAdapters с готовым контекстом остановки (например, с дедлайном WithShutdownTimeouts)
+func stopAdaptersIn(ctx context.Context, adapters []adapter) error {
+	var errs []error
+	for i := len(adapters) - 1; i >= 0; i-- {
+		ad := adapters[i]
+		var err error
+		switch s := ad.a.(type) {
+		case Stopper:
+			err = s.OnStop(ctx)
+		case io.Closer:
+			err = s.Close()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s stop: %w", ad.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withStopError добавляет ошибку остановки адаптеров к ошибке запуска
+func withStopError(err, stopErr error) error {
+	if stopErr == nil {
+		return err
+	}
+	if err == nil {
+		return stopErr
+	}
+	return errors.Join(err, stopErr)
+}
+
+// sameAdapter - источник и консюмер один и тот же объект. Несравнимые типы (структуры со слайсами по значению)
+// заведомо разные объекты, а == на них паникует.
+func sameAdapter(a, b any) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
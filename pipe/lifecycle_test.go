@@ -0,0 +1,159 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// startingProducer запоминает, вызывали ли OnStart до первого Next
+type startingProducer struct {
+	testProducer
+	err          error
+	started      int
+	nextBeforeOn bool
+}
+
+func (p *startingProducer) OnStart(ctx context.Context) error {
+	p.started++
+	return p.err
+}
+
+func (p *startingProducer) Next(ctx context.Context) ([]any, int, error) {
+	if p.started == 0 {
+		p.nextBeforeOn = true
+	}
+	return p.testProducer.Next(ctx)
+}
+
+// startingConsumer - консюмер с OnStart
+type startingConsumer struct {
+	testConsumer
+	err     error
+	started int
+}
+
+func (c *startingConsumer) OnStart(ctx context.Context) error {
+	c.started++
+	return c.err
+}
+
+// selfPipe - объект, который сам себе и источник, и приёмник
+type selfPipe struct {
+	startingProducer
+}
+
+func (s *selfPipe) Process(ctx context.Context, items []any) error {
+	return nil
+}
+
+func TestOnStartCalledBeforeFirstNext(t *testing.T) {
+	p := &startingProducer{testProducer: testProducer{chunks: 4, chunkSize: 3000}}
+	c := &startingConsumer{}
+
+	err := Pipe(p, c, WithInlineMode())
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if p.started != 1 || c.started != 1 || p.nextBeforeOn {
+		t.Fatalf("producer started %d times, consumer %d times, Next before OnStart: %v", p.started, c.started, p.nextBeforeOn)
+	}
+}
+
+func TestOnStartFailureIsStartupError(t *testing.T) {
+	boom := errors.New("dictionary cache unavailable")
+	p := &startingProducer{testProducer: testProducer{chunks: 4, chunkSize: 3000}}
+	c := &startingConsumer{err: boom}
+	var states []State
+
+	err := Pipe(p, c, WithStateHook(func(sc StateChange) { states = append(states, sc.To) }))
+	var startErr *StartError
+	if !errors.As(err, &startErr) || startErr.Adapter != "consumer" || !errors.Is(err, boom) {
+		t.Fatalf("Pipe() error = %v, want consumer StartError wrapping %v", err, boom)
+	}
+	if p.sent != 0 || len(c.batchSizes()) != 0 {
+		t.Fatalf("data was read (%d chunks) or processed (%v) after a failed start", p.sent, c.batchSizes())
+	}
+	if len(states) != 1 || states[0] != StateFailed {
+		t.Fatalf("states = %v, want [failed]", states)
+	}
+}
+
+func TestOnStartOnceForSharedAdapter(t *testing.T) {
+	s := &selfPipe{startingProducer{testProducer: testProducer{chunks: 1, chunkSize: 1}}}
+
+	Pipe(s, s, WithInlineMode())
+	if s.started != 1 {
+		t.Fatalf("OnStart called %d times, want 1", s.started)
+	}
+}
+
+// stoppingConsumer считает вызовы OnStop
+type stoppingConsumer struct {
+	startingConsumer
+	stopErr error
+	stopped int
+	// Сколько батчей было обработано к моменту OnStop
+	batchesAtStop int
+}
+
+func (c *stoppingConsumer) OnStop(ctx context.Context) error {
+	c.stopped++
+	c.batchesAtStop = len(c.batchSizes())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return c.stopErr
+}
+
+// closingProducer - источник с io.Closer
+type closingProducer struct {
+	testProducer
+	closed int
+}
+
+func (p *closingProducer) Close() error {
+	p.closed++
+	return nil
+}
+
+func TestOnStopAfterDrain(t *testing.T) {
+	p := &closingProducer{testProducer: testProducer{chunks: 7, chunkSize: 3000, finish: make(chan struct{})}}
+	c := &stoppingConsumer{}
+
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, c) }()
+	waitCommits(t, &p.testProducer, 6)
+	close(p.finish)
+	if err := <-done; !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	if p.closed != 1 || c.stopped != 1 {
+		t.Fatalf("producer closed %d times, consumer stopped %d times, want 1 each", p.closed, c.stopped)
+	}
+	// Два полных батча и остаток, дописанный после остановки источника - и только потом OnStop
+	if c.batchesAtStop != 3 {
+		t.Fatalf("consumer stopped after %d batches, want 3", c.batchesAtStop)
+	}
+}
+
+func TestOnStopErrorJoinedWithRunError(t *testing.T) {
+	boom := errors.New("flush failed")
+	c := &stoppingConsumer{stopErr: boom}
+
+	err := Pipe(&testProducer{chunks: 1, chunkSize: 1}, c, WithInlineMode())
+	if !errors.Is(err, errSourceDone) || !errors.Is(err, boom) {
+		t.Fatalf("Pipe() error = %v, want both %v and %v", err, errSourceDone, boom)
+	}
+}
+
+func TestOnStopOnlyForStartedAdapters(t *testing.T) {
+	p := &closingProducer{}
+	c := &stoppingConsumer{startingConsumer: startingConsumer{err: errors.New("no connection")}}
+
+	Pipe(p, c)
+	if p.closed != 1 || c.stopped != 0 {
+		t.Fatalf("producer closed %d times, consumer stopped %d times, want 1 and 0", p.closed, c.stopped)
+	}
+}
@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// WithLogger пишет в logger структурные события запуска: отправку и коммит батчей (Debug), упавшие попытки
+// Next, Process и Commit, DLQ и пропуски коммита (Warn) и итог запуска (Info или Error).
+// Теги запуска (WithTags) добавляются атрибутами к каждому событию.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *config) {
+		cfg.logger = logger
+	}
+}
+
+// runLogger - логгер запуска с тегами, nil - логгера нет
+func (cfg *config) runLogger() *slog.Logger {
+	if cfg.logger == nil || len(cfg.tags) == 0 {
+		return cfg.logger
+	}
+	keys := make([]string, 0, len(cfg.tags))
+	for k := range cfg.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]any, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, slog.String(k, cfg.tags[k]))
+	}
+	return cfg.logger.With(args...)
+}
+
+// log пишет событие, если логгер есть
+func (cfg *config) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if cfg.logger == nil {
+		return
+	}
+	cfg.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// batchAttrs - атрибуты батча: номер, размер и его cookie
+func batchAttrs(seq uint64, items int, cookies []int) []slog.Attr {
+	attrs := []slog.Attr{slog.Uint64("batch", seq), slog.Int("items", items), slog.Int("cookies", len(cookies))}
+	if len(cookies) > 0 {
+		attrs = append(attrs, slog.Int("first_cookie", cookies[0]), slog.Int("last_cookie", cookies[len(cookies)-1]))
+	}
+	return attrs
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logRecords разбирает JSON-лог по строке на событие
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	p := &testProducer{chunks: 2, chunkSize: 6000}
+	c := &flakyConsumer{fails: 1, err: errTransient}
+
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithLogger(logger), WithTags(map[string]string{"pipeline": "orders"}),
+		WithProcessRetry(RetryPolicy{Attempts: 2, Backoff: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	var msgs []string
+	for _, r := range logRecords(t, &buf) {
+		msgs = append(msgs, r["msg"].(string))
+		if r["pipeline"] != "orders" {
+			t.Errorf("record %v has no run tags", r)
+		}
+		switch r["msg"] {
+		case "batch flushed":
+			if r["items"] != 6000.0 || r["reason"] == nil {
+				t.Errorf("flush record = %v", r)
+			}
+		case "process attempt failed":
+			if r["level"] != "WARN" || r["attempt"] != 1.0 || r["error"] != errTransient.Error() {
+				t.Errorf("retry record = %v", r)
+			}
+		case "batch committed":
+			if r["first_cookie"] == nil || r["last_cookie"] == nil {
+				t.Errorf("commit record = %v", r)
+			}
+		}
+	}
+	// Первая попытка каждого батча падает, вторая проходит
+	want := []string{
+		"batch flushed", "process attempt failed", "batch committed",
+		"batch flushed", "process attempt failed", "batch committed", "pipe stopped",
+	}
+	if strings.Join(msgs, ", ") != strings.Join(want, ", ") {
+		t.Errorf("messages = %v, want %v", msgs, want)
+	}
+}
+
+func TestWithLoggerTerminalError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	c := &flakyConsumer{fails: 10, err: errTransient}
+	_ = Pipe(finiteProducer{&testProducer{chunks: 1, chunkSize: 10}}, c, WithInlineMode(), WithLogger(logger))
+
+	records := logRecords(t, &buf)
+	last := records[len(records)-1]
+	if last["msg"] != "pipe failed" || last["level"] != "ERROR" || !strings.Contains(last["error"].(string), errTransient.Error()) {
+		t.Errorf("last record = %v, want the terminal error", last)
+	}
+}
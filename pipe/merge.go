@@ -0,0 +1,272 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Чтение из нескольких источников в один Pipe: PriorityMerge - живой источник с добором из истории,
+Merge - несколько равноправных (например, топики, которые пишутся в одну таблицу).
+У каждого источника своё пространство cookie, поэтому наружу отдаём свои сквозные номера,
+а при Commit переводим их обратно в (источник, cookie) и коммитим в нужный источник.
+Pipe коммитит строго в порядке Next, значит и внутри каждого источника порядок сохраняется.
+*/
+
+// DefaultLivePoll - сколько по умолчанию ждём живой источник, прежде чем идти в backfill
+const DefaultLivePoll = 100 * time.Millisecond
+
+// sourceCookie - cookie конкретного источника, спрятанный за сквозным номером
+type sourceCookie struct {
+	src    int
+	cookie int
+}
+
+// PriorityMerge объединяет "живой" источник и источник с историческими данными (backfill).
+// На каждый вызов Next сначала спрашиваем live, но не дольше livePoll (livePoll <= 0 - DefaultLivePoll):
+// живой источник обычно блокируется до прихода данных, и без дедлайна backfill никогда бы не читался.
+// Если live за это время ничего не отдал, идём в backfill с исходным контекстом - в нём Pipe передаёт
+// свободное место в батче (см. BatchCapacity), и backfill может добить батч ровно до лимита.
+// Так батч в первую очередь набирается свежими данными, а оставшееся место заполняется историей.
+//
+// Live должен честно выходить по отмене контекста - по нему мы понимаем, что данных пока нет.
+// ErrEndOfStream от backfill - история дочитана: дальше ждём только live, без livePoll.
+// Поток кончается, только когда ErrEndOfStream отдаст сам live.
+func PriorityMerge(live, backfill Producer, livePoll time.Duration) Producer {
+	if livePoll <= 0 {
+		livePoll = DefaultLivePoll
+	}
+	return &priorityMerge{
+		cookieRouter: newCookieRouter([]Producer{live, backfill}),
+		livePoll:     livePoll,
+	}
+}
+
+type priorityMerge struct {
+	cookieRouter
+	livePoll time.Duration
+	// Backfill отдал ErrEndOfStream - больше его не спрашиваем
+	backfillDone atomic.Bool
+}
+
+func (m *priorityMerge) adapters() []adapter {
+	return []adapter{{"live", m.sources[0]}, {"backfill", m.sources[1]}}
+}
+
+func (m *priorityMerge) Next(ctx context.Context) ([]any, int, error) {
+	if m.backfillDone.Load() {
+		items, cookie, err := m.sources[0].Next(ctx)
+		if err != nil || len(items) == 0 {
+			return nil, 0, err
+		}
+		return items, m.remember(0, cookie), nil
+	}
+
+	liveCtx, cancel := context.WithTimeout(ctx, m.livePoll)
+	items, cookie, err := m.sources[0].Next(liveCtx)
+	polled := errors.Is(liveCtx.Err(), context.DeadlineExceeded)
+	cancel()
+
+	switch {
+	case err != nil && polled && ctx.Err() == nil:
+		// Живой источник ничего не дождался - это не ошибка, просто идём в backfill
+	case err != nil:
+		return nil, 0, err
+	case len(items) > 0:
+		return items, m.remember(0, cookie), nil
+	}
+
+	items, cookie, err = m.sources[1].Next(ctx)
+	if errors.Is(err, ErrEndOfStream) {
+		// История кончилась - это ещё не конец потока, следующий Next ждёт live
+		m.backfillDone.Store(true)
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// Пустые пачки Pipe не коммитит, поэтому их cookie и не запоминаем
+	if len(items) == 0 {
+		return nil, 0, nil
+	}
+	return items, m.remember(1, cookie), nil
+}
+
+// cookieRouter раздаёт сквозные номера cookie нескольких источников и коммитит каждый в свой источник
+type cookieRouter struct {
+	sources []Producer
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]sourceCookie
+}
+
+func newCookieRouter(sources []Producer) cookieRouter {
+	return cookieRouter{sources: sources, pending: make(map[int]sourceCookie)}
+}
+
+// remember выдаёт сквозной номер для cookie источника src
+func (r *cookieRouter) remember(src, cookie int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	r.pending[r.seq] = sourceCookie{src: src, cookie: cookie}
+	return r.seq
+}
+
+// readAheadStopper - источник, который сам читает вперёд. Pipe зовёт stopReadAhead, как только начинает
+// дописывание: Next больше не будет, и долгие чтения в фоне не должны тянуть остановку
+type readAheadStopper interface {
+	stopReadAhead()
+}
+
+// stopReadAhead передаёт остановку вложенным Merge
+func (r *cookieRouter) stopReadAhead() {
+	for _, src := range r.sources {
+		if s, ok := src.(readAheadStopper); ok {
+			s.stopReadAhead()
+		}
+	}
+}
+
+// PartitionOf - номер источника, из которого пришёл cookie (Partitioner)
+func (r *cookieRouter) PartitionOf(cookie int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pending[cookie].src
+}
+
+func (r *cookieRouter) Commit(ctx context.Context, cookie int) error {
+	r.mu.Lock()
+	sc, ok := r.pending[cookie]
+	r.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown cookie %d", cookie)
+	}
+	if err := r.sources[sc.src].Commit(ctx, sc.cookie); err != nil {
+		return err
+	}
+
+	// Забываем cookie только после успешного коммита, чтобы его можно было повторить
+	r.mu.Lock()
+	delete(r.pending, cookie)
+	r.mu.Unlock()
+	return nil
+}
+
+// Merge читает все sources одновременно и отдаёт пачки в том порядке, в каком они готовы: источник,
+// который молчит, не задерживает остальные. Каждый источник коммитится своими cookie и в своём порядке.
+// Ошибка любого источника (кроме ErrEndOfStream) - ошибка Next; ErrEndOfStream приходит, когда кончились все.
+//
+// Источники читаются в своих горутинах, каждый не больше чем на одну пачку вперёд, с контекстом первого
+// вызова Next без его отмены и дедлайна (BatchCapacity и арены им не достаются). Горутины останавливаются,
+// как только Pipe начинает дописывание (чтение источников получает отмену), или в OnStop/Close - Pipe зовёт
+// его сам; OnStart и OnStop/Close источников тоже вызываются через Merge.
+func Merge(sources ...Producer) Producer {
+	return &mergeProducer{cookieRouter: newCookieRouter(sources)}
+}
+
+type mergeProducer struct {
+	cookieRouter
+
+	once    sync.Once
+	results chan mergeResult
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	// Сколько источников отдали ErrEndOfStream (Next не зовут параллельно)
+	ended int
+}
+
+// mergeResult - ответ Next одного источника
+type mergeResult struct {
+	src    int
+	items  []any
+	cookie int
+	err    error
+}
+
+func (m *mergeProducer) Next(ctx context.Context) ([]any, int, error) {
+	m.once.Do(func() { m.read(ctx) })
+	for m.ended < len(m.sources) {
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case r := <-m.results:
+			switch {
+			case errors.Is(r.err, ErrEndOfStream):
+				m.ended++
+			case r.err != nil:
+				return nil, 0, fmt.Errorf("source %d: %w", r.src, r.err)
+			default:
+				return r.items, m.remember(r.src, r.cookie), nil
+			}
+		}
+	}
+	return nil, 0, ErrEndOfStream
+}
+
+// read запускает по горутине на источник
+func (m *mergeProducer) read(ctx context.Context) {
+	ctx, m.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	m.results = make(chan mergeResult)
+	for i, src := range m.sources {
+		m.wg.Add(1)
+		go func(i int, src Producer) {
+			defer m.wg.Done()
+			for {
+				items, cookie, err := src.Next(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				// Пустые пачки Pipe не коммитит, поэтому их и не отдаём
+				if err == nil && len(items) == 0 {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case m.results <- mergeResult{src: i, items: items, cookie: cookie, err: err}:
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(i, src)
+	}
+}
+
+func (m *mergeProducer) adapters() []adapter {
+	adapters := make([]adapter, len(m.sources))
+	for i, src := range m.sources {
+		adapters[i] = adapter{fmt.Sprintf("source %d", i), src}
+	}
+	return adapters
+}
+
+func (m *mergeProducer) OnStart(ctx context.Context) error {
+	started, err := startAdapters(ctx, m.adapters())
+	if err != nil {
+		return withStopError(err, stopAdapters(ctx, started))
+	}
+	return nil
+}
+
+func (m *mergeProducer) stopReadAhead() {
+	// Горутины чтения могли так и не стартовать, тогда once не даст им сделать это после остановки
+	m.once.Do(func() {})
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.cookieRouter.stopReadAhead()
+}
+
+func (m *mergeProducer) OnStop(ctx context.Context) error {
+	m.stopReadAhead()
+	m.wg.Wait()
+	return stopAdapters(ctx, m.adapters())
+}
@@ -0,0 +1,240 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chanProducer - "живой" источник: блокируется, пока в канал не придёт пачка или не отменят контекст
+type chanProducer struct {
+	ch chan []any
+
+	mu        sync.Mutex
+	cookie    int
+	committed []int
+}
+
+func (p *chanProducer) Next(ctx context.Context) ([]any, int, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case items := <-p.ch:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.cookie++
+		return items, p.cookie, nil
+	}
+}
+
+func (p *chanProducer) Commit(ctx context.Context, cookie int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed = append(p.committed, cookie)
+	return nil
+}
+
+func TestPriorityMergeOrderAndCommitRouting(t *testing.T) {
+	live := &chanProducer{ch: make(chan []any, 10)}
+	backfill := &testProducer{chunks: 10, chunkSize: 2}
+	m := PriorityMerge(live, backfill, 10*time.Millisecond)
+	ctx := context.Background()
+
+	// 1: у live есть данные - берём их, backfill не трогаем
+	live.ch <- []any{"l1"}
+	// 2: live пуст и блокируется - после poll идём в backfill
+	// 3: снова live
+	// 4: снова backfill
+	var got []any
+	var cookies []int
+	for step := 1; step <= 4; step++ {
+		if step == 3 {
+			live.ch <- []any{"l2"}
+		}
+		start := time.Now()
+		items, cookie, err := m.Next(ctx)
+		if err != nil {
+			t.Fatalf("step %d: Next() error = %v", step, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("step %d: Next() blocked on live source", step)
+		}
+		got = append(got, items[0])
+		cookies = append(cookies, cookie)
+	}
+
+	if want := []any{"l1", 1, "l2", 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("items = %v, want %v", got, want)
+	}
+
+	for _, cookie := range cookies {
+		if err := m.Commit(ctx, cookie); err != nil {
+			t.Fatalf("Commit(%d) error = %v", cookie, err)
+		}
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(live.committed, want) {
+		t.Fatalf("live commits = %v, want %v", live.committed, want)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(backfill.commits(), want) {
+		t.Fatalf("backfill commits = %v, want %v", backfill.commits(), want)
+	}
+	if err := m.Commit(ctx, cookies[0]); err == nil {
+		t.Fatal("Commit() of an already committed cookie succeeded")
+	}
+}
+
+// capacityBackfill отдаёт пачку ровно под свободное место в батче
+type capacityBackfill struct {
+	testProducer
+}
+
+func (p *capacityBackfill) Next(ctx context.Context) ([]any, int, error) {
+	items, cookie, err := p.testProducer.Next(ctx)
+	if n, ok := BatchCapacity(ctx); ok && n < len(items) {
+		items = items[:n]
+	}
+	return items, cookie, err
+}
+
+// Backfill добивает батч, начатый живым источником, ровно до лимита
+func TestPriorityMergeBackfillFillsCapacity(t *testing.T) {
+	live := &chanProducer{ch: make(chan []any, 1)}
+	live.ch <- make([]any, 2500)
+	backfill := &capacityBackfill{testProducer{chunks: 3, chunkSize: 4000}}
+	c := &sizedConsumer{hint: 5000}
+
+	Pipe(PriorityMerge(live, backfill, time.Millisecond), c)
+
+	if sizes := c.batchSizes(); len(sizes) == 0 || sizes[0] != 5000 {
+		t.Fatalf("batch sizes = %v, want first batch of 5000", sizes)
+	}
+}
+
+// Backfill дочитан раньше, чем live - Pipe продолжает читать live, а не заканчивает поток
+func TestPriorityMergeBackfillEndsBeforeLive(t *testing.T) {
+	live := &chanProducer{ch: make(chan []any, 10)}
+	backfill := &testProducer{chunks: 2, chunkSize: 100}
+	pl := NewPipeline(PriorityMerge(live, finiteProducer{backfill}, time.Millisecond), &testConsumer{})
+	ctx := context.Background()
+	if err := pl.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for backfill.sentChunks() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	feed(live, []any{1, 2, 3})
+	if _, err := pl.Barrier(ctx); err != nil {
+		t.Fatalf("Barrier() after backfill end error = %v", err)
+	}
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := backfill.commits(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("backfill commits = %v, want [1 2]", got)
+	}
+	if live.committed == nil || len(live.committed) != 1 {
+		t.Errorf("live commits = %v, want one after the backfill ended", live.committed)
+	}
+}
+
+// stopProducer запоминает, что его остановили
+type stopProducer struct {
+	chanProducer
+	stopped bool
+}
+
+func (p *stopProducer) OnStop(ctx context.Context) error {
+	p.stopped = true
+	return nil
+}
+
+func TestMergeCommitsEachSource(t *testing.T) {
+	a := &testProducer{chunks: 3, chunkSize: 3000}
+	b := &testProducer{chunks: 5, chunkSize: 3000}
+	c := &testConsumer{}
+
+	if err := Pipe(Merge(finiteProducer{a}, finiteProducer{b}), c); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if !reflect.DeepEqual(a.commits(), []int{1, 2, 3}) || !reflect.DeepEqual(b.commits(), []int{1, 2, 3, 4, 5}) {
+		t.Fatalf("commits = %v and %v, want each source committed in its order", a.commits(), b.commits())
+	}
+	total := 0
+	for _, size := range c.batchSizes() {
+		total += size
+	}
+	if total != 8*3000 {
+		t.Fatalf("processed %d items, want %d", total, 8*3000)
+	}
+}
+
+func TestMergeIdleSourceDoesNotBlock(t *testing.T) {
+	// Живой источник молчит, второй отдаёт всё и падает - Pipe не ждёт первого и останавливает его
+	idle := &stopProducer{chanProducer: chanProducer{ch: make(chan []any)}}
+	busy := &testProducer{chunks: 4, chunkSize: 3000}
+	c := &testConsumer{}
+
+	err := Pipe(Merge(idle, busy), c)
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if !reflect.DeepEqual(busy.commits(), []int{1, 2, 3, 4}) {
+		t.Fatalf("commits = %v, want [1 2 3 4]", busy.commits())
+	}
+	if !idle.stopped {
+		t.Fatal("idle source was not stopped")
+	}
+}
+
+// cancelWatchProducer закрывает canceled, когда его Next вернулся по отмене
+type cancelWatchProducer struct {
+	chanProducer
+	canceled chan struct{}
+}
+
+func (p *cancelWatchProducer) Next(ctx context.Context) ([]any, int, error) {
+	items, cookie, err := p.chanProducer.Next(ctx)
+	if err != nil {
+		close(p.canceled)
+	}
+	return items, cookie, err
+}
+
+// awaitConsumer в Process ждёт сигнала и запоминает, дождался ли
+type awaitConsumer struct {
+	signal <-chan struct{}
+	got    bool
+}
+
+func (c *awaitConsumer) Process(ctx context.Context, items []any) error {
+	select {
+	case <-c.signal:
+		c.got = true
+	case <-time.After(time.Second):
+	}
+	return nil
+}
+
+func TestMergeStopsReadAheadOnDrain(t *testing.T) {
+	// Второй источник упал - долгое чтение первого отменяется ещё до того, как допишется буфер
+	idle := &cancelWatchProducer{chanProducer: chanProducer{ch: make(chan []any)}, canceled: make(chan struct{})}
+	busy := &testProducer{chunks: 1, chunkSize: 10}
+	c := &awaitConsumer{signal: idle.canceled}
+
+	err := Pipe(Merge(idle, busy), c)
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if !c.got {
+		t.Fatal("idle source read was still running while the buffer drained")
+	}
+	if !reflect.DeepEqual(busy.commits(), []int{1}) {
+		t.Fatalf("commits = %v, want [1]", busy.commits())
+	}
+}
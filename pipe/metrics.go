@@ -0,0 +1,366 @@
+package pipe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Метрики для Prometheus: сколько прочитано и записано, сколько длятся Process и Commit, ошибки по стадиям
+и заполненность буфера. Клиентскую библиотеку не тянем - Metrics сама отдаёт текстовый формат,
+который Prometheus забирает напрямую (ServeHTTP), а время последнего коммита даёт алерт на вставший пайплайн.
+*/
+
+// metricsBuckets - границы гистограмм длительности в секундах, как по умолчанию в клиенте Prometheus
+var metricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// chunkBuckets - границы гистограммы размеров пачек Next: те же степени двойки, что у FlushStats
+var chunkBuckets = func() []float64 {
+	b := make([]float64, len(flushBuckets))
+	for i, n := range flushBuckets {
+		b[i] = float64(n)
+	}
+	return b
+}()
+
+// Metrics копит метрики запусков Pipe. Заводится через NewMetrics, подключается через WithMetrics,
+// отдаётся Prometheus через ServeHTTP или WriteTo. Один Metrics на несколько запусков подряд - суммируется.
+// Теги запуска (WithTags) становятся метками каждой серии, так что нескольким Pipe с разными тегами - по своему Metrics.
+type Metrics struct {
+	namespace string
+	now       func() time.Time
+
+	mu sync.Mutex
+	// Метки из тегов последнего запуска, уже готовые к выводу: k1="v1",k2="v2"
+	tags      string
+	itemsRead int64
+	committed int64
+	flushed   map[FlushReason]int64
+	// Выкинутые и отданные в ProcessDeletes tombstone-элементы
+	dropped, deleted int64
+	errors           map[Stage]int64
+	process          *histogram
+	commit           *histogram
+	chunks           *histogram
+	chunkGaps        *histogram
+	buffer           int
+	lastCommit       time.Time
+	// Статистика по ключу последнего батча (WithKeyStats), nil - её не было
+	keys *KeyStats
+}
+
+// histogram - гистограмма с границами bounds, корзины накопительные, как у Prometheus
+type histogram struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.bounds {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// NewMetrics создаёт метрики с префиксом namespace у имён ("" - pipe)
+func NewMetrics(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = "pipe"
+	}
+	return &Metrics{
+		namespace: namespace,
+		now:       time.Now,
+		flushed:   make(map[FlushReason]int64),
+		errors:    make(map[Stage]int64),
+		process:   newHistogram(metricsBuckets),
+		commit:    newHistogram(metricsBuckets),
+		chunks:    newHistogram(chunkBuckets),
+		chunkGaps: newHistogram(metricsBuckets),
+	}
+}
+
+// WithMetrics пишет в m метрики запуска
+func WithMetrics(m *Metrics) Option {
+	return func(cfg *config) {
+		cfg.metrics = m
+	}
+}
+
+// setTags берёт теги запуска в метки серий
+func (m *Metrics) setTags(tags map[string]string) {
+	if m == nil {
+		return
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", k, tags[k])
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tags = strings.Join(pairs, ",")
+}
+
+// labels - метки серии: теги запуска и pairs (имя, значение, имя, значение...). Без меток - пустая строка
+func (m *Metrics) labels(pairs ...string) string {
+	all := m.tags
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if all != "" {
+			all += ","
+		}
+		all += fmt.Sprintf("%s=%q", pairs[i], pairs[i+1])
+	}
+	if all == "" {
+		return ""
+	}
+	return "{" + all + "}"
+}
+
+// validMetricLabel - годится ли имя в метку Prometheus
+func validMetricLabel(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// metricLabels - метки, которые Metrics ставит сама: теги с такими именами их бы заслонили
+var metricLabels = map[string]bool{"reason": true, "action": true, "stage": true, "key": true, "le": true}
+
+func (m *Metrics) read(items int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.itemsRead += int64(items)
+}
+
+func (m *Metrics) flush(reason FlushReason) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushed[reason]++
+}
+
+func (m *Metrics) processed(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.process.observe(d.Seconds())
+}
+
+// chunk - Next вернул непустую пачку из items элементов через gap после предыдущей (0 - первая за запуск)
+func (m *Metrics) chunk(items int, gap time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunks.observe(float64(items))
+	if gap > 0 {
+		m.chunkGaps.observe(gap.Seconds())
+	}
+}
+
+// committedCookie - Commit одного cookie прошёл за d
+func (m *Metrics) committedCookie(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commit.observe(d.Seconds())
+	m.lastCommit = m.now()
+}
+
+// committedItems - закоммичены все cookie батча из items элементов
+func (m *Metrics) committedItems(items int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.committed += int64(items)
+}
+
+func (m *Metrics) fail(stage Stage, n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[stage] += int64(n)
+}
+
+// tombstones - n tombstone-элементов выкинуто (TombstonesDrop) или отдано в ProcessDeletes (TombstonesRoute)
+func (m *Metrics) tombstones(policy Tombstones, n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if policy == TombstonesDrop {
+		m.dropped += int64(n)
+	} else {
+		m.deleted += int64(n)
+	}
+}
+
+// bufferFill - сколько элементов сейчас в буфере собираемого батча
+func (m *Metrics) bufferFill(items int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buffer = items
+}
+
+// keyStats - статистика по ключу очередного батча, в метриках остаётся только последняя
+func (m *Metrics) keyStats(st KeyStats) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = &st
+}
+
+// ServeHTTP отдаёт метрики в текстовом формате Prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := m.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteTo пишет метрики в текстовом формате Prometheus
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	name := func(s string) string { return m.namespace + "_" + s }
+	header := func(metric, typ, help string) {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, typ)
+	}
+
+	header(name("items_read_total"), "counter", "Items returned by Next.")
+	fmt.Fprintf(cw, "%s%s %d\n", name("items_read_total"), m.labels(), m.itemsRead)
+
+	header(name("items_committed_total"), "counter", "Items of batches with all cookies committed.")
+	fmt.Fprintf(cw, "%s%s %d\n", name("items_committed_total"), m.labels(), m.committed)
+
+	header(name("batches_flushed_total"), "counter", "Batches sent to the consumer by flush reason.")
+	reasons := make([]string, 0, len(m.flushed))
+	for r := range m.flushed {
+		reasons = append(reasons, string(r))
+	}
+	sort.Strings(reasons)
+	for _, r := range reasons {
+		fmt.Fprintf(cw, "%s%s %d\n", name("batches_flushed_total"), m.labels("reason", r), m.flushed[FlushReason(r)])
+	}
+
+	header(name("tombstones_total"), "counter", "Tombstone items by action: dropped or routed to ProcessDeletes.")
+	fmt.Fprintf(cw, "%s%s %d\n", name("tombstones_total"), m.labels("action", "dropped"), m.dropped)
+	fmt.Fprintf(cw, "%s%s %d\n", name("tombstones_total"), m.labels("action", "routed"), m.deleted)
+
+	header(name("errors_total"), "counter", "Errors by pipeline stage.")
+	for _, st := range []Stage{StageRead, StageTransform, StageProcess, StageCommit} {
+		fmt.Fprintf(cw, "%s%s %d\n", name("errors_total"), m.labels("stage", string(st)), m.errors[st])
+	}
+
+	writeHistogram(cw, name("process_duration_seconds"), "Duration of successful Process calls.", m.process, header, m.labels)
+	writeHistogram(cw, name("commit_duration_seconds"), "Duration of successful Commit calls, retries included.", m.commit, header, m.labels)
+	writeHistogram(cw, name("chunk_items"), "Items in non-empty chunks returned by Next.", m.chunks, header, m.labels)
+	writeHistogram(cw, name("chunk_interarrival_seconds"), "Time between consecutive non-empty chunks returned by Next.", m.chunkGaps, header, m.labels)
+
+	header(name("buffer_items"), "gauge", "Items in the batch being filled.")
+	fmt.Fprintf(cw, "%s%s %d\n", name("buffer_items"), m.labels(), m.buffer)
+
+	header(name("last_commit_timestamp_seconds"), "gauge", "Unix time of the last successful Commit, 0 before the first one.")
+	last := 0.0
+	if !m.lastCommit.IsZero() {
+		last = float64(m.lastCommit.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(cw, "%s%s %s\n", name("last_commit_timestamp_seconds"), m.labels(), formatFloat(last))
+
+	if ks := m.keys; ks != nil {
+		header(name("batch_key_items"), "gauge", "Items per key among the most frequent keys of the last batch, an upper estimate.")
+		for _, kc := range ks.TopK {
+			fmt.Fprintf(cw, "%s%s %d\n", name("batch_key_items"), m.labels("key", kc.Key), kc.Count)
+		}
+		header(name("batch_key_cardinality"), "gauge", "Estimated number of distinct keys in the last batch.")
+		fmt.Fprintf(cw, "%s%s %d\n", name("batch_key_cardinality"), m.labels(), ks.Cardinality)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+func writeHistogram(w io.Writer, metric, help string, h *histogram, header func(metric, typ, help string), labels func(pairs ...string) string) {
+	header(metric, "histogram", help)
+	for i, le := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", metric, labels("le", formatFloat(le)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", metric, labels("le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", metric, labels(), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", metric, labels(), h.count)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter считает записанное и запоминает первую ошибку
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
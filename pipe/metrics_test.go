@@ -0,0 +1,148 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMetrics(t *testing.T) {
+	m := NewMetrics("")
+	m.now = func() time.Time { return time.Unix(1700000000, 0) }
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithMetrics(m)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	var out strings.Builder
+	if _, err := m.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE pipe_items_read_total counter",
+		"pipe_items_read_total 21000\n",
+		"pipe_items_committed_total 21000\n",
+		`pipe_batches_flushed_total{reason="size"} 2` + "\n",
+		`pipe_batches_flushed_total{reason="shutdown"} 1` + "\n",
+		`pipe_errors_total{stage="process"} 0` + "\n",
+		"# TYPE pipe_process_duration_seconds histogram",
+		`pipe_process_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"pipe_process_duration_seconds_count 3\n",
+		"pipe_commit_duration_seconds_count 7\n",
+		`pipe_chunk_items_bucket{le="4096"} 7` + "\n",
+		"pipe_chunk_items_sum 21000\n",
+		"pipe_chunk_interarrival_seconds_count 6\n",
+		"pipe_buffer_items 0\n",
+		"pipe_last_commit_timestamp_seconds 1.7e+09\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics have no %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsErrorsAndHTTP(t *testing.T) {
+	m := NewMetrics("etl")
+	c := &flakyConsumer{fails: 10, err: errTransient}
+	_ = Pipe(finiteProducer{&testProducer{chunks: 1, chunkSize: 10}}, c, WithInlineMode(), WithMetrics(m))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`etl_errors_total{stage="process"} 1`, "etl_last_commit_timestamp_seconds 0\n", "etl_process_duration_seconds_count 0\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics have no %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsTagLabels(t *testing.T) {
+	m := NewMetrics("")
+	p := &testProducer{chunks: 1, chunkSize: 10}
+	tags := map[string]string{"team": "billing", "env": `pr"od`}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithMetrics(m), WithTags(tags)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	var out strings.Builder
+	if _, err := m.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	for _, want := range []string{
+		`pipe_items_read_total{env="pr\"od",team="billing"} 10` + "\n",
+		`pipe_batches_flushed_total{env="pr\"od",team="billing",reason="shutdown"} 1` + "\n",
+		`pipe_errors_total{env="pr\"od",team="billing",stage="read"} 0` + "\n",
+		`pipe_process_duration_seconds_bucket{env="pr\"od",team="billing",le="+Inf"} 1` + "\n",
+		`pipe_process_duration_seconds_count{env="pr\"od",team="billing"} 1` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics have no %q:\n%s", want, out.String())
+		}
+	}
+	// Каждая серия с тегами
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.HasPrefix(line, "#") && !strings.Contains(line, `team="billing"`) {
+			t.Errorf("series without tags: %q", line)
+		}
+	}
+}
+
+func TestMetricsKeyStats(t *testing.T) {
+	m := NewMetrics("")
+	p := &testProducer{chunks: 3, chunkSize: 10}
+	// testProducer заполняет пачку её номером: в батче из трёх пачек три ключа по 10
+	key := func(item any) string { return fmt.Sprint(item) }
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithMetrics(m), WithKeyStats(key, 3, nil),
+		WithTags(map[string]string{"team": "billing"}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	var out strings.Builder
+	if _, err := m.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE pipe_batch_key_items gauge\n",
+		`pipe_batch_key_items{team="billing",key="1"} 10` + "\n",
+		`pipe_batch_key_items{team="billing",key="2"} 10` + "\n",
+		`pipe_batch_key_items{team="billing",key="3"} 10` + "\n",
+		`pipe_batch_key_cardinality{team="billing"} 3` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics have no %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsWithoutKeyStats(t *testing.T) {
+	m := NewMetrics("")
+	if err := Pipe(finiteProducer{&testProducer{chunks: 1, chunkSize: 10}}, &testConsumer{}, WithInlineMode(), WithMetrics(m)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	var out strings.Builder
+	if _, err := m.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if strings.Contains(out.String(), "batch_key") {
+		t.Errorf("key metrics without WithKeyStats:\n%s", out.String())
+	}
+}
+
+func TestMetricsTagLabelValidation(t *testing.T) {
+	_, err := newConfig([]Option{WithMetrics(NewMetrics("")), WithTags(map[string]string{"stage": "x", "my-tag": "y"})})
+	var ce *ConfigError
+	if !errors.As(err, &ce) || len(ce.Problems) != 2 {
+		t.Fatalf("newConfig() error = %v, want two tag problems", err)
+	}
+	// Без метрик теги могут быть любыми
+	if _, err := newConfig([]Option{WithTags(map[string]string{"my-tag": "y"})}); err != nil {
+		t.Fatalf("newConfig() without metrics error = %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithCommitMirror вызывает fn после каждого удачного Commit источника - чтобы продублировать прогресс во внешнее
+// хранилище (мониторинг, восстановление после аварии) без обёртки над источником. Контекст - контекст батча.
+// Ошибка останавливает Pipe как ошибка коммита: сам cookie уже закоммичен, и зеркало догонит прогресс
+// на следующем удачном коммите после рестарта. Cookie, пропущенные по CommitSkip, в зеркало не попадают.
+// Несколько опций - несколько зеркал, по порядку.
+func WithCommitMirror(fn func(ctx context.Context, cookie int) error) Option {
+	return func(cfg *config) {
+		cfg.commitMirrors = append(cfg.commitMirrors, fn)
+	}
+}
+
+// mirrorCommit отдаёт закоммиченный cookie во все зеркала
+func (cfg *config) mirrorCommit(ctx context.Context, cookie int) error {
+	for i, fn := range cfg.commitMirrors {
+		if fn == nil {
+			continue
+		}
+		if err := fn(ctx, cookie); err != nil {
+			return fmt.Errorf("commit mirror %d: cookie %d: %w", i, cookie, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWithCommitMirror(t *testing.T) {
+	p := &testProducer{chunks: 3, chunkSize: 6000}
+	var mirrored, second []int
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(),
+		WithCommitMirror(func(ctx context.Context, cookie int) error {
+			// Зеркало видит контекст батча, а источник к этому моменту уже закоммитил cookie
+			if _, ok := BatchContext(ctx); !ok {
+				t.Errorf("mirror for cookie %d called outside of a batch context", cookie)
+			}
+			if got := p.commits(); got[len(got)-1] != cookie {
+				t.Errorf("mirror for cookie %d called before the commit (commits %v)", cookie, got)
+			}
+			mirrored = append(mirrored, cookie)
+			return nil
+		}),
+		WithCommitMirror(func(ctx context.Context, cookie int) error {
+			second = append(second, cookie)
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(mirrored, want) || !reflect.DeepEqual(second, want) {
+		t.Errorf("mirrored = %v and %v, want %v", mirrored, second, want)
+	}
+}
+
+func TestWithCommitMirrorError(t *testing.T) {
+	errMirror := errors.New("mirror store unavailable")
+	p := &testProducer{chunks: 3, chunkSize: 6000}
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithCommitMirror(func(ctx context.Context, cookie int) error {
+		if cookie == 2 {
+			return errMirror
+		}
+		return nil
+	}))
+	if !errors.Is(err, errMirror) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errMirror)
+	}
+	// Cookie 2 уже закоммичен, дальше Pipe не идёт
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", got)
+	}
+}
@@ -0,0 +1,37 @@
+package pipe
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultQuietPeriod - сколько тишины источника по умолчанию ждёт PipeOnce
+const DefaultQuietPeriod = 5 * time.Second
+
+// WithQuietPeriod заканчивает чтение, когда источник d не давал данных (Next ждёт или отдаёт пустые пачки):
+// прочитанное дописывается и коммитится, как после конца источника, и Pipe возвращает nil.
+// Отсчёт идёт от последней непустой пачки, время на паузе Pipeline.Pause не считается.
+func WithQuietPeriod(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.quietPeriod = d
+	}
+}
+
+// PipeOnce - разовая догрузка для крона: читает источник, пока он не замолчит на WithQuietPeriod
+// (по умолчанию DefaultQuietPeriod), дописывает, коммитит и возвращает отчёт о запуске.
+// Отмена ctx - то же, что Pipeline.Stop без дедлайна: прочитанное всё равно дописывается.
+func PipeOnce(ctx context.Context, p Producer, c Consumer, opts ...Option) (RunReport, error) {
+	return PipeOnceOf[any](ctx, p, c, opts...)
+}
+
+// PipeOnceOf - PipeOnce для элементов конкретного типа
+func PipeOnceOf[T any](ctx context.Context, p ProducerOf[T], c ConsumerOf[T], opts ...Option) (RunReport, error) {
+	var report RunReport
+	opts = append(append([]Option{WithQuietPeriod(DefaultQuietPeriod)}, opts...), WithRunReport(func(r RunReport) { report = r }))
+	pl := NewPipelineOf(p, c, opts...)
+	if err := pl.Start(ctx); err != nil {
+		return RunReport{Err: err}, err
+	}
+	err := pl.Wait()
+	return report, err
+}
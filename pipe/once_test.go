@@ -0,0 +1,71 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPipeOnce(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	src.ch <- []any{1, 2}
+	src.ch <- []any{3}
+	c := &testConsumer{}
+
+	start := time.Now()
+	report, err := PipeOnce(context.Background(), src, c, WithQuietPeriod(30*time.Millisecond))
+	if err != nil {
+		t.Fatalf("PipeOnce() error = %v", err)
+	}
+	if took := time.Since(start); took < 30*time.Millisecond {
+		t.Errorf("PipeOnce() returned after %s, before the quiet period", took)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("batches = %v, want [3]", got)
+	}
+	if !reflect.DeepEqual(src.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", src.committed)
+	}
+	if report.Err != nil || len(report.Shutdown) == 0 || report.Shutdown[0].Stage != ShutdownNext {
+		t.Errorf("report = %+v, want a drain starting with %q", report, ShutdownNext)
+	}
+}
+
+// emptyProducer всё время отдаёт пустые пачки
+type emptyProducer struct{}
+
+func (emptyProducer) Next(ctx context.Context) ([]any, int, error) {
+	time.Sleep(time.Millisecond)
+	return nil, 0, ctx.Err()
+}
+
+func (emptyProducer) Commit(ctx context.Context, cookie int) error {
+	return nil
+}
+
+func TestWithQuietPeriodEmptyChunks(t *testing.T) {
+	// Пустые пачки - тоже тишина
+	done := make(chan error, 1)
+	go func() { done <- Pipe(emptyProducer{}, &testConsumer{}, WithQuietPeriod(20*time.Millisecond)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Pipe() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pipe() did not stop on a quiet source")
+	}
+}
+
+func TestPipeOnceConfigError(t *testing.T) {
+	report, err := PipeOnce(context.Background(), emptyProducer{}, &testConsumer{}, WithQuietPeriod(-time.Second))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Problems[0].Option != "WithQuietPeriod" {
+		t.Fatalf("PipeOnce() error = %v, want a WithQuietPeriod problem", err)
+	}
+	if report.Err != err {
+		t.Errorf("report.Err = %v, want %v", report.Err, err)
+	}
+}
@@ -0,0 +1,591 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Option - функциональная опция для Pipe
+type Option func(*config)
+
+// config - собранные настройки одного запуска Pipe
+type config struct {
+	// Жёсткий дедлайн на один вызов Next, 0 - без присмотра
+	nextTimeout time.Duration
+	// Сколько раз повторяем Next, вышедший по дедлайну
+	nextRetries int
+	// Как часто продлеваем аренду незакоммиченных cookie (для LeaseRenewer)
+	leaseRenewInterval time.Duration
+	// Журнал событий батчей, nil - не пишем
+	eventLog EventLog
+	// Читаем, обрабатываем и коммитим в одной горутине
+	inline bool
+	// Сколько батчей обрабатываем одновременно, 0 и 1 - по одному
+	workers int
+	// Коммитим батчи в порядке окончания обработки, а не сборки
+	unorderedCommits bool
+	// Размер куска арены для элементов, 0 - без арен
+	arenaSlabSize int
+	// Куда отдаём GC-статистику по батчам, nil - не считаем
+	gcStats func(GCStats)
+	// Доля мягкого лимита памяти, выше которой не зовём Next, 0 - не следим
+	memoryThrottle float64
+	// Политика для слишком крупных элементов, nil - не проверяем
+	largeItems *LargeItems
+	// Статистика по ключу батча, nil - не считаем
+	keyStats *keyStatsConfig
+	// Куда отправляем отчёты о доставке, nil - не отправляем
+	deliveryReports chan<- DeliveryReport
+	// Отслеживание SLO по времени обработки, nil - не следим
+	slo *sloTracker
+	// Кому сообщаем о смене фаз
+	stateHooks []func(StateChange)
+	// Отдаём консюмеру копию батча, и чем копировать элементы (nil - только слайс)
+	defensiveCopies bool
+	itemClone       func(any) any
+	// Базовый контекст запуска, nil - context.Background()
+	baseContext func() context.Context
+	// Остановка запуска снаружи (Pipeline), nil - только по источнику или ошибке
+	control *runControl
+	// Теги запуска для метрик, трейсов и логов
+	tags map[string]string
+	// Гистограмма отправленных батчей, nil - не копим
+	flushStats *FlushStats
+	// Считаем хеш содержимого батча и чем кодировать элементы (nil - по умолчанию)
+	batchHash  bool
+	hashEncode func(any) []byte
+	// Кольцо последних батчей на диске, nil - не пишем
+	capture *capture
+	// Проверка порядка cookie, nil - не проверяем
+	cookieCheck *CookieCheck
+	// Выбрасываем повторные пачки с уже виденным cookie, nil - не проверяем
+	duplicateChunks *duplicateChunks
+	// Преобразования пачек перед батчем, по порядку
+	transforms []Transform
+	// Выкидываем элементы, ключ которых уже закоммичен, nil - не выкидываем
+	dedup *dedupConfig
+	// Что делать с tombstone-элементами, nil - как с обычными
+	tombstones *tombstones
+	// Привязка партиции к обработчику (WithPartitionAffinity)
+	affinity *affinityConfig
+	// Колбэки стадий (WithHooks)
+	hooks []Hooks
+	// Сколько даём на дописывание прочитанного после остановки источника, 0 - без ограничения
+	drainTimeout time.Duration
+	// context.Canceled после отмены запуска - тоже ошибка запуска (WithCanceledAsFailure)
+	canceledAsFailure bool
+	// Таймауты шагов остановки, нули - без ограничения
+	shutdownTimeouts ShutdownTimeouts
+	// Кому отдаём отчёт о запуске
+	runReports []func(RunReport)
+	// Догон при большом лаге (WithCatchUp)
+	catchUp *CatchUp
+	// Сколько недобранный батч может ждать новых пачек, 0 - ждёт, пока не наберётся
+	maxBatchDelay time.Duration
+	// Через сколько тишины источника заканчиваем чтение (WithQuietPeriod), 0 - не заканчиваем
+	quietPeriod time.Duration
+	// Меньше скольких элементов не отправляем по таймеру и сколько максимум так ждём
+	minItems     int
+	minItemsWait time.Duration
+	// Лимит батча в байтах, nil - только по числу элементов
+	batchBytes *batchBytes
+	// Потолок байт во всех отправленных и ещё не закоммиченных батчах, nil - без потолка
+	inFlight *inFlightBytes
+	// Скорость отправки в элементах и батчах, nil - без ограничения
+	itemsRate   *tokenBucket
+	batchesRate *tokenBucket
+	// Повторы Process, nil - одна попытка
+	processRetry *RetryPolicy
+	// Сколько у батча времени на все попытки с момента сборки, 0 - только дедлайн контекста
+	batchDeadline time.Duration
+	// Предохранитель вокруг Process, nil - без него
+	breaker *breaker
+	// Глубина очереди батчей по скоростям чтения и обработки, nil - DefaultQueueDepth
+	queue *adaptiveQueue
+	// Конец группы, которую нельзя разрывать между батчами, nil - режем где угодно
+	boundaries func(item any) bool
+	// Сколько батчей с ошибками терпим, nil - не считаем
+	errorBudget *errorBudget
+	// Куда отдаём батчи, которые приёмник так и не принял, nil - останавливаемся
+	deadLetter DeadLetter
+	// Повторы Next на временных ошибках, nil - без повторов
+	nextRetry *RetryPolicy
+	// Повторы Commit и что делать после них, nil - одна попытка и остановка
+	commitRetry *CommitRetry
+	// Куда дублируем закоммиченные cookie (WithCommitMirror)
+	commitMirrors []func(ctx context.Context, cookie int) error
+	// Статистика по стадиям, nil - не считаем
+	stats *Stats
+	// Метрики для Prometheus, nil - не считаем
+	metrics *Metrics
+	// Метки runtime/pprof и время по стадиям (WithProfiling)
+	profiling bool
+	// Куда пишем события запуска, nil - никуда
+	logger *slog.Logger
+	// Куда отдаём дамп при аварии: колбэки и каталоги
+	crashHooks []func(CrashDump)
+	crashDirs  []string
+}
+
+// newConfig применяет опции и проверяет получившиеся настройки целиком
+func newConfig(opts []Option) (*config, error) {
+	cfg := &config{
+		leaseRenewInterval: DefaultLeaseRenewInterval,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ConfigProblem - одна некорректная или конфликтующая настройка
+type ConfigProblem struct {
+	// Опция, к которой относится проблема
+	Option string
+	// Что не так
+	Problem string
+	// Как поправить
+	Suggestion string
+}
+
+func (p ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.Option, p.Problem, p.Suggestion)
+}
+
+// ConfigError - все найденные проблемы в настройках сразу, а не только первая,
+// чтобы не чинить конфиг по одной ошибке за запуск
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Error() string {
+	parts := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		parts = append(parts, p.String())
+	}
+	return "invalid pipe config: " + strings.Join(parts, "; ")
+}
+
+// validate собирает все проблемы настроек в один *ConfigError
+func (cfg *config) validate() error {
+	var problems []ConfigProblem
+	add := func(option, problem, suggestion string) {
+		problems = append(problems, ConfigProblem{Option: option, Problem: problem, Suggestion: suggestion})
+	}
+
+	if cfg.nextTimeout < 0 {
+		add("WithNextTimeout", fmt.Sprintf("timeout %s is negative", cfg.nextTimeout), "use 0 to disable supervision")
+	}
+	if cfg.nextRetries < 0 {
+		add("WithNextTimeout", fmt.Sprintf("retries %d is negative", cfg.nextRetries), "use 0 to fail on the first timeout")
+	}
+	if cfg.nextTimeout == 0 && cfg.nextRetries > 0 {
+		add("WithNextTimeout", "retries are set without a timeout and will never be used", "set a positive timeout")
+	}
+
+	if cfg.leaseRenewInterval <= 0 {
+		add("WithLeaseRenewInterval", fmt.Sprintf("interval %s is not positive", cfg.leaseRenewInterval), "use an interval well below the source visibility timeout")
+	}
+
+	if cfg.arenaSlabSize < 0 {
+		add("WithArena", fmt.Sprintf("slab size %d is negative", cfg.arenaSlabSize), "use a slab of at least a few typical items, or 0 to disable arenas")
+	}
+
+	if cfg.memoryThrottle < 0 || cfg.memoryThrottle > 1 {
+		add("WithMemoryThrottle", fmt.Sprintf("fraction %g is outside (0, 1]", cfg.memoryThrottle), "use e.g. 0.9 to throttle at 90% of the memory limit")
+	}
+
+	if li := cfg.largeItems; li != nil {
+		if li.Size == nil {
+			add("WithLargeItems", "Size func is not set", "pass a func returning the item size")
+		}
+		if li.Threshold <= 0 {
+			add("WithLargeItems", fmt.Sprintf("threshold %d is not positive", li.Threshold), "use the sink's max item size")
+		}
+		if li.Policy == LargeItemSplit && li.Split == nil {
+			add("WithLargeItems", "LargeItemSplit policy without a Split func", "set Split or choose LargeItemSolo")
+		}
+	}
+
+	if ks := cfg.keyStats; ks != nil {
+		if ks.key == nil || ks.fn == nil && cfg.metrics == nil {
+			add("WithKeyStats", "key or callback func is not set", "pass the key extractor and the stats callback (or WithMetrics)")
+		}
+		if ks.topK <= 0 {
+			add("WithKeyStats", fmt.Sprintf("topK %d is not positive", ks.topK), "use e.g. 10")
+		}
+	}
+
+	if t := cfg.slo; t != nil {
+		if t.slo.Threshold <= 0 || t.slo.Window <= 0 {
+			add("WithLatencySLO", "threshold and window must be positive", "use e.g. Threshold: 2s, Window: 10m")
+		}
+		if t.slo.Target <= 0 || t.slo.Target >= 1 {
+			add("WithLatencySLO", fmt.Sprintf("target %g is outside (0, 1)", t.slo.Target), "use e.g. 0.99")
+		}
+		if t.slo.Alert == nil {
+			add("WithLatencySLO", "Alert func is not set", "pass a callback, otherwise breaches go unnoticed")
+		}
+	}
+
+	if cfg.drainTimeout < 0 {
+		add("WithDrainTimeout", fmt.Sprintf("timeout %s is negative", cfg.drainTimeout), "use 0 to wait for the drain without a limit")
+	}
+	if t := cfg.shutdownTimeouts; t.Flush < 0 || t.Process < 0 || t.Commit < 0 || t.Adapters < 0 {
+		add("WithShutdownTimeouts", "a step timeout is negative", "use 0 for steps without a limit")
+	}
+
+	if cfg.maxBatchDelay < 0 {
+		add("WithMaxBatchDelay", fmt.Sprintf("delay %s is negative", cfg.maxBatchDelay), "use 0 to flush only full batches")
+	}
+
+	if cfg.quietPeriod < 0 {
+		add("WithQuietPeriod", fmt.Sprintf("period %s is negative", cfg.quietPeriod), "use 0 to read until the source ends")
+	}
+
+	if cfg.minItems != 0 || cfg.minItemsWait != 0 {
+		switch {
+		case cfg.minItems <= 0 || cfg.minItems > MaxItems:
+			add("WithMinItems", fmt.Sprintf("min items %d is outside 1..%d", cfg.minItems, MaxItems), "use e.g. 1000")
+		case cfg.maxBatchDelay <= 0:
+			add("WithMinItems", "there is no WithMaxBatchDelay to hold back", "add WithMaxBatchDelay, full batches are sent without waiting anyway")
+		case cfg.minItemsWait < cfg.maxBatchDelay:
+			add("WithMinItems", fmt.Sprintf("maxWait %s is shorter than the batch delay %s", cfg.minItemsWait, cfg.maxBatchDelay), "use a maxWait of at least the batch delay")
+		}
+	}
+
+	if rp := cfg.processRetry; rp != nil {
+		rp.validate("WithProcessRetry", add)
+	}
+	cfg.itemsRate.validate("WithRateLimit", add)
+	cfg.breaker.validate(add)
+	cfg.tombstones.validate(add)
+	cfg.queue.validate(cfg.inline, add)
+	cfg.batchesRate.validate("WithBatchRateLimit", add)
+	if cfg.batchDeadline < 0 {
+		add("WithBatchDeadline", fmt.Sprintf("deadline %s is negative", cfg.batchDeadline), "use 0 to limit retries by the context deadline only")
+	} else if cfg.batchDeadline > 0 && cfg.processRetry == nil && cfg.commitRetry == nil {
+		add("WithBatchDeadline", "there are no retries to limit", "add WithProcessRetry or WithCommitRetry")
+	}
+	if cfg.boundaries != nil {
+		if cfg.arenaSlabSize > 0 {
+			add("WithBoundaries", "arenas are released per batch and cannot follow a group carried into the next one", "drop WithArena")
+		}
+		if li := cfg.largeItems; li != nil && li.Policy == LargeItemSolo {
+			add("WithBoundaries", "LargeItemSolo sends the buffer in the middle of a group", "use LargeItemFail or LargeItemSplit")
+		}
+	}
+
+	if b := cfg.errorBudget; b != nil {
+		if b.maxRate <= 0 || b.maxRate >= 1 {
+			add("WithErrorBudget", fmt.Sprintf("max error rate %g is outside (0, 1)", b.maxRate), "use e.g. 0.01")
+		}
+		if b.window <= 0 {
+			add("WithErrorBudget", "window is not positive", "use e.g. 10m")
+		}
+	}
+
+	if rp := cfg.nextRetry; rp != nil {
+		rp.validate("WithNextRetry", add)
+	}
+	if cr := cfg.commitRetry; cr != nil {
+		cr.validate("WithCommitRetry", add)
+		if cr.OnExhausted != CommitAbort && cr.OnExhausted != CommitSkip {
+			add("WithCommitRetry", fmt.Sprintf("unknown OnExhausted %d", cr.OnExhausted), "use CommitAbort or CommitSkip")
+		}
+	}
+
+	if cfg.workers < 0 {
+		add("WithWorkers", fmt.Sprintf("workers %d is negative", cfg.workers), "use 1 to process one batch at a time")
+	}
+	if cfg.workers > 1 && cfg.inline {
+		add("WithWorkers", "inline mode processes batches in the reading goroutine", "drop WithInlineMode")
+	}
+
+	if cfg.unorderedCommits && cfg.poolSize() <= 1 {
+		add("WithUnorderedCommits", "one worker commits in order anyway", "add WithWorkers or drop the option")
+	}
+	if cfg.affinity != nil && cfg.poolSize() <= 1 {
+		add("WithPartitionAffinity", "one worker never processes two batches at once", "add WithWorkers or drop the option")
+	}
+	if c := cfg.catchUp; c != nil {
+		switch {
+		case c.Enter <= 0:
+			add("WithCatchUp", fmt.Sprintf("Enter %d is not positive", c.Enter), "use the lag you can't catch up in steady mode, e.g. 1000000")
+		case c.Exit < 0 || c.Exit >= c.Enter:
+			add("WithCatchUp", fmt.Sprintf("Exit %d is outside [0, Enter)", c.Exit), "use 0 for half of Enter")
+		}
+		if c.Interval < 0 {
+			add("WithCatchUp", fmt.Sprintf("interval %s is negative", c.Interval), "use 0 for DefaultCatchUpInterval")
+		}
+		if c.Workers < 0 {
+			add("WithCatchUp", fmt.Sprintf("workers %d is negative", c.Workers), "use 0 to keep WithWorkers")
+		} else if c.Workers > 1 && cfg.inline {
+			add("WithCatchUp", "inline mode processes batches in the reading goroutine", "drop WithInlineMode or catch-up workers")
+		}
+	}
+	if cfg.unorderedCommits && cfg.commitRetry != nil && cfg.commitRetry.OnExhausted == CommitSkip {
+		add("WithUnorderedCommits", "CommitSkip relies on a later commit covering the skipped cookie", "use CommitAbort")
+	}
+	if d := cfg.dedup; d != nil {
+		if d.key == nil || d.window == nil {
+			add("WithDedup", "key func or window is nil", "pass a key extractor and a window from NewDedupWindow")
+		} else if d.window.maxKeys <= 0 && d.window.ttl <= 0 {
+			add("WithDedup", "window has neither a key limit nor a ttl and would grow forever", "use e.g. NewDedupWindow(1_000_000, time.Hour)")
+		}
+	}
+	for i, t := range cfg.transforms {
+		if t == nil {
+			add("WithTransform", fmt.Sprintf("transform %d is nil", i), "drop it from the list")
+		}
+	}
+	if dc := cfg.duplicateChunks; dc != nil && dc.window <= 0 {
+		add("WithDuplicateChunks", fmt.Sprintf("window %d is not positive", dc.window), "use a few times the number of chunks a retry can repeat, e.g. 16")
+	}
+
+	if bb := cfg.batchBytes; bb != nil {
+		if bb.max <= 0 {
+			add("WithMaxBatchBytes", fmt.Sprintf("limit %d is not positive", bb.max), "use e.g. 64 << 20 for 64 MiB")
+		}
+		if bb.size == nil {
+			add("WithMaxBatchBytes", "size func is nil", "pass a func that returns the item size in bytes")
+		}
+	}
+
+	if f := cfg.inFlight; f != nil {
+		if f.max <= 0 {
+			add("WithMaxInFlightBytes", fmt.Sprintf("limit %d is not positive", f.max), "use e.g. 256 << 20 for 256 MiB")
+		}
+		if f.size == nil {
+			add("WithMaxInFlightBytes", "size func is nil", "pass a func that returns the item size in bytes")
+		}
+		if bb := cfg.batchBytes; bb != nil && f.max > 0 && f.max < bb.max {
+			add("WithMaxInFlightBytes", fmt.Sprintf("limit %d is below the batch limit %d", f.max, bb.max), "use a limit of a few full batches")
+		}
+	}
+
+	for _, fn := range cfg.crashHooks {
+		if fn == nil {
+			add("WithCrashDump", "hook func is nil", "pass a func or drop the option")
+			break
+		}
+	}
+	for _, dir := range cfg.crashDirs {
+		if dir == "" {
+			add("WithCrashDumpDir", "directory is empty", "pass a directory for the dump files")
+			break
+		}
+	}
+
+	if c := cfg.capture; c != nil {
+		if c.dir == "" {
+			add("WithCapture", "directory is empty", "pass a directory for the capture files")
+		}
+		if c.max <= 0 {
+			add("WithCapture", fmt.Sprintf("maxBatches %d is not positive", c.max), "keep e.g. the last 100 batches")
+		}
+	}
+
+	if _, ok := cfg.tags[""]; ok {
+		add("WithTags", "tag with an empty key", "drop it or give it a name")
+	}
+	// С WithMetrics теги становятся метками Prometheus
+	if cfg.metrics != nil {
+		keys := make([]string, 0, len(cfg.tags))
+		for k := range cfg.tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k != "" && (!validMetricLabel(k) || metricLabels[k]) {
+				add("WithTags", fmt.Sprintf("tag %q is not usable as a metric label", k), "use letters, digits and _, not reason, action, stage or le")
+			}
+		}
+	}
+
+	for _, hook := range cfg.stateHooks {
+		if hook == nil {
+			add("WithStateHook", "hook func is nil", "pass a func or drop the option")
+			break
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// Options собирает несколько опций в одну - для готовых наборов настроек (см. пакет presets).
+// Опции применяются по порядку, так что всё, что передано после набора, перекрывает его.
+func Options(opts ...Option) Option {
+	return func(cfg *config) {
+		for _, opt := range opts {
+			opt(cfg)
+		}
+	}
+}
+
+// ErrNextTimeout - Next не уложился в дедлайн из WithNextTimeout и повторы закончились
+var ErrNextTimeout = errors.New("producer Next timed out")
+
+// WithNextTimeout запускает каждый Next в отдельной горутине под присмотром с дедлайном d.
+// Если источник вышел по дедлайну (вернул ошибку после отмены контекста), вызываем Next заново,
+// но не больше retries раз, после чего Pipe завершается с ErrNextTimeout.
+// Если же источник не слушает ctx и не вернулся даже через d после дедлайна, второй Next параллельно
+// не запускаем (источник рассчитан на последовательные вызовы), а сразу завершаемся с ErrNextTimeout -
+// так плохой драйвер не может навсегда подвесить остановку Pipe, а висеть остаётся максимум одна горутина.
+//
+// Ответ, пришедший с опозданием, но до конца этого ожидания, не теряется и уходит в батч как обычно.
+func WithNextTimeout(d time.Duration, retries int) Option {
+	return func(cfg *config) {
+		cfg.nextTimeout = d
+		cfg.nextRetries = retries
+	}
+}
+
+// WithLeaseRenewInterval задаёт, как часто Pipe продлевает аренду выданных, но ещё не закоммиченных cookie
+// у источника, реализующего LeaseRenewer. По умолчанию DefaultLeaseRenewInterval.
+func WithLeaseRenewInterval(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.leaseRenewInterval = d
+	}
+}
+
+// ErrDrainTimeout - после остановки чтения прочитанное не успели дописать за WithDrainTimeout
+var ErrDrainTimeout = errors.New("drain timed out")
+
+// WithDrainTimeout ограничивает остановку: если за d уже прочитанные батчи не обработаны и не закоммичены,
+// Pipe отменяет их обработку и возвращает ошибку источника вместе с ErrDrainTimeout. Незакоммиченное придёт
+// из источника повторно после рестарта. Отсчёт - с конца или ошибки источника, а при Pipeline.Stop (отмене
+// контекста Start, например по SIGTERM) и WithQuietPeriod - с самого запроса: в d входит и ожидание Next,
+// который сначала получает отмену (остановка приёма), и только потом дописывается буфер и очередь.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.drainTimeout = d
+	}
+}
+
+// WithCanceledAsFailure возвращает context.Canceled из Process и Commit как ошибку запуска, даже когда
+// их отменил сам Pipe: таймаут дописывания, шаг остановки или ошибка другого батча. По умолчанию такая
+// отмена - только эхо настоящей причины, и в ошибке Pipe, отчёте и логе остаётся сама причина.
+func WithCanceledAsFailure() Option {
+	return func(cfg *config) {
+		cfg.canceledAsFailure = true
+	}
+}
+
+// WithMaxBatchDelay отправляет недобранный батч, если с первой пачки в нём прошло d, а источник
+// так и не дал данных до лимита - чтобы редкие записи не ждали в буфере, пока наберутся тысячи.
+// Next при этом вызывается в отдельной горутине (по-прежнему по одному), а таймер живёт в горутине чтения.
+func WithMaxBatchDelay(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.maxBatchDelay = d
+	}
+}
+
+// WithMinItems не даёт таймеру WithMaxBatchDelay отправлять батчи меньше n элементов: такой батч ждёт,
+// пока наберётся n, но не дольше maxWait с первой пачки. Спасает от крошечных вставок, когда источник
+// в тихие часы отдаёт по несколько записей. Работает только вместе с WithMaxBatchDelay.
+func WithMinItems(n int, maxWait time.Duration) Option {
+	return func(cfg *config) {
+		cfg.minItems = n
+		cfg.minItemsWait = maxWait
+	}
+}
+
+// WithInlineMode выполняет чтение, Process и Commit по очереди в одной горутине, без канала между ними.
+// Пропускная способность ниже (пока идёт Process, источник не читается), зато поведение полностью
+// детерминированное - удобно для небольших потоков, отладки и тестов.
+func WithInlineMode() Option {
+	return func(cfg *config) {
+		cfg.inline = true
+	}
+}
+
+// nextResult - результат одного вызова Next
+type nextResult[T any] struct {
+	items  []T
+	cookie int
+	err    error
+	// Вызов закончился уже после нашего дедлайна
+	timedOut bool
+}
+
+// WithNextRetry повторяет Next, упавший с ошибкой, которую policy считает временной (ребаланс, таймаут брокера),
+// вместо того чтобы останавливать Pipe на первой же. ErrEndOfStream и ErrNextTimeout не повторяются никогда:
+// первое - не ошибка, а после второго прошлый вызов ещё может висеть, и второй Next пошёл бы параллельно.
+// С WithNextTimeout повторы по таймауту идут внутри одной попытки policy.
+func WithNextRetry(policy RetryPolicy) Option {
+	return func(cfg *config) {
+		cfg.nextRetry = &policy
+	}
+}
+
+// readNext вызывает Next с повторами WithNextRetry
+func readNext[T any](ctx context.Context, cfg *config, p ProducerOf[T]) (items []T, cookie int, err error) {
+	if cfg.nextRetry == nil {
+		return callNext(ctx, cfg, p)
+	}
+	policy := *cfg.nextRetry
+	policy.Retryable = func(err error) bool {
+		if errors.Is(err, ErrEndOfStream) || errors.Is(err, ErrNextTimeout) {
+			return false
+		}
+		return cfg.nextRetry.Retryable == nil || cfg.nextRetry.Retryable(err)
+	}
+	err = policy.do(ctx, func(attempt int) error {
+		items, cookie, err = callNext(ctx, cfg, p)
+		if err != nil && ctx.Err() == nil && !errors.Is(err, ErrEndOfStream) {
+			cfg.log(ctx, slog.LevelWarn, "next attempt failed", slog.Int("attempt", attempt), slog.Any("error", err))
+		}
+		return err
+	})
+	return items, cookie, err
+}
+
+// callNext вызывает Next источника с учётом настроек
+func callNext[T any](ctx context.Context, cfg *config, p ProducerOf[T]) ([]T, int, error) {
+	if cfg.nextTimeout <= 0 {
+		return p.Next(ctx)
+	}
+
+	for attempt := 0; attempt <= cfg.nextRetries; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, cfg.nextTimeout)
+		// Буфер на 1, чтобы зависшая горутина, если когда-нибудь вернётся, не висела ещё и на отправке
+		resCh := make(chan nextResult[T], 1)
+		go func() {
+			defer cancel()
+			items, cookie, err := p.Next(callCtx)
+			timedOut := errors.Is(callCtx.Err(), context.DeadlineExceeded)
+			resCh <- nextResult[T]{items: items, cookie: cookie, err: err, timedOut: timedOut}
+		}()
+
+		// Ждём сам дедлайн и ещё столько же сверху на то, чтобы источник успел отреагировать на отмену
+		grace := time.NewTimer(2 * cfg.nextTimeout)
+		select {
+		case r := <-resCh:
+			grace.Stop()
+			// Источник честно вышел по нашему дедлайну - пробуем ещё раз
+			if r.err != nil && r.timedOut && ctx.Err() == nil {
+				continue
+			}
+			return r.items, r.cookie, r.err
+		case <-ctx.Done():
+			grace.Stop()
+			return nil, 0, ctx.Err()
+		case <-grace.C:
+			// Источник игнорирует отмену, повторять нельзя - старый вызов ещё идёт
+			return nil, 0, fmt.Errorf("%w: call ignored cancellation for %s", ErrNextTimeout, 2*cfg.nextTimeout)
+		}
+	}
+	return nil, 0, fmt.Errorf("%w after %d attempts", ErrNextTimeout, cfg.nextRetries+1)
+}
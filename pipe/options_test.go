@@ -0,0 +1,361 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubbornProducer первые hangCalls вызовов Next висит: если honorCtx - до отмены контекста,
+// иначе до закрытия release. Дальше ведёт себя как обычный testProducer.
+type stubbornProducer struct {
+	testProducer
+	hangCalls int
+	honorCtx  bool
+	release   chan struct{}
+
+	callsMu  sync.Mutex
+	calls    int
+	inFlight int
+	overlap  bool
+}
+
+func (p *stubbornProducer) Next(ctx context.Context) ([]any, int, error) {
+	p.callsMu.Lock()
+	p.calls++
+	call := p.calls
+	p.inFlight++
+	if p.inFlight > 1 {
+		p.overlap = true
+	}
+	p.callsMu.Unlock()
+	defer func() {
+		p.callsMu.Lock()
+		p.inFlight--
+		p.callsMu.Unlock()
+	}()
+
+	if call <= p.hangCalls {
+		if p.honorCtx {
+			<-ctx.Done()
+			return nil, 0, ctx.Err()
+		}
+		<-p.release
+		return nil, 0, errors.New("released")
+	}
+	return p.testProducer.Next(ctx)
+}
+
+func TestWithNextTimeoutRetriesTimedOutCall(t *testing.T) {
+	p := &stubbornProducer{testProducer: testProducer{chunks: 4, chunkSize: 3000}, hangCalls: 2, honorCtx: true}
+	c := &testConsumer{}
+
+	err := Pipe(p, c, WithNextTimeout(50*time.Millisecond, 3))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if got := c.batchSizes(); len(got) != 2 || got[0] != 9000 || got[1] != 3000 {
+		t.Fatalf("batch sizes = %v, want [9000 3000]", got)
+	}
+	if p.overlap {
+		t.Fatal("Next was called while a previous call was still in flight")
+	}
+}
+
+func TestWithNextTimeoutReturnsProducerError(t *testing.T) {
+	boom := errors.New("boom")
+	p := &errProducer{err: boom}
+
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, &testConsumer{}, WithNextTimeout(time.Second, 3)) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, boom) {
+			t.Fatalf("Pipe() error = %v, want %v", err, boom)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Pipe() did not return the producer error")
+	}
+}
+
+func TestWithNextTimeoutRetriesExhausted(t *testing.T) {
+	p := &stubbornProducer{testProducer: testProducer{chunks: 1, chunkSize: 1}, hangCalls: 100, honorCtx: true}
+
+	err := Pipe(p, &testConsumer{}, WithNextTimeout(50*time.Millisecond, 2))
+	if !errors.Is(err, ErrNextTimeout) {
+		t.Fatalf("Pipe() error = %v, want %v", err, ErrNextTimeout)
+	}
+	if p.calls != 3 {
+		t.Fatalf("Next called %d times, want 3", p.calls)
+	}
+}
+
+// Источник, который вообще не слушает ctx, не должен подвешивать остановку
+func TestWithNextTimeoutShutdownWithHungProducer(t *testing.T) {
+	p := &stubbornProducer{hangCalls: 100, release: make(chan struct{})}
+	defer close(p.release)
+
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, &testConsumer{}, WithNextTimeout(10*time.Millisecond, 5)) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrNextTimeout) {
+			t.Fatalf("Pipe() error = %v, want %v", err, ErrNextTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pipe() hung on a producer ignoring cancellation")
+	}
+
+	p.callsMu.Lock()
+	defer p.callsMu.Unlock()
+	if p.calls != 1 {
+		t.Fatalf("Next called %d times while the first call was still in flight", p.calls)
+	}
+}
+
+// errProducer всегда возвращает err
+type errProducer struct {
+	err error
+}
+
+func (p *errProducer) Next(ctx context.Context) ([]any, int, error) {
+	return nil, 0, p.err
+}
+
+func (p *errProducer) Commit(ctx context.Context, cookie int) error {
+	return nil
+}
+
+func TestConfigErrorListsAllProblems(t *testing.T) {
+	p := &testProducer{chunks: 1, chunkSize: 1}
+
+	err := Pipe(p, &testConsumer{}, WithNextTimeout(-time.Second, -1))
+
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Pipe() error = %v, want *ConfigError", err)
+	}
+	if len(cfgErr.Problems) != 2 {
+		t.Fatalf("problems = %v, want 2", cfgErr.Problems)
+	}
+	for _, problem := range cfgErr.Problems {
+		if problem.Option != "WithNextTimeout" || problem.Suggestion == "" {
+			t.Fatalf("unexpected problem %+v", problem)
+		}
+	}
+	if p.sent != 0 {
+		t.Fatal("Pipe() read the source despite invalid config")
+	}
+}
+
+func TestConfigErrorConflictingSettings(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithNextTimeout(0, 3))
+
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want one conflict", err)
+	}
+}
+
+// overlapConsumer отмечает, если Process вызвали, пока у источника идёт Next
+type overlapConsumer struct {
+	testConsumer
+	p *stubbornProducer
+}
+
+func (c *overlapConsumer) Process(ctx context.Context, items []any) error {
+	c.p.callsMu.Lock()
+	if c.p.inFlight > 0 {
+		c.p.overlap = true
+	}
+	c.p.callsMu.Unlock()
+	return c.testConsumer.Process(ctx, items)
+}
+
+func TestWithInlineModeIsSequential(t *testing.T) {
+	p := &stubbornProducer{testProducer: testProducer{chunks: 7, chunkSize: 3000}}
+	c := &overlapConsumer{p: p}
+
+	if err := Pipe(p, c, WithInlineMode()); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	if got := c.batchSizes(); len(got) != 3 || got[0] != 9000 || got[1] != 9000 || got[2] != 3000 {
+		t.Fatalf("batch sizes = %v, want [9000 9000 3000]", got)
+	}
+	if got := p.commits(); len(got) != 7 {
+		t.Fatalf("commits = %v, want 1..7", got)
+	}
+	checkCommitsInOrder(t, p.commits())
+	if p.overlap {
+		t.Fatal("Process ran concurrently with Next")
+	}
+}
+
+// trickleProducer отдаёт пачки из канала по мере их появления, после закрытия канала - ErrEndOfStream
+type trickleProducer struct {
+	chanProducer
+}
+
+func (p *trickleProducer) Next(ctx context.Context) ([]any, int, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case items, ok := <-p.ch:
+		if !ok {
+			return nil, 0, ErrEndOfStream
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.cookie++
+		return items, p.cookie, nil
+	}
+}
+
+func (p *trickleProducer) commits() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int(nil), p.committed...)
+}
+
+func TestWithMaxBatchDelayFlushesTrickle(t *testing.T) {
+	p := &trickleProducer{chanProducer{ch: make(chan []any, 2)}}
+	c := &testConsumer{}
+	stats := NewFlushStats()
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, c, WithMaxBatchDelay(20*time.Millisecond), WithFlushStats(stats)) }()
+
+	// Две редкие пачки до лимита не дотягивают, но по таймеру уходят в приёмник и коммитятся
+	p.ch <- []any{1}
+	p.ch <- []any{2}
+	waitTrickleCommits(t, p, 2)
+
+	p.ch <- []any{3}
+	close(p.ch)
+	if err := <-done; err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got := p.commits(); len(got) != 3 {
+		t.Fatalf("commits = %v, want [1 2 3]", got)
+	}
+	if got := stats.Histogram().ByReason[FlushTimer]; got < 1 {
+		t.Errorf("timer flushes = %d, want at least 1", got)
+	}
+}
+
+// waitTrickleCommits ждёт, пока источник получит n коммитов
+func waitTrickleCommits(t *testing.T, p *trickleProducer, n int) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for len(p.commits()) < n {
+		select {
+		case <-deadline:
+			t.Fatalf("got commits %v, want %d", p.commits(), n)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestWithMinItemsHoldsSmallBatches(t *testing.T) {
+	p := &trickleProducer{chanProducer{ch: make(chan []any, 2)}}
+	c := &testConsumer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- Pipe(p, c, WithMaxBatchDelay(5*time.Millisecond), WithMinItems(3, time.Minute))
+	}()
+
+	// Одного элемента мало - таймер батча уже прошёл, но ждём дальше
+	p.ch <- []any{1}
+	time.Sleep(50 * time.Millisecond)
+	if got := p.commits(); len(got) != 0 {
+		t.Fatalf("commits = %v before min items were buffered", got)
+	}
+	p.ch <- []any{2, 3}
+	waitTrickleCommits(t, p, 2)
+
+	close(p.ch)
+	if err := <-done; err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got := c.batchSizes(); len(got) != 1 || got[0] != 3 {
+		t.Errorf("batch sizes = %v, want [3]", got)
+	}
+}
+
+func TestWithMinItemsMaxWait(t *testing.T) {
+	p := &trickleProducer{chanProducer{ch: make(chan []any, 1)}}
+	done := make(chan error, 1)
+	go func() {
+		done <- Pipe(p, &testConsumer{}, WithMaxBatchDelay(5*time.Millisecond), WithMinItems(100, 20*time.Millisecond))
+	}()
+
+	// До 100 элементов не дойдём, но дольше maxWait батч не лежит
+	p.ch <- []any{1}
+	waitTrickleCommits(t, p, 1)
+	close(p.ch)
+	if err := <-done; err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+}
+
+func TestWithMinItemsValidation(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithMinItems(10, time.Second)},
+		{WithMaxBatchDelay(time.Second), WithMinItems(0, time.Minute)},
+		{WithMaxBatchDelay(time.Second), WithMinItems(10, time.Millisecond)},
+	} {
+		var cfgErr *ConfigError
+		if _, err := newConfig(opts); !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+			t.Errorf("newConfig() error = %v, want one problem", err)
+		}
+	}
+}
+
+var errRebalance = errors.New("group rebalance in progress")
+
+// rebalancingProducer первые fails вызовов Next падает с errRebalance
+type rebalancingProducer struct {
+	testProducer
+	fails int
+	calls int
+}
+
+func (p *rebalancingProducer) Next(ctx context.Context) ([]any, int, error) {
+	p.calls++
+	if p.calls <= p.fails {
+		return nil, 0, errRebalance
+	}
+	return p.testProducer.Next(ctx)
+}
+
+func TestWithNextRetry(t *testing.T) {
+	p := &rebalancingProducer{testProducer: testProducer{chunks: 2, chunkSize: 10}, fails: 2}
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool {
+		return errors.Is(err, errRebalance)
+	}}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithNextRetry(policy)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	// 2 неудачных, 2 пачки и конец потока без повторов
+	if p.calls != 5 {
+		t.Errorf("Next calls = %d, want 5", p.calls)
+	}
+	if got := p.commits(); len(got) != 2 {
+		t.Errorf("commits = %v, want [1 2]", got)
+	}
+}
+
+func TestWithNextRetryNotRetryable(t *testing.T) {
+	p := &rebalancingProducer{testProducer: testProducer{chunks: 2, chunkSize: 10}, fails: 1}
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool { return false }}
+	if err := Pipe(p, &testConsumer{}, WithInlineMode(), WithNextRetry(policy)); !errors.Is(err, errRebalance) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errRebalance)
+	}
+	if p.calls != 1 {
+		t.Errorf("Next calls = %d, want 1", p.calls)
+	}
+}
@@ -0,0 +1,110 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"io"
+	"sync"
+)
+
+// Splitter делит батч на не больше чем parts кусков. Пустые куски Process не получают.
+type Splitter func(items []any, parts int) [][]any
+
+// SplitEven режет батч на parts подряд идущих кусков почти одинаковой длины
+func SplitEven(items []any, parts int) [][]any {
+	shards := make([][]any, 0, parts)
+	size := (len(items) + parts - 1) / parts
+	for start := 0; start < len(items); start += size {
+		shards = append(shards, items[start:min(start+size, len(items))])
+	}
+	return shards
+}
+
+// SplitByKey раскладывает элементы по кускам по хешу ключа: элементы с одним ключом попадают в один кусок
+// и сохраняют свой порядок - для приёмников, где важен порядок записей одного ключа
+func SplitByKey(key func(item any) string) Splitter {
+	seed := maphash.MakeSeed()
+	return func(items []any, parts int) [][]any {
+		shards := make([][]any, parts)
+		for _, item := range items {
+			i := maphash.String(seed, key(item)) % uint64(parts)
+			shards[i] = append(shards[i], item)
+		}
+		return shards
+	}
+}
+
+// ParallelConsumer обрабатывает куски батча параллельно, до parts одновременных вызовов c.Process.
+// Для приёмников с построчным, но потокобезопасным API (часть HTTP API): для Pipe это по-прежнему
+// один Process, и коммит будет только если все куски записались. Ошибки кусков объединяются через errors.Join.
+// splitter nil - SplitEven. BatchSizer, SinkDescriber, OnStart/OnStop (и io.Closer) берутся у c.
+func ParallelConsumer(c Consumer, parts int, splitter Splitter) Consumer {
+	if parts < 1 {
+		parts = 1
+	}
+	if splitter == nil {
+		splitter = SplitEven
+	}
+	return &parallelConsumer{c: c, parts: parts, split: splitter}
+}
+
+type parallelConsumer struct {
+	c     Consumer
+	parts int
+	split Splitter
+}
+
+func (p *parallelConsumer) Process(ctx context.Context, items []any) error {
+	if len(items) == 0 {
+		return p.c.Process(ctx, items)
+	}
+
+	shards := p.split(items, p.parts)
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, shard []any) {
+			defer wg.Done()
+			errs[i] = p.c.Process(ctx, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (p *parallelConsumer) PreferredBatchSize(ctx context.Context) int {
+	if bs, ok := p.c.(BatchSizer); ok {
+		return bs.PreferredBatchSize(ctx)
+	}
+	return 0
+}
+
+func (p *parallelConsumer) adapters() []adapter {
+	return []adapter{{"consumer", p.c}}
+}
+
+func (p *parallelConsumer) DescribeSink() string {
+	return describeSink(p.c)
+}
+
+func (p *parallelConsumer) OnStart(ctx context.Context) error {
+	if s, ok := p.c.(Starter); ok {
+		return s.OnStart(ctx)
+	}
+	return nil
+}
+
+func (p *parallelConsumer) OnStop(ctx context.Context) error {
+	switch s := p.c.(type) {
+	case Stopper:
+		return s.OnStop(ctx)
+	case io.Closer:
+		return s.Close()
+	}
+	return nil
+}
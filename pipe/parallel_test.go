@@ -0,0 +1,101 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rowSink - построчный потокобезопасный приёмник, считает параллельные вызовы
+type rowSink struct {
+	mu       sync.Mutex
+	rows     []any
+	inFlight atomic.Int32
+	peak     atomic.Int32
+	fail     any
+}
+
+func (s *rowSink) Process(ctx context.Context, items []any) error {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		if item == s.fail {
+			return fmt.Errorf("row %v rejected", item)
+		}
+	}
+	s.rows = append(s.rows, items...)
+	return nil
+}
+
+func TestParallelConsumerProcessesShardsConcurrently(t *testing.T) {
+	sink := &rowSink{}
+	c := ParallelConsumer(sink, 4, nil)
+
+	items := []any{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if err := c.Process(context.Background(), items); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]int, 0, len(sink.rows))
+	for _, r := range sink.rows {
+		got = append(got, r.(int))
+	}
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+		t.Fatalf("rows = %v", got)
+	}
+	if peak := sink.peak.Load(); peak < 2 || peak > 4 {
+		t.Fatalf("peak concurrency = %d, want 2..4", peak)
+	}
+}
+
+func TestParallelConsumerJoinsErrors(t *testing.T) {
+	sink := &rowSink{fail: 7}
+	err := ParallelConsumer(sink, 3, nil).Process(context.Background(), []any{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	if err == nil || len(sink.rows) != 6 {
+		t.Fatalf("Process() = %v with %d rows written, want an error and 6 rows", err, len(sink.rows))
+	}
+}
+
+func TestSplitByKeyKeepsKeyOrder(t *testing.T) {
+	items := []any{"a1", "b1", "a2", "c1", "b2", "a3"}
+	shards := SplitByKey(func(item any) string { return item.(string)[:1] })(items, 2)
+
+	shardOf := map[byte]int{}
+	for i, shard := range shards {
+		last := map[byte]byte{}
+		for _, item := range shard {
+			s := item.(string)
+			if j, ok := shardOf[s[0]]; ok && j != i {
+				t.Fatalf("key %c split across shards %d and %d", s[0], j, i)
+			}
+			shardOf[s[0]] = i
+			if s[1] <= last[s[0]] {
+				t.Fatalf("shard %v breaks the order of key %c", shard, s[0])
+			}
+			last[s[0]] = s[1]
+		}
+	}
+}
+
+func TestSplitEven(t *testing.T) {
+	got := SplitEven([]any{1, 2, 3, 4, 5}, 2)
+	if want := [][]any{{1, 2, 3}, {4, 5}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("SplitEven() = %v, want %v", got, want)
+	}
+}
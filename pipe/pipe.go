@@ -0,0 +1,1201 @@
+// Package pipe перекладывает данные из источника, отдающего небольшие пачки, в приёмник крупными батчами
+// и коммитит прогресс источника строго в порядке чтения. Точка входа - Pipe, настройки - опции With* или Config.
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Описание
+Нам нужно передать данные из некоторого источника некоторому потребителю. При этом источник отдает данные небольшими пачками (~ десятки записей), а потребитель оптимальнее работает с крупными батчами (~ тысячи записей). Реальный пример - поставка данных из очередей типа Kafka в базу Clickhouse.
+Источник и потребитель описываются интерфейсами:
+*/
+
+/*
+
+Источник:
+Условно бесконечный.
+Источник никогда не возвращает более `MaxItems` записей за один вызов `Next`.
+В рамках одной "сессии" (одного вызова функции `Pipe`) источник каждый раз возвращает новые данные на каждый вызов `Next`.
+Однако, после перезапуска источник начнет с прошлой "подтвержденной" позиции, задаваемой `cookie`.
+Поэтому *каждое* значение `cookie`, которое вернул вызов `Next`, после сохранения данных в приемнике,
+должно быть фиксировано вызовом `Commit`, причем строго в той же последовательности, в которой их вернул `Next`
+Приемник:
+Не может обработать более `MaxItems` за один раз.
+Базовая задача:
+Требуется реализовать функцию:
+для Go: func Pipe(p Producer, c Consumer) error ,
+Которая читает данные из источника, группирует их в буфер размером не более `MaxItems` и сохраняет в приёмник,
+после чего фиксирует прогресс в источнике.
+*/
+
+const MaxItems = 10000
+
+// ErrEndOfStream - источник конечный (например, бэкфилл из файлов) и данных больше не будет.
+// Next возвращает его вместо ошибки: Pipe дописывает и коммитит прочитанное и возвращает nil.
+var ErrEndOfStream = errors.New("end of stream")
+
+// Producer - источник данных. Какой контекст приходит в методы Producer и Consumer - см. context.go
+type Producer interface {
+	// Next returns:
+	// - batch of items to be processed
+	// - cookie to be commited when processing is done
+	// - error (ErrEndOfStream - данные кончились, это не ошибка)
+	Next(ctx context.Context) (items []any, cookie int, err error) // Добавил контекст
+	// Commit is used to mark data batch as processed
+	Commit(ctx context.Context, cookie int) error // Добавил контекст
+}
+
+type Consumer interface {
+	Process(ctx context.Context, items []any) error // Добавил контекст
+}
+
+// ProducerOf - типизированный Producer: пачки приходят как []T, без упаковки каждого элемента в any.
+// Producer - это ProducerOf[any].
+type ProducerOf[T any] interface {
+	Next(ctx context.Context) (items []T, cookie int, err error)
+	Commit(ctx context.Context, cookie int) error
+}
+
+// ConsumerOf - типизированный Consumer, Consumer - это ConsumerOf[any]
+type ConsumerOf[T any] interface {
+	Process(ctx context.Context, items []T) error
+}
+
+// BatchSizer - опциональный интерфейс для консюмера, который сам знает свой оптимальный размер батча
+// (например, max_insert_block_size у Clickhouse).
+// Pipe читает подсказку один раз перед сборкой каждого нового батча, то есть подсказка опрашивается
+// на каждом батче и может меняться между ними. Вызов идёт из горутины чтения источника,
+// поэтому PreferredBatchSize должен быть безопасен для вызова параллельно с Process.
+// Значения <= 0 и > MaxItems игнорируются - тогда используется MaxItems.
+type BatchSizer interface {
+	PreferredBatchSize(ctx context.Context) int
+}
+
+// batchLimit возвращает лимит для очередного батча. Если консюмер подсказывает размер - берём его,
+// но никогда не выходим за MaxItems (и не верим нулю/отрицательным значениям).
+func batchLimit(ctx context.Context, c any) int {
+	bs, ok := c.(BatchSizer)
+	if !ok {
+		return MaxItems
+	}
+	if n := bs.PreferredBatchSize(ctx); n > 0 && n < MaxItems {
+		return n
+	}
+	return MaxItems
+}
+
+// capacityKey - ключ контекста, под которым Pipe передаёт в Next свободное место в текущем батче
+type capacityKey struct{}
+
+// BatchCapacity возвращает, сколько элементов ещё влезает в батч, который сейчас собирает Pipe.
+// Pipe кладёт это значение в контекст каждого вызова Next, так источник может отдать пачку ровно под
+// оставшееся место. Если батч уже заполнен, возвращается лимит целого батча - следующая пачка всё равно
+// уйдёт в новый батч. Второе значение false, если контекст пришёл не из Pipe.
+func BatchCapacity(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(capacityKey{}).(int)
+	return n, ok
+}
+
+// 3000
+// 3000
+// 3000
+// 3000 либо обработать 9000, либо 12000, либо 10000 => обработать 9000
+
+// Pipe читает источник, собирает батчи до MaxItems элементов, отдаёт их консюмеру и коммитит cookie по порядку.
+// Работает до первой ошибки. Возвращает её и всё, что упало следом (например, Commit после ошибки Next), через
+// errors.Join. Ошибки на пути данных - адаптеров, опций, журнала событий - обёрнуты в StageError со своей стадией;
+// ErrDrainTimeout, таймауты шагов остановки, отмена через Pipeline.Stop и ошибки OnStart/OnStop - нет. Если ошибка со стороны источника (Next, WithCookieCheck,
+// WithLargeItems, WithTransform), всё уже прочитанное сначала дописывается в приёмник и коммитится (см. WithDrainTimeout).
+// Ошибка обработки или коммита останавливает всё сразу - после неё коммитить по порядку уже нельзя.
+func Pipe(p Producer, c Consumer, opts ...Option) error {
+	return PipeOf[any](p, c, opts...)
+}
+
+// PipeOf - Pipe для элементов конкретного типа: буферы и батчи - []T, элементы не упаковываются в any.
+// Опции, которые работают с элементами через any (WithLargeItems, WithKeyStats, WithItemClone, WithBatchHash,
+// WithTransform, WithDedup), упаковывают элемент только на время вызова своей функции.
+func PipeOf[T any](p ProducerOf[T], c ConsumerOf[T], opts ...Option) error {
+	// 1 - Создаём слайс с капасити MaxItems - буфер, и слайс для cookie
+	// 2 - Наполняем его пачками проверяя текущую длину и MaxItems-что осталось из cap-len (в цикле) + накапливаем cookie
+	// * внимательно обработать кейс с 3000 выше
+	// 3 - Вызываем Process
+	// 4 - Коммитим имеющиеся данные (в цикле - может быть накоплено несколько cookie)
+	// 5 - Продолжаем принимать данные
+
+	// -----------------------------------------------------------------------------------------------------------------
+
+	// Настройки из опций, с кривым конфигом даже не начинаем
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return err
+	}
+	if err := checkTombstoneConsumer(cfg, c); err != nil {
+		return err
+	}
+	// С WithPartitionAffinity батчи одной партиции обрабатываются по одному
+	affinity, err := newPartitionAffinity(cfg, p)
+	if err != nil {
+		return err
+	}
+	// С WithCatchUp настройки меняются, пока источник отстаёт
+	catchUp, err := newCatchUpMode(cfg, p)
+	if err != nil {
+		return err
+	}
+	cfg.logger = cfg.runLogger()
+	// Фазы запуска: Init → Running → Draining → Stopped/Failed
+	state := newStateMachine(cfg.stateHooks)
+	// Слайс для батчей (ёмкость выставляем по лимиту батча уже в горутине чтения)
+	var buffer []T
+	// Слайс для куки
+	var cookies []int
+	// Сколько элементов было в буфере, когда в него легла пачка каждого cookie
+	var cookieEnds []int
+	// Конец последней закрытой группы в буфере (WithBoundaries)
+	var groupEnd int
+	// Какие элементы буфера из какой пачки
+	var spans []CookieSpan
+	// Когда в пустой буфер легла первая пачка
+	var filling time.Time
+	// Сколько байт в буфере (WithMaxBatchBytes)
+	var bufferBytes int
+	// Добавил структуру, которую будем передавать в канал (сразу и слайс данных и куки, которые надо закоммитить)
+	type batch struct {
+		seq    uint64
+		items  []T
+		cookie []int
+		// Арены, в которых лежат элементы батча (WithArena)
+		arenas []*Arena
+		// Из каких пачек состоит батч
+		spans []CookieSpan
+		// Хеш содержимого (WithBatchHash)
+		hash string
+		// Сколько байт батч занимает под WithMaxInFlightBytes
+		bytes int
+		// Когда батч собран - от этого момента считается WithBatchDeadline
+		formed time.Time
+		// Партиции cookie батча (WithPartitionAffinity)
+		partitions []int
+	}
+	// Номер последнего собранного батча
+	var batchSeq uint64
+	// Канал, через который будем передавать батчи из продюссера в консюмер
+	butchCh := make(chan batch, cfg.queue.capacity()) // Добавил небольшой буфер для подстраховки (WithAdaptiveQueue - подбирает сам)
+	// Ошибка для возврата из функции
+	var firstError error
+	// Все ошибки запуска по порядку, первая - причина остановки. sealed - запуск закончен, новые не принимаем
+	var errMu sync.Mutex
+	var runErrors []error
+	var sealed bool
+	// Таймер на дописывание после остановки чтения (WithDrainTimeout) и сработал ли он
+	var drainTimer *time.Timer
+	var drainTimedOut atomic.Bool
+	var drainOnce sync.Once
+	// Обработка вернула context.Canceled уже после отмены запуска - причину записал тот, кто отменял
+	var canceledEcho atomic.Bool
+	// wg для наших горутин
+	var wg sync.WaitGroup
+	// Контекст для отмены по ошибке, в нём же теги запуска
+	ctx, cancel := context.WithCancel(withTags(cfg.runBase(), cfg.tags))
+	defer cancel()
+	// Дамп для разбора аварии (WithCrashDump), nil - выключен
+	crash := newCrashDumper(cfg)
+	cfg.metrics.setTags(cfg.tags)
+	// Метки pprof и время по стадиям (WithProfiling), nil - выключено
+	prof := newStageProfile(cfg)
+	// Шаги остановки и отчёт о запуске (WithShutdownTimeouts, WithRunReport)
+	runStarted := time.Now()
+	drain := newShutdown(cfg.shutdownTimeouts, cancel)
+	reportRun := func(err error) error {
+		if err != nil {
+			cfg.log(ctx, slog.LevelError, "pipe failed", slog.Any("error", err), slog.Duration("took", time.Since(runStarted)))
+		} else {
+			cfg.log(ctx, slog.LevelInfo, "pipe stopped", slog.Duration("took", time.Since(runStarted)))
+		}
+		for _, fn := range cfg.runReports {
+			r := drain.report(runStarted, err)
+			r.StageTime = prof.stageTime()
+			fn(r)
+		}
+		return err
+	}
+	// Запоминаем ошибку, остальное пусть работает. Вернём все, первой - ту, из-за которой остановились
+	record := func(err error) {
+		// Отмену устроил сам Pipe (таймаут дописывания, шаг остановки, чужая ошибка) - её context.Canceled
+		// не причина остановки, иначе в ошибке и логах он заслонит настоящую
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) && !cfg.canceledAsFailure {
+			canceledEcho.Store(true)
+			return
+		}
+		errMu.Lock()
+		defer errMu.Unlock()
+		if sealed {
+			return
+		}
+		runErrors = append(runErrors, err)
+		if len(runErrors) == 1 {
+			// Дамп пишем сразу, пока горутины ещё живы и видно, кто где стоит
+			if dumpErr := crash.write(err); dumpErr != nil {
+				runErrors = append(runErrors, dumpErr)
+			}
+		}
+	}
+	// Запоминаем ошибку и останавливаем всё остальное
+	fail := func(err error) {
+		record(err)
+		cancel()
+	}
+	// Stop у Pipeline не уложился в свой ctx - отменяем всё
+	go func() {
+		select {
+		case <-cfg.control.aborted():
+			fail(cfg.control.abortErr)
+		case <-ctx.Done():
+		}
+	}()
+	// Cookie, которые уже выданы источником, но ещё не закоммичены
+	leases := &leaseTracker{}
+	// Ключи прочитанных, но ещё не закоммиченных элементов (WithDedup)
+	dedup := newItemDeduper(cfg.dedup)
+	// Арены для элементов, nil - без арен
+	arenas := newArenaSet(cfg.arenaSlabSize)
+	// GC-статистика по батчам
+	var gcs *gcSampler
+	if cfg.gcStats != nil {
+		gcs = newGCSampler()
+	}
+	// Пишем событие жизни батча в журнал, если он настроен
+	logEvent := func(typ EventType, b batch) error {
+		if cfg.eventLog == nil {
+			return nil
+		}
+		e := Event{
+			Time:  time.Now(),
+			Type:  typ,
+			Batch: b.seq,
+			Items: len(b.items),
+			Hash:  b.hash,
+			Tags:  cfg.tags,
+		}
+		// У батча из одного крупного элемента своих cookie может и не быть
+		if len(b.cookie) > 0 {
+			e.FirstCookie = b.cookie[0]
+			e.LastCookie = b.cookie[len(b.cookie)-1]
+		}
+		return cfg.eventLog.Append(ctx, e)
+	}
+	/*
+		Нашёл интересную вещь по завершению, можно сделать контекст через:
+		ctx, cancel := signal.NotifyContext(
+			context.Background(),
+			syscall.SIGTERM,
+			syscall.SIGHUP,
+			syscall.SIGINT,
+			syscall.SIGQUIT,
+		)
+		Возможно такой вариант будет даже чуть интереснее простого контекста с отменой.
+
+	*/
+
+	// -----------------------------------------------------------------------------------------------------------------
+
+	/*
+		Так, надо поделить код на две горутины:
+		1) Читаем источник через Next(), соединяем данные и проверяем длину. Когда надо - пишем наш батч в канал.
+		2) Ждёт данные из канала, когда получает - запускаем Process() и Commit().
+	*/
+
+	// Адаптеры готовятся к работе до первого батча - их ошибка не выдаётся за ошибку обработки
+	started, err := startAdapters(ctx, adaptersOf(p, c))
+	if err != nil {
+		err = withStopError(err, drain.stopAdapters(ctx, started))
+		cancel()
+		state.transition(StateFailed, err)
+		return reportRun(err)
+	}
+
+	// Перед стартом отмечаем в журнале новый запуск и сколько данных прошлого запуска придёт повторно
+	if err := startEventLog(ctx, cfg.eventLog); err != nil {
+		err = withStopError(err, drain.stopAdapters(ctx, started))
+		cancel()
+		state.transition(StateFailed, err)
+		return reportRun(err)
+	}
+	state.transition(StateRunning, nil)
+
+	// С WithWorkers батчи обрабатываются параллельно, а коммитятся по очереди
+	commits := newCommitSequencer(cfg)
+
+	// Приёмник и его описание для отчётов о доставке. Pipeline.ReplaceConsumer меняет его на ходу,
+	// и каждый батч берёт тот, что был на его старте
+	var consumerMu sync.Mutex
+	consumer, consumerSink := c, describeSink(c)
+	currentConsumer := func() (ConsumerOf[T], string) {
+		consumerMu.Lock()
+		defer consumerMu.Unlock()
+		return consumer, consumerSink
+	}
+	// Источник тоже меняется на ходу (Pipeline.ReplaceProducer). Батчи, собранные до замены, коммитятся
+	// по своему отрезку: в старый источник или, с переводом cookie, в новый
+	var producerMu sync.Mutex
+	producers := []ProducerOf[T]{p}
+	var cuts []producerCut
+	commitFor := func(seq uint64) (func(ctx context.Context, cookie int) error, ProducerOf[T]) {
+		producerMu.Lock()
+		defer producerMu.Unlock()
+		for _, cut := range cuts {
+			if seq <= cut.through {
+				return commitCut(cut, producers[cut.target]), producers[cut.target]
+			}
+		}
+		return producers[len(producers)-1].Commit, producers[len(producers)-1]
+	}
+	// Описание DLQ для отчётов о доставке
+	var deadLetterSink string
+	if cfg.deadLetter != nil {
+		deadLetterSink = describeSink(cfg.deadLetter)
+	}
+
+	// Обработка одного батча: Process, потом Commit всех его cookie строго по порядку
+	handle := func(b batch) error {
+		c, sink := currentConsumer()
+		commit, source := commitFor(b.seq)
+		meta := BatchMeta{Seq: b.seq, Items: len(b.items), Cookies: b.cookie, Spans: b.spans, Hash: b.hash}
+		bctx, done := withBatch(ctx, meta)
+		defer done()
+		if cfg.batchDeadline > 0 {
+			bctx = context.WithValue(bctx, retryDeadlineKey{}, b.formed.Add(cfg.batchDeadline))
+		}
+		crash.batch(meta)
+		// Предыдущий батч тех же партиций должен пройти Process и Commit целиком
+		if err := affinity.acquire(ctx, b.seq, b.partitions); err != nil {
+			return stageError(StageProcess, err)
+		}
+		defer affinity.release(b.partitions)
+
+		// Куда и с каким статусом ушёл батч: в приёмник или, если он не принял, в DLQ
+		status, statusErr, dest := DeliveryCommitted, error(nil), sink
+		// Падала ли хоть одна попытка Process - для бюджета ошибок
+		failed := false
+		// Cookie пачек, элементы которых ушли в DLQ, nil - ушёл весь батч. С ProcessWithResults это могут быть не все пачки
+		var deadCookies map[int]bool
+		// Пустой батч - только cookie, которые осталось закоммитить (см. flush): писать в приёмник нечего
+		if len(b.items) > 0 {
+			collectKeyStats(cfg.keyStats, cfg.metrics, b.seq, b.items)
+			if err := cfg.capture.write(meta, b.items); err != nil {
+				return stageError(StageProcess, err)
+			}
+			// SLO меряем по удачной попытке, паузы между повторами в него не входят
+			var started time.Time
+			// Что ещё не принято: nil - весь батч, иначе позиции элементов, отбитых ProcessWithResults
+			var pending []int
+			err := cfg.processRetry.do(bctx, func(attempt int) error {
+				// Предохранитель разомкнут - ждём, пока приёмник не оживёт (WithCircuitBreaker)
+				probe, err := cfg.breaker.allow(bctx)
+				if err != nil {
+					return err
+				}
+				started = time.Now()
+				prof.do(withAttempt(bctx, attempt), StageProcess, sinkName(sink), func(ctx context.Context) {
+					pending, err = processBatch(ctx, cfg, c, b.items, pending)
+				})
+				if err != nil && ctx.Err() == nil {
+					cfg.log(bctx, slog.LevelWarn, "process attempt failed", append(batchAttrs(b.seq, len(b.items), b.cookie),
+						slog.Int("attempt", attempt), slog.Any("error", err))...)
+				}
+				// Отмена запуска - не ответ приёмника
+				if ctx.Err() == nil {
+					cfg.breaker.record(probe, err)
+				} else {
+					cfg.breaker.record(probe, nil)
+				}
+				failed = failed || err != nil
+				return err
+			})
+			if err != nil {
+				cfg.stats.fail(StageProcess, 1)
+				cfg.metrics.fail(StageProcess, 1)
+				cfg.hookError(bctx, meta, StageProcess, err)
+				// Приёмник батч не принял - отдаём его в DLQ, если она есть и это не отмена запуска
+				if cfg.deadLetter == nil || ctx.Err() != nil {
+					cfg.reportDelivery(ctx, meta, sink, DeliveryFailed, err, b.cookie...)
+					return stageError(StageProcess, err)
+				}
+				// Не приняты отдельные элементы - в DLQ только они, остальные уже в приёмнике
+				dlCtx, dlItems := bctx, b.items
+				if pending != nil {
+					dlMeta := subBatch(meta, pending)
+					dlCtx, dlItems = context.WithValue(bctx, batchKey{}, dlMeta), pickItems(b.items, pending)
+					deadCookies = make(map[int]bool, len(dlMeta.Spans))
+					for _, s := range dlMeta.Spans {
+						deadCookies[s.Cookie] = true
+					}
+				}
+				if dlErr := deadLetterItems(dlCtx, cfg.deadLetter, dlItems, err); dlErr != nil {
+					cfg.reportDelivery(ctx, meta, sink, DeliveryFailed, dlErr, b.cookie...)
+					return stageError(StageProcess, dlErr)
+				}
+				status, statusErr, dest = DeliveryDeadLettered, err, deadLetterSink
+				cfg.log(bctx, slog.LevelWarn, "batch dead-lettered", append(batchAttrs(b.seq, len(dlItems), b.cookie), slog.Any("error", err))...)
+			} else {
+				cfg.slo.observe(time.Now(), time.Since(started))
+				cfg.stats.queueDepth(cfg.queue.observe(0, time.Since(started)))
+				cfg.stats.observe(StageProcess, 1, len(b.items))
+				cfg.metrics.processed(time.Since(started))
+				cfg.hookProcessed(bctx, meta, time.Since(started))
+			}
+			// Батч уже в приёмнике (или в DLQ): если дальше не пройдёт Commit, после рестарта это повтор
+			rememberDedup(dedup, b.items)
+		}
+		// Пишем сразу, не дожидаясь очереди коммитов: если раньше упадёт чужой батч, этот в приёмнике
+		// уже есть, и окно дублей после рестарта должно о нём знать
+		if err := logEvent(EventBatchProcessed, b); err != nil {
+			return stageError(StageProcess, err)
+		}
+		drain.processedBatch()
+		// Дальше всё строго по порядку батчей
+		if err := commits.wait(ctx, b.seq); err != nil {
+			return stageError(StageCommit, err)
+		}
+		defer commits.done()
+		for i, c := range b.cookie {
+			commitStarted := time.Now()
+			err := cfg.commitRetry.do(bctx, func(attempt int) error {
+				var err error
+				prof.do(bctx, StageCommit, sourceName(source), func(ctx context.Context) {
+					err = commit(ctx, c)
+				})
+				if err != nil && ctx.Err() == nil {
+					cfg.log(bctx, slog.LevelWarn, "commit attempt failed", slog.Uint64("batch", b.seq), slog.Int("cookie", c),
+						slog.Int("attempt", attempt), slog.Any("error", err))
+				}
+				return err
+			})
+			if err != nil {
+				cfg.stats.fail(StageCommit, 1)
+				cfg.metrics.fail(StageCommit, 1)
+				cfg.hookError(bctx, meta, StageCommit, err)
+				// С CommitSkip отмечаем только этот cookie, следующий Commit подтвердит прогресс за него.
+				// Отмену запуска не пропускаем - тут коммитить уже нечем
+				if ctx.Err() == nil && cfg.commitRetry.skip(c, err) {
+					cfg.log(bctx, slog.LevelWarn, "commit skipped", slog.Uint64("batch", b.seq), slog.Int("cookie", c), slog.Any("error", err))
+					leases.remove(c)
+					cfg.reportDelivery(ctx, meta, sink, DeliveryFailed, err, c)
+					continue
+				}
+				cfg.reportDelivery(ctx, meta, sink, DeliveryFailed, err, b.cookie[i:]...)
+				return stageError(StageCommit, err)
+			}
+			leases.remove(c)
+			cfg.metrics.committedCookie(time.Since(commitStarted))
+			if deadCookies != nil && !deadCookies[c] {
+				cfg.reportDelivery(ctx, meta, sink, DeliveryCommitted, nil, c)
+			} else {
+				cfg.reportDelivery(ctx, meta, dest, status, statusErr, c)
+			}
+			// Cookie уже закоммичен и доставлен, зеркало - вдогонку
+			if err := cfg.mirrorCommit(bctx, c); err != nil {
+				cfg.stats.fail(StageCommit, 1)
+				cfg.metrics.fail(StageCommit, 1)
+				cfg.hookError(bctx, meta, StageCommit, err)
+				return stageError(StageCommit, err)
+			}
+		}
+		crash.commit(b.cookie)
+		cfg.control.committed(b.seq)
+		cfg.stats.observe(StageCommit, len(b.cookie), len(b.items))
+		cfg.metrics.committedItems(len(b.items))
+		cfg.log(bctx, slog.LevelDebug, "batch committed", batchAttrs(b.seq, len(b.items), b.cookie)...)
+		cfg.hookCommitted(bctx, meta)
+		// Всё закоммичено - память элементов больше не нужна
+		releaseArenas(b.arenas)
+		if gcs != nil {
+			cfg.gcStats(gcs.sample(b.seq, len(b.items)))
+		}
+		if err := logEvent(EventBatchCommitted, b); err != nil {
+			return stageError(StageCommit, err)
+		}
+		// Пустой батч с одними cookie в бюджет не идёт - Process для него не было
+		if len(b.items) == 0 {
+			return nil
+		}
+		return stageError(StageProcess, cfg.errorBudget.observe(time.Now(), failed))
+	}
+
+	// Передаём собранный батч дальше: в канал для 2-ой горутины, а в inline режиме обрабатываем прямо тут.
+	// false - дальше работать нельзя (отмена или ошибка)
+	emit := func(b batch) bool {
+		crash.flush(b.seq)
+		b.formed = time.Now()
+		// Лимит скорости (WithRateLimit, WithBatchRateLimit). Пустой батч с одними cookie приёмник не нагружает
+		if cfg.itemsRate.wait(ctx, len(b.items)) != nil || cfg.batchesRate.wait(ctx, min(len(b.items), 1)) != nil {
+			return false
+		}
+		// Место под потолком отдаёт тот, кто батч обработал
+		b.bytes = inFlightItemsBytes(cfg.inFlight, b.items)
+		if cfg.inFlight.acquire(ctx, b.bytes) != nil {
+			return false
+		}
+		if cfg.inline {
+			err := handle(b)
+			cfg.inFlight.release(b.bytes)
+			if err != nil {
+				fail(err)
+				return false
+			}
+			return true
+		}
+		// Очередь партиций - в порядке сборки, пока батчи ещё не разошлись по обработчикам
+		b.partitions = affinity.register(b.seq, b.cookie)
+		// Очередь не глубже подобранной (WithAdaptiveQueue), место освобождает обработчик, забрав батч
+		if cfg.queue.enter(ctx) != nil {
+			cfg.inFlight.release(b.bytes)
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			cfg.queue.leave()
+			cfg.inFlight.release(b.bytes)
+			return false
+		case butchCh <- b:
+			return true
+		}
+	}
+
+	// 1-ая горутина
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(butchCh)
+		// Источник больше не читаем - дальше только дорабатываем то, что уже отправили
+		defer state.transition(StateDraining, nil)
+		defer crash.onPanic()
+
+		// Следим за порядком cookie, если попросили
+		order := &cookieChecker{check: cfg.cookieCheck}
+		// Выбрасываем повторно отданные пачки, если попросили
+		chunkDedup := newChunkDeduper(cfg.duplicateChunks)
+		// Когда пришла предыдущая непустая пачка (WithFlushStats, WithMetrics)
+		var lastChunk time.Time
+		// Последний cookie, ушедший в батч (Pipeline.Barrier)
+		var flushedCookie int
+		// Из какого источника читаем сейчас и не ждёт ли своей очереди его замена
+		producer := p
+		var swap *controlRequest
+		// Отмена текущего Next - только ради замены источника
+		cancelNext := func() {}
+		// Лимит текущего батча, консюмер может его менять между батчами
+		limit := catchUp.batchLimit(ctx, consumer)
+		buffer = make([]T, 0, limit)
+
+		// Отправляем накопленный буфер батчем и заводим новый. false - дальше работать нельзя.
+		// Буфер может быть и пустым, если в нём только cookie пачки из одного крупного элемента
+		// С WithBoundaries уходят только закрытые группы, а хвост остаётся в буфере
+		ready := func() int {
+			if cfg.boundaries != nil {
+				return groupEnd
+			}
+			return len(buffer)
+		}
+		flush := func(reason FlushReason) bool {
+			n := ready()
+			// Cookie пачек, целиком попавших в батч. Пачка, разрезанная границей, закоммитится с батчем своего конца
+			k := 0
+			for k < len(cookies) && cookieEnds[k] <= n {
+				k++
+			}
+			if n == 0 && k == 0 {
+				return true
+			}
+			sent, carry := buffer[:n:n], buffer[n:]
+			sentSpans, carrySpans := splitSpans(spans, n)
+			if k > 0 {
+				flushedCookie = cookies[k-1]
+			}
+
+			batchSeq++
+			b := batch{seq: batchSeq, items: sent, cookie: cookies[:k:k], arenas: arenas.flush(), spans: sentSpans, hash: hashItems(cfg, sent)}
+			cfg.flushStats.observe(len(sent), limit, reason, time.Since(filling))
+			cfg.metrics.flush(reason)
+			cfg.log(ctx, slog.LevelDebug, "batch flushed", append(batchAttrs(b.seq, len(sent), b.cookie), slog.String("reason", string(reason)))...)
+			cfg.hookFlush(ctx, BatchMeta{Seq: b.seq, Items: len(sent), Cookies: b.cookie, Spans: b.spans, Hash: b.hash}, reason)
+			if len(sent) > 0 {
+				cfg.stats.queueDepth(cfg.queue.observe(time.Since(filling), 0))
+			}
+			// Пишем до отправки, иначе консюмер может успеть записать processed раньше
+			if err := logEvent(EventBatchFlushed, b); err != nil {
+				fail(stageError(StageRead, err))
+				return false
+			}
+			if !emit(b) {
+				return false
+			}
+			// Слайсы уже ушли в канал и консюмер их читает, поэтому не переиспользуем их, а заводим новые
+			limit = catchUp.batchLimit(ctx, consumer)
+			buffer = append(make([]T, 0, max(limit, len(carry))), carry...)
+			bufferBytes = itemsBytes(cfg.batchBytes, buffer)
+			cookies = append([]int(nil), cookies[k:]...)
+			ends := cookieEnds[k:]
+			cookieEnds = nil
+			for _, end := range ends {
+				cookieEnds = append(cookieEnds, end-n)
+			}
+			cfg.stats.flush(len(buffer))
+			spans = carrySpans
+			groupEnd = 0
+			cfg.metrics.bufferFill(len(buffer))
+			if len(buffer) > 0 {
+				filling = time.Now()
+			}
+			return true
+		}
+
+		// Отсчёт WithDrainTimeout - с момента, когда решили больше не читать: при остановке снаружи
+		// в него входит и ожидание Next
+		armDrain := func() {
+			drainOnce.Do(func() {
+				if cfg.drainTimeout > 0 {
+					drainTimer = time.AfterFunc(cfg.drainTimeout, func() {
+						drainTimedOut.Store(true)
+						cancel()
+					})
+				}
+			})
+		}
+
+		// Источник больше ничего не даст: запоминаем ошибку, но уже прочитанное дописываем и коммитим
+		// ErrEndOfStream не ошибка: просто дописываем прочитанное
+		finish := func(err error) {
+			if err != nil && !errors.Is(err, ErrEndOfStream) {
+				record(err)
+			}
+			state.transition(StateDraining, nil)
+			armDrain()
+			// Next больше не будет - фоновое чтение источника (Merge) тоже останавливаем
+			if s, ok := any(producer).(readAheadStopper); ok {
+				s.stopReadAhead()
+			}
+			// Дальше по шагам: Next уже вернулся, буфер уходит последним батчем, потом ждём очередь
+			drain.next()
+			drain.enter(ShutdownFlush)
+			if ctx.Err() == nil && (len(buffer) > 0 || len(cookies) > 0) {
+				flush(FlushShutdown)
+			}
+			drain.queued(batchSeq)
+		}
+
+		// Замена приёмника: ждём, пока закоммитятся все отправленные батчи, запускаем новый, останавливаем старый.
+		// Буфер остаётся на месте и уйдёт уже в новый. false - запуск отменили, пока ждали
+		replaceConsumer := func(next ConsumerOf[T]) (bool, error) {
+			if err := checkTombstoneConsumer(cfg, next); err != nil {
+				return true, err
+			}
+			for {
+				reached, changed := cfg.control.mark.reached(batchSeq)
+				if reached {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return false, nil
+				case <-changed:
+				}
+			}
+			var fresh []adapter
+			if !sameAdapter(p, next) {
+				var err error
+				if fresh, err = startAdapters(ctx, []adapter{{"consumer", next}}); err != nil {
+					return true, err
+				}
+			}
+			consumerMu.Lock()
+			old := consumer
+			consumer, consumerSink = next, describeSink(next)
+			consumerMu.Unlock()
+
+			// Старый больше не нужен, если только он не сам источник
+			if sameAdapter(p, old) {
+				started = append(started, fresh...)
+				return true, nil
+			}
+			kept := started[:0]
+			for _, ad := range started {
+				if ad.name != "consumer" {
+					kept = append(kept, ad)
+				}
+			}
+			started = append(kept, fresh...)
+			return true, stopAdapters(ctx, []adapter{{"consumer", old}})
+		}
+
+		// Замена источника. Next старого уже вернулся, буфер уходит батчем, чтобы cookie двух источников не
+		// попали в один батч. false - запуск отменили
+		replaceProducer := func(req controlRequest) (bool, error) {
+			if !flush(FlushManual) {
+				return false, nil
+			}
+			next := req.producer.(ProducerOf[T])
+			var fresh []adapter
+			if !sameAdapter(consumer, next) {
+				var err error
+				if fresh, err = startAdapters(ctx, []adapter{{"producer", next}}); err != nil {
+					return true, err
+				}
+			}
+			producerMu.Lock()
+			old, cur := producer, len(producers)-1
+			cuts = append(cuts, producerCut{through: batchSeq, target: cur})
+			if req.translate != nil {
+				// Всё, что коммитилось в старый, теперь идёт в новый через перевод
+				for i := range cuts {
+					if cuts[i].target == cur {
+						cuts[i].target, cuts[i].translate = cur+1, chainTranslate(cuts[i].translate, req.translate)
+					}
+				}
+			}
+			producers = append(producers, next)
+			producerMu.Unlock()
+
+			// Новый источник нумерует cookie заново
+			producer = next
+			order = &cookieChecker{check: cfg.cookieCheck}
+			chunkDedup = newChunkDeduper(cfg.duplicateChunks)
+			lastChunk = time.Time{}
+
+			// Без перевода старый нужен до коммита своих батчей и остановится в конце запуска, с переводом - уже нет
+			if req.translate == nil || sameAdapter(consumer, old) {
+				started = append(started, fresh...)
+				return true, nil
+			}
+			kept := started[:0]
+			for _, ad := range started {
+				if ad.name != "producer" || !sameAdapter(ad.a, old) {
+					kept = append(kept, ad)
+				}
+			}
+			started = append(kept, fresh...)
+			return true, stopAdapters(ctx, []adapter{{"producer", old}})
+		}
+
+		// Запрос Pipeline: отправить буфер и сказать, каким батчем и cookie он ушёл, заменить приёмник или
+		// источник. false - дальше работать нельзя
+		serve := func(req controlRequest) bool {
+			if req.producer != nil {
+				if err := checkProducerSwap(cfg, producer, req.producer, swap != nil); err != nil {
+					req.reply <- controlReply{err: err}
+					return true
+				}
+				// Меняем, когда вернётся Next старого: его пачку ещё надо принять
+				swap = &req
+				cancelNext()
+				return true
+			}
+			if req.consumer != nil {
+				// Pipeline того же T, так что приведение не падает
+				ok, err := replaceConsumer(req.consumer.(ConsumerOf[T]))
+				if ok {
+					req.reply <- controlReply{err: err}
+				}
+				return ok
+			}
+			if !flush(FlushManual) {
+				return false
+			}
+			req.reply <- controlReply{seq: batchSeq, cookie: flushedCookie}
+			return true
+		}
+
+		// С WithMaxBatchDelay Next идёт в отдельной горутине, чтобы пока источник молчит, буфер можно было
+		// отправить по таймеру. Вызов всё равно один за раз: следующий Next - только после ответа на этот.
+		// Под Pipeline - тоже, чтобы пока Next ждёт данных, отвечать на запросы. С WithQuietPeriod - чтобы
+		// заметить тишину, пока Next ждёт
+		async := cfg.maxBatchDelay > 0 || cfg.control != nil || cfg.quietPeriod > 0
+		var pending chan nextResult[T]
+		delay := time.NewTimer(0)
+		delay.Stop()
+		// Когда источник последний раз дал данные (WithQuietPeriod)
+		lastData := time.Now()
+		quietTimer := time.NewTimer(0)
+		quietTimer.Stop()
+		defer delay.Stop()
+
+		// Pipeline.Stop отменяет только чтение: Next получает отмену, а прочитанное дописываем,
+		// как после конца источника
+		readCtx, stopRead := context.WithCancel(ctx)
+		defer stopRead()
+		go func() {
+			select {
+			case <-cfg.control.stopped():
+				// Запуск уже падает - тогда это не остановка, а отмена
+				if ctx.Err() == nil {
+					drain.next()
+					armDrain()
+				}
+				stopRead()
+			case <-readCtx.Done():
+			}
+		}()
+		stopping := func() bool {
+			return readCtx.Err() != nil && ctx.Err() == nil
+		}
+
+		for {
+			if ctx.Err() != nil {
+				// Отмена - значит упала обработка или коммит, дописывать накопленное уже некуда
+				return
+			}
+			// Next, который уже идёт, остановка прервала - сначала забираем его пачку
+			if stopping() && pending == nil {
+				finish(nil)
+				return
+			}
+			// Pipeline.Pause: не читаем и не отправляем, пока не снимут паузу или не остановят
+			if paused, changed := cfg.control.pauseState(); paused && !stopping() {
+				pausedAt := time.Now()
+				cfg.log(ctx, slog.LevelInfo, "pipe paused", slog.Int("buffered_items", len(buffer)), slog.Int("buffered_cookies", len(cookies)))
+				select {
+				case <-ctx.Done():
+					return
+				case <-readCtx.Done():
+				case <-changed:
+					cfg.log(ctx, slog.LevelInfo, "pipe resumed", slog.Duration("paused", time.Since(pausedAt)))
+					// Пауза - не тишина источника
+					lastData = time.Now()
+				}
+				continue
+			}
+			if swap != nil && pending == nil {
+				ok, err := replaceProducer(*swap)
+				if !ok {
+					return
+				}
+				swap.reply <- controlReply{err: err}
+				swap = nil
+			}
+
+			var items []T
+			var cookie int
+			var err error
+			if pending == nil {
+				// Процесс упёрся в лимит памяти - новые данные пока не читаем
+				if err := cfg.throttleMemory(readCtx); err != nil {
+					if stopping() {
+						finish(nil)
+					} else {
+						fail(stageError(StageRead, err))
+					}
+					return
+				}
+
+				// Подсказываем источнику, сколько ещё места в батче
+				capacity := limit - len(buffer)
+				if capacity <= 0 {
+					capacity = limit
+				}
+				nextCtx := arenas.withArena(context.WithValue(readCtx, capacityKey{}, capacity))
+				if !async {
+					prof.do(nextCtx, StageRead, sourceName(producer), func(ctx context.Context) {
+						items, cookie, err = readNext(ctx, cfg, producer)
+					})
+				} else {
+					pending = make(chan nextResult[T], 1)
+					callCtx, cancelCall := context.WithCancel(nextCtx)
+					cancelNext = cancelCall
+					wg.Add(1)
+					go func(res chan<- nextResult[T], producer ProducerOf[T]) {
+						defer wg.Done()
+						defer crash.onPanic()
+						defer cancelCall()
+						var r nextResult[T]
+						prof.do(callCtx, StageRead, sourceName(producer), func(ctx context.Context) {
+							r.items, r.cookie, r.err = readNext(ctx, cfg, producer)
+						})
+						res <- r
+					}(pending, producer)
+				}
+			}
+
+			if pending != nil {
+				// Таймер взводим от первой пачки в буфере, пустой буфер ждёт сколько угодно.
+				// Пока элементов меньше WithMinItems, ждём дольше - чтобы не слать крошечные вставки
+				var expired <-chan time.Time
+				_, pauseChanged := cfg.control.pauseState()
+				if cfg.maxBatchDelay > 0 && ready() > 0 {
+					wait := cfg.maxBatchDelay
+					if len(buffer) < cfg.minItems && !catchUp.isActive() {
+						wait = cfg.minItemsWait
+					}
+					delay.Reset(wait - time.Since(filling))
+					expired = delay.C
+				}
+				var quiet <-chan time.Time
+				if cfg.quietPeriod > 0 && readCtx.Err() == nil {
+					quietTimer.Reset(cfg.quietPeriod - time.Since(lastData))
+					quiet = quietTimer.C
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-expired:
+					// Next ещё ждёт данных, а то, что уже есть, пора отдать консюмеру
+					if !flush(FlushTimer) {
+						return
+					}
+					continue
+				case req := <-cfg.control.requested():
+					if !serve(req) {
+						return
+					}
+					continue
+				case <-pauseChanged:
+					continue
+				case <-quiet:
+					// Источник молчит - дописываем прочитанное и заканчиваем, как после Pipeline.Stop
+					cfg.log(ctx, slog.LevelInfo, "source quiet, stopping", slog.Duration("quiet_period", cfg.quietPeriod))
+					drain.next()
+					armDrain()
+					stopRead()
+					continue
+				case r := <-pending:
+					pending = nil
+					items, cookie, err = r.items, r.cookie, r.err
+				}
+				for _, t := range []*time.Timer{delay, quietTimer} {
+					if !t.Stop() {
+						select {
+						case <-t.C:
+						default:
+						}
+					}
+				}
+			}
+
+			// Тут теперь не просто проверяем на ошибку, а пишем её в переменную firstError, которую вернём из функции
+			// (теперь через sync.Once) и дописываем то, что уже успели прочитать
+			// Next прервала остановка - это не ошибка источника
+			if err != nil && stopping() {
+				finish(nil)
+				return
+			}
+			// Или замена источника - тогда просто идём к ней
+			if err != nil && swap != nil && ctx.Err() == nil {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, ErrEndOfStream) {
+					cfg.stats.fail(StageRead, 1)
+					cfg.metrics.fail(StageRead, 1)
+					cfg.hookError(ctx, BatchMeta{}, StageRead, err)
+				}
+				finish(stageError(StageRead, err))
+				return
+			}
+
+			if len(items) > 0 {
+				lastData = time.Now()
+			}
+			// Если источник пустой, просто продолжаем. Повтор уже прочитанной пачки - тоже
+			if len(items) == 0 || chunkDedup.duplicate(cookie, len(items)) {
+				continue
+			}
+			// Размер пачки и промежуток до неё - как их отдал источник, до преобразований
+			var gap time.Duration
+			if !lastChunk.IsZero() {
+				gap = time.Since(lastChunk)
+			}
+			lastChunk = time.Now()
+			cfg.flushStats.observeArrival(len(items), gap)
+			cfg.metrics.chunk(len(items), gap)
+			// Преобразования (WithTransform) - дальше считаем и собираем уже то, что из них вышло
+			if len(cfg.transforms) > 0 {
+				var dropped []*ItemFailures
+				if items, dropped, err = applyTransforms(ctx, prof, cfg.transforms, items); err == nil {
+					err = cfg.dropItems(ctx, cookie, dropped)
+				}
+				if err != nil {
+					finish(stageError(StageTransform, err))
+					return
+				}
+				cfg.stats.observe(StageTransform, 1, len(items))
+			}
+			// Повторы уже закоммиченных элементов (WithDedup) и удаления, если их выкидываем (WithTombstones)
+			items = dedupItems(dedup, items)
+			items = dropTombstones(cfg, items)
+			cfg.flushStats.observeChunk(len(items))
+			cfg.stats.observe(StageRead, 1, len(items))
+			cfg.metrics.read(len(items))
+			if err := order.observe(cookie); err != nil {
+				finish(stageError(StageRead, err))
+				return
+			}
+
+			// Крупные элементы: ошибка, разрезание или отдельные батчи - смотря по политике.
+			// Пачка, из которой всё отфильтровали, приносит в буфер только свой cookie
+			var segments []segment[T]
+			if len(items) > 0 {
+				if segments, err = segmentItems(cfg.largeItems, items); err != nil {
+					finish(stageError(StageTransform, err))
+					return
+				}
+			}
+
+			for _, seg := range segments {
+				// Крупный элемент уходит один: сначала отправляем накопленное, потом его отдельным батчем.
+				// Cookie пачки допишем в буфер после него, так что закоммитится он не раньше этого батча
+				if seg.solo {
+					if len(buffer) > 0 && !flush(FlushLargeItem) {
+						return
+					}
+					batchSeq++
+					cfg.flushStats.observe(len(seg.items), limit, FlushLargeItem, 0)
+					cfg.metrics.flush(FlushLargeItem)
+					cfg.log(ctx, slog.LevelDebug, "batch flushed", append(batchAttrs(batchSeq, len(seg.items), nil), slog.String("reason", string(FlushLargeItem)))...)
+					solo := []CookieSpan{{Cookie: cookie, Items: 1}}
+					b := batch{seq: batchSeq, items: seg.items, spans: solo, hash: hashItems(cfg, seg.items)}
+					cfg.hookFlush(ctx, BatchMeta{Seq: b.seq, Items: len(b.items), Spans: b.spans, Hash: b.hash}, FlushLargeItem)
+					if !emit(b) {
+						return
+					}
+					cfg.stats.flush(len(buffer))
+					continue
+				}
+
+				// Если не влезаем, то пишем наши слайсы в структуру батча и кладём её в канал
+				// (пустой буфер не отправляем - пачка может оказаться больше подсказанного консюмером лимита)
+				if len(buffer) > 0 && (limit-len(buffer)) < len(seg.items) && !flush(FlushSize) {
+					return
+				}
+				// В буфере осталась незакрытая группа (WithBoundaries) - батч выйдет больше лимита, но не больше MaxItems
+				if len(buffer) > 0 && len(buffer)+len(seg.items) > MaxItems {
+					finish(stageError(StageRead, fmt.Errorf("%w: %d items and no end yet", ErrGroupTooLarge, len(buffer)+len(seg.items))))
+					return
+				}
+				// То же по байтам
+				segBytes := itemsBytes(cfg.batchBytes, seg.items)
+				if len(buffer) > 0 && !cfg.batchBytes.fits(bufferBytes, segBytes) && !flush(FlushBytes) {
+					return
+				}
+				if len(buffer) == 0 {
+					filling = time.Now()
+				}
+				if cfg.boundaries != nil {
+					if end := lastBoundary(cfg.boundaries, seg.items); end > 0 {
+						groupEnd = len(buffer) + end
+					}
+				}
+				spans = appendSpan(spans, CookieSpan{Cookie: cookie, Offset: len(buffer), Items: len(seg.items)})
+				buffer = append(buffer, seg.items...)
+				bufferBytes += segBytes
+			}
+
+			cookies = append(cookies, cookie)
+			cookieEnds = append(cookieEnds, len(buffer))
+			leases.add(cookie)
+			cfg.metrics.bufferFill(len(buffer))
+			cfg.stats.buffer(len(buffer))
+
+		}
+	}()
+
+	// 2-ая горутина (в inline режиме её нет, батчи обрабатывает первая), с WithWorkers - несколько.
+	// Обработчики сверх WithWorkers (WithCatchUp) берут батчи только в догоне
+	for w := 0; !cfg.inline && w < cfg.poolSize(); w++ {
+		wg.Add(1)
+		go func(turbo bool) {
+			defer wg.Done()
+			defer crash.onPanic()
+
+			for {
+				in, wake := butchCh, (<-chan struct{})(nil)
+				if turbo {
+					if active, changed := catchUp.state(); !active {
+						in, wake = nil, changed
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-wake:
+				case b, ok := <-in:
+					if !ok {
+						return
+					}
+					cfg.queue.leave()
+					err := handle(b)
+					cfg.inFlight.release(b.bytes)
+					if err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}(w >= max(cfg.workers, 1))
+	}
+
+	// 3-я горутина - продлеваем аренду ещё не закоммиченных cookie, если источник это умеет
+	stopRenew := make(chan struct{})
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		defer crash.onPanic()
+		if err := leases.renewLoop(ctx, p, cfg.leaseRenewInterval, stopRenew); err != nil {
+			fail(stageError(StageRead, err))
+		}
+	}()
+
+	// 4-я горутина - следим за лагом источника (WithCatchUp)
+	stopCatchUp := make(chan struct{})
+	catchUpDone := make(chan struct{})
+	go func() {
+		defer close(catchUpDone)
+		defer crash.onPanic()
+		catchUp.loop(ctx, cfg, stopCatchUp)
+	}()
+
+	wg.Wait()
+	close(stopRenew)
+	<-renewDone
+	close(stopCatchUp)
+	<-catchUpDone
+	// Дальше ошибку запуска только дополняем: поздний abort от Pipeline в неё уже не попадёт
+	errMu.Lock()
+	sealed = true
+	if len(runErrors) == 1 {
+		firstError = runErrors[0]
+	} else {
+		firstError = errors.Join(runErrors...)
+	}
+	errMu.Unlock()
+	drainOnce.Do(func() {})
+	if drainTimer != nil {
+		drainTimer.Stop()
+	}
+	if drainTimedOut.Load() {
+		firstError = errors.Join(firstError, ErrDrainTimeout)
+	}
+	if err := drain.timeoutErr(); err != nil {
+		firstError = errors.Join(firstError, err)
+	}
+	// Отменили, но причину так никто и не записал - тогда уж хотя бы саму отмену
+	if firstError == nil && canceledEcho.Load() {
+		firstError = context.Canceled
+	}
+
+	// Всё остановлено - теперь адаптеры могут сбросить буферы и закрыть соединения
+	firstError = withStopError(firstError, drain.stopAdapters(ctx, started))
+
+	if firstError != nil {
+		state.transition(StateFailed, firstError)
+	} else {
+		state.transition(StateStopped, nil)
+	}
+	return reportRun(firstError)
+}
@@ -0,0 +1,421 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errSourceDone = errors.New("source done")
+
+// testProducer отдаёт chunks пачек по chunkSize элементов, потом возвращает errSourceDone.
+// Если задан finish, перед errSourceDone ждёт его закрытия (или отмены контекста).
+type testProducer struct {
+	chunks    int
+	chunkSize int
+	finish    chan struct{}
+
+	mu        sync.Mutex
+	sent      int
+	committed []int
+}
+
+func (p *testProducer) Next(ctx context.Context) ([]any, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent >= p.chunks {
+		if p.finish != nil {
+			p.mu.Unlock()
+			select {
+			case <-p.finish:
+			case <-ctx.Done():
+			}
+			p.mu.Lock()
+		}
+		return nil, 0, errSourceDone
+	}
+	p.sent++
+	items := make([]any, p.chunkSize)
+	for i := range items {
+		items[i] = p.sent
+	}
+	return items, p.sent, nil
+}
+
+func (p *testProducer) Commit(ctx context.Context, cookie int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed = append(p.committed, cookie)
+	return nil
+}
+
+// sentChunks - сколько пачек уже отдано
+func (p *testProducer) sentChunks() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sent
+}
+
+func (p *testProducer) commits() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int(nil), p.committed...)
+}
+
+// waitCommits ждёт, пока источник получит n коммитов
+func waitCommits(t *testing.T, p *testProducer, n int) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for len(p.commits()) < n {
+		select {
+		case <-deadline:
+			t.Fatalf("got commits %v, want %d", p.commits(), n)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// testConsumer запоминает размеры всех батчей
+type testConsumer struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (c *testConsumer) Process(ctx context.Context, items []any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sizes = append(c.sizes, len(items))
+	return nil
+}
+
+func (c *testConsumer) batchSizes() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.sizes...)
+}
+
+// sizedConsumer подсказывает размер батча через BatchSizer
+type sizedConsumer struct {
+	testConsumer
+	hint int
+}
+
+func (c *sizedConsumer) PreferredBatchSize(ctx context.Context) int {
+	return c.hint
+}
+
+func checkCommitsInOrder(t *testing.T, committed []int) {
+	t.Helper()
+	for i, cookie := range committed {
+		if cookie != i+1 {
+			t.Fatalf("commits out of order: %v", committed)
+		}
+	}
+}
+
+func TestPipeBatchSizerHint(t *testing.T) {
+	tests := []struct {
+		name     string
+		hint     int
+		maxBatch int
+	}{
+		{name: "smaller than chunks sum", hint: 5000, maxBatch: 5000},
+		{name: "fits two chunks", hint: 7000, maxBatch: 7000},
+		{name: "zero is ignored", hint: 0, maxBatch: MaxItems},
+		{name: "negative is ignored", hint: -1, maxBatch: MaxItems},
+		{name: "above MaxItems is ignored", hint: MaxItems * 2, maxBatch: MaxItems},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testProducer{chunks: 20, chunkSize: 3000}
+			c := &sizedConsumer{hint: tt.hint}
+
+			if err := Pipe(p, c); !errors.Is(err, errSourceDone) {
+				t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+			}
+
+			sizes := c.batchSizes()
+			if len(sizes) == 0 {
+				t.Fatal("no batches processed")
+			}
+			total := 0
+			for i, size := range sizes {
+				total += size
+				if size > tt.maxBatch {
+					t.Fatalf("batch of %d items exceeds limit %d (sizes %v)", size, tt.maxBatch, sizes)
+				}
+				// Лимит не должен дробить батч сильнее, чем нужно (последний - остаток после остановки источника)
+				if i < len(sizes)-1 && size+3000 <= tt.maxBatch {
+					t.Fatalf("batch of %d items could fit one more chunk (sizes %v)", size, sizes)
+				}
+			}
+			if total != 20*3000 || len(p.commits()) != 20 {
+				t.Fatalf("processed %d items with %d commits, want everything read: 60000 and 20", total, len(p.commits()))
+			}
+			checkCommitsInOrder(t, p.commits())
+		})
+	}
+}
+
+// Пачка больше подсказки уходит отдельным батчем, а не пустым батчем перед ней
+func TestPipeBatchSizerChunkLargerThanHint(t *testing.T) {
+	p := &testProducer{chunks: 5, chunkSize: 3000}
+	c := &sizedConsumer{hint: 1000}
+
+	if err := Pipe(p, c); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	for _, size := range c.batchSizes() {
+		if size != 3000 {
+			t.Fatalf("unexpected batch sizes %v", c.batchSizes())
+		}
+	}
+	checkCommitsInOrder(t, p.commits())
+}
+
+// capacityProducer запоминает свободное место, которое Pipe передал в Next
+type capacityProducer struct {
+	testProducer
+	capacities []int
+}
+
+func (p *capacityProducer) Next(ctx context.Context) ([]any, int, error) {
+	if n, ok := BatchCapacity(ctx); ok {
+		p.mu.Lock()
+		p.capacities = append(p.capacities, n)
+		p.mu.Unlock()
+	}
+	return p.testProducer.Next(ctx)
+}
+
+func TestPipeBatchCapacityInNextContext(t *testing.T) {
+	p := &capacityProducer{testProducer: testProducer{chunks: 6, chunkSize: 3000}}
+
+	if err := Pipe(p, &testConsumer{}); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	want := []int{10000, 7000, 4000, 1000, 7000, 4000, 1000}
+	if len(p.capacities) != len(want) {
+		t.Fatalf("capacities = %v, want %v", p.capacities, want)
+	}
+	for i := range want {
+		if p.capacities[i] != want[i] {
+			t.Fatalf("capacities = %v, want %v", p.capacities, want)
+		}
+	}
+	if _, ok := BatchCapacity(context.Background()); ok {
+		t.Fatal("BatchCapacity reported a value outside of Pipe")
+	}
+}
+
+func TestPipeFlushesBufferOnSourceError(t *testing.T) {
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	c := &testConsumer{}
+
+	if err := Pipe(p, c); !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Всё, что Next успел отдать, должно дойти до приёмника и закоммититься
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{9000, 9000, 3000}) {
+		t.Fatalf("batch sizes = %v, want [9000 9000 3000]", got)
+	}
+	if got := p.commits(); len(got) != 7 {
+		t.Fatalf("commits = %v, want 1..7", got)
+	}
+	checkCommitsInOrder(t, p.commits())
+}
+
+func TestPipeCommitsCookieOnlyTail(t *testing.T) {
+	// Последняя пачка - один крупный элемент: он уходит отдельным батчем, а его cookie ждёт следующего
+	p := &listProducer{chunks: [][]any{{1}, {100}}}
+	c := &itemsConsumer{p: p, hint: 4}
+
+	err := Pipe(p, c, WithInlineMode(), WithLargeItems(LargeItems{Threshold: 10, Size: intSize, Policy: LargeItemSolo}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]any{{1}, {100}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v (no empty Process for the cookie-only tail)", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2}) {
+		t.Fatalf("commits = %v, want [1 2]", p.committed)
+	}
+}
+
+// stuckConsumer висит в Process до отмены контекста
+type stuckConsumer struct{}
+
+func (stuckConsumer) Process(ctx context.Context, items []any) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWithDrainTimeout(t *testing.T) {
+	p := &testProducer{chunks: 1, chunkSize: 10}
+	c := stuckConsumer{}
+
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, c, WithDrainTimeout(20*time.Millisecond)) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errSourceDone) || !errors.Is(err, ErrDrainTimeout) {
+			t.Fatalf("Pipe() error = %v, want %v and %v", err, errSourceDone, ErrDrainTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain was not cut by the timeout")
+	}
+	if got := p.commits(); len(got) != 0 {
+		t.Fatalf("commits = %v after a cancelled drain", got)
+	}
+}
+
+func TestDrainCancelNotReportedAsFailure(t *testing.T) {
+	// Источник кончился штатно, Process отменил таймаут дописывания - причина в таймауте, а не в отмене
+	for _, tt := range []struct {
+		name         string
+		opts         []Option
+		wantCanceled bool
+	}{
+		{"default", nil, false},
+		{"canceled as failure", []Option{WithCanceledAsFailure()}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testProducer{chunks: 1, chunkSize: 10}
+			var report RunReport
+			opts := append([]Option{WithDrainTimeout(20 * time.Millisecond), WithRunReport(func(r RunReport) { report = r })}, tt.opts...)
+			err := Pipe(finiteProducer{p}, stuckConsumer{}, opts...)
+			if !errors.Is(err, ErrDrainTimeout) || errors.Is(err, context.Canceled) != tt.wantCanceled {
+				t.Fatalf("Pipe() error = %v, want %v (context.Canceled reported: %v)", err, ErrDrainTimeout, tt.wantCanceled)
+			}
+			if report.Err != err {
+				t.Errorf("RunReport.Err = %v, want %v", report.Err, err)
+			}
+		})
+	}
+}
+
+// finiteProducer - конечный источник: вместо errSourceDone отдаёт ErrEndOfStream
+type finiteProducer struct {
+	Producer
+}
+
+func (p finiteProducer) Next(ctx context.Context) ([]any, int, error) {
+	items, cookie, err := p.Producer.Next(ctx)
+	if errors.Is(err, errSourceDone) {
+		err = fmt.Errorf("read file: %w", ErrEndOfStream)
+	}
+	return items, cookie, err
+}
+
+func TestPipeEndOfStream(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		p := &testProducer{chunks: 7, chunkSize: 3000}
+		c := &testConsumer{}
+		if err := Pipe(finiteProducer{p}, c, WithConfig(Config{Inline: inline})); err != nil {
+			t.Fatalf("inline=%v: Pipe() error = %v, want nil", inline, err)
+		}
+		if got, want := c.batchSizes(), []int{9000, 9000, 3000}; !reflect.DeepEqual(got, want) {
+			t.Errorf("inline=%v: batch sizes = %v, want %v", inline, got, want)
+		}
+		committed := p.commits()
+		checkCommitsInOrder(t, committed)
+		if len(committed) != 7 {
+			t.Errorf("inline=%v: commits = %v, want 1..7", inline, committed)
+		}
+	}
+}
+
+func TestPipeEndOfStreamReportsTailError(t *testing.T) {
+	// Хвост дописывается уже после конца потока - его ошибка не должна потеряться
+	p := &commitFailProducer{testProducer: testProducer{chunks: 2, chunkSize: 10}, failOn: 2}
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode())
+	if err == nil || errors.Is(err, ErrEndOfStream) {
+		t.Fatalf("Pipe() error = %v, want the commit error", err)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("commits = %v, want [1]", got)
+	}
+}
+
+// stagesOf - стадии всех StageError в ошибке Pipe, по порядку
+func stagesOf(err error) []Stage {
+	var stages []Stage
+	var se *StageError
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if errors.As(e, &se) {
+				stages = append(stages, se.Stage)
+			}
+		}
+	} else if errors.As(err, &se) {
+		stages = append(stages, se.Stage)
+	}
+	return stages
+}
+
+func TestPipeJoinsStageErrors(t *testing.T) {
+	// Источник упал, а дописать прочитанное не дал Commit - в ошибке обе причины, первой - источник
+	p := &commitFailProducer{testProducer: testProducer{chunks: 1, chunkSize: 10}, failOn: 1}
+	err := Pipe(p, &testConsumer{}, WithInlineMode())
+	if !errors.Is(err, errSourceDone) || !strings.Contains(fmt.Sprint(err), "broker unavailable") {
+		t.Fatalf("Pipe() error = %v, want both %v and the commit error", err, errSourceDone)
+	}
+	if got := stagesOf(err); !reflect.DeepEqual(got, []Stage{StageRead, StageCommit}) {
+		t.Errorf("stages = %v, want [read commit]", got)
+	}
+	var se *StageError
+	if !errors.As(err, &se) || se.Stage != StageRead || !errors.Is(se, errSourceDone) {
+		t.Errorf("errors.As() = %v, want the read error first", se)
+	}
+}
+
+// batchErrConsumer ждёт, пока в работе не окажутся два батча, и роняет оба - каждый своей ошибкой
+type batchErrConsumer struct {
+	mu      sync.Mutex
+	entered int
+	both    chan struct{}
+}
+
+func (c *batchErrConsumer) Process(ctx context.Context, items []any) error {
+	c.mu.Lock()
+	c.entered++
+	if c.entered > 2 {
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+	if c.entered == 2 {
+		close(c.both)
+	}
+	c.mu.Unlock()
+	<-c.both
+	return fmt.Errorf("batch of chunk %v rejected", items[0])
+}
+
+func TestPipeJoinsConcurrentProcessErrors(t *testing.T) {
+	// Живой источник на отмену отвечает ctx.Err() - в ошибке только две обработки
+	p := &chanProducer{ch: make(chan []any, 3)}
+	for i := 1; i <= 3; i++ {
+		items := make([]any, MaxItems)
+		for j := range items {
+			items[j] = i
+		}
+		p.ch <- items
+	}
+	err := Pipe(p, &batchErrConsumer{both: make(chan struct{})}, WithWorkers(2))
+	if err == nil {
+		t.Fatal("Pipe() error = nil")
+	}
+	// Второй Process вернул свою ошибку, а не эхо отмены - её не прячем
+	if got := stagesOf(err); !reflect.DeepEqual(got, []Stage{StageProcess, StageProcess}) {
+		t.Errorf("stages = %v in %v, want both process errors", got, err)
+	}
+}
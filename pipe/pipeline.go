@@ -0,0 +1,451 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Pipe блокирует вызывающего до конца запуска - в сервисе, где рядом HTTP-сервер и другие компоненты,
+это неудобно. Pipeline - тот же запуск как объект: Start запускает его в фоне, Stop останавливает чтение
+и дописывает прочитанное, Wait ждёт результата.
+*/
+
+// ErrPipelineStarted - Start на уже запущенном Pipeline. Запускается он один раз, для рестарта - новый
+var ErrPipelineStarted = errors.New("pipeline already started")
+
+// ErrPipelineNotStarted - Stop или Wait до Start
+var ErrPipelineNotStarted = errors.New("pipeline not started")
+
+// ErrPipelineStopped - запуск уже закончился без ошибки, а Barrier ещё ждёт
+var ErrPipelineStopped = errors.New("pipeline stopped")
+
+// PipelineOf - запуск PipeOf с жизненным циклом сервиса: Start, Stop, Wait. Методы можно звать из разных горутин
+type PipelineOf[T any] struct {
+	p    ProducerOf[T]
+	c    ConsumerOf[T]
+	opts []Option
+	ctl  *runControl
+
+	mu      sync.Mutex
+	started bool
+	// Закрывается, когда запуск закончился, err - его результат
+	done chan struct{}
+	err  error
+}
+
+// Pipeline - PipelineOf для Pipe с элементами any
+type Pipeline = PipelineOf[any]
+
+// NewPipeline готовит запуск Pipe, но не начинает его
+func NewPipeline(p Producer, c Consumer, opts ...Option) *Pipeline {
+	return NewPipelineOf[any](p, c, opts...)
+}
+
+// NewPipelineOf готовит запуск PipeOf, но не начинает его
+func NewPipelineOf[T any](p ProducerOf[T], c ConsumerOf[T], opts ...Option) *PipelineOf[T] {
+	return &PipelineOf[T]{p: p, c: c, opts: opts, ctl: newRunControl(), done: make(chan struct{})}
+}
+
+// Start запускает Pipe в фоне и сразу возвращается. Кривой конфиг - *ConfigError сразу, остальные ошибки
+// (в том числе OnStart адаптеров) - из Wait. ctx - базовый контекст запуска вместо WithBaseContext: его
+// значения видны адаптерам, а его отмена - то же, что Stop без дедлайна.
+func (pl *PipelineOf[T]) Start(ctx context.Context) error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.started {
+		return ErrPipelineStarted
+	}
+	opts := append(append([]Option(nil), pl.opts...), WithBaseContext(func() context.Context { return ctx }), withRunControl(pl.ctl))
+	if _, err := newConfig(opts); err != nil {
+		return err
+	}
+	pl.started = true
+
+	go func() {
+		pl.err = PipeOf(pl.p, pl.c, opts...)
+		close(pl.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			pl.ctl.stop()
+		case <-pl.done:
+		}
+	}()
+	return nil
+}
+
+// Stop останавливает чтение (Next получает отмену своего контекста), дописывает и коммитит уже прочитанное,
+// останавливает адаптеры и возвращает то же, что Wait. Шаги остановки - как после конца источника
+// (WithDrainTimeout, WithShutdownTimeouts, RunReport). Не уложились в ctx - запуск отменяется,
+// незакоммиченное придёт из источника повторно, а в ошибке будет ошибка ctx.
+func (pl *PipelineOf[T]) Stop(ctx context.Context) error {
+	if !pl.isStarted() {
+		return ErrPipelineNotStarted
+	}
+	pl.ctl.stop()
+	select {
+	case <-pl.done:
+	case <-ctx.Done():
+		pl.ctl.abort(fmt.Errorf("pipeline stop: %w", ctx.Err()))
+		<-pl.done
+	}
+	return pl.err
+}
+
+// Wait ждёт конца запуска и возвращает то, что вернул бы Pipe. nil - источник кончился или Stop
+// всё дописал. Можно звать сколько угодно раз и из нескольких горутин
+func (pl *PipelineOf[T]) Wait() error {
+	if !pl.isStarted() {
+		return ErrPipelineNotStarted
+	}
+	<-pl.done
+	return pl.err
+}
+
+// Barrier ждёт точки согласованности: всё, что источник отдал до вызова, обработано и закоммичено.
+// Недобранный буфер уходит батчем сразу (FlushManual), не дожидаясь размера или WithMaxBatchDelay.
+// Возвращает последний cookie, закоммиченный к этой точке, 0 - данных ещё не было. С WithBoundaries
+// незакрытая группа остаётся в буфере, и её пачки в точку не входят. Запуск закончился раньше -
+// его ошибка или ErrPipelineStopped; вышел ctx - ошибка ctx, а запуск идёт дальше как ни в чём не бывало.
+func (pl *PipelineOf[T]) Barrier(ctx context.Context) (int, error) {
+	if !pl.isStarted() {
+		return 0, ErrPipelineNotStarted
+	}
+	reply, err := pl.request(ctx, controlRequest{})
+	if err != nil {
+		return 0, err
+	}
+	for {
+		reached, changed := pl.ctl.mark.reached(reply.seq)
+		if reached {
+			return reply.cookie, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-pl.done:
+			if reached, _ := pl.ctl.mark.reached(reply.seq); reached {
+				return reply.cookie, nil
+			}
+			return 0, pl.stoppedErr()
+		case <-changed:
+		}
+	}
+}
+
+// Flush отправляет недобранный буфер батчем прямо сейчас и ждёт, пока он и всё до него обработаны
+// и закоммичены, - перед плановыми работами или в тестах. Это Barrier без cookie; запуск, который уже
+// закончился без ошибки, дописал всё сам, и тогда Flush возвращает nil.
+func (pl *PipelineOf[T]) Flush(ctx context.Context) error {
+	_, err := pl.Barrier(ctx)
+	if errors.Is(err, ErrPipelineStopped) {
+		return nil
+	}
+	return err
+}
+
+// ReplaceConsumer переключает запуск на новый приёмник, не останавливая чтение и не теряя буфер: уже
+// отправленные батчи дописываются в старый и коммитятся, после чего новый получает OnStart, старый -
+// OnStop/Close, а следующие батчи (и то, что лежит в буфере) идут в новый. Пока старый дописывает, Next
+// не вызывается. Ошибка OnStart или проверки нового приёмника - старый остаётся на месте; ошибка OnStop
+// старого возвращается, но замена уже сделана. Запуск закончился раньше - его ошибка или ErrPipelineStopped
+func (pl *PipelineOf[T]) ReplaceConsumer(c ConsumerOf[T]) error {
+	if !pl.isStarted() {
+		return ErrPipelineNotStarted
+	}
+	_, err := pl.request(context.Background(), controlRequest{consumer: c})
+	return err
+}
+
+// ReplaceProducer переключает чтение на новый источник - например, при переезде между кластерами. Next
+// старого получает отмену (то, что он успел отдать, принимается), буфер уходит батчем, новый получает OnStart,
+// и дальше Next зовётся у него. Батчи, собранные до замены, коммитятся так: с translate - в новый источник,
+// cookie старого переводится в cookie нового (и старый сразу получает OnStop/Close); без него - в старый,
+// который тогда живёт до конца запуска. Источники с LeaseRenewer, WithBoundaries и WithPartitionAffinity
+// без своей функции партиций замену не поддерживают. Ошибка OnStart нового - читаем дальше старый
+func (pl *PipelineOf[T]) ReplaceProducer(p ProducerOf[T], translate func(cookie int) (int, error)) error {
+	if !pl.isStarted() {
+		return ErrPipelineNotStarted
+	}
+	_, err := pl.request(context.Background(), controlRequest{producer: p, translate: translate})
+	return err
+}
+
+// Pause приостанавливает чтение и отправку батчей, например на время работ в приёмнике: новый Next
+// не вызывается, буфер не уходит ни по размеру, ни по WithMaxBatchDelay, ни по Flush/Barrier - они ждут Resume.
+// Буфер, незакоммиченные cookie и позиция в источнике остаются как есть, аренда cookie продлевается.
+// Уже отправленные батчи дописываются. Next, который уже идёт, доработает, а его пачка подождёт Resume.
+// Stop на паузе дописывает буфер как обычно. Pause до Start - запуск начнётся на паузе
+func (pl *PipelineOf[T]) Pause() {
+	pl.ctl.setPaused(true)
+}
+
+// Resume продолжает чтение после Pause
+func (pl *PipelineOf[T]) Resume() {
+	pl.ctl.setPaused(false)
+}
+
+// Paused - стоит ли запуск на паузе
+func (pl *PipelineOf[T]) Paused() bool {
+	paused, _ := pl.ctl.pauseState()
+	return paused
+}
+
+// Stats - снимок статистики запуска: счётчики и время последней работы стадий, буфер, отправленные батчи.
+// Можно звать из любой горутины в любой момент, до Start - нули. С WithStats - снимок переданной Stats
+func (pl *PipelineOf[T]) Stats() StatsSnapshot {
+	return pl.ctl.stats.Load().Snapshot()
+}
+
+// request передаёт запрос горутине чтения и ждёт её ответа
+func (pl *PipelineOf[T]) request(ctx context.Context, req controlRequest) (controlReply, error) {
+	req.reply = make(chan controlReply, 1)
+	select {
+	case pl.ctl.requests <- req:
+	case <-ctx.Done():
+		return controlReply{}, ctx.Err()
+	case <-pl.done:
+		return controlReply{}, pl.stoppedErr()
+	}
+	select {
+	case r := <-req.reply:
+		return r, r.err
+	case <-ctx.Done():
+		return controlReply{}, ctx.Err()
+	case <-pl.done:
+		return controlReply{}, pl.stoppedErr()
+	}
+}
+
+// stoppedErr - ошибка запуска, который уже закончился
+func (pl *PipelineOf[T]) stoppedErr() error {
+	if pl.err != nil {
+		return pl.err
+	}
+	return ErrPipelineStopped
+}
+
+func (pl *PipelineOf[T]) isStarted() bool {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.started
+}
+
+// runControl - управление запуском снаружи (Pipeline). nil - обычный Pipe, им никто не управляет
+type runControl struct {
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	abortOnce sync.Once
+	abortCh   chan struct{}
+	abortErr  error
+	// Запросы к горутине чтения
+	requests chan controlRequest
+	// До какого батча всё закоммичено
+	mark commitMark
+	// Pause/Resume, changed закрывается на каждой смене
+	pauseMu sync.Mutex
+	paused  bool
+	changed chan struct{}
+	// Куда пишет статистику запуск: WithStats из опций или своя (Pipeline.Stats)
+	stats atomic.Pointer[Stats]
+}
+
+// controlRequest - запрос к горутине чтения: отправить буфер и ответить, каким батчем он ушёл,
+// заменить приёмник на consumer или источник на producer (с переводом cookie translate)
+type controlRequest struct {
+	consumer  any
+	producer  any
+	translate func(cookie int) (int, error)
+	reply     chan controlReply
+}
+
+// controlReply - ответ горутины чтения. seq - последний собранный батч, cookie - последний cookie в батчах до него
+type controlReply struct {
+	seq    uint64
+	cookie int
+	err    error
+}
+
+func newRunControl() *runControl {
+	c := &runControl{stopCh: make(chan struct{}), abortCh: make(chan struct{}), requests: make(chan controlRequest),
+		mark: commitMark{changed: make(chan struct{})}, changed: make(chan struct{})}
+	c.stats.Store(NewStats(0))
+	return c
+}
+
+// withRunControl отдаёт запуск под управление Pipeline. Идёт последней опцией: без WithStats
+// статистику пишем в свою, с WithStats - Pipeline.Stats отдаёт её
+func withRunControl(ctl *runControl) Option {
+	return func(cfg *config) {
+		cfg.control = ctl
+		if cfg.stats == nil {
+			cfg.stats = ctl.stats.Load()
+		} else {
+			ctl.stats.Store(cfg.stats)
+		}
+	}
+}
+
+// stop просит перестать читать и дописать прочитанное
+func (c *runControl) stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+// abort отменяет запуск с ошибкой err
+func (c *runControl) abort(err error) {
+	c.abortOnce.Do(func() {
+		c.abortErr = err
+		close(c.abortCh)
+	})
+}
+
+// stopped закрывается на stop, nil - остановить снаружи нельзя
+func (c *runControl) stopped() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.stopCh
+}
+
+// aborted закрывается на abort, nil - отменить снаружи нельзя
+func (c *runControl) aborted() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.abortCh
+}
+
+// requested - канал запросов, nil - запросов не будет
+func (c *runControl) requested() <-chan controlRequest {
+	if c == nil {
+		return nil
+	}
+	return c.requests
+}
+
+// committed отмечает, что батч seq закоммичен целиком
+func (c *runControl) committed(seq uint64) {
+	if c == nil {
+		return
+	}
+	c.mark.committed(seq)
+}
+
+// commitMark - до какого батча включительно закоммичено всё. С WithUnorderedCommits батчи приходят вразнобой,
+// и те, что обогнали очередь, ждут в ahead
+type commitMark struct {
+	mu      sync.Mutex
+	through uint64
+	ahead   map[uint64]bool
+	// Закрывается, когда through сдвинулся
+	changed chan struct{}
+}
+
+func (m *commitMark) committed(seq uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if seq != m.through+1 {
+		if m.ahead == nil {
+			m.ahead = make(map[uint64]bool)
+		}
+		m.ahead[seq] = true
+		return
+	}
+	m.through = seq
+	for m.ahead[m.through+1] {
+		delete(m.ahead, m.through+1)
+		m.through++
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// reached - закоммичено ли всё до seq, и канал, который закроется на следующем сдвиге
+func (m *commitMark) reached(seq uint64) (bool, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.through >= seq, m.changed
+}
+
+// producerCut - батчи до through включительно собраны из источника, который потом заменили.
+// Коммитятся они в источник target, cookie переводит translate (nil - как есть)
+type producerCut struct {
+	through   uint64
+	target    int
+	translate func(cookie int) (int, error)
+}
+
+// commitCut - Commit батчей отрезка cut в источник p
+func commitCut[T any](cut producerCut, p ProducerOf[T]) func(ctx context.Context, cookie int) error {
+	if cut.translate == nil {
+		return p.Commit
+	}
+	return func(ctx context.Context, cookie int) error {
+		translated, err := cut.translate(cookie)
+		if err != nil {
+			return fmt.Errorf("translate cookie %d: %w", cookie, err)
+		}
+		return p.Commit(ctx, translated)
+	}
+}
+
+// chainTranslate - сначала first (nil - без перевода), потом then
+func chainTranslate(first, then func(int) (int, error)) func(int) (int, error) {
+	if first == nil {
+		return then
+	}
+	return func(cookie int) (int, error) {
+		cookie, err := first(cookie)
+		if err != nil {
+			return 0, err
+		}
+		return then(cookie)
+	}
+}
+
+// checkProducerSwap - можно ли заменить источник old на next
+func checkProducerSwap(cfg *config, old, next any, swapping bool) error {
+	switch {
+	case swapping:
+		return errors.New("replace producer: another replacement is in progress")
+	case cfg.boundaries != nil:
+		return errors.New("replace producer: WithBoundaries may hold an open group of the old producer")
+	case cfg.affinity != nil && cfg.affinity.partition == nil:
+		return errors.New("replace producer: WithPartitionAffinity takes partitions from the old producer")
+	case cfg.catchUp != nil && cfg.catchUp.Lag == nil:
+		return errors.New("replace producer: WithCatchUp asks the old producer for lag")
+	}
+	for _, p := range []any{old, next} {
+		if _, ok := p.(LeaseRenewer); ok {
+			return fmt.Errorf("replace producer: %T renews leases by its own cookies", p)
+		}
+	}
+	return nil
+}
+
+// setPaused ставит или снимает паузу
+func (c *runControl) setPaused(paused bool) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if c.paused == paused {
+		return
+	}
+	c.paused = paused
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// pauseState - на паузе ли запуск, и канал, который закроется на следующей смене. Без Pipeline паузы нет
+func (c *runControl) pauseState() (bool, <-chan struct{}) {
+	if c == nil {
+		return false, nil
+	}
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	return c.paused, c.changed
+}
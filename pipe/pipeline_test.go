@@ -0,0 +1,562 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// feed отдаёт пачки живому источнику и ждёт, пока Pipe их прочитает и разложит по буферу
+func feed(p *chanProducer, chunks ...[]any) {
+	for _, chunk := range chunks {
+		p.ch <- chunk
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(p.ch) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestPipelineStopDrains(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	c := &testConsumer{}
+	var report RunReport
+	pl := NewPipeline(src, c, WithMaxBatchDelay(time.Hour), WithRunReport(func(r RunReport) { report = r }))
+	if err := pl.Stop(context.Background()); !errors.Is(err, ErrPipelineNotStarted) {
+		t.Fatalf("Stop() before Start error = %v, want %v", err, ErrPipelineNotStarted)
+	}
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := pl.Start(context.Background()); !errors.Is(err, ErrPipelineStarted) {
+		t.Fatalf("second Start() error = %v, want %v", err, ErrPipelineStarted)
+	}
+
+	// Пачки лежат в буфере: батч не набран, а таймер батча - час. Stop должен их дописать
+	feed(src, []any{1, 2}, []any{3})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := pl.Wait(); err != nil {
+		t.Fatalf("Wait() after Stop error = %v", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("batches = %v, want [3]", got)
+	}
+	if !reflect.DeepEqual(src.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", src.committed)
+	}
+	var stages []ShutdownStage
+	for _, s := range report.Shutdown {
+		stages = append(stages, s.Stage)
+	}
+	if want := []ShutdownStage{ShutdownNext, ShutdownFlush, ShutdownProcess, ShutdownCommit, ShutdownAdapters}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("shutdown steps = %v, want %v", stages, want)
+	}
+}
+
+func TestPipelineStopTimeout(t *testing.T) {
+	// Обработка висит дольше, чем Stop готов ждать, - запуск отменяется
+	src := &chanProducer{ch: make(chan []any, 1)}
+	pl := NewPipeline(src, stuckConsumer{})
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	feed(src, make([]any, MaxItems))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := pl.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if waitErr := pl.Wait(); waitErr != err {
+		t.Errorf("Wait() error = %v, want the Stop() error %v", waitErr, err)
+	}
+	if len(src.committed) != 0 {
+		t.Errorf("commits = %v after an aborted stop", src.committed)
+	}
+}
+
+func TestPipelineStartContextStops(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 1)}
+	c := &testConsumer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	pl := NewPipeline(src, c, WithMaxBatchDelay(time.Hour))
+	if err := pl.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	feed(src, []any{1})
+
+	// Отмена контекста Start - та же мягкая остановка
+	cancel()
+	if err := pl.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if !reflect.DeepEqual(src.committed, []int{1}) {
+		t.Errorf("commits = %v, want [1]", src.committed)
+	}
+}
+
+func TestPipelineEndOfStream(t *testing.T) {
+	p := &testProducer{chunks: 2, chunkSize: 6000}
+	pl := NewPipelineOf[any](finiteProducer{p}, &testConsumer{})
+	if err := pl.Wait(); !errors.Is(err, ErrPipelineNotStarted) {
+		t.Fatalf("Wait() before Start error = %v, want %v", err, ErrPipelineNotStarted)
+	}
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := pl.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	// Запуск уже закончился - Stop просто отдаёт его результат
+	if err := pl.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() after the end error = %v", err)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", got)
+	}
+}
+
+func TestPipelineStartConfigError(t *testing.T) {
+	pl := NewPipeline(&testProducer{}, &testConsumer{}, WithWorkers(-1))
+	var cfgErr *ConfigError
+	if err := pl.Start(context.Background()); !errors.As(err, &cfgErr) {
+		t.Fatalf("Start() error = %v, want *ConfigError", err)
+	}
+	if err := pl.Wait(); !errors.Is(err, ErrPipelineNotStarted) {
+		t.Errorf("Wait() after a failed Start error = %v, want %v", err, ErrPipelineNotStarted)
+	}
+}
+
+func TestPipelineBarrier(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	c := &testConsumer{}
+	pl := NewPipeline(src, c, WithWorkers(2), WithUnorderedCommits())
+	if _, err := pl.Barrier(context.Background()); !errors.Is(err, ErrPipelineNotStarted) {
+		t.Fatalf("Barrier() before Start error = %v, want %v", err, ErrPipelineNotStarted)
+	}
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer pl.Stop(context.Background())
+
+	// Данных ещё не было - точка пустая
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if cookie, err := pl.Barrier(ctx); err != nil || cookie != 0 {
+		t.Fatalf("Barrier() on an empty run = %d, %v, want 0, nil", cookie, err)
+	}
+
+	// Батч не набран и без таймера так бы и лежал - Barrier отправляет его сам и ждёт коммита
+	feed(src, []any{1, 2}, []any{3})
+	cookie, err := pl.Barrier(ctx)
+	if err != nil || cookie != 2 {
+		t.Fatalf("Barrier() = %d, %v, want 2, nil", cookie, err)
+	}
+	src.mu.Lock()
+	committed := append([]int(nil), src.committed...)
+	src.mu.Unlock()
+	if !reflect.DeepEqual(committed, []int{1, 2}) {
+		t.Errorf("commits at the barrier = %v, want [1 2]", committed)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("batches = %v, want [3]", got)
+	}
+
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if _, err := pl.Barrier(ctx); !errors.Is(err, ErrPipelineStopped) {
+		t.Errorf("Barrier() after Stop error = %v, want %v", err, ErrPipelineStopped)
+	}
+}
+
+func TestCommitMarkOutOfOrder(t *testing.T) {
+	m := commitMark{changed: make(chan struct{})}
+	m.committed(2)
+	m.committed(3)
+	if reached, _ := m.reached(2); reached {
+		t.Fatal("batch 2 reached before batch 1 committed")
+	}
+	_, changed := m.reached(3)
+	m.committed(1)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("waiters were not woken up")
+	}
+	if reached, _ := m.reached(3); !reached || len(m.ahead) != 0 {
+		t.Errorf("through = %d ahead = %v, want 3 and none", m.through, m.ahead)
+	}
+}
+
+func TestPipelineFlush(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	c := &testConsumer{}
+	pl := NewPipeline(src, c, WithMaxBatchDelay(time.Hour))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i, chunk := range [][]any{{1, 2}, {3}} {
+		feed(src, chunk)
+		// Каждый Flush - отдельный батч, закоммиченный к возврату
+		if err := pl.Flush(ctx); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+		src.mu.Lock()
+		got := len(src.committed)
+		src.mu.Unlock()
+		if got != i+1 {
+			t.Errorf("after Flush %d: %d commits, want %d", i+1, got, i+1)
+		}
+	}
+	// Пустой буфер - Flush ничего не отправляет
+	if err := pl.Flush(ctx); err != nil {
+		t.Fatalf("Flush() of an empty buffer error = %v", err)
+	}
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := pl.Flush(ctx); err != nil {
+		t.Errorf("Flush() after Stop error = %v, want nil", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Errorf("batches = %v, want [2 1]", got)
+	}
+}
+
+func TestPipelineReplaceConsumer(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	old := &stoppingConsumer{}
+	pl := NewPipeline(src, old, WithMaxBatchDelay(time.Hour))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	feed(src, []any{1, 2})
+	if err := pl.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	// Этот остаётся в буфере и должен уйти уже в новый приёмник
+	feed(src, []any{3})
+
+	// Новый не стартовал - остаётся старый
+	errDial := errors.New("dial failed")
+	broken := &stoppingConsumer{startingConsumer: startingConsumer{err: errDial}}
+	var startErr *StartError
+	if err := pl.ReplaceConsumer(broken); !errors.As(err, &startErr) || !errors.Is(err, errDial) {
+		t.Fatalf("ReplaceConsumer(broken) error = %v, want a *StartError with %v", err, errDial)
+	}
+
+	next := &stoppingConsumer{}
+	if err := pl.ReplaceConsumer(next); err != nil {
+		t.Fatalf("ReplaceConsumer() error = %v", err)
+	}
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	if got := old.batchSizes(); !reflect.DeepEqual(got, []int{2}) || old.stopped != 1 || old.batchesAtStop != 1 {
+		t.Errorf("old consumer: batches %v, stopped %d times after %d batches; want [2], once after 1", got, old.stopped, old.batchesAtStop)
+	}
+	if got := next.batchSizes(); !reflect.DeepEqual(got, []int{1}) || next.started != 1 || next.stopped != 1 {
+		t.Errorf("new consumer: batches %v, started %d, stopped %d; want [1], 1, 1", got, next.started, next.stopped)
+	}
+	if broken.stopped != 0 || len(broken.batchSizes()) != 0 {
+		t.Errorf("broken consumer was stopped %d times and got batches %v", broken.stopped, broken.batchSizes())
+	}
+	if !reflect.DeepEqual(src.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", src.committed)
+	}
+}
+
+// closingChanProducer - живой источник с Close
+type closingChanProducer struct {
+	*chanProducer
+	closed int
+}
+
+func (p *closingChanProducer) Close() error {
+	p.closed++
+	return nil
+}
+
+// renewingChanProducer - живой источник, который продлевает аренду своих cookie
+type renewingChanProducer struct {
+	*chanProducer
+}
+
+func (p *renewingChanProducer) RenewLeases(ctx context.Context, cookies []int) error {
+	return nil
+}
+
+func TestPipelineReplaceProducer(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		translate func(int) (int, error)
+		// Куда ушли коммиты: в старый и в новый источник, и сколько раз закрыли старый до конца запуска
+		wantOld, wantNew []int
+		wantClosed       int
+	}{
+		{"translate", func(c int) (int, error) { return c + 100, nil }, []int{1}, []int{102, 1}, 1},
+		{"no translate", nil, []int{1, 2}, []int{1}, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &closingChanProducer{chanProducer: &chanProducer{ch: make(chan []any, 10)}}
+			next := &chanProducer{ch: make(chan []any, 10)}
+			c := &testConsumer{}
+			pl := NewPipeline(old, c, WithMaxBatchDelay(time.Hour))
+			if err := pl.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			// Этот батч закоммичен в старый ещё до замены
+			feed(old.chanProducer, []any{1})
+			if err := pl.Flush(ctx); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			// Эта пачка старого лежит в буфере: она уйдёт батчем при замене
+			feed(old.chanProducer, []any{2, 3})
+			if err := pl.ReplaceProducer(next, tt.translate); err != nil {
+				t.Fatalf("ReplaceProducer() error = %v", err)
+			}
+			if old.closed != tt.wantClosed {
+				t.Errorf("old producer closed %d times right after the swap, want %d", old.closed, tt.wantClosed)
+			}
+			// У нового cookie снова с 1 - они не должны перепутаться со старыми
+			feed(next, []any{4})
+			if err := pl.Stop(ctx); err != nil {
+				t.Fatalf("Stop() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(old.committed, tt.wantOld) || !reflect.DeepEqual(next.committed, tt.wantNew) {
+				t.Errorf("commits: old %v, new %v; want %v and %v", old.committed, next.committed, tt.wantOld, tt.wantNew)
+			}
+			if old.closed != 1 {
+				t.Errorf("old producer closed %d times by the end, want 1", old.closed)
+			}
+			if got := c.batchSizes(); !reflect.DeepEqual(got, []int{1, 2, 1}) {
+				t.Errorf("batches = %v, want [1 2 1]", got)
+			}
+		})
+	}
+}
+
+func TestPipelineReplaceProducerUnsupported(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 1)}
+	pl := NewPipeline(src, &testConsumer{})
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer pl.Stop(context.Background())
+	if err := pl.ReplaceProducer(&renewingChanProducer{&chanProducer{}}, nil); err == nil {
+		t.Fatal("ReplaceProducer() to a LeaseRenewer succeeded")
+	}
+	// Запуск после отказа идёт дальше со старым источником
+	feed(src, []any{1})
+	if err := pl.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if !reflect.DeepEqual(src.committed, []int{1}) {
+		t.Errorf("commits = %v, want [1]", src.committed)
+	}
+}
+
+func TestPipelinePause(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	c := &testConsumer{}
+	pl := NewPipeline(src, c, WithMaxBatchDelay(5*time.Millisecond))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	feed(src, []any{1})
+	if err := pl.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	pl.Pause()
+	if !pl.Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+	// Пачку забирает Next, который уже шёл, но ни таймер, ни Flush её не отправляют
+	src.ch <- []any{2, 3}
+	time.Sleep(30 * time.Millisecond)
+	short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelShort()
+	if err := pl.Flush(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Flush() while paused error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("batches while paused = %v, want [1]", got)
+	}
+
+	pl.Resume()
+	if pl.Paused() {
+		t.Fatal("Paused() = true after Resume")
+	}
+	// Отложенная пачка уходит по таймеру
+	deadline := time.Now().Add(time.Second)
+	for len(c.batchSizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("batches after Resume = %v, want [1 2]", got)
+	}
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+}
+
+func TestPipelineStopWhilePaused(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	c := &testConsumer{}
+	pl := NewPipeline(src, c, WithMaxBatchDelay(time.Hour))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	feed(src, []any{1, 2})
+	pl.Pause()
+	// Эта пачка ждёт Resume в уже шедшем Next - Stop дописывает и её
+	src.ch <- []any{3}
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("batches = %v, want [3]", got)
+	}
+	if !reflect.DeepEqual(src.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", src.committed)
+	}
+}
+
+func TestPipelineStartPaused(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	pl := NewPipeline(src, &testConsumer{}, WithMaxBatchDelay(time.Hour))
+	pl.Pause()
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	// На паузе Next не вызывается вовсе
+	src.ch <- []any{1}
+	time.Sleep(20 * time.Millisecond)
+	if len(src.ch) != 1 {
+		t.Fatalf("chunk read while paused")
+	}
+	pl.Resume()
+	feed(src)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !reflect.DeepEqual(src.committed, []int{1}) {
+		t.Errorf("commits = %v, want [1]", src.committed)
+	}
+}
+
+// slowStopProducer - long-poll, которому после отмены нужно время, чтобы вернуться
+type slowStopProducer struct {
+	chanProducer
+	teardown time.Duration
+}
+
+func (p *slowStopProducer) Next(ctx context.Context) ([]any, int, error) {
+	items, cookie, err := p.chanProducer.Next(ctx)
+	if err != nil {
+		time.Sleep(p.teardown)
+	}
+	return items, cookie, err
+}
+
+func TestPipelineStopDrainTimeoutIncludesNext(t *testing.T) {
+	src := &slowStopProducer{chanProducer: chanProducer{ch: make(chan []any, 10)}, teardown: 100 * time.Millisecond}
+	c := &testConsumer{}
+	pl := NewPipeline(src, c, WithMaxBatchDelay(time.Hour), WithDrainTimeout(30*time.Millisecond))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	feed(&src.chanProducer, []any{1, 2})
+
+	// Next возвращается дольше, чем весь срок на остановку - дописывать уже поздно
+	err := pl.Stop(context.Background())
+	if !errors.Is(err, ErrDrainTimeout) {
+		t.Fatalf("Stop() error = %v, want %v", err, ErrDrainTimeout)
+	}
+	if got := c.batchSizes(); len(got) != 0 {
+		t.Errorf("batches = %v, want none after the drain timed out", got)
+	}
+	if len(src.committed) != 0 {
+		t.Errorf("commits = %v, want none", src.committed)
+	}
+}
+
+func TestPipelineStats(t *testing.T) {
+	src := &chanProducer{ch: make(chan []any, 10)}
+	pl := NewPipeline(src, &testConsumer{})
+	if got := pl.Stats(); got.Stages[StageRead].Calls != 0 || got.Flushed != 0 || got.Buffered != 0 {
+		t.Fatalf("Stats() before Start = %+v, want zeros", got)
+	}
+	ctx := context.Background()
+	started := time.Now()
+	if err := pl.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	feed(src, []any{1, 2, 3}, []any{4, 5})
+	s := pl.Stats()
+	if read := s.Stages[StageRead]; read.Items != 5 || read.Last.Before(started) || s.Buffered != 5 || s.Flushed != 0 {
+		t.Fatalf("Stats() after reads = %+v, want 5 items read and buffered", s)
+	}
+
+	// Barrier отправляет буфер и ждёт коммита
+	if _, err := pl.Barrier(ctx); err != nil {
+		t.Fatalf("Barrier() error = %v", err)
+	}
+	s = pl.Stats()
+	process, commit := s.Stages[StageProcess], s.Stages[StageCommit]
+	if s.Flushed != 1 || s.Buffered != 0 || process.Items != 5 || commit.Calls != 2 {
+		t.Fatalf("Stats() after Barrier = %+v, want one batch of 5 processed and 2 cookies committed", s)
+	}
+	if s.LastFlush.Before(s.Stages[StageRead].Last) || process.Last.Before(s.LastFlush) || commit.Last.Before(process.Last) {
+		t.Errorf("Stats() times out of order: %+v", s)
+	}
+	if err := pl.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+}
+
+func TestPipelineStatsWithStats(t *testing.T) {
+	// Своя Stats из опций - Pipeline.Stats отдаёт её же
+	stats := NewStats(time.Minute)
+	pl := NewPipeline(finiteProducer{&testProducer{chunks: 2, chunkSize: 10}}, &testConsumer{}, WithStats(stats))
+	if err := pl.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := pl.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if got, want := pl.Stats(), stats.Snapshot(); got.Version != want.Version || got.Stages[StageCommit].Calls != 2 {
+		t.Errorf("Stats() = %+v, want the WithStats snapshot %+v", got, want)
+	}
+}
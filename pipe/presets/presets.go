@@ -0,0 +1,49 @@
+// Package presets - готовые наборы настроек Pipe под типичные задачи. Каждый набор - одна pipe.Option,
+// опции после него перекрывают его настройки:
+//
+//	pipe.Pipe(p, c, presets.HighThroughput(), pipe.WithWorkers(8))
+package presets
+
+import (
+	"time"
+
+	"PipeProducerConsumer/pipe"
+)
+
+// LowLatency - данные должны доехать до приёмника быстро, а не крупными батчами: неполный батч уходит
+// через 100ms, упавшие Next и Process коротко повторяются, чтобы мелкий сбой не стоил рестарта.
+func LowLatency() pipe.Option {
+	return pipe.Options(
+		pipe.WithMaxBatchDelay(100*time.Millisecond),
+		pipe.WithNextRetry(pipe.RetryPolicy{Attempts: 3, Backoff: 20 * time.Millisecond, MaxBackoff: 200 * time.Millisecond, Jitter: 0.2}),
+		pipe.WithProcessRetry(pipe.RetryPolicy{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: 500 * time.Millisecond, Jitter: 0.2}),
+		pipe.WithCommitRetry(pipe.CommitRetry{RetryPolicy: pipe.RetryPolicy{Attempts: 3, Backoff: 20 * time.Millisecond, Jitter: 0.2}}),
+	)
+}
+
+// HighThroughput - крупные вставки и несколько батчей в работе: батч ждёт до 5s, а меньше 5000 элементов
+// не уходит по таймеру ещё до 30s. Четыре обработчика, коммиты по порядку. Повторы длинные - приёмник
+// под нагрузкой может отвечать ошибками минутами.
+func HighThroughput() pipe.Option {
+	return pipe.Options(
+		pipe.WithMaxBatchDelay(5*time.Second),
+		pipe.WithMinItems(5000, 30*time.Second),
+		pipe.WithWorkers(4),
+		pipe.WithNextRetry(pipe.RetryPolicy{Attempts: 5, Backoff: 200 * time.Millisecond, MaxBackoff: 10 * time.Second, Jitter: 0.2}),
+		pipe.WithProcessRetry(pipe.RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 30 * time.Second, Jitter: 0.2}),
+		pipe.WithCommitRetry(pipe.CommitRetry{RetryPolicy: pipe.RetryPolicy{Attempts: 5, Backoff: 200 * time.Millisecond, MaxBackoff: 10 * time.Second, Jitter: 0.2}}),
+	)
+}
+
+// StrictOnce - как можно меньше повторной записи: Process не повторяется (повтор после частичной вставки
+// пишет её дважды), один обработчик, повторные пачки источника выбрасываются, а cookie не по порядку
+// останавливают Pipe. Повторяется только Commit - данные к этому моменту уже записаны, а упасть на
+// коммите значит записать их ещё раз после рестарта.
+func StrictOnce() pipe.Option {
+	return pipe.Options(
+		pipe.WithWorkers(1),
+		pipe.WithCookieCheck(pipe.CookieCheck{}),
+		pipe.WithDuplicateChunks(64, nil),
+		pipe.WithCommitRetry(pipe.CommitRetry{RetryPolicy: pipe.RetryPolicy{Attempts: 10, Backoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second, Jitter: 0.2}}),
+	)
+}
@@ -0,0 +1,75 @@
+package presets
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"PipeProducerConsumer/pipe"
+)
+
+// finiteProducer отдаёт chunks пачек по 1000 элементов, потом ErrEndOfStream
+type finiteProducer struct {
+	chunks int
+
+	mu        sync.Mutex
+	sent      int
+	committed []int
+}
+
+func (p *finiteProducer) Next(ctx context.Context) ([]any, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent >= p.chunks {
+		return nil, 0, pipe.ErrEndOfStream
+	}
+	p.sent++
+	return make([]any, 1000), p.sent, nil
+}
+
+func (p *finiteProducer) Commit(ctx context.Context, cookie int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed = append(p.committed, cookie)
+	return nil
+}
+
+type nopConsumer struct{}
+
+func (nopConsumer) Process(ctx context.Context, items []any) error {
+	return nil
+}
+
+func TestPresets(t *testing.T) {
+	presets := map[string]pipe.Option{
+		"LowLatency":     LowLatency(),
+		"HighThroughput": HighThroughput(),
+		"StrictOnce":     StrictOnce(),
+	}
+	for name, preset := range presets {
+		t.Run(name, func(t *testing.T) {
+			p := &finiteProducer{chunks: 25}
+			if err := pipe.Pipe(p, nopConsumer{}, preset); err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			if len(p.committed) != 25 || p.committed[24] != 25 {
+				t.Fatalf("commits = %v, want 1..25", p.committed)
+			}
+		})
+	}
+}
+
+func TestPresetOverride(t *testing.T) {
+	// Опция после набора перекрывает его: с одним обработчиком inline режим уже не конфликтует
+	p := &finiteProducer{chunks: 3}
+	if err := pipe.Pipe(p, nopConsumer{}, HighThroughput(), pipe.WithWorkers(1), pipe.WithInlineMode()); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	err := pipe.Pipe(&finiteProducer{}, nopConsumer{}, HighThroughput(), pipe.WithInlineMode())
+	var cfgErr *pipe.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Pipe() error = %v, want a *pipe.ConfigError for workers in inline mode", err)
+	}
+}
@@ -0,0 +1,109 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Профилирование по стадиям. На обычном CPU-профиле Pipe - это горутины с лямбдами из pipe.go, и не
+понять, кто тормозит: источник, преобразования или приёмник. С WithProfiling каждый вызов адаптера
+идёт под метками runtime/pprof, так что профиль режется по стадиям (go tool pprof -tagfocus=pipe_stage=process),
+а время в стадиях Pipe считает сам и отдаёт в RunReport.
+*/
+
+// Метки runtime/pprof, которые ставит WithProfiling
+const (
+	// Стадия: read, transform, process, commit
+	ProfileLabelStage = "pipe_stage"
+	// Адаптер стадии: тип источника, описание приёмника (SinkDescriber) или "transform N <тип>"
+	ProfileLabelAdapter = "pipe_adapter"
+)
+
+// WithProfiling вызывает Next, преобразования, Process и Commit под метками runtime/pprof ProfileLabelStage
+// и ProfileLabelAdapter (плюс теги WithTags), а время в вызовах каждой стадии копит в RunReport.StageTime.
+// Горутины, которые адаптер запускает изнутри вызова, наследуют его метки. Сам профиль снимает приложение
+// (net/http/pprof, pprof.StartCPUProfile) - без него метки ничего не стоят, кроме пары аллокаций на вызов.
+func WithProfiling() Option {
+	return func(cfg *config) {
+		cfg.profiling = true
+	}
+}
+
+// stageProfile ставит метки и копит время стадий. nil - профилирование выключено
+type stageProfile struct {
+	// Метки WithTags, парами ключ-значение
+	tags []string
+	// Время в вызовах стадии, наносекунды
+	nanos map[Stage]*atomic.Int64
+}
+
+func newStageProfile(cfg *config) *stageProfile {
+	if !cfg.profiling {
+		return nil
+	}
+	p := &stageProfile{nanos: make(map[Stage]*atomic.Int64)}
+	for _, st := range []Stage{StageRead, StageTransform, StageProcess, StageCommit} {
+		p.nanos[st] = &atomic.Int64{}
+	}
+	keys := make([]string, 0, len(cfg.tags))
+	for k := range cfg.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p.tags = append(p.tags, k, cfg.tags[k])
+	}
+	return p
+}
+
+// do вызывает fn под метками стадии и адаптера. Имя адаптера считаем только при включённом профилировании
+func (p *stageProfile) do(ctx context.Context, stage Stage, adapter func() string, fn func(ctx context.Context)) {
+	if p == nil {
+		fn(ctx)
+		return
+	}
+	labels := make([]string, 0, len(p.tags)+4)
+	labels = append(labels, p.tags...)
+	labels = append(labels, ProfileLabelStage, string(stage), ProfileLabelAdapter, adapter())
+	started := time.Now()
+	pprof.Do(ctx, pprof.Labels(labels...), fn)
+	p.nanos[stage].Add(int64(time.Since(started)))
+}
+
+// stageTime - накопленное время по стадиям, nil без профилирования
+func (p *stageProfile) stageTime() map[Stage]time.Duration {
+	if p == nil {
+		return nil
+	}
+	t := make(map[Stage]time.Duration, len(p.nanos))
+	for st, n := range p.nanos {
+		t[st] = time.Duration(n.Load())
+	}
+	return t
+}
+
+// sourceName - имя источника для метки ProfileLabelAdapter
+func sourceName(p any) func() string {
+	return func() string {
+		return fmt.Sprintf("%T", p)
+	}
+}
+
+// sinkName - имя приёмника для метки ProfileLabelAdapter
+func sinkName(sink string) func() string {
+	return func() string {
+		return sink
+	}
+}
+
+// transformName - имя преобразования i для метки ProfileLabelAdapter
+func transformName(i int, t Transform) func() string {
+	return func() string {
+		return fmt.Sprintf("transform %d %T", i, t)
+	}
+}
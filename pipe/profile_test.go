@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+)
+
+// labelRecorder запоминает метки pprof, под которыми его вызвали
+type labelRecorder struct {
+	mu     sync.Mutex
+	labels map[string]bool
+}
+
+func (r *labelRecorder) record(ctx context.Context) {
+	stage, _ := pprof.Label(ctx, ProfileLabelStage)
+	adapter, _ := pprof.Label(ctx, ProfileLabelAdapter)
+	team, _ := pprof.Label(ctx, "team")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.labels == nil {
+		r.labels = map[string]bool{}
+	}
+	r.labels[stage+" | "+adapter+" | "+team] = true
+}
+
+// labeledProducer - testProducer, который смотрит на метки Next и Commit
+type labeledProducer struct {
+	testProducer
+	rec *labelRecorder
+}
+
+func (p *labeledProducer) Next(ctx context.Context) ([]any, int, error) {
+	p.rec.record(ctx)
+	return p.testProducer.Next(ctx)
+}
+
+func (p *labeledProducer) Commit(ctx context.Context, cookie int) error {
+	p.rec.record(ctx)
+	return p.testProducer.Commit(ctx, cookie)
+}
+
+// labeledSink смотрит на метки Process и обрабатывает батч не быстрее took
+type labeledSink struct {
+	rec  *labelRecorder
+	took time.Duration
+}
+
+func (s *labeledSink) Process(ctx context.Context, items []any) error {
+	s.rec.record(ctx)
+	time.Sleep(s.took)
+	return nil
+}
+
+func (s *labeledSink) DescribeSink() string {
+	return "warehouse"
+}
+
+func TestWithProfiling(t *testing.T) {
+	rec := &labelRecorder{}
+	p := &labeledProducer{testProducer: testProducer{chunks: 2, chunkSize: MaxItems}, rec: rec}
+	keep := TransformFunc(func(ctx context.Context, items []any) ([]any, error) {
+		rec.record(ctx)
+		return items, nil
+	})
+	var report RunReport
+	err := Pipe(p, &labeledSink{rec: rec, took: 20 * time.Millisecond}, WithProfiling(), WithTags(map[string]string{"team": "billing"}),
+		WithTransform(keep), WithRunReport(func(r RunReport) { report = r }))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	want := map[string]bool{
+		"read | *pipe.labeledProducer | billing":               true,
+		"transform | transform 0 pipe.TransformFunc | billing": true,
+		"process | warehouse | billing":                        true,
+		"commit | *pipe.labeledProducer | billing":             true,
+	}
+	if !reflect.DeepEqual(rec.labels, want) {
+		t.Errorf("labels = %v, want %v", rec.labels, want)
+	}
+	// Два батча по 20ms в приёмнике
+	if got := report.StageTime[StageProcess]; got < 40*time.Millisecond {
+		t.Errorf("StageTime[process] = %v, want at least 40ms", got)
+	}
+	for _, st := range []Stage{StageRead, StageTransform, StageCommit} {
+		if _, ok := report.StageTime[st]; !ok {
+			t.Errorf("StageTime has no %s", st)
+		}
+	}
+}
+
+func TestWithoutProfiling(t *testing.T) {
+	rec := &labelRecorder{}
+	p := &labeledProducer{testProducer: testProducer{chunks: 1, chunkSize: 10}, rec: rec}
+	var report RunReport
+	err := Pipe(p, &labeledSink{rec: rec}, WithInlineMode(), WithRunReport(func(r RunReport) { report = r }))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := map[string]bool{" |  | ": true}; !reflect.DeepEqual(rec.labels, want) {
+		t.Errorf("labels = %v, want none", rec.labels)
+	}
+	if report.StageTime != nil {
+		t.Errorf("StageTime = %v, want nil", report.StageTime)
+	}
+}
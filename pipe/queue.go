@@ -0,0 +1,135 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+Глубина очереди батчей между чтением и обработкой. Когда одна сторона явно медленнее, глубокая очередь
+ничего не даёт: либо она всегда полна и только держит память, либо всегда пуста. Когда скорости близки,
+она сглаживает рывки той и другой стороны. WithAdaptiveQueue подбирает глубину по этому правилу сама.
+*/
+
+// DefaultQueueDepth - сколько собранных батчей ждут обработки без WithAdaptiveQueue
+const DefaultQueueDepth = 3
+
+// queueSmoothing - вес нового замера в скользящем среднем времени чтения и обработки
+const queueSmoothing = 0.2
+
+// WithAdaptiveQueue подбирает глубину очереди батчей в [minDepth, maxDepth] по отношению времени чтения батча
+// (от первой пачки в буфере до отправки) и времени Process: чем ближе они, тем глубже очередь.
+// Текущая глубина видна в StatsSnapshot.QueueDepth. В inline-режиме очереди нет.
+func WithAdaptiveQueue(minDepth, maxDepth int) Option {
+	return func(cfg *config) {
+		cfg.queue = &adaptiveQueue{min: minDepth, max: maxDepth, depth: minDepth, freed: make(chan struct{})}
+	}
+}
+
+// adaptiveQueue - сколько батчей сейчас в очереди и сколько можно
+type adaptiveQueue struct {
+	min, max int
+
+	mu     sync.Mutex
+	depth  int
+	queued int
+	// Скользящие средние времени чтения и обработки батча
+	read, process time.Duration
+	// Закрывается, когда место в очереди могло появиться
+	freed chan struct{}
+}
+
+func (q *adaptiveQueue) validate(inline bool, add func(option, problem, suggestion string)) {
+	if q == nil {
+		return
+	}
+	if q.min < 1 || q.max < q.min {
+		add("WithAdaptiveQueue", fmt.Sprintf("bounds [%d, %d] are invalid", q.min, q.max), "use e.g. 1 and 16")
+	}
+	if inline {
+		add("WithAdaptiveQueue", "inline mode has no queue", "drop WithInlineMode")
+	}
+}
+
+// capacity - ёмкость канала батчей: очередь никогда не глубже неё
+func (q *adaptiveQueue) capacity() int {
+	if q == nil {
+		return DefaultQueueDepth
+	}
+	return q.max
+}
+
+// enter ждёт места в очереди под текущей глубиной и занимает его. Ошибка - только отмена ctx
+func (q *adaptiveQueue) enter(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	for {
+		q.mu.Lock()
+		if q.queued < q.depth {
+			q.queued++
+			q.mu.Unlock()
+			return nil
+		}
+		freed := q.freed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// leave - батч забрали из очереди (или так и не положили)
+func (q *adaptiveQueue) leave() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued--
+	q.wakeLocked()
+}
+
+// observe учитывает время чтения или обработки батча (0 - не замерено) и пересчитывает глубину.
+// Возвращает новую глубину
+func (q *adaptiveQueue) observe(read, process time.Duration) int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.read = smooth(q.read, read)
+	q.process = smooth(q.process, process)
+	if q.read <= 0 || q.process <= 0 {
+		return q.depth
+	}
+	ratio := float64(min(q.read, q.process)) / float64(max(q.read, q.process))
+	depth := min(max(int(math.Ceil(ratio*float64(q.max))), q.min), q.max)
+	if depth > q.depth {
+		q.wakeLocked()
+	}
+	q.depth = depth
+	return depth
+}
+
+// smooth - скользящее среднее с новым замером d, 0 - замера нет
+func smooth(avg, d time.Duration) time.Duration {
+	switch {
+	case d <= 0:
+		return avg
+	case avg <= 0:
+		return d
+	}
+	return avg + time.Duration(queueSmoothing*float64(d-avg))
+}
+
+func (q *adaptiveQueue) wakeLocked() {
+	close(q.freed)
+	q.freed = make(chan struct{})
+}
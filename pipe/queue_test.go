@@ -0,0 +1,86 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveQueueDepth(t *testing.T) {
+	q := &adaptiveQueue{min: 1, max: 8, depth: 1, freed: make(chan struct{})}
+
+	// Чтение и обработка наравне - очередь на всю глубину
+	q.observe(10*time.Millisecond, 0)
+	if got := q.observe(0, 10*time.Millisecond); got != 8 {
+		t.Fatalf("depth with equal latencies = %d, want 8", got)
+	}
+	// Обработка всё медленнее - очередь только держит память
+	var got int
+	for i := 0; i < 50; i++ {
+		got = q.observe(0, time.Second)
+	}
+	if got != 1 {
+		t.Fatalf("depth with a slow consumer = %d, want 1", got)
+	}
+}
+
+func TestAdaptiveQueueEnter(t *testing.T) {
+	q := &adaptiveQueue{min: 1, max: 4, depth: 1, freed: make(chan struct{})}
+	if err := q.enter(context.Background()); err != nil {
+		t.Fatalf("enter() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.enter(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("enter() into a full queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	got := make(chan error, 1)
+	go func() { got <- q.enter(context.Background()) }()
+	q.leave()
+	if err := <-got; err != nil {
+		t.Fatalf("enter() after leave() error = %v", err)
+	}
+}
+
+func TestWithAdaptiveQueue(t *testing.T) {
+	// Глубина начинается с нижней границы: пока первый батч висит в Process, в очереди один,
+	// третий собран и ждёт места, а четвёртая пачка прочитана в буфер
+	p := &testProducer{chunks: 20, chunkSize: 6000}
+	c := &slowConsumer{release: make(chan struct{})}
+	s := NewStats(time.Minute)
+
+	done := make(chan error, 1)
+	go func() { done <- Pipe(p, c, WithAdaptiveQueue(1, 8), WithStats(s)) }()
+
+	deadline := time.After(time.Second)
+	for p.sentChunks() < 4 {
+		select {
+		case <-deadline:
+			t.Fatalf("read %d chunks, want 4", p.sentChunks())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := p.sentChunks(); got != 4 {
+		t.Fatalf("read %d chunks while the first batch is stuck, want 4", got)
+	}
+
+	close(c.release)
+	if err := <-done; !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	checkCommitsInOrder(t, p.commits())
+	if got := s.Snapshot().QueueDepth; got < 1 || got > 8 {
+		t.Errorf("QueueDepth = %d, want within [1, 8]", got)
+	}
+}
+
+func TestWithAdaptiveQueueValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithAdaptiveQueue(4, 2), WithInlineMode())
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 2 problems", err)
+	}
+}
@@ -0,0 +1,97 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Ограничение скорости записи. На бэкфилле источник отдаёт данные сколько угодно быстро, и приёмник
+(кластер ClickHouse) ложится под вставками. Token bucket пропускает в среднем rate в секунду,
+а после простоя - разовый всплеск до burst.
+*/
+
+// WithRateLimit ограничивает отправку батчей консюмеру itemsPerSec элементами в секунду с всплеском до burst.
+// Батч больше burst уходит целиком, когда накопится burst, а следующие ждут, пока он не отработается по скорости.
+// Пока батч ждёт, чтение источника тоже встаёт.
+func WithRateLimit(itemsPerSec float64, burst int) Option {
+	return func(cfg *config) {
+		cfg.itemsRate = newTokenBucket(itemsPerSec, burst)
+	}
+}
+
+// WithBatchRateLimit - то же по числу батчей: не больше batchesPerSec в секунду с всплеском до burst
+func WithBatchRateLimit(batchesPerSec float64, burst int) Option {
+	return func(cfg *config) {
+		cfg.batchesRate = newTokenBucket(batchesPerSec, burst)
+	}
+}
+
+// tokenBucket - токены копятся со скоростью rate до burst, отправка забирает по токену на единицу
+type tokenBucket struct {
+	rate  float64
+	burst int
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, now: time.Now, tokens: float64(burst)}
+}
+
+// validate проверяет лимит, option - имя опции для ConfigError
+func (b *tokenBucket) validate(option string, add func(option, problem, suggestion string)) {
+	if b == nil {
+		return
+	}
+	if b.rate <= 0 {
+		add(option, fmt.Sprintf("rate %g is not positive", b.rate), "use e.g. 50000")
+	}
+	if b.burst <= 0 {
+		add(option, fmt.Sprintf("burst %d is not positive", b.burst), "use at least the batch size")
+	}
+}
+
+// reserve забирает n токенов (в долг, если их меньше) и возвращает, сколько надо подождать перед отправкой.
+// Ждём, пока набежит min(n, burst): больше в корзину всё равно не влезет
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens = min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	need := float64(min(n, b.burst))
+	var wait time.Duration
+	if b.tokens < need {
+		wait = time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	return wait
+}
+
+// wait ждёт своей очереди на n единиц. nil-корзина и n == 0 не ждут. Ошибка - только отмена ctx
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	if b == nil || n == 0 {
+		return nil
+	}
+	d := b.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package pipe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(10, 10)
+	b.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance time.Duration
+		n       int
+		wait    time.Duration
+	}{
+		// Полная корзина пропускает всплеск сразу
+		{0, 10, 0},
+		{0, 5, 500 * time.Millisecond},
+		// Долг в 5 токенов отработан, ещё 5 набежало
+		{time.Second, 5, 0},
+		// Больше burst: ждём полную корзину, остальное уходит в долг
+		{0, 20, time.Second},
+		{2 * time.Second, 1, 100 * time.Millisecond},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		if got := b.reserve(s.n); got != s.wait {
+			t.Fatalf("step %d: reserve(%d) = %s, want %s", i, s.n, got, s.wait)
+		}
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		// Пять батчей по 6000: первый из корзины, остальные по 30ms
+		{name: "items", opt: WithRateLimit(200000, 6000)},
+		{name: "batches", opt: WithBatchRateLimit(33, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testProducer{chunks: 5, chunkSize: 6000}
+			c := &testConsumer{}
+			start := time.Now()
+			if err := Pipe(finiteProducer{p}, c, tt.opt); err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed < 110*time.Millisecond {
+				t.Errorf("5 batches took %s, want at least 120ms under the limit", elapsed)
+			}
+			if got := len(c.batchSizes()); got != 5 {
+				t.Errorf("got %d batches, want 5", got)
+			}
+		})
+	}
+}
+
+func TestWithRateLimitValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithRateLimit(0, 0), WithBatchRateLimit(-1, 1))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 3 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 3 problems", err)
+	}
+}
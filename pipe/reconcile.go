@@ -0,0 +1,102 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+/*
+Сверка числа строк. Часть приёмников пишет "выстрелил и забыл": Process вернул nil, а до таблицы
+дошла только часть строк (буфер драйвера, асинхронная вставка, тихий отброс дубликатов).
+ReconcileConsumer после каждого Process спрашивает у приёмника, сколько строк батча реально легло,
+и сравнивает с тем, сколько отдали. Дёшево и ловит частичную запись до коммита.
+*/
+
+// RowCounter - сколько строк батча items реально есть в приёмнике (например, SELECT count(*) по ключам батча).
+// Номер батча, если нужен, - в BatchContext(ctx).
+type RowCounter func(ctx context.Context, items []any) (int, error)
+
+// CountMismatchError - приёмник принял батч, но строк в нём не столько, сколько отдали
+type CountMismatchError struct {
+	// Номер батча в рамках запуска Pipe (0, если консюмер вызван не из Pipe)
+	Batch    uint64
+	Expected int
+	Actual   int
+	Time     time.Time
+}
+
+func (e *CountMismatchError) Error() string {
+	return fmt.Sprintf("batch %d: sink has %d rows, want %d", e.Batch, e.Actual, e.Expected)
+}
+
+// ReconcileConsumer после каждого успешного c.Process сверяет count с длиной батча. Расхождение с
+// onMismatch nil - ошибка Process (*CountMismatchError, батч не коммитится), иначе оно уходит в onMismatch
+// синхронно, до коммита, и батч коммитится. Ошибка count - ошибка Process: без неё не понять, записался ли батч.
+// С WithProcessRetry батч после расхождения пишется заново - count должен считать строки без дублей.
+// BatchSizer, SinkDescriber, OnStart/OnStop (и io.Closer) берутся у c.
+func ReconcileConsumer(c Consumer, count RowCounter, onMismatch func(CountMismatchError)) Consumer {
+	return &reconcileConsumer{c: c, count: count, onMismatch: onMismatch}
+}
+
+type reconcileConsumer struct {
+	c          Consumer
+	count      RowCounter
+	onMismatch func(CountMismatchError)
+}
+
+func (r *reconcileConsumer) Process(ctx context.Context, items []any) error {
+	if err := r.c.Process(ctx, items); err != nil {
+		return err
+	}
+	actual, err := r.count(ctx, items)
+	if err != nil {
+		return fmt.Errorf("reconcile row count: %w", err)
+	}
+	if actual == len(items) {
+		return nil
+	}
+
+	m := CountMismatchError{Expected: len(items), Actual: actual, Time: time.Now()}
+	if meta, ok := BatchContext(ctx); ok {
+		m.Batch = meta.Seq
+	}
+	if r.onMismatch == nil {
+		return &m
+	}
+	r.onMismatch(m)
+	return nil
+}
+
+func (r *reconcileConsumer) PreferredBatchSize(ctx context.Context) int {
+	if bs, ok := r.c.(BatchSizer); ok {
+		return bs.PreferredBatchSize(ctx)
+	}
+	return 0
+}
+
+func (r *reconcileConsumer) adapters() []adapter {
+	return []adapter{{"consumer", r.c}}
+}
+
+func (r *reconcileConsumer) DescribeSink() string {
+	return describeSink(r.c)
+}
+
+func (r *reconcileConsumer) OnStart(ctx context.Context) error {
+	if s, ok := r.c.(Starter); ok {
+		return s.OnStart(ctx)
+	}
+	return nil
+}
+
+func (r *reconcileConsumer) OnStop(ctx context.Context) error {
+	switch s := r.c.(type) {
+	case Stopper:
+		return s.OnStop(ctx)
+	case io.Closer:
+		return s.Close()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// lossySink "пишет" батч, но на батче dropOn теряет одну строку и не говорит об этом
+type lossySink struct {
+	mu     sync.Mutex
+	dropOn uint64
+	rows   map[uint64]int
+}
+
+func (s *lossySink) Process(ctx context.Context, items []any) error {
+	meta, _ := BatchContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rows == nil {
+		s.rows = map[uint64]int{}
+	}
+	s.rows[meta.Seq] = len(items)
+	if meta.Seq == s.dropOn {
+		s.rows[meta.Seq]--
+	}
+	return nil
+}
+
+func (s *lossySink) count(ctx context.Context, items []any) (int, error) {
+	meta, _ := BatchContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows[meta.Seq], nil
+}
+
+func TestReconcileConsumerFailsOnMismatch(t *testing.T) {
+	sink := &lossySink{dropOn: 2}
+	p := &testProducer{chunks: 3, chunkSize: MaxItems}
+	err := Pipe(p, ReconcileConsumer(sink, sink.count, nil), WithInlineMode())
+
+	var m *CountMismatchError
+	if !errors.As(err, &m) {
+		t.Fatalf("Pipe() error = %v, want CountMismatchError", err)
+	}
+	if m.Batch != 2 || m.Expected != MaxItems || m.Actual != MaxItems-1 {
+		t.Errorf("mismatch = %+v, want batch 2 with one row missing", m)
+	}
+	// Недописанный батч не коммитится
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("commits = %v, want [1]", got)
+	}
+}
+
+func TestReconcileConsumerReportsMismatch(t *testing.T) {
+	sink := &lossySink{dropOn: 2}
+	p := &testProducer{chunks: 3, chunkSize: MaxItems}
+	var mismatches []CountMismatchError
+	c := ReconcileConsumer(sink, sink.count, func(m CountMismatchError) { mismatches = append(mismatches, m) })
+	err := Pipe(p, c, WithInlineMode())
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if len(mismatches) != 1 || mismatches[0].Batch != 2 || mismatches[0].Time.IsZero() {
+		t.Fatalf("mismatches = %+v, want one for batch 2", mismatches)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("commits = %v, want [1 2 3]", got)
+	}
+}
+
+func TestReconcileConsumerCountError(t *testing.T) {
+	errQuery := errors.New("count query timed out")
+	count := func(ctx context.Context, items []any) (int, error) { return 0, errQuery }
+	c := ReconcileConsumer(NullConsumer{}, count, func(CountMismatchError) {
+		t.Error("a failed count is not a mismatch")
+	})
+	err := c.Process(context.Background(), []any{1, 2})
+	if !errors.Is(err, errQuery) {
+		t.Fatalf("Process() error = %v, want %v", err, errQuery)
+	}
+}
@@ -0,0 +1,106 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+Источники, которые после переподключения отдают пачки слегка вразнобой (несколько партиций
+за одним клиентом, повтор запроса, обогнавший следующий). Приёмнику, которому нужен порядок,
+такие пачки надо выстроить по cookie, прежде чем они попадут в батч.
+*/
+
+// DefaultReorderWindow - сколько пачек по умолчанию держит Reorder в ожидании пропущенного cookie
+const DefaultReorderWindow = 16
+
+// Reorder отдаёт пачки p строго по возрастанию cookie. Cookie должны идти подряд (номера сообщений):
+// пачка ждёт, пока не придут все cookie перед ней, но ждут не больше window пачек (window <= 0 -
+// DefaultReorderWindow). Когда окно заполнено, отдаётся самая ранняя из ждущих, а пропущенный cookie
+// считается потерянным - если он придёт позже, Next вернёт ErrCookieOrder. Начало последовательности
+// Reorder не знает, поэтому сначала набирает полное окно и начинает с самого раннего cookie в нём:
+// первые пачки после переподключения тоже могут прийти вразнобой. Если следующий cookie известен
+// (последний закоммиченный плюс один), лучше ReorderFrom - она не ждёт окна на старте.
+// Ошибку p Reorder возвращает после того, как отдаст все ждущие пачки.
+func Reorder[T any](p ProducerOf[T], window int) ProducerOf[T] {
+	if window <= 0 {
+		window = DefaultReorderWindow
+	}
+	return &reorderProducer[T]{p: p, window: window, held: make(map[int][]T)}
+}
+
+// ReorderFrom - Reorder, у которой последовательность начинается с cookie next: пачка next уходит сразу,
+// а cookie меньше next - ErrCookieOrder.
+func ReorderFrom[T any](p ProducerOf[T], window, next int) ProducerOf[T] {
+	r := Reorder(p, window).(*reorderProducer[T])
+	r.next, r.started = next, true
+	return r
+}
+
+type reorderProducer[T any] struct {
+	p      ProducerOf[T]
+	window int
+
+	// Ждущие пачки по cookie и следующий cookie, который можно отдать (Next не зовут параллельно).
+	// Пока started false, next не известен и пачки копятся до полного окна
+	held    map[int][]T
+	next    int
+	started bool
+	// Ошибка p, которую вернём, когда отдадим ждущие пачки
+	err error
+}
+
+func (r *reorderProducer[T]) Next(ctx context.Context) ([]T, int, error) {
+	for {
+		if items, ok := r.held[r.next]; r.started && ok {
+			delete(r.held, r.next)
+			r.next++
+			return items, r.next - 1, nil
+		}
+		// Источник больше ничего не даст или окно заполнено - отдаём самую раннюю, не дожидаясь пропуска
+		if r.err != nil || len(r.held) >= r.window {
+			if len(r.held) == 0 {
+				// Ошибку отдаём один раз: с WithNextRetry следующий Next снова пойдёт в источник
+				err := r.err
+				r.err = nil
+				return nil, 0, err
+			}
+			first, found := 0, false
+			for cookie := range r.held {
+				if !found || cookie < first {
+					first, found = cookie, true
+				}
+			}
+			items := r.held[first]
+			delete(r.held, first)
+			r.next, r.started = first+1, true
+			return items, first, nil
+		}
+
+		items, cookie, err := r.p.Next(ctx)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		if len(items) == 0 {
+			return nil, 0, nil
+		}
+		if r.started && cookie < r.next {
+			return nil, 0, fmt.Errorf("%w: cookie %d arrived after %d was released", ErrCookieOrder, cookie, r.next-1)
+		}
+		r.held[cookie] = items
+	}
+}
+
+func (r *reorderProducer[T]) Commit(ctx context.Context, cookie int) error {
+	return r.p.Commit(ctx, cookie)
+}
+
+func (r *reorderProducer[T]) OnStart(ctx context.Context) error {
+	_, err := startAdapters(ctx, []adapter{{"source", r.p}})
+	return err
+}
+
+func (r *reorderProducer[T]) OnStop(ctx context.Context) error {
+	return stopAdapters(ctx, []adapter{{"source", r.p}})
+}
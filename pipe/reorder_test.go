@@ -0,0 +1,94 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// readAll вызывает Next до первой ошибки и возвращает cookie пачек и эту ошибку
+func readAll(p Producer) ([]int, error) {
+	var cookies []int
+	for {
+		items, cookie, err := p.Next(context.Background())
+		if err != nil {
+			return cookies, err
+		}
+		if len(items) > 0 {
+			cookies = append(cookies, cookie)
+		}
+	}
+}
+
+func TestReorder(t *testing.T) {
+	p := &cookieListProducer{cookies: []int{10, 12, 11, 13, 16, 14, 15}}
+	cookies, err := readAll(Reorder[any](p, 4))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Next() error = %v, want %v", err, errSourceDone)
+	}
+	if want := []int{10, 11, 12, 13, 14, 15, 16}; !reflect.DeepEqual(cookies, want) {
+		t.Fatalf("cookies = %v, want %v", cookies, want)
+	}
+}
+
+func TestReorderFirstWindowOutOfOrder(t *testing.T) {
+	// После переподключения первым пришёл 5, а 4 - следом: начало последовательности - 4, а не 5
+	p := &cookieListProducer{cookies: []int{5, 4, 6, 7}}
+	cookies, err := readAll(Reorder[any](p, 2))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Next() error = %v, want %v", err, errSourceDone)
+	}
+	if want := []int{4, 5, 6, 7}; !reflect.DeepEqual(cookies, want) {
+		t.Fatalf("cookies = %v, want %v", cookies, want)
+	}
+}
+
+func TestReorderFrom(t *testing.T) {
+	// Следующий cookie известен: 4 уходит сразу, не дожидаясь окна, а 3 уже был закоммичен
+	p := &cookieListProducer{cookies: []int{5, 4, 3}}
+	r := ReorderFrom[any](p, 4, 4)
+	_, cookie, err := r.Next(context.Background())
+	if err != nil || cookie != 4 {
+		t.Fatalf("Next() = %d, %v, want 4", cookie, err)
+	}
+	cookies, err := readAll(r)
+	if !errors.Is(err, ErrCookieOrder) {
+		t.Fatalf("Next() error = %v, want %v", err, ErrCookieOrder)
+	}
+	if want := []int{5}; !reflect.DeepEqual(cookies, want) {
+		t.Fatalf("cookies = %v, want %v", cookies, want)
+	}
+}
+
+func TestReorderWindowFull(t *testing.T) {
+	// 2 потерян: окно из двух пачек заполнилось, 3 и 4 уходят без него, а опоздавший 2 - ошибка
+	p := &cookieListProducer{cookies: []int{1, 3, 4, 2}}
+	cookies, err := readAll(Reorder[any](p, 2))
+	if !errors.Is(err, ErrCookieOrder) {
+		t.Fatalf("Next() error = %v, want %v", err, ErrCookieOrder)
+	}
+	if want := []int{1, 3, 4}; !reflect.DeepEqual(cookies, want) {
+		t.Fatalf("cookies = %v, want %v", cookies, want)
+	}
+}
+
+func TestReorderDrainsBeforeSourceError(t *testing.T) {
+	// Источник упал, пока 3 ждёт 2 - ждущие пачки всё равно дописываются и коммитятся по порядку
+	p := &cookieListProducer{cookies: []int{1, 3}}
+	c := &testConsumer{}
+	reports := make(chan DeliveryReport, 10)
+
+	err := Pipe(Reorder[any](p, 4), c, WithInlineMode(), WithDeliveryReports(reports))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	close(reports)
+	var committed []int
+	for r := range reports {
+		committed = append(committed, r.Cookie)
+	}
+	if !reflect.DeepEqual(committed, []int{1, 3}) {
+		t.Fatalf("commits = %v, want [1 3]", committed)
+	}
+}
@@ -0,0 +1,127 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+Ошибки по отдельным элементам. Некоторые приёмники отбивают не батч целиком, а отдельные строки
+(не прошли схему, нарушили ограничение). Повторять из-за них весь батч - писать принятое ещё раз,
+а отдавать весь батч в DLQ - прятать туда хорошие строки. Консюмер с ProcessWithResults сообщает,
+какие элементы не приняты, и Pipe повторяет и отправляет в DLQ только их, а остальное коммитит.
+*/
+
+// ResultConsumerOf - опциональный интерфейс консюмера: если он есть, Pipe вызывает ProcessWithResults вместо Process.
+// errs - по ошибке на каждый элемент (nil - принят), nil-слайс - приняты все.
+// err - не принят весь переданный слайс, как ошибка Process. ResultConsumer - это ResultConsumerOf[any].
+type ResultConsumerOf[T any] interface {
+	ProcessWithResults(ctx context.Context, items []T) (errs []error, err error)
+}
+
+type ResultConsumer interface {
+	ProcessWithResults(ctx context.Context, items []any) (errs []error, err error)
+}
+
+// ItemError - элемент батча, который приёмник не принял
+type ItemError struct {
+	// Позиция элемента в батче (в исходном, даже если это повтор части батча)
+	Index int
+	Err   error
+}
+
+// PartialError - приёмник не принял часть батча. errors.Is/As видят ошибки элементов
+type PartialError struct {
+	Items []ItemError
+	// Сколько элементов в батче
+	Total int
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d items failed, first at %d: %v", len(e.Items), e.Total, e.Items[0].Index, e.Items[0].Err)
+}
+
+func (e *PartialError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Items))
+	for _, item := range e.Items {
+		errs = append(errs, item.Err)
+	}
+	return errs
+}
+
+// processBatch отдаёт приёмнику элементы батча с позициями pending (nil - весь батч) и возвращает позиции,
+// которые надо повторить: те же при ошибке всего вызова, не принятые при PartialError, nil - всё принято
+func processBatch[T any](ctx context.Context, cfg *config, c ConsumerOf[T], items []T, pending []int) ([]int, error) {
+	if t := cfg.tombstones; t != nil && t.policy == TombstonesRoute {
+		return pending, processTombstoneRuns(ctx, cfg, c, items)
+	}
+	rc, ok := c.(ResultConsumerOf[T])
+	if !ok {
+		cp, err := consumerItems(cfg, items)
+		if err != nil {
+			return pending, err
+		}
+		return pending, c.Process(ctx, cp)
+	}
+	part := items
+	if pending != nil {
+		part = pickItems(items, pending)
+		// BatchContext повтора описывает то, что приёмник получил, иначе CookieOf укажет не туда
+		if meta, ok := BatchContext(ctx); ok {
+			ctx = context.WithValue(ctx, batchKey{}, subBatch(meta, pending))
+		}
+	}
+	cp, err := consumerItems(cfg, part)
+	if err != nil {
+		return pending, err
+	}
+	errs, err := rc.ProcessWithResults(ctx, cp)
+	if err != nil {
+		return pending, err
+	}
+	if errs == nil {
+		return nil, nil
+	}
+	if len(errs) != len(part) {
+		return pending, fmt.Errorf("ProcessWithResults returned %d results for %d items", len(errs), len(part))
+	}
+	var failed []int
+	var itemErrs []ItemError
+	for i, itemErr := range errs {
+		if itemErr == nil {
+			continue
+		}
+		at := i
+		if pending != nil {
+			at = pending[i]
+		}
+		failed = append(failed, at)
+		itemErrs = append(itemErrs, ItemError{Index: at, Err: itemErr})
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return failed, &PartialError{Items: itemErrs, Total: len(items)}
+}
+
+// pickItems - элементы с позициями idx
+func pickItems[T any](items []T, idx []int) []T {
+	part := make([]T, len(idx))
+	for i, at := range idx {
+		part[i] = items[at]
+	}
+	return part
+}
+
+// subBatch - описание части батча из элементов idx: Spans пересчитаны под новые позиции, остальное как у батча
+func subBatch(meta BatchMeta, idx []int) BatchMeta {
+	sub := meta
+	sub.Items = len(idx)
+	sub.Spans = nil
+	for i, at := range idx {
+		if cookie, ok := meta.CookieOf(at); ok {
+			sub.Spans = appendSpan(sub.Spans, CookieSpan{Cookie: cookie, Offset: i, Items: 1})
+		}
+	}
+	return sub
+}
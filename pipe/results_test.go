@@ -0,0 +1,139 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var errBadRow = errors.New("row violates constraint")
+
+// rejectingConsumer не принимает элементы из reject, пока они не отбиты fails раз (0 - всегда)
+type rejectingConsumer struct {
+	reject map[any]bool
+	fails  int
+	// Что получил каждый вызов, BatchContext вызова и сколько раз отбит каждый элемент
+	calls    [][]any
+	metas    []BatchMeta
+	rejected map[any]int
+	accepted []any
+}
+
+func (c *rejectingConsumer) Process(ctx context.Context, items []any) error {
+	panic("Pipe must call ProcessWithResults")
+}
+
+func (c *rejectingConsumer) ProcessWithResults(ctx context.Context, items []any) ([]error, error) {
+	meta, _ := BatchContext(ctx)
+	c.calls = append(c.calls, append([]any(nil), items...))
+	c.metas = append(c.metas, meta)
+	if c.rejected == nil {
+		c.rejected = map[any]int{}
+	}
+	var errs []error
+	for i, item := range items {
+		if c.reject[item] && (c.fails == 0 || c.rejected[item] < c.fails) {
+			if errs == nil {
+				errs = make([]error, len(items))
+			}
+			errs[i] = errBadRow
+			c.rejected[item]++
+			continue
+		}
+		c.accepted = append(c.accepted, item)
+	}
+	return errs, nil
+}
+
+func TestResultConsumerRetriesFailedItems(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}, {4, 5, 6}}}
+	c := &rejectingConsumer{reject: map[any]bool{2: true, 5: true}, fails: 1}
+
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithProcessRetry(RetryPolicy{Attempts: 3}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	// Повтор - только отбитые элементы, принятые второй раз не пишутся
+	if want := [][]any{{1, 2, 3, 4, 5, 6}, {2, 5}}; !reflect.DeepEqual(c.calls, want) {
+		t.Fatalf("calls = %v, want %v", c.calls, want)
+	}
+	if want := []any{1, 3, 4, 6, 2, 5}; !reflect.DeepEqual(c.accepted, want) {
+		t.Errorf("accepted = %v, want %v", c.accepted, want)
+	}
+	// BatchContext повтора описывает то, что в нём пришло
+	if got := c.metas[1]; got.Items != 2 || len(got.Spans) != 2 || got.Spans[1] != (CookieSpan{Cookie: 2, Offset: 1, Items: 1}) {
+		t.Errorf("retry meta = %+v", got)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", p.committed)
+	}
+}
+
+func TestResultConsumerDeadLettersFailedItems(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}, {4, 5, 6}, {7}}}
+	c := &rejectingConsumer{reject: map[any]bool{5: true}}
+	dl := &memoryDeadLetter{}
+	reports := make(chan DeliveryReport, 10)
+
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithDeadLetter(dl), WithDeliveryReports(reports))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	close(reports)
+
+	if want := [][]any{{5}}; !reflect.DeepEqual(dl.batches, want) {
+		t.Fatalf("dead letters = %v, want %v", dl.batches, want)
+	}
+	var partial *PartialError
+	if !errors.As(dl.errs[0], &partial) || !reflect.DeepEqual(partial.Items, []ItemError{{Index: 4, Err: errBadRow}}) || partial.Total != 7 {
+		t.Fatalf("dead letter error = %v, want a *PartialError for item 4", dl.errs[0])
+	}
+	if cookie, ok := dl.metas[0].CookieOf(0); !ok || cookie != 2 || dl.metas[0].Items != 1 {
+		t.Errorf("dead letter meta = %+v, want one item of cookie 2", dl.metas[0])
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Errorf("commits = %v, want [1 2 3]", p.committed)
+	}
+
+	statuses := map[int]DeliveryStatus{}
+	for r := range reports {
+		statuses[r.Cookie] = r.Status
+	}
+	want := map[int]DeliveryStatus{1: DeliveryCommitted, 2: DeliveryDeadLettered, 3: DeliveryCommitted}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+}
+
+func TestResultConsumerStopsWithoutDeadLetter(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}}}
+	c := &rejectingConsumer{reject: map[any]bool{3: true}}
+
+	err := Pipe(finiteProducer{p}, c, WithInlineMode())
+	var partial *PartialError
+	if !errors.As(err, &partial) || !errors.Is(err, errBadRow) || partial.Items[0].Index != 2 {
+		t.Fatalf("Pipe() error = %v, want a *PartialError for item 2", err)
+	}
+	if len(p.committed) != 0 {
+		t.Errorf("commits = %v, want none", p.committed)
+	}
+}
+
+// shortResultsConsumer возвращает меньше результатов, чем элементов
+type shortResultsConsumer struct{ testConsumer }
+
+func (c *shortResultsConsumer) ProcessWithResults(ctx context.Context, items []any) ([]error, error) {
+	return make([]error, len(items)-1), nil
+}
+
+func TestResultConsumerWrongResultCount(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}}}
+	err := Pipe(finiteProducer{p}, &shortResultsConsumer{}, WithInlineMode())
+	if err == nil {
+		t.Fatalf("Pipe() error = %v, want a result count error", err)
+	}
+	if len(p.committed) != 0 {
+		t.Errorf("commits = %v, want none", p.committed)
+	}
+}
@@ -0,0 +1,179 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy - как повторять упавший вызов адаптера
+type RetryPolicy struct {
+	// Сколько всего попыток, включая первую. 0 и 1 - без повторов
+	Attempts int
+	// Пауза перед второй попыткой, дальше растёт в Multiplier раз (0 - в 2 раза), но не выше MaxBackoff (0 - без потолка)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	// Случайный разброс паузы в долях от неё (0.2 - ±20%), чтобы много Pipe не повторяли хором
+	Jitter float64
+	// Стоит ли повторять эту ошибку, nil - повторяем любую
+	Retryable func(err error) bool
+}
+
+// WithProcessRetry повторяет упавший Process по policy, прежде чем считать ошибку фатальной.
+// Каждая попытка получает свой контекст с номером в AttemptFromContext и свежую копию батча (WithDefensiveCopies).
+// Отчёт о неудачной доставке уходит только после последней попытки.
+func WithProcessRetry(policy RetryPolicy) Option {
+	return func(cfg *config) {
+		cfg.processRetry = &policy
+	}
+}
+
+// ErrRetryBudget - следующий повтор не успел бы до дедлайна запуска или батча (WithBatchDeadline)
+var ErrRetryBudget = errors.New("retry budget exhausted")
+
+// WithBatchDeadline даёт каждому батчу d на все попытки Process и Commit, считая от момента, когда он собран.
+// Повтор, пауза перед которым кончится позже дедлайна, не начинается: батч сразу идёт дальше как упавший
+// (DLQ, CommitSkip или остановка) с ErrRetryBudget. Так повторы не выходят за лимит вроде max.poll.interval.ms
+// Kafka. Идущую попытку дедлайн не прерывает - для этого у Process свой таймаут. Дедлайн контекста из
+// WithBaseContext ограничивает повторы так же, и без этой опции.
+func WithBatchDeadline(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.batchDeadline = d
+	}
+}
+
+type retryDeadlineKey struct{}
+
+// retryDeadline - до какого момента ещё можно повторять: ближайший из дедлайнов ctx и батча
+func retryDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if batch, set := ctx.Value(retryDeadlineKey{}).(time.Time); set && (!ok || batch.Before(deadline)) {
+		deadline, ok = batch, true
+	}
+	return deadline, ok
+}
+
+// validate проверяет политику, option - имя опции для ConfigError
+func (rp *RetryPolicy) validate(option string, add func(option, problem, suggestion string)) {
+	if rp.Attempts < 0 {
+		add(option, fmt.Sprintf("attempts %d is negative", rp.Attempts), "use 1 to disable retries")
+	}
+	if rp.Backoff < 0 || rp.MaxBackoff < 0 {
+		add(option, "backoff is negative", "use e.g. Backoff: 100ms, MaxBackoff: 10s")
+	}
+	if rp.Multiplier != 0 && rp.Multiplier < 1 {
+		add(option, fmt.Sprintf("multiplier %g is below 1", rp.Multiplier), "use 0 for the default 2")
+	}
+	if rp.Jitter < 0 || rp.Jitter > 1 {
+		add(option, fmt.Sprintf("jitter %g is outside [0, 1]", rp.Jitter), "use e.g. 0.2")
+	}
+}
+
+// delay - пауза перед попыткой attempt (со 2-й)
+func (rp *RetryPolicy) delay(attempt int) time.Duration {
+	mult := rp.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+	d := float64(rp.Backoff)
+	for i := 2; i < attempt; i++ {
+		d *= mult
+		if rp.MaxBackoff > 0 && d >= float64(rp.MaxBackoff) {
+			break
+		}
+	}
+	if rp.MaxBackoff > 0 && d > float64(rp.MaxBackoff) {
+		d = float64(rp.MaxBackoff)
+	}
+	if rp.Jitter > 0 {
+		d += d * rp.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// do вызывает fn с номером попытки, пока она не пройдёт, не кончатся попытки или ошибка не окажется неповторяемой.
+// nil-политика - одна попытка. Отмена ctx во время паузы прерывает повторы с последней ошибкой fn,
+// а повтор, который начался бы позже дедлайна (retryDeadline), не начинается.
+func (rp *RetryPolicy) do(ctx context.Context, fn func(attempt int) error) error {
+	attempts := 1
+	if rp != nil && rp.Attempts > 1 {
+		attempts = rp.Attempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			pause := rp.delay(attempt)
+			if deadline, ok := retryDeadline(ctx); ok && !time.Now().Add(pause).Before(deadline) {
+				return fmt.Errorf("%w: %d of %d attempts failed before the deadline: %w", ErrRetryBudget, attempt-1, attempts, err)
+			}
+			timer := time.NewTimer(pause)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		if rp != nil && rp.Retryable != nil && !rp.Retryable(err) {
+			return err
+		}
+	}
+	if attempts > 1 {
+		return fmt.Errorf("%d attempts failed: %w", attempts, err)
+	}
+	return err
+}
+
+// CommitFailure - что делать, когда повторы Commit кончились
+type CommitFailure int
+
+const (
+	// Останавливаем Pipe с ошибкой Commit, незакоммиченное придёт повторно после рестарта
+	CommitAbort CommitFailure = iota
+	// Сообщаем в OnSkip и работаем дальше: данные уже в приёмнике, а следующий удачный Commit
+	// подтвердит и этот прогресс. Годится только для источников с накопительным cookie (оффсеты Kafka)
+	CommitSkip
+)
+
+// CommitRetry - повторы Commit и решение на случай, когда они не помогли
+type CommitRetry struct {
+	RetryPolicy
+	OnExhausted CommitFailure
+	// Кому сообщаем о пропущенном cookie при CommitSkip, nil - никому
+	OnSkip func(cookie int, err error)
+}
+
+// WithCommitRetry повторяет упавший Commit по cr.RetryPolicy. Commit идёт после того, как данные уже
+// записаны, поэтому остановка на мимолётной ошибке брокера - это гарантированная повторная обработка.
+// Когда повторы кончились, решает cr.OnExhausted.
+func WithCommitRetry(cr CommitRetry) Option {
+	return func(cfg *config) {
+		cfg.commitRetry = &cr
+	}
+}
+
+// do вызывает fn по политике повторов, nil - одна попытка
+func (cr *CommitRetry) do(ctx context.Context, fn func(attempt int) error) error {
+	if cr == nil {
+		return fn(1)
+	}
+	return cr.RetryPolicy.do(ctx, fn)
+}
+
+// skip - можно ли пропустить cookie, на котором Commit так и не прошёл
+func (cr *CommitRetry) skip(cookie int, err error) bool {
+	if cr == nil || cr.OnExhausted != CommitSkip {
+		return false
+	}
+	if cr.OnSkip != nil {
+		cr.OnSkip(cookie, err)
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyConsumer падает на первых fails попытках каждого батча и запоминает номера попыток
+type flakyConsumer struct {
+	testConsumer
+	fails int
+	err   error
+
+	mu       sync.Mutex
+	attempts []int
+}
+
+func (c *flakyConsumer) Process(ctx context.Context, items []any) error {
+	attempt, _ := AttemptFromContext(ctx)
+	c.mu.Lock()
+	c.attempts = append(c.attempts, attempt)
+	c.mu.Unlock()
+	if attempt <= c.fails {
+		return c.err
+	}
+	return c.testConsumer.Process(ctx, items)
+}
+
+var errTransient = errors.New("connection reset")
+
+func TestWithProcessRetry(t *testing.T) {
+	p := &testProducer{chunks: 2, chunkSize: 10}
+	c := &flakyConsumer{fails: 2, err: errTransient}
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithProcessRetry(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(c.attempts, want) {
+		t.Errorf("attempts = %v, want %v", c.attempts, want)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", got)
+	}
+}
+
+func TestWithProcessRetryExhausted(t *testing.T) {
+	p := &testProducer{chunks: 2, chunkSize: 10}
+	c := &flakyConsumer{fails: 10, err: errTransient}
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithProcessRetry(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}))
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errTransient)
+	}
+	if len(c.attempts) != 3 || len(p.commits()) != 0 {
+		t.Errorf("attempts = %v, commits = %v", c.attempts, p.commits())
+	}
+}
+
+func TestWithProcessRetryNotRetryable(t *testing.T) {
+	bad := errors.New("column type mismatch")
+	p := &testProducer{chunks: 2, chunkSize: 10}
+	c := &flakyConsumer{fails: 10, err: bad}
+	policy := RetryPolicy{Attempts: 5, Backoff: time.Millisecond, Retryable: func(err error) bool {
+		return errors.Is(err, errTransient)
+	}}
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithProcessRetry(policy)); !errors.Is(err, bad) {
+		t.Fatalf("Pipe() error = %v, want %v", err, bad)
+	}
+	if len(c.attempts) != 1 {
+		t.Errorf("attempts = %v, want a single one", c.attempts)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	rp := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond}
+	var got []time.Duration
+	for attempt := 2; attempt <= 5; attempt++ {
+		got = append(got, rp.delay(attempt))
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delays = %v, want %v", got, want)
+	}
+
+	rp.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := rp.delay(2); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("delay with jitter = %s, want 5ms..15ms", d)
+		}
+	}
+}
+
+func TestWithProcessRetryValidation(t *testing.T) {
+	_, err := newConfig([]Option{WithProcessRetry(RetryPolicy{Attempts: -1, Backoff: -time.Second, Multiplier: 0.5, Jitter: 2})})
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 4 {
+		t.Fatalf("newConfig() error = %v, want a *ConfigError with 4 problems", err)
+	}
+}
+
+// flakyCommitProducer не может закоммитить cookie из failing первые fails раз
+type flakyCommitProducer struct {
+	testProducer
+	failing map[int]bool
+	fails   int
+
+	calls map[int]int
+}
+
+func (p *flakyCommitProducer) Commit(ctx context.Context, cookie int) error {
+	p.mu.Lock()
+	if p.calls == nil {
+		p.calls = make(map[int]int)
+	}
+	p.calls[cookie]++
+	n := p.calls[cookie]
+	p.mu.Unlock()
+	if p.failing[cookie] && n <= p.fails {
+		return errTransient
+	}
+	return p.testProducer.Commit(ctx, cookie)
+}
+
+func TestWithCommitRetry(t *testing.T) {
+	p := &flakyCommitProducer{testProducer: testProducer{chunks: 3, chunkSize: 10}, failing: map[int]bool{2: true}, fails: 2}
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(),
+		WithCommitRetry(CommitRetry{RetryPolicy: RetryPolicy{Attempts: 3, Backoff: time.Millisecond}}))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("commits = %v, want [1 2 3]", got)
+	}
+	if p.calls[2] != 3 {
+		t.Errorf("Commit(2) calls = %d, want 3", p.calls[2])
+	}
+}
+
+func TestWithCommitRetryAbort(t *testing.T) {
+	p := &flakyCommitProducer{testProducer: testProducer{chunks: 3, chunkSize: 10}, failing: map[int]bool{2: true}, fails: 10}
+	err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(),
+		WithCommitRetry(CommitRetry{RetryPolicy: RetryPolicy{Attempts: 2, Backoff: time.Millisecond}}))
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errTransient)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("commits = %v, want [1]", got)
+	}
+}
+
+func TestWithCommitRetrySkip(t *testing.T) {
+	p := &flakyCommitProducer{testProducer: testProducer{chunks: 3, chunkSize: 10}, failing: map[int]bool{2: true}, fails: 10}
+	var skipped []int
+	cr := CommitRetry{
+		RetryPolicy: RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+		OnExhausted: CommitSkip,
+		OnSkip:      func(cookie int, err error) { skipped = append(skipped, cookie) },
+	}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithCommitRetry(cr)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got := p.commits(); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("commits = %v, want [1 3]", got)
+	}
+	if !reflect.DeepEqual(skipped, []int{2}) {
+		t.Errorf("skipped = %v, want [2]", skipped)
+	}
+}
+
+func TestWithBatchDeadline(t *testing.T) {
+	// Пауза 20ms, на батч 50ms: третий повтор начался бы позже дедлайна
+	p := &testProducer{chunks: 1, chunkSize: 10}
+	c := &flakyConsumer{fails: 10, err: errTransient}
+	policy := RetryPolicy{Attempts: 10, Backoff: 20 * time.Millisecond, Multiplier: 1}
+
+	start := time.Now()
+	err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithProcessRetry(policy), WithBatchDeadline(50*time.Millisecond))
+	if !errors.Is(err, ErrRetryBudget) || !errors.Is(err, errTransient) {
+		t.Fatalf("Pipe() error = %v, want %v and %v", err, ErrRetryBudget, errTransient)
+	}
+	if len(c.attempts) < 2 || len(c.attempts) > 3 {
+		t.Errorf("attempts = %v, want the ones that fit into the deadline", c.attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("retries took %s, past the deadline", elapsed)
+	}
+}
+
+func TestRetryPolicyContextDeadline(t *testing.T) {
+	// Пауза длиннее, чем осталось у контекста: второй попытки нет и ждать её не надо
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rp := &RetryPolicy{Attempts: 3, Backoff: time.Second}
+	calls := 0
+	start := time.Now()
+	err := rp.do(ctx, func(int) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, ErrRetryBudget) || !errors.Is(err, errTransient) || calls != 1 {
+		t.Fatalf("do() error = %v after %d calls, want %v after 1", err, calls, ErrRetryBudget)
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("do() waited %s instead of giving up", elapsed)
+	}
+}
+
+func TestWithBatchDeadlineValidation(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithBatchDeadline(-time.Second), WithProcessRetry(RetryPolicy{Attempts: 2})},
+		{WithBatchDeadline(time.Second)},
+	} {
+		var cfgErr *ConfigError
+		if err := Pipe(&testProducer{}, &testConsumer{}, opts...); !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+			t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+		}
+	}
+}
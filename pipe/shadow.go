@@ -0,0 +1,97 @@
+package pipe
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Теневой консюмер для проверки миграции приёмника: каждый батч пишется и в основной приёмник,
+и в теневой, после чего пользовательский Compare сверяет результат (число строк, контрольные суммы).
+Коммиты зависят только от основного приёмника - ошибки тени и расхождения лишь репортятся.
+*/
+
+// Divergence - батч, на котором теневой приёмник разошёлся с основным
+type Divergence struct {
+	// Номер батча в рамках запуска Pipe (0, если консюмер вызван не из Pipe)
+	Batch uint64
+	Items int
+	// Тень не смогла обработать батч - тогда Compare не вызывается
+	ShadowErr error
+	// Что нашёл Compare
+	CompareErr error
+	Time       time.Time
+}
+
+// ShadowCompare сверяет основной и теневой приёмники после того, как оба обработали items.
+// Ошибка - расхождение.
+type ShadowCompare func(ctx context.Context, items []any) error
+
+// ShadowConsumer отдаёт каждый батч primary и shadow параллельно (тени - своя копия слайса),
+// потом сверяет их через compare (nil - сверяется только успех обработки). Расхождения уходят
+// в onDivergence синхронно, до коммита батча. Ошибка primary возвращается как есть, ошибка тени - нет.
+// BatchSizer и SinkDescriber берутся у primary, OnStart/OnStop (и io.Closer) вызываются у обоих.
+func ShadowConsumer(primary, shadow Consumer, compare ShadowCompare, onDivergence func(Divergence)) Consumer {
+	return &shadowConsumer{primary: primary, shadow: shadow, compare: compare, onDivergence: onDivergence}
+}
+
+type shadowConsumer struct {
+	primary      Consumer
+	shadow       Consumer
+	compare      ShadowCompare
+	onDivergence func(Divergence)
+}
+
+func (c *shadowConsumer) Process(ctx context.Context, items []any) error {
+	shadowItems := append([]any(nil), items...)
+	shadowErr := make(chan error, 1)
+	go func() {
+		shadowErr <- c.shadow.Process(ctx, shadowItems)
+	}()
+
+	err := c.primary.Process(ctx, items)
+	sErr := <-shadowErr
+	if err != nil {
+		return err
+	}
+
+	d := Divergence{Items: len(items), ShadowErr: sErr}
+	if meta, ok := BatchContext(ctx); ok {
+		d.Batch = meta.Seq
+	}
+	if sErr == nil && c.compare != nil {
+		d.CompareErr = c.compare(ctx, items)
+	}
+	if (d.ShadowErr != nil || d.CompareErr != nil) && c.onDivergence != nil {
+		d.Time = time.Now()
+		c.onDivergence(d)
+	}
+	return nil
+}
+
+func (c *shadowConsumer) PreferredBatchSize(ctx context.Context) int {
+	if bs, ok := c.primary.(BatchSizer); ok {
+		return bs.PreferredBatchSize(ctx)
+	}
+	return 0
+}
+
+func (c *shadowConsumer) DescribeSink() string {
+	return describeSink(c.primary)
+}
+
+func (c *shadowConsumer) adapters() []adapter {
+	return []adapter{{"primary", c.primary}, {"shadow", c.shadow}}
+}
+
+func (c *shadowConsumer) OnStart(ctx context.Context) error {
+	started, err := startAdapters(ctx, c.adapters())
+	if err != nil {
+		return withStopError(err, stopAdapters(ctx, started))
+	}
+	return nil
+}
+
+func (c *shadowConsumer) OnStop(ctx context.Context) error {
+	return stopAdapters(ctx, c.adapters())
+}
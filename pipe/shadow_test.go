@@ -0,0 +1,95 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// countingSink считает записанные элементы, может падать на батче с номером failOn
+type countingSink struct {
+	mu      sync.Mutex
+	items   int
+	batches int
+	failOn  int
+	closed  int
+	hint    int
+}
+
+func (s *countingSink) PreferredBatchSize(ctx context.Context) int {
+	return s.hint
+}
+
+func (s *countingSink) Process(ctx context.Context, items []any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	if s.batches == s.failOn {
+		return errors.New("sink rejected batch")
+	}
+	s.items += len(items)
+	return nil
+}
+
+func (s *countingSink) Close() error {
+	s.closed++
+	return nil
+}
+
+func (s *countingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items
+}
+
+func sameCount(primary, shadow *countingSink) ShadowCompare {
+	return func(ctx context.Context, items []any) error {
+		if primary.count() != shadow.count() {
+			return errors.New("row counts differ")
+		}
+		return nil
+	}
+}
+
+func TestShadowConsumerReportsDivergence(t *testing.T) {
+	primary, shadow := &countingSink{hint: 1000}, &countingSink{failOn: 2}
+	var divergences []Divergence
+	c := ShadowConsumer(primary, shadow, sameCount(primary, shadow), func(d Divergence) { divergences = append(divergences, d) })
+	p := &testProducer{chunks: 4, chunkSize: 1000}
+
+	err := Pipe(p, c, WithInlineMode())
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+
+	// Размер батча берётся у primary - батчи 1-4 по 1000: на втором тень падает, дальше уже расходится число строк
+	if got := p.commits(); len(got) != 4 {
+		t.Fatalf("commits = %v, shadow failures must not block them", got)
+	}
+	if len(divergences) != 3 {
+		t.Fatalf("divergences = %+v, want 3", divergences)
+	}
+	if d := divergences[0]; d.Batch != 2 || d.ShadowErr == nil || d.CompareErr != nil {
+		t.Fatalf("first divergence = %+v, want shadow error on batch 2", d)
+	}
+	if d := divergences[1]; d.Batch != 3 || d.CompareErr == nil {
+		t.Fatalf("second divergence = %+v, want compare error on batch 3", d)
+	}
+	if primary.closed != 1 || shadow.closed != 1 {
+		t.Fatalf("closed primary %d, shadow %d times, want 1 each", primary.closed, shadow.closed)
+	}
+}
+
+func TestShadowConsumerPrimaryErrorFailsBatch(t *testing.T) {
+	primary, shadow := &countingSink{failOn: 1}, &countingSink{}
+	called := false
+	c := ShadowConsumer(primary, shadow, nil, func(Divergence) { called = true })
+
+	if err := c.Process(context.Background(), []any{1}); err == nil {
+		t.Fatal("primary error was swallowed")
+	}
+	if called {
+		t.Fatal("divergence reported for a batch that failed in primary")
+	}
+}
@@ -0,0 +1,261 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Порядок остановки. Когда источник закончился (или Pipeline.Stop), Pipe дорабатывает прочитанное строго по шагам:
+Next больше не вызывается → буфер уходит последним батчем → очередь батчей обрабатывается →
+оставшиеся cookie коммитятся → адаптеры останавливаются. У каждого шага свой таймаут, а сколько
+он занял - видно в отчёте о запуске.
+*/
+
+// ErrShutdownTimeout - шаг остановки не уложился в свой таймаут из WithShutdownTimeouts
+var ErrShutdownTimeout = errors.New("shutdown step timed out")
+
+// ShutdownStage - шаг остановки
+type ShutdownStage string
+
+const (
+	// Чтение остановлено. С Pipeline.Stop - от запроса остановки до возврата Next, если остановку начал
+	// источник - Next к этому моменту уже вернулся
+	ShutdownNext ShutdownStage = "next"
+	// Накопленный буфер уходит последним батчем (преобразования к нему уже применены при чтении)
+	ShutdownFlush ShutdownStage = "flush"
+	// Process для батчей, которые уже в очереди. В inline-режиме последний батч обрабатывается прямо во flush
+	ShutdownProcess ShutdownStage = "process"
+	// Коммит cookie, оставшихся после последнего Process
+	ShutdownCommit ShutdownStage = "commit"
+	// OnStop/Close источника и консюмера. Этот шаг есть у любого запуска, даже упавшего
+	ShutdownAdapters ShutdownStage = "adapters"
+)
+
+// ShutdownTimeouts - сколько даём каждому шагу остановки, 0 - без ограничения.
+// Вышло время на flush, process или commit - запуск отменяется, как с WithDrainTimeout
+// (незакоммиченное придёт из источника повторно). WithDrainTimeout ограничивает эти три шага вместе,
+// а при остановке снаружи - и next.
+type ShutdownTimeouts struct {
+	Flush   time.Duration
+	Process time.Duration
+	Commit  time.Duration
+	// OnStop получает контекст с этим дедлайном. Адаптер, который его не слушает, Pipe дальше не ждёт
+	Adapters time.Duration
+}
+
+// WithShutdownTimeouts задаёт таймауты шагов остановки. Шаг, не уложившийся в свой, отмечается в RunReport,
+// а Pipe возвращает ErrShutdownTimeout вместе с остальными ошибками.
+func WithShutdownTimeouts(t ShutdownTimeouts) Option {
+	return func(cfg *config) {
+		cfg.shutdownTimeouts = t
+	}
+}
+
+// ShutdownStep - как прошёл шаг остановки
+type ShutdownStep struct {
+	Stage    ShutdownStage
+	Took     time.Duration
+	TimedOut bool
+}
+
+// RunReport - итог запуска Pipe
+type RunReport struct {
+	Started time.Time
+	Took    time.Duration
+	// То же, что вернул Pipe
+	Err error
+	// Шаги остановки по порядку. Дописывание (next, flush, process, commit) бывает, только если остановку начал
+	// источник или Pipeline.Stop. После ошибки обработки или коммита дописывать нечего и шаг один - adapters
+	Shutdown []ShutdownStep
+	// Время в вызовах адаптеров каждой стадии, с WithWorkers - сумма по обработчикам. nil без WithProfiling
+	StageTime map[Stage]time.Duration
+}
+
+// WithRunReport вызывает fn с отчётом, когда запуск полностью завершён, перед возвратом из Pipe
+func WithRunReport(fn func(RunReport)) Option {
+	return func(cfg *config) {
+		cfg.runReports = append(cfg.runReports, fn)
+	}
+}
+
+// shutdown ведёт шаги остановки: засекает время, взводит таймаут текущего шага и переключает
+// process → commit, когда обработан последний батч очереди
+type shutdown struct {
+	timeouts ShutdownTimeouts
+	// Отмена запуска по таймауту шага
+	cancel func()
+
+	mu    sync.Mutex
+	steps []ShutdownStep
+	// Когда начался текущий шаг и не закончился ли он уже
+	started time.Time
+	open    bool
+	timer   *time.Timer
+	// Первый таймаут шага до adapters (его stopAdapters возвращает сам)
+	err error
+	// Сколько батчей обработано, сколько их всего после flush и закончилось ли чтение
+	processed uint64
+	total     uint64
+	draining  bool
+}
+
+func newShutdown(timeouts ShutdownTimeouts, cancel func()) *shutdown {
+	return &shutdown{timeouts: timeouts, cancel: cancel}
+}
+
+// timeout - таймаут шага, 0 - без ограничения
+func (t ShutdownTimeouts) timeout(stage ShutdownStage) time.Duration {
+	switch stage {
+	case ShutdownFlush:
+		return t.Flush
+	case ShutdownProcess:
+		return t.Process
+	case ShutdownCommit:
+		return t.Commit
+	case ShutdownAdapters:
+		return t.Adapters
+	}
+	return 0
+}
+
+// next начинает остановку с шага next, если она ещё не началась: его открывает или Pipeline.Stop,
+// или конец источника - смотря что раньше
+func (s *shutdown) next() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.steps) == 0 {
+		s.enterLocked(ShutdownNext)
+	}
+}
+
+// enter закрывает текущий шаг и начинает следующий
+func (s *shutdown) enter(stage ShutdownStage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enterLocked(stage)
+}
+
+func (s *shutdown) enterLocked(stage ShutdownStage) {
+	s.closeLocked()
+	s.steps = append(s.steps, ShutdownStep{Stage: stage})
+	s.started, s.open = time.Now(), true
+	// Таймаут adapters ждёт сам stopAdapters - отменять там уже нечего
+	if d := s.timeouts.timeout(stage); d > 0 && stage != ShutdownAdapters {
+		step := len(s.steps) - 1
+		s.timer = time.AfterFunc(d, func() { s.expire(step, d) })
+	}
+}
+
+// closeLocked заканчивает текущий шаг, если он есть
+func (s *shutdown) closeLocked() {
+	if !s.open {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.steps[len(s.steps)-1].Took = time.Since(s.started)
+	s.open = false
+}
+
+// expire - вышло время шага step: отмечаем его и отменяем запуск
+func (s *shutdown) expire(step int, d time.Duration) {
+	s.mu.Lock()
+	if !s.open || step != len(s.steps)-1 {
+		s.mu.Unlock()
+		return
+	}
+	if err := s.timedOutLocked(d); s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// timedOutLocked отмечает текущий шаг как не уложившийся в d
+func (s *shutdown) timedOutLocked(d time.Duration) error {
+	step := &s.steps[len(s.steps)-1]
+	step.TimedOut = true
+	return fmt.Errorf("%w: %s after %s", ErrShutdownTimeout, step.Stage, d)
+}
+
+// queued - буфер отправлен, в очереди до total-го батча включительно: ждём их Process
+func (s *shutdown) queued(total uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total, s.draining = total, true
+	s.enterLocked(ShutdownProcess)
+	if s.processed >= s.total {
+		s.enterLocked(ShutdownCommit)
+	}
+}
+
+// processedBatch - Process батча закончен. На последнем батче очереди остановка переходит к коммиту
+func (s *shutdown) processedBatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed++
+	if s.draining && s.processed >= s.total && s.open && s.steps[len(s.steps)-1].Stage == ShutdownProcess {
+		s.enterLocked(ShutdownCommit)
+	}
+}
+
+// stopAdapters - последний шаг: останавливаем адаптеры, с таймаутом - не дольше него
+func (s *shutdown) stopAdapters(ctx context.Context, adapters []adapter) error {
+	s.enter(ShutdownAdapters)
+	d := s.timeouts.Adapters
+	if d <= 0 {
+		err := stopAdapters(ctx, adapters)
+		s.finish()
+		return err
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- stopAdaptersIn(stopCtx, adapters) }()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		s.finish()
+		return err
+	case <-timer.C:
+		s.mu.Lock()
+		err := s.timedOutLocked(d)
+		s.closeLocked()
+		s.mu.Unlock()
+		return err
+	}
+}
+
+// finish закрывает последний шаг
+func (s *shutdown) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+// timeoutErr - первый таймаут шага до adapters, nil - все уложились
+func (s *shutdown) timeoutErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// report собирает отчёт о запуске
+func (s *shutdown) report(started time.Time, err error) RunReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RunReport{
+		Started:  started,
+		Took:     time.Since(started),
+		Err:      err,
+		Shutdown: append([]ShutdownStep(nil), s.steps...),
+	}
+}
@@ -0,0 +1,130 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stageNames - шаги остановки из отчёта по порядку
+func stageNames(steps []ShutdownStep) []ShutdownStage {
+	var stages []ShutdownStage
+	for _, s := range steps {
+		stages = append(stages, s.Stage)
+	}
+	return stages
+}
+
+func TestRunReportShutdownOrder(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		p := &testProducer{chunks: 7, chunkSize: 3000}
+		var reports []RunReport
+		err := Pipe(finiteProducer{p}, &testConsumer{}, WithConfig(Config{Inline: inline}), WithRunReport(func(r RunReport) {
+			reports = append(reports, r)
+		}))
+		if err != nil {
+			t.Fatalf("inline=%v: Pipe() error = %v", inline, err)
+		}
+		if len(reports) != 1 {
+			t.Fatalf("inline=%v: got %d reports, want 1", inline, len(reports))
+		}
+		want := []ShutdownStage{ShutdownNext, ShutdownFlush, ShutdownProcess, ShutdownCommit, ShutdownAdapters}
+		if got := stageNames(reports[0].Shutdown); !reflect.DeepEqual(got, want) {
+			t.Errorf("inline=%v: shutdown steps = %v, want %v", inline, got, want)
+		}
+		for _, s := range reports[0].Shutdown {
+			if s.TimedOut {
+				t.Errorf("inline=%v: step %s timed out", inline, s.Stage)
+			}
+		}
+		if len(p.commits()) != 7 {
+			t.Errorf("inline=%v: commits = %v, want 1..7", inline, p.commits())
+		}
+	}
+}
+
+func TestRunReportAfterProcessError(t *testing.T) {
+	// Первый батч падает ещё до конца источника: дописывать нечего, остаётся только остановка адаптеров
+	p := &testProducer{chunks: 3, chunkSize: 6000}
+	c := &flakyConsumer{fails: 1, err: errTransient}
+	var report RunReport
+	err := Pipe(p, c, WithInlineMode(), WithRunReport(func(r RunReport) { report = r }))
+	if !errors.Is(err, errTransient) || !errors.Is(report.Err, errTransient) {
+		t.Fatalf("Pipe() error = %v, report error = %v, want %v", err, report.Err, errTransient)
+	}
+	if got := stageNames(report.Shutdown); !reflect.DeepEqual(got, []ShutdownStage{ShutdownAdapters}) {
+		t.Errorf("shutdown steps = %v, want [adapters]", got)
+	}
+}
+
+func TestShutdownProcessTimeout(t *testing.T) {
+	p := &testProducer{chunks: 1, chunkSize: 10}
+	var report RunReport
+	done := make(chan error, 1)
+	go func() {
+		done <- Pipe(p, stuckConsumer{}, WithShutdownTimeouts(ShutdownTimeouts{Process: 20 * time.Millisecond}),
+			WithRunReport(func(r RunReport) { report = r }))
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errSourceDone) || !errors.Is(err, ErrShutdownTimeout) {
+			t.Fatalf("Pipe() error = %v, want %v and %v", err, errSourceDone, ErrShutdownTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("process step was not cut by its timeout")
+	}
+	var timedOut []ShutdownStage
+	for _, s := range report.Shutdown {
+		if s.TimedOut {
+			timedOut = append(timedOut, s.Stage)
+		}
+	}
+	if !reflect.DeepEqual(timedOut, []ShutdownStage{ShutdownProcess}) {
+		t.Errorf("timed out steps = %v, want [process] (report %+v)", timedOut, report.Shutdown)
+	}
+	if got := p.commits(); len(got) != 0 {
+		t.Errorf("commits = %v after a cancelled drain", got)
+	}
+}
+
+// hangingStopConsumer не слушает контекст в OnStop
+type hangingStopConsumer struct {
+	testConsumer
+	release chan struct{}
+}
+
+func (c *hangingStopConsumer) OnStop(ctx context.Context) error {
+	<-c.release
+	return nil
+}
+
+func TestShutdownAdaptersTimeout(t *testing.T) {
+	c := &hangingStopConsumer{release: make(chan struct{})}
+	defer close(c.release)
+	var report RunReport
+
+	start := time.Now()
+	err := Pipe(finiteProducer{&testProducer{chunks: 1, chunkSize: 10}}, c,
+		WithShutdownTimeouts(ShutdownTimeouts{Adapters: 20 * time.Millisecond}), WithRunReport(func(r RunReport) { report = r }))
+	if !errors.Is(err, ErrShutdownTimeout) {
+		t.Fatalf("Pipe() error = %v, want %v", err, ErrShutdownTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Pipe() waited %s for a stuck OnStop", elapsed)
+	}
+	last := report.Shutdown[len(report.Shutdown)-1]
+	if last.Stage != ShutdownAdapters || !last.TimedOut {
+		t.Errorf("last step = %+v, want a timed out adapters step", last)
+	}
+}
+
+func TestWithShutdownTimeoutsValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithShutdownTimeouts(ShutdownTimeouts{Commit: -time.Second}))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+	}
+}
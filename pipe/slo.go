@@ -0,0 +1,97 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencySLO - цель по времени обработки батча: доля Target батчей должна укладываться в Threshold
+// на скользящем окне Window. Например, 99% батчей быстрее 2s за последние 10 минут.
+type LatencySLO struct {
+	Threshold time.Duration
+	Target    float64
+	Window    time.Duration
+	// Пока в окне меньше батчей, SLO не оцениваем - на паре батчей доля ничего не значит
+	MinBatches int
+	// Вызывается, когда SLO нарушено (бюджет ошибок съеден) и когда оно снова выполняется
+	Alert func(SLOReport)
+}
+
+// SLOReport - состояние SLO на окне
+type SLOReport struct {
+	Total int
+	// Сколько батчей уложились в Threshold
+	Good int
+	// Доля хороших батчей
+	Compliance float64
+	// Во сколько раз быстрее допустимого тратится бюджет ошибок (1 - ровно по бюджету)
+	BurnRate float64
+	// true - SLO нарушено
+	Breached bool
+}
+
+// WithLatencySLO меряет время Process каждого батча и сообщает в slo.Alert о нарушении и восстановлении SLO
+func WithLatencySLO(slo LatencySLO) Option {
+	return func(cfg *config) {
+		cfg.slo = &sloTracker{slo: slo}
+	}
+}
+
+// sloTracker - скользящее окно замеров
+type sloTracker struct {
+	slo LatencySLO
+
+	mu       sync.Mutex
+	samples  []sloSample
+	breached bool
+}
+
+type sloSample struct {
+	at   time.Time
+	good bool
+}
+
+// observe добавляет замер и, если состояние SLO поменялось, вызывает Alert
+func (t *sloTracker) observe(now time.Time, took time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.samples = append(t.samples, sloSample{at: now, good: took <= t.slo.Threshold})
+	// Выкидываем замеры, выпавшие из окна
+	cut := 0
+	for cut < len(t.samples) && now.Sub(t.samples[cut].at) > t.slo.Window {
+		cut++
+	}
+	t.samples = t.samples[cut:]
+
+	r := t.report()
+	changed := len(t.samples) >= t.slo.MinBatches && r.Breached != t.breached
+	if changed {
+		t.breached = r.Breached
+	}
+	t.mu.Unlock()
+
+	if changed && t.slo.Alert != nil {
+		t.slo.Alert(r)
+	}
+}
+
+func (t *sloTracker) report() SLOReport {
+	r := SLOReport{Total: len(t.samples)}
+	for _, s := range t.samples {
+		if s.good {
+			r.Good++
+		}
+	}
+	if r.Total == 0 {
+		return r
+	}
+	r.Compliance = float64(r.Good) / float64(r.Total)
+	if budget := 1 - t.slo.Target; budget > 0 {
+		r.BurnRate = (1 - r.Compliance) / budget
+	}
+	r.Breached = r.Compliance < t.slo.Target
+	return r
+}
@@ -0,0 +1,73 @@
+package pipe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSLOTrackerAlertsOnBreachAndRecovery(t *testing.T) {
+	var alerts []SLOReport
+	tr := &sloTracker{slo: LatencySLO{
+		Threshold:  time.Second,
+		Target:     0.9,
+		Window:     time.Minute,
+		MinBatches: 10,
+		Alert:      func(r SLOReport) { alerts = append(alerts, r) },
+	}}
+	now := time.Now()
+
+	// Медленный батч до набора MinBatches не оцениваем
+	tr.observe(now, 2*time.Second)
+	for i := 0; i < 8; i++ {
+		tr.observe(now, time.Millisecond)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("alerted before MinBatches: %+v", alerts)
+	}
+
+	// 10-й батч: 2 из 10 медленные - 80% < 90%
+	tr.observe(now, 2*time.Second)
+	if len(alerts) != 1 || !alerts[0].Breached || alerts[0].Total != 10 || alerts[0].Good != 8 {
+		t.Fatalf("alerts = %+v, want one breach with 8/10", alerts)
+	}
+	if alerts[0].BurnRate < 1.99 || alerts[0].BurnRate > 2.01 {
+		t.Fatalf("burn rate = %g, want 2", alerts[0].BurnRate)
+	}
+
+	// Повторное нарушение не шумит
+	tr.observe(now, 2*time.Second)
+	if len(alerts) != 1 {
+		t.Fatalf("alerted twice for the same breach: %+v", alerts)
+	}
+
+	// Старые замеры выпали из окна - SLO снова выполняется
+	later := now.Add(2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		tr.observe(later, time.Millisecond)
+	}
+	if len(alerts) != 2 || alerts[1].Breached || alerts[1].Total != 10 {
+		t.Fatalf("alerts = %+v, want recovery on a fresh window", alerts)
+	}
+}
+
+func TestWithLatencySLOMeasuresProcess(t *testing.T) {
+	var alerts []SLOReport
+	slo := LatencySLO{Threshold: time.Millisecond, Target: 0.5, Window: time.Minute, Alert: func(r SLOReport) { alerts = append(alerts, r) }}
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+
+	err := Pipe(p, DelayConsumer(FixedLatency(5*time.Millisecond)), WithInlineMode(), WithLatencySLO(slo))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if len(alerts) != 1 || !alerts[0].Breached {
+		t.Fatalf("alerts = %+v, want one breach", alerts)
+	}
+}
+
+func TestWithLatencySLOValidation(t *testing.T) {
+	var cfgErr *ConfigError
+	if err := Pipe(&testProducer{}, &testConsumer{}, WithLatencySLO(LatencySLO{Target: 1})); !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 3 {
+		t.Fatalf("Pipe() error = %v, want 3 problems", err)
+	}
+}
@@ -0,0 +1,98 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+// State - фаза жизни запуска Pipe
+type State string
+
+const (
+	// Опции разобраны, горутины ещё не запущены
+	StateInit State = "init"
+	// Читаем источник и обрабатываем батчи
+	StateRunning State = "running"
+	// Источник больше не читаем, дорабатывают уже собранные батчи
+	StateDraining State = "draining"
+	// Остановились без ошибки
+	StateStopped State = "stopped"
+	// Остановились с ошибкой
+	StateFailed State = "failed"
+)
+
+// Допустимые переходы: Init → Running → Draining → Stopped/Failed, упасть можно с любой фазы
+var stateTransitions = map[State][]State{
+	StateInit:     {StateRunning, StateFailed},
+	StateRunning:  {StateDraining, StateFailed},
+	StateDraining: {StateStopped, StateFailed},
+}
+
+// StateChange - один переход между фазами
+type StateChange struct {
+	From State
+	To   State
+	// Ошибка, из-за которой перешли в StateFailed
+	Err  error
+	Time time.Time
+}
+
+// WithStateHook вызывает fn на каждом переходе между фазами. Вызов синхронный, из горутин Pipe.
+func WithStateHook(fn func(StateChange)) Option {
+	return func(cfg *config) {
+		cfg.stateHooks = append(cfg.stateHooks, fn)
+	}
+}
+
+// WithStateChanges отправляет каждый переход в ch. Отправка блокирующая, Pipe канал не закрывает -
+// последним всегда приходит StateStopped или StateFailed.
+func WithStateChanges(ch chan<- StateChange) Option {
+	return WithStateHook(func(sc StateChange) {
+		ch <- sc
+	})
+}
+
+// stateMachine следит, чтобы фазы менялись только по разрешённым переходам
+type stateMachine struct {
+	hooks []func(StateChange)
+
+	mu    sync.Mutex
+	state State
+}
+
+func newStateMachine(hooks []func(StateChange)) *stateMachine {
+	return &stateMachine{hooks: hooks, state: StateInit}
+}
+
+// transition переводит в фазу to. Недопустимый переход (например, повторный Draining) игнорируется.
+func (m *stateMachine) transition(to State, err error) bool {
+	m.mu.Lock()
+	from := m.state
+	allowed := false
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			allowed = true
+			break
+		}
+	}
+	if allowed {
+		m.state = to
+	}
+	m.mu.Unlock()
+
+	if !allowed {
+		return false
+	}
+	sc := StateChange{From: from, To: to, Err: err, Time: time.Now()}
+	for _, hook := range m.hooks {
+		hook(sc)
+	}
+	return true
+}
+
+// current - текущая фаза
+func (m *stateMachine) current() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
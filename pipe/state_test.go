@@ -0,0 +1,64 @@
+package pipe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWithStateChangesLifecycle(t *testing.T) {
+	ch := make(chan StateChange, 10)
+	p := &testProducer{chunks: 4, chunkSize: 3000}
+
+	err := Pipe(p, &testConsumer{}, WithStateChanges(ch))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	close(ch)
+
+	var got []State
+	var last StateChange
+	for sc := range ch {
+		got = append(got, sc.To)
+		last = sc
+	}
+	if want := []State{StateRunning, StateDraining, StateFailed}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("states = %v, want %v", got, want)
+	}
+	if last.From != StateDraining || !errors.Is(last.Err, errSourceDone) {
+		t.Fatalf("last change = %+v", last)
+	}
+}
+
+func TestWithStateHookFailsFromInitOnStartupError(t *testing.T) {
+	var got []StateChange
+	log := failingEventLog{err: errors.New("log unavailable")}
+
+	Pipe(&testProducer{}, &testConsumer{}, WithEventLog(log), WithStateHook(func(sc StateChange) { got = append(got, sc) }))
+
+	if len(got) != 1 || got[0].From != StateInit || got[0].To != StateFailed {
+		t.Fatalf("changes = %+v, want init → failed", got)
+	}
+}
+
+func TestStateMachineRejectsInvalidTransitions(t *testing.T) {
+	var got []State
+	m := newStateMachine([]func(StateChange){func(sc StateChange) { got = append(got, sc.To) }})
+
+	if m.transition(StateDraining, nil) {
+		t.Fatal("init → draining allowed")
+	}
+	m.transition(StateRunning, nil)
+	m.transition(StateDraining, nil)
+	if m.transition(StateDraining, nil) {
+		t.Fatal("repeated draining allowed")
+	}
+	m.transition(StateStopped, nil)
+	if m.transition(StateFailed, nil) {
+		t.Fatal("transition out of a final state allowed")
+	}
+
+	if want := []State{StateRunning, StateDraining, StateStopped}; !reflect.DeepEqual(got, want) || m.current() != StateStopped {
+		t.Fatalf("states = %v (current %s), want %v", got, m.current(), want)
+	}
+}
@@ -0,0 +1,247 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Счётчики по стадиям Pipe для дашбордов и админки. Stats живёт отдельно от запуска (как FlushStats):
+Pipe пишет в неё через WithStats, а читают только Snapshot - готовую копию, так что снаружи
+никаких блокировок и живых счётчиков, которые меняются посреди отрисовки.
+Pipeline ведёт Stats и без WithStats - её снимок отдаёт Pipeline.Stats.
+*/
+
+// Stage - стадия, по которой считается статистика
+type Stage string
+
+const (
+	// Next: вызовы - непустые пачки
+	StageRead Stage = "read"
+	// WithTransform: вызовы - пачки, элементы - вышедшие из преобразований, ошибки - выкинутые элементы
+	StageTransform Stage = "transform"
+	// Process: вызовы - батчи (успешные, не попытки)
+	StageProcess Stage = "process"
+	// Commit: вызовы - cookie, элементы - батча, у которого закоммичены все cookie
+	StageCommit Stage = "commit"
+)
+
+// StageError - ошибка на пути данных (адаптер, опция, журнал событий) в ошибке Pipe с её стадией: read - всё
+// до отправки батча (Next, WithCookieCheck, WithBoundaries, аренда cookie), transform - WithTransform и WithLargeItems,
+// process и commit - свои вызовы и всё вокруг них. Pipe возвращает все ошибки запуска через errors.Join,
+// так что errors.As находит первую StageError, а errors.Is - любую из причин.
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return string(e.Stage) + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// stageError заворачивает err в StageError, nil остаётся nil
+func stageError(stage Stage, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// statsBuckets - на сколько корзин делим скользящее окно
+const statsBuckets = 10
+
+// StageStats - статистика одной стадии
+type StageStats struct {
+	// С создания Stats
+	Calls  int64
+	Items  int64
+	Errors int64
+	// В секунду за скользящее окно (или за время с создания, если оно короче окна)
+	CallRate float64
+	ItemRate float64
+	// Последний успешный вызов, нулевое время - ещё ни одного
+	Last time.Time
+}
+
+// StatsSnapshot - неизменяемый снимок Stats. Version растёт на каждом изменении: снимки с одной
+// Version одинаковые, а по разнице видно, было ли что-то между ними.
+type StatsSnapshot struct {
+	Version uint64
+	Time    time.Time
+	Window  time.Duration
+	Stages  map[Stage]StageStats
+	// Прочитано, но ещё не закоммичено элементов
+	InFlight int64
+	// Глубина очереди батчей, подобранная WithAdaptiveQueue, 0 - фиксированная DefaultQueueDepth
+	QueueDepth int
+	// Сколько элементов сейчас в буфере и ещё не ушло в батч
+	Buffered int
+	// Отправлено батчей (включая пустые, с одними cookie) и когда последний
+	Flushed   int64
+	LastFlush time.Time
+}
+
+// Stats копит статистику по стадиям. Заводится через NewStats, подключается через WithStats,
+// Snapshot можно звать в любой момент и из любой горутины. Один Stats на несколько запусков подряд - суммируется.
+type Stats struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	version uint64
+	created time.Time
+	stages  map[Stage]*stageCounter
+	depth   int
+	// Буфер и отправленные батчи
+	buffered  int
+	flushed   int64
+	lastFlush time.Time
+}
+
+// stageCounter - счётчики стадии и её корзины окна
+type stageCounter struct {
+	calls, items, errors int64
+	last                 time.Time
+	buckets              [statsBuckets]statsBucket
+}
+
+type statsBucket struct {
+	// Номер интервала ширины window/statsBuckets, к которому относится корзина
+	slot         int64
+	calls, items int64
+}
+
+// NewStats создаёт статистику со скользящим окном window для скоростей (<= 0 - минута)
+func NewStats(window time.Duration) *Stats {
+	if window <= 0 {
+		window = time.Minute
+	}
+	return newStats(window, time.Now)
+}
+
+func newStats(window time.Duration, now func() time.Time) *Stats {
+	s := &Stats{window: window, now: now, created: now(), stages: make(map[Stage]*stageCounter)}
+	for _, st := range []Stage{StageRead, StageTransform, StageProcess, StageCommit} {
+		s.stages[st] = &stageCounter{}
+	}
+	return s
+}
+
+// WithStats пишет в s вызовы, элементы и ошибки каждой стадии
+func WithStats(s *Stats) Option {
+	return func(cfg *config) {
+		cfg.stats = s
+	}
+}
+
+// observe учитывает calls вызовов стадии с items элементами
+func (s *Stats) observe(stage Stage, calls, items int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	c := s.stages[stage]
+	c.calls += int64(calls)
+	c.items += int64(items)
+	c.last = s.now()
+	slot := s.slot()
+	b := &c.buckets[slot%statsBuckets]
+	if b.slot != slot {
+		*b = statsBucket{slot: slot}
+	}
+	b.calls += int64(calls)
+	b.items += int64(items)
+}
+
+// fail учитывает n ошибок стадии
+func (s *Stats) fail(stage Stage, n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.stages[stage].errors += int64(n)
+}
+
+// queueDepth запоминает текущую глубину очереди, 0 - она не подбирается
+func (s *Stats) queueDepth(depth int) {
+	if s == nil || depth == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.depth != depth {
+		s.version++
+		s.depth = depth
+	}
+}
+
+// buffer запоминает, сколько элементов в буфере
+func (s *Stats) buffer(items int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buffered != items {
+		s.version++
+		s.buffered = items
+	}
+}
+
+// flush учитывает отправленный батч, после которого в буфере осталось buffered элементов
+func (s *Stats) flush(buffered int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.flushed++
+	s.lastFlush = s.now()
+	s.buffered = buffered
+}
+
+// slot - номер текущего интервала окна
+func (s *Stats) slot() int64 {
+	return int64(s.now().Sub(s.created) / max(s.window/statsBuckets, 1))
+}
+
+// Snapshot возвращает снимок статистики
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	snap := StatsSnapshot{Version: s.version, Time: now, Window: s.window, Stages: make(map[Stage]StageStats, len(s.stages))}
+	// Окно - последние statsBuckets интервалов вместе с текущим. Делим на длину окна, а пока
+	// с создания прошло меньше - на прошедшее время, иначе первые минуты скорость занижена
+	cur := s.slot()
+	span := min(now.Sub(s.created), s.window).Seconds()
+	for stage, c := range s.stages {
+		st := StageStats{Calls: c.calls, Items: c.items, Errors: c.errors, Last: c.last}
+		if span > 0 {
+			var calls, items int64
+			for _, b := range c.buckets {
+				if b.slot > cur-statsBuckets {
+					calls += b.calls
+					items += b.items
+				}
+			}
+			st.CallRate = float64(calls) / span
+			st.ItemRate = float64(items) / span
+		}
+		snap.Stages[stage] = st
+	}
+	snap.InFlight = s.stages[StageRead].items - s.stages[StageCommit].items
+	snap.QueueDepth = s.depth
+	snap.Buffered, snap.Flushed, snap.LastFlush = s.buffered, s.flushed, s.lastFlush
+	return snap
+}
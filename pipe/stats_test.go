@@ -0,0 +1,78 @@
+package pipe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithStats(t *testing.T) {
+	s := NewStats(time.Minute)
+	p := &testProducer{chunks: 7, chunkSize: 3000}
+	if err := Pipe(finiteProducer{p}, &testConsumer{}, WithInlineMode(), WithStats(s)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+
+	snap := s.Snapshot()
+	want := map[Stage]StageStats{
+		StageRead:    {Calls: 7, Items: 21000},
+		StageProcess: {Calls: 3, Items: 21000},
+		StageCommit:  {Calls: 7, Items: 21000},
+	}
+	for stage, w := range want {
+		got := snap.Stages[stage]
+		if got.Calls != w.Calls || got.Items != w.Items || got.Errors != 0 {
+			t.Errorf("%s = %+v, want calls %d, items %d", stage, got, w.Calls, w.Items)
+		}
+		if got.ItemRate <= 0 {
+			t.Errorf("%s item rate = %g, want > 0", stage, got.ItemRate)
+		}
+	}
+	if snap.InFlight != 0 {
+		t.Errorf("in flight = %d after a clean run", snap.InFlight)
+	}
+	if snap.Flushed != 3 || snap.LastFlush.IsZero() || snap.Buffered != 0 || snap.Stages[StageCommit].Last.IsZero() {
+		t.Errorf("flushed %d at %v, buffered %d, last commit %v, want 3 batches and an empty buffer",
+			snap.Flushed, snap.LastFlush, snap.Buffered, snap.Stages[StageCommit].Last)
+	}
+	if snap.Version == 0 || s.Snapshot().Version != snap.Version {
+		t.Errorf("version = %d, want a stable non-zero version without changes", snap.Version)
+	}
+}
+
+func TestWithStatsErrors(t *testing.T) {
+	s := NewStats(time.Minute)
+	p := &testProducer{chunks: 2, chunkSize: 10}
+	err := Pipe(p, &describedConsumer{err: errors.New("sink down")}, WithInlineMode(), WithStats(s))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	snap := s.Snapshot()
+	if snap.Stages[StageRead].Errors != 1 || snap.Stages[StageProcess].Errors != 1 || snap.Stages[StageCommit].Calls != 0 {
+		t.Errorf("stages = %+v", snap.Stages)
+	}
+	if snap.InFlight != 20 {
+		t.Errorf("in flight = %d, want 20", snap.InFlight)
+	}
+}
+
+func TestStatsSlidingWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newStats(10*time.Second, func() time.Time { return now })
+
+	// Первые 5 секунд: по 10 элементов в секунду
+	for i := 0; i < 5; i++ {
+		s.observe(StageRead, 1, 10)
+		now = now.Add(time.Second)
+	}
+	if got := s.Snapshot().Stages[StageRead].ItemRate; got != 10 {
+		t.Errorf("rate after 5s = %g, want 10", got)
+	}
+
+	// Через 20 секунд тишины старые корзины выпали из окна, а итоги остались
+	now = now.Add(20 * time.Second)
+	st := s.Snapshot().Stages[StageRead]
+	if st.ItemRate != 0 || st.Items != 50 || st.Calls != 5 {
+		t.Errorf("after the window = %+v, want rate 0 and 50 items in total", st)
+	}
+}
@@ -0,0 +1,39 @@
+package pipe
+
+import "context"
+
+/*
+Теги запуска: произвольные ключ/значение (команда, топик, окружение), по которым потом режут метрики,
+трейсы и логи нескольких Pipe в одном процессе. Pipe передаёт теги туда, где их видят адаптеры
+и внешние системы: в контексты Next/Process/Commit (TagsFromContext), в события журнала (Event.Tags),
+в отчёты о доставке (DeliveryReport.Tags), в метки серий WithMetrics, в логи и метки pprof (WithProfiling).
+*/
+
+type tagsKey struct{}
+
+// WithTags добавляет теги запуска. Можно вызывать несколько раз, одинаковые ключи перезаписываются.
+func WithTags(tags map[string]string) Option {
+	return func(cfg *config) {
+		if cfg.tags == nil {
+			cfg.tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			cfg.tags[k] = v
+		}
+	}
+}
+
+// TagsFromContext возвращает теги запуска Pipe, в котором вызван метод адаптера.
+// Карта общая на весь запуск - её нельзя менять. nil, если тегов нет.
+func TagsFromContext(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(tagsKey{}).(map[string]string)
+	return tags
+}
+
+// withTags кладёт теги в контекст запуска
+func withTags(ctx context.Context, tags map[string]string) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, tagsKey{}, tags)
+}
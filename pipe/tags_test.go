@@ -0,0 +1,69 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// tagsConsumer запоминает теги из контекста Process
+type tagsConsumer struct {
+	got []map[string]string
+}
+
+func (c *tagsConsumer) Process(ctx context.Context, items []any) error {
+	c.got = append(c.got, TagsFromContext(ctx))
+	return nil
+}
+
+// memoryEventLog держит события в памяти
+type memoryEventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *memoryEventLog) Append(ctx context.Context, e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	return nil
+}
+
+func TestWithTagsPropagation(t *testing.T) {
+	want := map[string]string{"team": "ingest", "topic": "clicks"}
+	log := &memoryEventLog{}
+	reports := make(chan DeliveryReport, 100)
+	c := &tagsConsumer{}
+
+	err := Pipe(&testProducer{chunks: 4, chunkSize: 3000}, c, WithInlineMode(),
+		WithTags(map[string]string{"team": "ingest"}), WithTags(map[string]string{"topic": "clicks"}),
+		WithEventLog(log), WithDeliveryReports(reports))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	close(reports)
+
+	if len(c.got) == 0 || !reflect.DeepEqual(c.got[0], want) {
+		t.Fatalf("tags in Process = %v, want %v", c.got, want)
+	}
+	for _, e := range log.events {
+		if !reflect.DeepEqual(e.Tags, want) {
+			t.Fatalf("event %s tags = %v, want %v", e.Type, e.Tags, want)
+		}
+	}
+	for r := range reports {
+		if !reflect.DeepEqual(r.Tags, want) {
+			t.Fatalf("report for cookie %d tags = %v, want %v", r.Cookie, r.Tags, want)
+		}
+	}
+}
+
+func TestWithTagsRejectsEmptyKey(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithTags(map[string]string{"": "x"}))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Problems[0].Option != "WithTags" {
+		t.Fatalf("Pipe() error = %v, want WithTags problem", err)
+	}
+}
@@ -0,0 +1,164 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+/*
+Tombstone-элементы. В CDC-потоках (Kafka с compaction, Debezium) удаление приходит отдельным элементом
+без значения. Обычный Process их не ждёт: upsert с nil падает или пишет пустую строку. WithTombstones
+решает, что с ними делать: пропустить как есть, выкинуть или отдать в ProcessDeletes консюмера.
+*/
+
+// Tombstones - что делать с tombstone-элементами
+type Tombstones int
+
+const (
+	// Как обычные элементы - в Process (так же без WithTombstones)
+	TombstonesPass Tombstones = iota
+	// Выкидываем до батча, счёт - в Metrics
+	TombstonesDrop
+	// Отдаём в ProcessDeletes консюмера (DeleteConsumerOf)
+	TombstonesRoute
+)
+
+func (t Tombstones) String() string {
+	switch t {
+	case TombstonesPass:
+		return "pass"
+	case TombstonesDrop:
+		return "drop"
+	case TombstonesRoute:
+		return "route"
+	}
+	return fmt.Sprintf("Tombstones(%d)", int(t))
+}
+
+// DeleteConsumerOf - консюмер, который умеет удаления. Нужен для TombstonesRoute. DeleteConsumer - это DeleteConsumerOf[any].
+type DeleteConsumerOf[T any] interface {
+	ProcessDeletes(ctx context.Context, items []T) error
+}
+
+type DeleteConsumer interface {
+	ProcessDeletes(ctx context.Context, items []any) error
+}
+
+// WithTombstones задаёт, что делать с tombstone-элементами. isTombstone отличает их от обычных,
+// nil - tombstone это nil (в том числе nil-указатель, слайс или map).
+// С TombstonesRoute батч режется на отрезки подряд идущих обычных элементов и удалений, и они уходят в Process
+// и ProcessDeletes по порядку - upsert и delete одного ключа не переставляются. BatchContext каждого вызова
+// описывает его отрезок. Повтор Process повторяет весь батч, так что уже записанные отрезки придут ещё раз.
+func WithTombstones(policy Tombstones, isTombstone func(item any) bool) Option {
+	return func(cfg *config) {
+		if isTombstone == nil {
+			isTombstone = isNilItem
+		}
+		cfg.tombstones = &tombstones{policy: policy, is: isTombstone}
+	}
+}
+
+type tombstones struct {
+	policy Tombstones
+	is     func(item any) bool
+}
+
+func (t *tombstones) validate(add func(option, problem, suggestion string)) {
+	if t == nil {
+		return
+	}
+	if t.policy < TombstonesPass || t.policy > TombstonesRoute {
+		add("WithTombstones", fmt.Sprintf("unknown policy %d", t.policy), "use TombstonesPass, TombstonesDrop or TombstonesRoute")
+	}
+}
+
+// isNilItem - элемент nil сам или nil внутри интерфейса
+func isNilItem(item any) bool {
+	if item == nil {
+		return true
+	}
+	v := reflect.ValueOf(item)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// checkTombstoneConsumer - для TombstonesRoute консюмер должен уметь ProcessDeletes.
+// ProcessWithResults с отрезками не сочетается: результаты пришлось бы собирать по нескольким вызовам
+func checkTombstoneConsumer[T any](cfg *config, c ConsumerOf[T]) error {
+	if cfg.tombstones == nil || cfg.tombstones.policy != TombstonesRoute {
+		return nil
+	}
+	var problems []ConfigProblem
+	if _, ok := c.(DeleteConsumerOf[T]); !ok {
+		problems = append(problems, ConfigProblem{Option: "WithTombstones", Problem: fmt.Sprintf("consumer %T has no ProcessDeletes", c), Suggestion: "implement DeleteConsumerOf or use TombstonesDrop"})
+	}
+	if _, ok := c.(ResultConsumerOf[T]); ok {
+		problems = append(problems, ConfigProblem{Option: "WithTombstones", Problem: "TombstonesRoute cannot split ProcessWithResults into runs", Suggestion: "use TombstonesDrop or drop ProcessWithResults"})
+	}
+	if problems != nil {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// dropTombstones выкидывает tombstone-элементы при TombstonesDrop
+func dropTombstones[T any](cfg *config, items []T) []T {
+	t := cfg.tombstones
+	if t == nil || t.policy != TombstonesDrop {
+		return items
+	}
+	kept := items[:0:0]
+	for _, item := range items {
+		if !t.is(any(item)) {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == len(items) {
+		// Выкидывать нечего - отдаём пачку как есть
+		return items
+	}
+	cfg.metrics.tombstones(TombstonesDrop, len(items)-len(kept))
+	return kept
+}
+
+// processTombstoneRuns отдаёт батч отрезками: обычные элементы в Process, удаления в ProcessDeletes, по порядку
+func processTombstoneRuns[T any](ctx context.Context, cfg *config, c ConsumerOf[T], items []T) error {
+	dc := c.(DeleteConsumerOf[T])
+	meta, hasMeta := BatchContext(ctx)
+	for start := 0; start < len(items); {
+		deletes := cfg.tombstones.is(any(items[start]))
+		end := start + 1
+		for end < len(items) && cfg.tombstones.is(any(items[end])) == deletes {
+			end++
+		}
+		runCtx := ctx
+		if hasMeta && (start > 0 || end < len(items)) {
+			idx := make([]int, 0, end-start)
+			for i := start; i < end; i++ {
+				idx = append(idx, i)
+			}
+			runCtx = context.WithValue(ctx, batchKey{}, subBatch(meta, idx))
+		}
+		part, err := consumerItems(cfg, items[start:end])
+		if err != nil {
+			return err
+		}
+		if deletes {
+			err = dc.ProcessDeletes(runCtx, part)
+		} else {
+			err = c.Process(runCtx, part)
+		}
+		if err != nil {
+			return err
+		}
+		if deletes {
+			cfg.metrics.tombstones(TombstonesRoute, len(part))
+		}
+		start = end
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithTombstonesDrop(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, nil, 2}, {nil}, {3}}}
+	c := &itemsConsumer{p: p}
+	m := NewMetrics("")
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithTombstones(TombstonesDrop, nil), WithMetrics(m)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if want := [][]any{{1, 2, 3}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	// Пачка из одних удалений коммитится как обычная
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Errorf("commits = %v, want [1 2 3]", p.committed)
+	}
+	var out strings.Builder
+	m.WriteTo(&out)
+	if want := `pipe_tombstones_total{action="dropped"} 2`; !strings.Contains(out.String(), want) {
+		t.Errorf("metrics have no %q", want)
+	}
+}
+
+// deletesConsumer запоминает вызовы Process и ProcessDeletes по порядку
+type deletesConsumer struct {
+	calls []string
+	metas []BatchMeta
+}
+
+func (c *deletesConsumer) Process(ctx context.Context, items []any) error {
+	return c.record(ctx, "upsert", items)
+}
+
+func (c *deletesConsumer) ProcessDeletes(ctx context.Context, items []any) error {
+	return c.record(ctx, "delete", items)
+}
+
+func (c *deletesConsumer) record(ctx context.Context, kind string, items []any) error {
+	meta, _ := BatchContext(ctx)
+	c.calls = append(c.calls, fmt.Sprint(kind, items))
+	c.metas = append(c.metas, meta)
+	return nil
+}
+
+func TestWithTombstonesRoute(t *testing.T) {
+	// Отрицательные - удаления своих ключей
+	p := &listProducer{chunks: [][]any{{1, -1, -2}, {2, 3, -3}}}
+	c := &deletesConsumer{}
+	isDelete := func(item any) bool { return item.(int) < 0 }
+	if err := Pipe(finiteProducer{p}, c, WithInlineMode(), WithTombstones(TombstonesRoute, isDelete)); err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	want := []string{"upsert[1]", "delete[-1 -2]", "upsert[2 3]", "delete[-3]"}
+	if !reflect.DeepEqual(c.calls, want) {
+		t.Fatalf("calls = %v, want %v", c.calls, want)
+	}
+	// BatchContext отрезка - его элементы и их пачки
+	if m := c.metas[2]; m.Items != 2 || len(m.Spans) != 1 || m.Spans[0] != (CookieSpan{Cookie: 2, Offset: 0, Items: 2}) {
+		t.Errorf("meta of the third run = %+v", m)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2}) {
+		t.Errorf("commits = %v, want [1 2]", p.committed)
+	}
+}
+
+func TestWithTombstonesRouteNeedsProcessDeletes(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithTombstones(TombstonesRoute, nil))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+	}
+	err = Pipe(&testProducer{}, &testConsumer{}, WithTombstones(Tombstones(7), nil))
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError", err)
+	}
+}
+
+func TestIsNilItem(t *testing.T) {
+	var nilPtr *int
+	var nilSlice []byte
+	for _, tt := range []struct {
+		item any
+		want bool
+	}{
+		{nil, true},
+		{nilPtr, true},
+		{nilSlice, true},
+		{0, false},
+		{"", false},
+		{[]byte{}, false},
+	} {
+		if got := isNilItem(tt.item); got != tt.want {
+			t.Errorf("isNilItem(%#v) = %v, want %v", tt.item, got, tt.want)
+		}
+	}
+}
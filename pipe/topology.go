@@ -0,0 +1,377 @@
+package pipe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topology - устройство запуска Pipe: откуда читаем, что делаем с данными по дороге и куда пишем.
+// Собирается Describe из тех же опций, что и запуск, - чтобы ревьюить потоки, собранные из конфига,
+// не читая код. String - описание текстом, Mermaid и DOT - схема.
+type Topology struct {
+	Source Component
+	// Стадии по порядку: read, transform, batch, process, commit и shutdown. В каждой только включённые
+	// настройки, transform и shutdown - только если там что-то есть
+	Stages []TopologyStage
+	Sink   Component
+	// Куда уходят батчи, которые приёмник так и не принял, пусто - Pipe останавливается
+	DeadLetter string
+	// Кто ещё смотрит на запуск: журнал событий, метрики, колбэки
+	Observers []string
+}
+
+// Component - источник или приёмник. Parts - из чего он собран (Merge, BroadcastConsumer и т.п.)
+type Component struct {
+	Name  string
+	Parts []Component
+}
+
+// TopologyStage - стадия и её настройки словами
+type TopologyStage struct {
+	Name     string
+	Policies []string
+}
+
+// Describe собирает Topology для p, c и opts, ничего не запуская. Кривой конфиг - *ConfigError, как у Pipe.
+// p и c - Producer/Consumer или ProducerOf/ConsumerOf любого типа.
+func Describe(p, c any, opts ...Option) (Topology, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return Topology{}, err
+	}
+	t := Topology{Source: component(p, false), Sink: component(c, true)}
+	if cfg.deadLetter != nil {
+		t.DeadLetter = describeSink(cfg.deadLetter)
+	}
+
+	stage := func(name string, always bool, policies ...string) {
+		var on []string
+		for _, p := range policies {
+			if p != "" {
+				on = append(on, p)
+			}
+		}
+		if always || len(on) > 0 {
+			t.Stages = append(t.Stages, TopologyStage{Name: name, Policies: on})
+		}
+	}
+	when := func(ok bool, format string, args ...any) string {
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf(format, args...)
+	}
+	retry := func(rp *RetryPolicy) string {
+		return fmt.Sprintf("retry %d attempts, backoff %s", rp.Attempts, rp.Backoff)
+	}
+
+	_, renews := p.(LeaseRenewer)
+	read := []string{
+		when(cfg.nextTimeout > 0, "Next timeout %s, %d retries", cfg.nextTimeout, cfg.nextRetries),
+		when(renews, "lease renewal every %s", cfg.leaseRenewInterval),
+		when(cfg.cookieCheck != nil, "cookie order check"),
+		when(cfg.duplicateChunks != nil, "drop repeated chunks"),
+		when(cfg.memoryThrottle > 0, "pause reads above %g of the memory limit", cfg.memoryThrottle),
+		when(cfg.quietPeriod > 0, "stop after %s of quiet", cfg.quietPeriod),
+	}
+	if cfg.nextRetry != nil {
+		read = append(read, retry(cfg.nextRetry))
+	}
+	if cu := cfg.catchUp; cu != nil {
+		read = append(read, fmt.Sprintf("catch-up above lag %d", cu.Enter))
+	}
+	stage("read", true, read...)
+
+	transform := []string{when(len(cfg.transforms) > 0, "%d transforms", len(cfg.transforms)), when(cfg.dedup != nil, "dedup by key")}
+	if li := cfg.largeItems; li != nil {
+		transform = append(transform, fmt.Sprintf("items over %d: %s", li.Threshold, li.Policy))
+	}
+	if ts := cfg.tombstones; ts != nil {
+		transform = append(transform, fmt.Sprintf("tombstones: %s", ts.policy))
+	}
+	stage("transform", false, transform...)
+
+	_, sized := c.(BatchSizer)
+	batch := []string{
+		fmt.Sprintf("up to %d items", MaxItems),
+		when(sized, "size hinted by the sink"),
+		when(cfg.maxBatchDelay > 0, "flush after %s", cfg.maxBatchDelay),
+		when(cfg.minItems > 0, "at least %d items, up to %s", cfg.minItems, cfg.minItemsWait),
+		when(cfg.boundaries != nil, "keep groups whole"),
+		when(cfg.arenaSlabSize > 0, "arena slabs of %d", cfg.arenaSlabSize),
+		when(cfg.defensiveCopies, "defensive copies"),
+	}
+	if bb := cfg.batchBytes; bb != nil {
+		batch = append(batch, fmt.Sprintf("up to %d bytes", bb.max))
+	}
+	if b := cfg.itemsRate; b != nil {
+		batch = append(batch, fmt.Sprintf("at most %g items/s", b.rate))
+	}
+	if b := cfg.batchesRate; b != nil {
+		batch = append(batch, fmt.Sprintf("at most %g batches/s", b.rate))
+	}
+	if q := cfg.queue; q != nil {
+		batch = append(batch, fmt.Sprintf("queue %d..%d batches", q.min, q.max))
+	} else if !cfg.inline {
+		batch = append(batch, fmt.Sprintf("queue %d batches", DefaultQueueDepth))
+	}
+	if f := cfg.inFlight; f != nil {
+		batch = append(batch, fmt.Sprintf("at most %d bytes in flight", f.max))
+	}
+	stage("batch", true, batch...)
+
+	process := []string{
+		when(cfg.inline, "inline with reads"),
+		when(!cfg.inline, "%d workers", max(cfg.workers, 1)),
+		when(cfg.catchUp != nil && cfg.poolSize() > max(cfg.workers, 1), "%d workers in catch-up", cfg.poolSize()),
+		when(cfg.affinity != nil, "one batch per partition"),
+		when(cfg.batchDeadline > 0, "batch deadline %s", cfg.batchDeadline),
+	}
+	if cfg.processRetry != nil {
+		process = append(process, retry(cfg.processRetry))
+	}
+	if b := cfg.breaker; b != nil {
+		process = append(process, fmt.Sprintf("circuit breaker after %d failures", b.cb.Failures))
+	}
+	if eb := cfg.errorBudget; eb != nil {
+		process = append(process, fmt.Sprintf("error budget %g over %s", eb.maxRate, eb.window))
+	}
+	if s := cfg.slo; s != nil {
+		process = append(process, fmt.Sprintf("SLO %g under %s", s.slo.Target, s.slo.Threshold))
+	}
+	stage("process", true, process...)
+
+	commit := []string{
+		when(cfg.unorderedCommits, "as soon as processed"),
+		when(!cfg.unorderedCommits, "in batch order"),
+		when(len(cfg.commitMirrors) > 0, "mirrored to %d", len(cfg.commitMirrors)),
+	}
+	if cr := cfg.commitRetry; cr != nil {
+		commit = append(commit, retry(&cr.RetryPolicy)+when(cr.OnExhausted == CommitSkip, ", then skip"))
+	}
+	stage("commit", true, commit...)
+
+	stage("shutdown", false,
+		when(cfg.drainTimeout > 0, "drain within %s", cfg.drainTimeout),
+		when(cfg.shutdownTimeouts != (ShutdownTimeouts{}), "step timeouts"))
+
+	t.Observers = observers(cfg)
+	return t, nil
+}
+
+// observers - всё, что только смотрит на запуск и не меняет данные
+func observers(cfg *config) []string {
+	var o []string
+	add := func(ok bool, format string, args ...any) {
+		if ok {
+			o = append(o, fmt.Sprintf(format, args...))
+		}
+	}
+	add(cfg.eventLog != nil, "event log %T", cfg.eventLog)
+	add(cfg.metrics != nil, "metrics")
+	add(cfg.profiling, "profiling")
+	add(cfg.stats != nil, "stats")
+	add(cfg.flushStats != nil, "flush stats")
+	add(cfg.keyStats != nil, "key stats")
+	add(cfg.gcStats != nil, "GC stats")
+	add(cfg.deliveryReports != nil, "delivery reports")
+	add(len(cfg.hooks) > 0, "%d hooks", len(cfg.hooks))
+	add(len(cfg.stateHooks) > 0, "state hooks")
+	add(len(cfg.runReports) > 0, "run reports")
+	if c := cfg.capture; c != nil {
+		o = append(o, "capture to "+c.dir)
+	}
+	add(len(cfg.crashHooks)+len(cfg.crashDirs) > 0, "crash dumps")
+	add(cfg.batchHash, "batch hashes")
+	add(cfg.logger != nil, "logger")
+	return o
+}
+
+// component описывает адаптер и то, из чего он собран
+func component(a any, sink bool) Component {
+	comp := Component{Name: fmt.Sprintf("%T", a)}
+	if sink {
+		comp.Name = describeSink(a)
+	}
+	if c, ok := a.(interface{ adapters() []adapter }); ok {
+		switch a := a.(type) {
+		case *mergeProducer:
+			comp.Name = "Merge"
+		case *priorityMerge:
+			comp.Name = fmt.Sprintf("PriorityMerge (live poll %s)", a.livePoll)
+		case *broadcastConsumer:
+			comp.Name = fmt.Sprintf("BroadcastConsumer (quorum %d)", a.quorum)
+		case *shadowConsumer:
+			comp.Name = "ShadowConsumer"
+		case *Cutover:
+			comp.Name = fmt.Sprintf("Cutover (%s)", a.Phase())
+		case *parallelConsumer:
+			comp.Name = fmt.Sprintf("ParallelConsumer (%d parts)", a.parts)
+		case *reconcileConsumer:
+			comp.Name = "ReconcileConsumer"
+		}
+		for _, part := range c.adapters() {
+			p := component(part.a, sink)
+			p.Name = part.name + ": " + p.Name
+			comp.Parts = append(comp.Parts, p)
+		}
+	}
+	return comp
+}
+
+// String - описание текстом, по строке на стадию
+func (t Topology) String() string {
+	var b strings.Builder
+	var parts func(c Component, indent string)
+	parts = func(c Component, indent string) {
+		for _, p := range c.Parts {
+			fmt.Fprintf(&b, "%s- %s\n", indent, p.Name)
+			parts(p, indent+"  ")
+		}
+	}
+	fmt.Fprintf(&b, "source: %s\n", t.Source.Name)
+	parts(t.Source, "  ")
+	for _, s := range t.Stages {
+		if len(s.Policies) == 0 {
+			fmt.Fprintf(&b, "%s\n", s.Name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\n", s.Name, strings.Join(s.Policies, "; "))
+	}
+	fmt.Fprintf(&b, "sink: %s\n", t.Sink.Name)
+	parts(t.Sink, "  ")
+	if t.DeadLetter != "" {
+		fmt.Fprintf(&b, "dead letter: %s\n", t.DeadLetter)
+	}
+	if len(t.Observers) > 0 {
+		fmt.Fprintf(&b, "observers: %s\n", strings.Join(t.Observers, ", "))
+	}
+	return b.String()
+}
+
+// topologyNode - узел схемы, общий для Mermaid и DOT
+type topologyNode struct {
+	id    string
+	lines []string
+}
+
+// topologyEdge - связь узлов, dashed - не поток данных, а обратная связь (коммит, DLQ)
+type topologyEdge struct {
+	from, to, label string
+	dashed          bool
+}
+
+// graph раскладывает Topology на узлы и связи: части источника → источник → стадии → приёмник → его части
+func (t Topology) graph() ([]topologyNode, []topologyEdge) {
+	var nodes []topologyNode
+	var edges []topologyEdge
+	var addParts func(c Component, id string, in bool)
+	addParts = func(c Component, id string, in bool) {
+		for i, p := range c.Parts {
+			pid := fmt.Sprintf("%s_%d", id, i)
+			nodes = append(nodes, topologyNode{id: pid, lines: []string{p.Name}})
+			if in {
+				edges = append(edges, topologyEdge{from: pid, to: id})
+			} else {
+				edges = append(edges, topologyEdge{from: id, to: pid})
+			}
+			addParts(p, pid, in)
+		}
+	}
+
+	nodes = append(nodes, topologyNode{id: "source", lines: []string{"source", t.Source.Name}})
+	addParts(t.Source, "source", true)
+	prev := "source"
+	var commit string
+	for _, s := range t.Stages {
+		id := "stage_" + s.Name
+		nodes = append(nodes, topologyNode{id: id, lines: append([]string{s.Name}, s.Policies...)})
+		switch s.Name {
+		case "commit":
+			// Коммит - обратная связь в источник, а не следующая стадия потока
+			commit = id
+			edges = append(edges, topologyEdge{from: id, to: "source", label: "cookies", dashed: true})
+			continue
+		case "shutdown":
+			continue
+		case "process":
+			edges = append(edges, topologyEdge{from: prev, to: id}, topologyEdge{from: id, to: "sink"})
+		default:
+			edges = append(edges, topologyEdge{from: prev, to: id})
+		}
+		prev = id
+	}
+	nodes = append(nodes, topologyNode{id: "sink", lines: []string{"sink", t.Sink.Name}})
+	addParts(t.Sink, "sink", false)
+	if commit != "" {
+		edges = append(edges, topologyEdge{from: "sink", to: commit, label: "processed"})
+	}
+	if t.DeadLetter != "" {
+		nodes = append(nodes, topologyNode{id: "dead_letter", lines: []string{"dead letter", t.DeadLetter}})
+		edges = append(edges, topologyEdge{from: "stage_process", to: "dead_letter", label: "rejected", dashed: true})
+	}
+	if len(t.Observers) > 0 {
+		nodes = append(nodes, topologyNode{id: "observers", lines: append([]string{"observers"}, t.Observers...)})
+	}
+	return nodes, edges
+}
+
+// Mermaid - схема для Mermaid (flowchart), её рисуют GitHub, GitLab и большинство вики
+func (t Topology) Mermaid() string {
+	nodes, edges := t.graph()
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, n := range nodes {
+		lines := make([]string, len(n.lines))
+		for i, l := range n.lines {
+			lines[i] = strings.ReplaceAll(l, `"`, "#quot;")
+		}
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", n.id, strings.Join(lines, "<br/>"))
+	}
+	for _, e := range edges {
+		switch {
+		case e.label != "" && e.dashed:
+			fmt.Fprintf(&b, "    %s -. %s .-> %s\n", e.from, e.label, e.to)
+		case e.label != "":
+			fmt.Fprintf(&b, "    %s -- %s --> %s\n", e.from, e.label, e.to)
+		case e.dashed:
+			fmt.Fprintf(&b, "    %s -.-> %s\n", e.from, e.to)
+		default:
+			fmt.Fprintf(&b, "    %s --> %s\n", e.from, e.to)
+		}
+	}
+	return b.String()
+}
+
+// DOT - схема для Graphviz: dot -Tsvg
+func (t Topology) DOT() string {
+	nodes, edges := t.graph()
+	quote := func(s string) string {
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	}
+	var b strings.Builder
+	b.WriteString("digraph pipe {\n    rankdir=LR;\n    node [shape=box];\n")
+	for _, n := range nodes {
+		lines := make([]string, len(n.lines))
+		for i, l := range n.lines {
+			lines[i] = quote(l)
+		}
+		fmt.Fprintf(&b, "    %s [label=\"%s\"];\n", n.id, strings.Join(lines, `\n`))
+	}
+	for _, e := range edges {
+		var attrs []string
+		if e.label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=\"%s\"", quote(e.label)))
+		}
+		if e.dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, "    %s -> %s [%s];\n", e.from, e.to, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(&b, "    %s -> %s;\n", e.from, e.to)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	src := Merge(&testProducer{}, &chanProducer{})
+	sink := BroadcastConsumer([]Consumer{&testConsumer{}, NullConsumer{}}, 1, nil)
+	top, err := Describe(src, sink, WithWorkers(2), WithMaxBatchDelay(time.Second), WithTransform(Filter(func(any) bool { return true })),
+		WithProcessRetry(RetryPolicy{Attempts: 3, Backoff: time.Second}), WithDeadLetter(&memoryDeadLetter{}),
+		WithDrainTimeout(time.Minute), WithStats(NewStats(time.Minute)))
+	if err != nil {
+		t.Fatalf("Describe() error = %v", err)
+	}
+	want := `source: Merge
+  - source 0: *pipe.testProducer
+  - source 1: *pipe.chanProducer
+read
+transform: 1 transforms
+batch: up to 10000 items; size hinted by the sink; flush after 1s; queue 3 batches
+process: 2 workers; retry 3 attempts, backoff 1s
+commit: in batch order
+shutdown: drain within 1m0s
+sink: BroadcastConsumer (quorum 1)
+  - consumer 0: *pipe.testConsumer
+  - consumer 1: pipe.NullConsumer
+dead letter: memory-dlq
+observers: stats
+`
+	if got := top.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+
+	mermaid := top.Mermaid()
+	for _, line := range []string{
+		"flowchart LR",
+		`stage_process["process<br/>2 workers<br/>retry 3 attempts, backoff 1s"]`,
+		"source_1 --> source",
+		"stage_process --> sink",
+		"sink -- processed --> stage_commit",
+		"stage_commit -. cookies .-> source",
+		"stage_process -. rejected .-> dead_letter",
+	} {
+		if !strings.Contains(mermaid, line) {
+			t.Errorf("Mermaid() has no %q:\n%s", line, mermaid)
+		}
+	}
+	dot := top.DOT()
+	for _, line := range []string{
+		`stage_batch [label="batch\nup to 10000 items\nsize hinted by the sink\nflush after 1s\nqueue 3 batches"];`,
+		"sink -> sink_0;",
+		`stage_commit -> source [label="cookies", style=dashed];`,
+	} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("DOT() has no %q:\n%s", line, dot)
+		}
+	}
+}
+
+func TestDescribeQuotes(t *testing.T) {
+	top := Topology{Source: Component{Name: `say "hi"`}, Sink: Component{Name: `a\b`}}
+	if m := top.Mermaid(); !strings.Contains(m, `source["source<br/>say #quot;hi#quot;"]`) {
+		t.Errorf("Mermaid() = %s", m)
+	}
+	if d := top.DOT(); !strings.Contains(d, `source [label="source\nsay \"hi\""];`) || !strings.Contains(d, `sink [label="sink\na\\b"];`) {
+		t.Errorf("DOT() = %s", d)
+	}
+}
+
+func TestDescribeConfigError(t *testing.T) {
+	_, err := Describe(&testProducer{}, &testConsumer{}, WithWorkers(-1))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Describe() error = %v, want *ConfigError", err)
+	}
+}
@@ -0,0 +1,202 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Преобразования между источником и приёмником: разобрать, отфильтровать битые записи, обогатить
+из справочника. Без них это приходится делать внутри консюмера, обёртывая его ради каждого шага.
+*/
+
+// Transform преобразует пачку Next до того, как она попадёт в батч: может менять, выкидывать
+// и добавлять элементы. Ошибка ведёт себя как ошибка источника: прочитанное до этой пачки
+// дописывается и коммитится, и Pipe останавливается с ней.
+type Transform interface {
+	Apply(ctx context.Context, items []any) ([]any, error)
+}
+
+// TransformFunc - Transform из функции
+type TransformFunc func(ctx context.Context, items []any) ([]any, error)
+
+func (f TransformFunc) Apply(ctx context.Context, items []any) ([]any, error) {
+	return f(ctx, items)
+}
+
+// Filter оставляет элементы, для которых keep вернул true
+func Filter(keep func(item any) bool) Transform {
+	return TransformFunc(func(ctx context.Context, items []any) ([]any, error) {
+		kept := items[:0:0]
+		for _, item := range items {
+			if keep(item) {
+				kept = append(kept, item)
+			}
+		}
+		return kept, nil
+	})
+}
+
+// Map заменяет каждый элемент результатом fn. Ошибка на любом элементе - ошибка всей пачки
+func Map(fn func(item any) (any, error)) Transform {
+	return TransformFunc(func(ctx context.Context, items []any) ([]any, error) {
+		mapped := make([]any, len(items))
+		for i, item := range items {
+			v, err := fn(item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			mapped[i] = v
+		}
+		return mapped, nil
+	})
+}
+
+// ItemErrors - что делать с элементом, на котором преобразование упало
+type ItemErrors int
+
+const (
+	// Ошибка всей пачки: Pipe останавливается, как с Map
+	ItemErrorsFail ItemErrors = iota
+	// Элемент выкидывается. Выкинутые видны в Stats (ошибки StageTransform) и в журнале (EventItemsSkipped)
+	ItemErrorsSkip
+	// Элемент уходит в DeadLetter (нужен WithDeadLetter) и из пачки выкидывается. BatchContext в HandleFailed
+	// нет - батча ещё нет, ошибка - *ItemFailures. Видны в Stats и в журнале (EventItemsDeadLettered)
+	ItemErrorsDeadLetter
+)
+
+// FailedItem - элемент, на котором упало преобразование
+type FailedItem struct {
+	Item any
+	Err  error
+}
+
+// ItemFailures - преобразование не справилось с частью элементов, но по своей политике не валит из-за них пачку.
+// errors.Is/As видят ошибки элементов.
+// Transform возвращает его вместе с остальными элементами, и Pipe поступает с Items по Policy.
+// С ItemErrorsFail это обычная ошибка пачки.
+type ItemFailures struct {
+	Policy ItemErrors
+	Items  []FailedItem
+}
+
+func (e *ItemFailures) Error() string {
+	return fmt.Sprintf("%d items failed, first: %v", len(e.Items), e.Items[0].Err)
+}
+
+func (e *ItemFailures) Unwrap() []error {
+	errs := make([]error, 0, len(e.Items))
+	for _, item := range e.Items {
+		errs = append(errs, item.Err)
+	}
+	return errs
+}
+
+// MapEach - Map, которому один битый элемент не ломает пачку: элементы, на которых fn упала, уходят по policy
+func MapEach(fn func(item any) (any, error), policy ItemErrors) Transform {
+	return TransformFunc(func(ctx context.Context, items []any) ([]any, error) {
+		mapped := make([]any, 0, len(items))
+		var failed []FailedItem
+		for i, item := range items {
+			v, err := fn(item)
+			if err == nil {
+				mapped = append(mapped, v)
+				continue
+			}
+			if policy == ItemErrorsFail {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			failed = append(failed, FailedItem{Item: item, Err: err})
+		}
+		if len(failed) > 0 {
+			return mapped, &ItemFailures{Policy: policy, Items: failed}
+		}
+		return mapped, nil
+	})
+}
+
+// WithTransform добавляет преобразования пачек, они применяются по порядку (и после уже добавленных).
+// Вызываются из горутины чтения источника. Если пачка стала пустой, её cookie всё равно коммитится -
+// вместе с батчем, в который она попала бы. В PipeOf элементы упаковываются в any только на время
+// преобразований, и на выходе должны быть того же типа T.
+func WithTransform(ts ...Transform) Option {
+	return func(cfg *config) {
+		cfg.transforms = append(cfg.transforms, ts...)
+	}
+}
+
+// applyTransforms прогоняет пачку через все преобразования. Элементы, которые преобразования выкинули
+// по своей политике, возвращаются отдельно
+func applyTransforms[T any](ctx context.Context, prof *stageProfile, ts []Transform, items []T) ([]T, []*ItemFailures, error) {
+	if len(ts) == 0 {
+		return items, nil, nil
+	}
+	all, ok := any(items).([]any)
+	if !ok {
+		all = make([]any, len(items))
+		for i, item := range items {
+			all[i] = item
+		}
+	}
+	var dropped []*ItemFailures
+	for i, t := range ts {
+		var out []any
+		var err error
+		prof.do(ctx, StageTransform, transformName(i, t), func(ctx context.Context) {
+			out, err = t.Apply(ctx, all)
+		})
+		var failures *ItemFailures
+		if errors.As(err, &failures) && failures.Policy != ItemErrorsFail {
+			dropped = append(dropped, failures)
+			err = nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("transform %d: %w", i, err)
+		}
+		all = out
+	}
+	if out, ok := any(all).([]T); ok {
+		return out, dropped, nil
+	}
+	out := make([]T, len(all))
+	for i, item := range all {
+		v, ok := item.(T)
+		if !ok {
+			return nil, nil, fmt.Errorf("transform returned %T for item %d, want %T", item, i, v)
+		}
+		out[i] = v
+	}
+	return out, dropped, nil
+}
+
+// dropItems считает выкинутые преобразованиями элементы пачки cookie и отправляет их в DLQ, если так велит политика
+func (cfg *config) dropItems(ctx context.Context, cookie int, dropped []*ItemFailures) error {
+	for _, f := range dropped {
+		cfg.stats.fail(StageTransform, len(f.Items))
+		cfg.metrics.fail(StageTransform, len(f.Items))
+		cfg.hookError(ctx, BatchMeta{Cookies: []int{cookie}}, StageTransform, f)
+		typ := EventItemsSkipped
+		if f.Policy == ItemErrorsDeadLetter {
+			if cfg.deadLetter == nil {
+				return fmt.Errorf("transform dead-letters %d items, but there is no WithDeadLetter: %w", len(f.Items), f)
+			}
+			items := make([]any, len(f.Items))
+			for i, item := range f.Items {
+				items[i] = item.Item
+			}
+			if err := cfg.deadLetter.HandleFailed(ctx, items, f); err != nil {
+				return errors.Join(f, fmt.Errorf("dead letter: %w", err))
+			}
+			typ = EventItemsDeadLettered
+		}
+		if cfg.eventLog != nil {
+			e := Event{Time: time.Now(), Type: typ, Items: len(f.Items), FirstCookie: cookie, LastCookie: cookie, Tags: cfg.tags}
+			if err := cfg.eventLog.Append(ctx, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
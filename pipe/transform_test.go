@@ -0,0 +1,146 @@
+package pipe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func double(item any) (any, error) {
+	return item.(int) * 10, nil
+}
+
+func TestWithTransform(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}, {4, 5, 6}, {7}}}
+	c := &itemsConsumer{p: p}
+	odd := Filter(func(item any) bool { return item.(int)%2 == 1 })
+
+	err := Pipe(p, c, WithInlineMode(), WithTransform(odd), WithTransform(Map(double)))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]any{{10, 30, 50, 70}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Fatalf("commits = %v, want [1 2 3]", p.committed)
+	}
+}
+
+func TestWithTransformEmptyChunkIsCommitted(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1}, {2}, {3}}}
+	c := &itemsConsumer{p: p}
+
+	err := Pipe(p, c, WithInlineMode(), WithTransform(Filter(func(item any) bool { return item != 2 })))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]any{{1, 3}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Fatalf("commits = %v, want [1 2 3]", p.committed)
+	}
+}
+
+func TestWithTransformErrorDrains(t *testing.T) {
+	errMalformed := errors.New("malformed")
+	p := &listProducer{chunks: [][]any{{1}, {2}, {3}}}
+	c := &itemsConsumer{p: p}
+	parse := Map(func(item any) (any, error) {
+		if item == 2 {
+			return nil, errMalformed
+		}
+		return item, nil
+	})
+
+	err := Pipe(p, c, WithInlineMode(), WithTransform(parse))
+	if !errors.Is(err, errMalformed) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errMalformed)
+	}
+	// Прочитанное до битой пачки дописано и закоммичено
+	if want := [][]any{{1}}; !reflect.DeepEqual(c.batches, want) || !reflect.DeepEqual(p.committed, []int{1}) {
+		t.Fatalf("batches = %v, commits = %v, want [[1]] and [1]", c.batches, p.committed)
+	}
+}
+
+func TestWithTransformTypeMismatch(t *testing.T) {
+	in := make(chan int, 1)
+	in <- 1
+	close(in)
+	toString := Map(func(item any) (any, error) { return "one", nil })
+
+	err := PipeOf(ChanProducer(in, nil, nil), ChanConsumer(make(chan []int, 1)), WithTransform(toString))
+	if err == nil {
+		t.Fatal("PipeOf() error = nil, want a type mismatch")
+	}
+}
+
+var errUnparsable = errors.New("unparsable")
+
+// parseEven не разбирает чётные числа
+func parseEven(item any) (any, error) {
+	if item.(int)%2 == 0 {
+		return nil, errUnparsable
+	}
+	return item, nil
+}
+
+func TestMapEachSkip(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}, {4}, {5}}}
+	c := &itemsConsumer{p: p}
+	stats := NewStats(time.Minute)
+	log := &memoryEventLog{}
+
+	err := Pipe(p, c, WithInlineMode(), WithTransform(MapEach(parseEven, ItemErrorsSkip)), WithStats(stats), WithEventLog(log))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]any{{1, 3, 5}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3}) {
+		t.Fatalf("commits = %v, want [1 2 3]", p.committed)
+	}
+	if st := stats.Snapshot().Stages[StageTransform]; st.Calls != 3 || st.Items != 3 || st.Errors != 2 {
+		t.Errorf("transform stats = %+v, want 3 calls, 3 items, 2 errors", st)
+	}
+	var skipped []int
+	for _, e := range log.events {
+		if e.Type == EventItemsSkipped {
+			skipped = append(skipped, e.FirstCookie)
+		}
+	}
+	if !reflect.DeepEqual(skipped, []int{1, 2}) {
+		t.Errorf("skipped events for cookies %v, want [1 2]", skipped)
+	}
+}
+
+func TestMapEachDeadLetter(t *testing.T) {
+	p := &listProducer{chunks: [][]any{{1, 2, 3}, {4, 5}}}
+	c := &itemsConsumer{p: p}
+	dl := &memoryDeadLetter{}
+
+	err := Pipe(p, c, WithInlineMode(), WithTransform(MapEach(parseEven, ItemErrorsDeadLetter)), WithDeadLetter(dl))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]any{{2}, {4}}; !reflect.DeepEqual(dl.batches, want) {
+		t.Fatalf("dead letters = %v, want %v", dl.batches, want)
+	}
+	var failures *ItemFailures
+	if !errors.As(dl.errs[0], &failures) || !errors.Is(failures.Items[0].Err, errUnparsable) {
+		t.Errorf("dead letter error = %v, want *ItemFailures", dl.errs[0])
+	}
+	if want := [][]any{{1, 3, 5}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+
+	// Без DLQ отправлять некуда - это ошибка, как у источника
+	p = &listProducer{chunks: [][]any{{1}, {2}}}
+	err = Pipe(p, &itemsConsumer{p: p}, WithInlineMode(), WithTransform(MapEach(parseEven, ItemErrorsDeadLetter)))
+	if !errors.Is(err, errUnparsable) || !reflect.DeepEqual(p.committed, []int{1}) {
+		t.Fatalf("Pipe() error = %v, commits = %v, want the item error and [1]", err, p.committed)
+	}
+}
@@ -0,0 +1,154 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type row struct {
+	ID   int
+	Size int
+}
+
+// rowProducer отдаёт заранее заданные пачки строк, cookie - номер пачки с 1
+type rowProducer struct {
+	chunks [][]row
+
+	mu        sync.Mutex
+	sent      int
+	committed []int
+}
+
+func (p *rowProducer) Next(ctx context.Context) ([]row, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent >= len(p.chunks) {
+		return nil, 0, errSourceDone
+	}
+	p.sent++
+	return p.chunks[p.sent-1], p.sent, nil
+}
+
+func (p *rowProducer) Commit(ctx context.Context, cookie int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed = append(p.committed, cookie)
+	return nil
+}
+
+// rowConsumer запоминает батчи строк
+type rowConsumer struct {
+	hint    int
+	batches [][]row
+}
+
+func (c *rowConsumer) Process(ctx context.Context, items []row) error {
+	c.batches = append(c.batches, append([]row(nil), items...))
+	return nil
+}
+
+func (c *rowConsumer) PreferredBatchSize(ctx context.Context) int {
+	return c.hint
+}
+
+func TestPipeOfTypedItems(t *testing.T) {
+	p := &rowProducer{chunks: [][]row{{{1, 1}, {2, 1}}, {{3, 1}}, {{4, 1}, {5, 1}}, {{6, 1}}}}
+	c := &rowConsumer{hint: 3}
+
+	err := PipeOf[row](p, c, WithInlineMode())
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("PipeOf() error = %v, want %v", err, errSourceDone)
+	}
+
+	want := [][]row{{{1, 1}, {2, 1}, {3, 1}}, {{4, 1}, {5, 1}, {6, 1}}}
+	if !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+	if !reflect.DeepEqual(p.committed, []int{1, 2, 3, 4}) {
+		t.Fatalf("commits = %v, want [1 2 3 4]", p.committed)
+	}
+}
+
+func TestPipeOfLargeItemsSplit(t *testing.T) {
+	p := &rowProducer{chunks: [][]row{{{1, 1}, {2, 4}}, {{3, 1}}}}
+	c := &rowConsumer{hint: 3}
+	split := func(item any) []any {
+		r := item.(row)
+		return []any{row{r.ID, 2}, row{r.ID, 2}}
+	}
+
+	err := PipeOf[row](p, c, WithInlineMode(), WithLargeItems(LargeItems{
+		Threshold: 2,
+		Size:      func(item any) int { return item.(row).Size },
+		Policy:    LargeItemSplit,
+		Split:     split,
+	}))
+	if !errors.Is(err, errSourceDone) {
+		t.Fatalf("PipeOf() error = %v, want %v", err, errSourceDone)
+	}
+	if want := [][]row{{{1, 1}, {2, 2}, {2, 2}}, {{3, 1}}}; !reflect.DeepEqual(c.batches, want) {
+		t.Fatalf("batches = %v, want %v", c.batches, want)
+	}
+}
+
+func TestPipeOfLargeItemsSplitWrongType(t *testing.T) {
+	p := &rowProducer{chunks: [][]row{{{1, 4}}}}
+
+	err := PipeOf[row](p, &rowConsumer{}, WithInlineMode(), WithLargeItems(LargeItems{
+		Threshold: 2,
+		Size:      func(item any) int { return item.(row).Size },
+		Policy:    LargeItemSplit,
+		Split:     func(item any) []any { return []any{"half", "half"} },
+	}))
+	if err == nil || errors.Is(err, errSourceDone) {
+		t.Fatalf("PipeOf() error = %v, want a Split type error", err)
+	}
+}
+
+func BenchmarkPipeOf(b *testing.B) {
+	chunk := make([]row, 100)
+	chunks := make([][]row, 1000)
+	for i := range chunks {
+		chunks[i] = chunk
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		PipeOf[row](&rowProducer{chunks: chunks}, &countRows{}, WithInlineMode())
+	}
+}
+
+// boxingProducer - то, что приходится писать без PipeOf: каждая строка упаковывается в any
+type boxingProducer struct {
+	rowProducer
+}
+
+func (p *boxingProducer) Next(ctx context.Context) ([]any, int, error) {
+	rows, cookie, err := p.rowProducer.Next(ctx)
+	items := make([]any, len(rows))
+	for i, r := range rows {
+		items[i] = r
+	}
+	return items, cookie, err
+}
+
+func BenchmarkPipeAny(b *testing.B) {
+	chunk := make([]row, 100)
+	chunks := make([][]row, 1000)
+	for i := range chunks {
+		chunks[i] = chunk
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Pipe(&boxingProducer{rowProducer{chunks: chunks}}, NullConsumer{}, WithInlineMode())
+	}
+}
+
+type countRows struct{ n int }
+
+func (c *countRows) Process(ctx context.Context, items []row) error {
+	c.n += len(items)
+	return nil
+}
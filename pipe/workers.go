@@ -0,0 +1,82 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+Несколько Process одновременно. Один Process за раз упирается в задержку приёмника, а ParallelConsumer
+делит один батч и коммитит его только целиком. С WithWorkers батчи обрабатываются независимо,
+но коммитятся строго в порядке сборки: батч, обработанный раньше предыдущего, ждёт своей очереди.
+*/
+
+// WithWorkers обрабатывает до n батчей одновременно. Commit (и всё, что после него: отчёты о доставке,
+// журнал, бюджет ошибок) идёт строго по порядку батчей, как с одним обработчиком.
+// Process, ProcessWithResults, DeadLetter и колбэк WithKeyStats при этом вызываются из n горутин сразу.
+// Ошибка одного батча останавливает всех: уже обработанные, но не закоммиченные батчи придут повторно.
+func WithWorkers(n int) Option {
+	return func(cfg *config) {
+		cfg.workers = n
+	}
+}
+
+// WithUnorderedCommits с WithWorkers коммитит каждый батч сразу после обработки, не дожидаясь предыдущих.
+// Только для источников, которые явно это терпят: cookie независимы (ack отдельных сообщений), и Commit
+// одного не подтверждает другие. Для оффсетов Kafka это потеря данных: закоммиченный оффсет батча 2
+// подтвердит и батч 1, который ещё не записан. Cookie одного батча по-прежнему коммитятся по порядку.
+func WithUnorderedCommits() Option {
+	return func(cfg *config) {
+		cfg.unorderedCommits = true
+	}
+}
+
+// commitSequencer пропускает к коммиту батчи по порядку их номеров. nil - один обработчик или
+// WithUnorderedCommits, ждать некого
+type commitSequencer struct {
+	mu   sync.Mutex
+	next uint64
+	// Закрывается, когда очередь переходит к следующему батчу
+	turn chan struct{}
+}
+
+func newCommitSequencer(cfg *config) *commitSequencer {
+	if cfg.poolSize() <= 1 || cfg.unorderedCommits {
+		return nil
+	}
+	return &commitSequencer{next: 1, turn: make(chan struct{})}
+}
+
+// wait ждёт, пока закоммитятся все батчи до seq. Ошибка - только отмена ctx
+func (s *commitSequencer) wait(ctx context.Context, seq uint64) error {
+	if s == nil {
+		return nil
+	}
+	for {
+		s.mu.Lock()
+		if s.next == seq {
+			s.mu.Unlock()
+			return nil
+		}
+		turn := s.turn
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-turn:
+		}
+	}
+}
+
+// done передаёт очередь следующему батчу
+func (s *commitSequencer) done() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	close(s.turn)
+	s.turn = make(chan struct{})
+}
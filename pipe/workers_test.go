@@ -0,0 +1,142 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderConsumer держит каждый батч, пока не начнутся все, а первый ещё немного и запоминает,
+// что было закоммичено к тому моменту, когда его отпустили
+type orderConsumer struct {
+	p       *testProducer
+	batches int
+	// Сколько коммитов первый батч ждёт, прежде чем вернуться
+	wantEarly int
+
+	mu         sync.Mutex
+	started    int
+	all        chan struct{}
+	early      []int
+	maxRunning int
+	running    int
+}
+
+func (c *orderConsumer) Process(ctx context.Context, items []any) error {
+	c.mu.Lock()
+	c.started++
+	c.running++
+	c.maxRunning = max(c.maxRunning, c.running)
+	if c.started == c.batches {
+		close(c.all)
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.running--
+		c.mu.Unlock()
+	}()
+
+	select {
+	case <-c.all:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Second):
+		return errors.New("other batches never started")
+	}
+	if items[0] != 1 {
+		return nil
+	}
+	// Остальные батчи уже обработаны или обрабатываются, но без первого их коммитить нельзя.
+	// Если ждём чужих коммитов (WithUnorderedCommits) - даём им случиться
+	deadline := time.Now().Add(time.Second)
+	for len(c.p.commits()) < c.wantEarly && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	c.early = c.p.commits()
+	c.mu.Unlock()
+	return nil
+}
+
+func TestWithWorkersCommitsInOrder(t *testing.T) {
+	// 8 пачек по 5000 - 4 батча, первый обрабатывается последним
+	p := &testProducer{chunks: 8, chunkSize: 5000}
+	c := &orderConsumer{p: p, batches: 4, all: make(chan struct{})}
+	reports := make(chan DeliveryReport, 10)
+
+	err := Pipe(finiteProducer{p}, c, WithWorkers(4), WithDeliveryReports(reports))
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	close(reports)
+
+	if c.maxRunning != 4 {
+		t.Errorf("at most %d Process calls at once, want 4", c.maxRunning)
+	}
+	if len(c.early) != 0 {
+		t.Errorf("commits before the first batch was processed: %v", c.early)
+	}
+	if got := p.commits(); len(got) != 8 {
+		t.Fatalf("commits = %v, want 1..8", got)
+	}
+	checkCommitsInOrder(t, p.commits())
+	var cookies []int
+	for r := range reports {
+		cookies = append(cookies, r.Cookie)
+	}
+	checkCommitsInOrder(t, cookies)
+}
+
+func TestWithUnorderedCommits(t *testing.T) {
+	p := &testProducer{chunks: 8, chunkSize: 5000}
+	c := &orderConsumer{p: p, batches: 4, wantEarly: 6, all: make(chan struct{})}
+
+	err := Pipe(finiteProducer{p}, c, WithWorkers(4), WithUnorderedCommits())
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	// Пока первый батч висел, остальные успели закоммитить свои cookie
+	if len(c.early) != 6 {
+		t.Errorf("commits before the first batch was processed = %v, want the other 6", c.early)
+	}
+	if got := p.commits(); len(got) != 8 || got[6] != 1 || got[7] != 2 {
+		t.Errorf("commits = %v, want 1 and 2 last", got)
+	}
+}
+
+func TestWithWorkersStopsOnError(t *testing.T) {
+	p := &testProducer{chunks: 20, chunkSize: 5000}
+	c := &poisonConsumer{poison: 3}
+
+	err := Pipe(p, c, WithWorkers(3))
+	if !errors.Is(err, errPoison) {
+		t.Fatalf("Pipe() error = %v, want %v", err, errPoison)
+	}
+	// Батч с пачкой 3 не закоммичен, а значит и ничего после него
+	for _, cookie := range p.commits() {
+		if cookie >= 3 {
+			t.Fatalf("commits = %v, want nothing from the failed batch on", p.commits())
+		}
+	}
+	checkCommitsInOrder(t, p.commits())
+}
+
+func TestWithWorkersValidation(t *testing.T) {
+	err := Pipe(&testProducer{}, &testConsumer{}, WithWorkers(-1))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+	}
+	err = Pipe(&testProducer{}, &testConsumer{}, WithWorkers(2), WithInlineMode())
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 1 problem", err)
+	}
+	err = Pipe(&testProducer{}, &testConsumer{}, WithUnorderedCommits(), WithCommitRetry(CommitRetry{OnExhausted: CommitSkip}))
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 2 {
+		t.Fatalf("Pipe() error = %v, want a *ConfigError with 2 problems", err)
+	}
+}